@@ -37,7 +37,7 @@ func (s *azureClient) ListAzureADAppRoleAssignments(ctx context.Context, service
 		params.Top = 999
 	}
 
-	go getAzureObjectList[azure.AppRoleAssignment](s.msgraph, ctx, path, params, out)
+	go getAzureObjectList[azure.AppRoleAssignment](s.msgraphBatch, ctx, path, params, out)
 
 	return out
 }
@@ -55,7 +55,7 @@ func (s *azureClient) ListAzureADAppOwners(ctx context.Context, objectId string,
 		params.Top = 99
 	}
 
-	go getAzureObjectList[json.RawMessage](s.msgraph, ctx, path, params, out)
+	go getAzureObjectList[json.RawMessage](s.msgraphBatch, ctx, path, params, out)
 
 	return out
 }
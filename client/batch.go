@@ -0,0 +1,349 @@
+// Copyright (C) 2024 Specter Ops, Inc.
+//
+// This file is part of AzureHound.
+//
+// AzureHound is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// AzureHound is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bloodhoundad/azurehound/v2/client/query"
+	"github.com/bloodhoundad/azurehound/v2/client/rest"
+	"github.com/bloodhoundad/azurehound/v2/metrics"
+	"github.com/bloodhoundad/azurehound/v2/tracing"
+)
+
+const (
+	graphBatchSize   = 20                    // The maximum number of requests Microsoft Graph accepts in a single batch
+	graphBatchWindow = 50 * time.Millisecond // How long to wait for a batch to fill before sending it anyway
+)
+
+type graphBatchRequest struct {
+	Id      string            `json:"id"`
+	Method  string            `json:"method"`
+	Url     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+type graphBatchResponse struct {
+	Id      string            `json:"id"`
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    json.RawMessage   `json:"body,omitempty"`
+}
+
+type graphBatchItem struct {
+	ctx        context.Context
+	span       *tracing.Span
+	endpoint   string // The endpoint to which the request is attributed, as if it had been sent on its own
+	url        string // Relative to the API version
+	headers    map[string]string
+	start      time.Time
+	retries    int
+	throttles  int
+	challenged bool // Whether the request has been sent again to satisfy a claims challenge, which happens only once
+	done       chan struct{}
+	res        *http.Response
+	err        error
+}
+
+func (s *graphBatchItem) finish(res *http.Response, err error) {
+	s.res, s.err = res, err
+	close(s.done)
+}
+
+// record attributes the outcome of the request to its endpoint, the stage of its context and its span as though it had
+// been sent on its own
+func (s *graphBatchItem) record(err error) {
+	metrics.RecordRequest(s.ctx, metrics.Request{
+		Endpoint:  s.endpoint,
+		Attempts:  s.retries + 1,
+		Throttles: s.throttles,
+		Latency:   time.Since(s.start),
+		Failed:    err != nil,
+	})
+	s.span.SetAttributes(tracing.Int("azurehound.attempts", s.retries+1), tracing.Int("azurehound.throttles", s.throttles))
+	s.span.SetError(err)
+}
+
+// graphBatcher is a rest.RestClient that packs concurrent GET requests into Microsoft Graph JSON batches of up to 20
+// requests each, demultiplexing each response back to its caller. Requests other than GETs are sent as is.
+// See https://learn.microsoft.com/en-us/graph/json-batching
+type graphBatcher struct {
+	rest.RestClient
+	broker  *rest.TokenBroker // Refreshes the access token when a request within a batch is met with a claims challenge
+	api     url.URL
+	host    string           // The host of the API, to which the requests within a batch are attributed
+	size    int              // The number of requests sent in each full batch
+	limiter *rest.Limiter    // The limiter of the API host, which sees the push-back only within the batch responses
+	retry   rest.RetryPolicy // Decides whether and when a throttled or failed request within a batch is sent again
+	mutex   sync.Mutex
+	pending map[string][]*graphBatchItem // Keyed by API version since a batch is sent to a versioned endpoint
+	timers  map[string]*time.Timer
+}
+
+func newGraphBatcher(client rest.RestClient, broker *rest.TokenBroker, api url.URL, retry rest.RetryPolicy) *graphBatcher {
+	size := graphBatchSize
+	if rest.UsesCassette() {
+		// which requests share a batch depends on timing, so a cassette is only replayable with batches of one
+		size = 1
+	}
+	return &graphBatcher{
+		RestClient: client,
+		broker:     broker,
+		api:        api,
+		host:       api.Host,
+		size:       size,
+		limiter:    rest.APILimiter(api.Host),
+		retry:      retry,
+		pending:    make(map[string][]*graphBatchItem),
+		timers:     make(map[string]*time.Timer),
+	}
+}
+
+func (s *graphBatcher) Get(ctx context.Context, path string, params query.Params, headers map[string]string) (*http.Response, error) {
+	endpoint := url.URL{Path: path}
+	if params != nil {
+		q := endpoint.Query()
+		for key, value := range params.AsMap() {
+			q.Set(key, value)
+		}
+		endpoint.RawQuery = q.Encode()
+
+		if params.NeedsEventualConsistencyHeaderFlag() {
+			if headers == nil {
+				headers = make(map[string]string)
+			}
+			headers["ConsistencyLevel"] = "eventual"
+		}
+	}
+	return s.do(ctx, endpoint, headers)
+}
+
+func (s *graphBatcher) Send(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet {
+		return s.RestClient.Send(req)
+	} else {
+		var headers map[string]string
+		if consistencyLevel := req.Header.Get("ConsistencyLevel"); consistencyLevel != "" {
+			headers = map[string]string{"ConsistencyLevel": consistencyLevel}
+		}
+		return s.do(req.Context(), *req.URL, headers)
+	}
+}
+
+func (s *graphBatcher) do(ctx context.Context, endpoint url.URL, headers map[string]string) (*http.Response, error) {
+	if parts := strings.SplitN(strings.TrimPrefix(endpoint.Path, "/"), "/", 2); len(parts) != 2 {
+		return nil, fmt.Errorf("unable to batch request without an API version: %s", endpoint.Path)
+	} else {
+		var (
+			relative  = url.URL{Path: "/" + parts[1], RawQuery: endpoint.RawQuery}
+			name      = metrics.Endpoint(http.MethodGet, &url.URL{Host: s.host, Path: endpoint.Path})
+			ctx, span = tracing.Start(ctx, "HTTP GET", tracing.SpanKindClient,
+				tracing.String("http.request.method", http.MethodGet),
+				tracing.String("server.address", s.host),
+				tracing.String("url.path", endpoint.Path),
+				tracing.String("azurehound.endpoint", name),
+				tracing.Bool("azurehound.batched", true),
+			)
+			item = &graphBatchItem{
+				ctx:      ctx,
+				span:     span,
+				endpoint: name,
+				url:      relative.String(),
+				headers:  headers,
+				start:    time.Now(),
+				done:     make(chan struct{}),
+			}
+		)
+		defer span.End()
+
+		s.enqueue(parts[0], item)
+
+		select {
+		case <-item.done:
+			return item.res, item.err
+		case <-ctx.Done():
+			span.SetError(ctx.Err())
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// enqueue adds the request to the next batch for the API version, sending the batch once it is full or the batch
+// window elapses
+func (s *graphBatcher) enqueue(version string, item *graphBatchItem) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.pending[version] = append(s.pending[version], item)
+	if len(s.pending[version]) >= s.size {
+		go s.flush(version, s.take(version))
+	} else if _, ok := s.timers[version]; !ok {
+		s.timers[version] = time.AfterFunc(graphBatchWindow, func() {
+			s.mutex.Lock()
+			batch := s.take(version)
+			s.mutex.Unlock()
+			s.flush(version, batch)
+		})
+	}
+}
+
+// take removes and returns the pending requests for the API version. Must be called while holding the mutex.
+func (s *graphBatcher) take(version string) []*graphBatchItem {
+	if timer, ok := s.timers[version]; ok {
+		timer.Stop()
+		delete(s.timers, version)
+	}
+	batch := s.pending[version]
+	delete(s.pending, version)
+	return batch
+}
+
+func (s *graphBatcher) flush(version string, batch []*graphBatchItem) {
+	var (
+		items    = make(map[string]*graphBatchItem, len(batch))
+		requests = make([]graphBatchRequest, 0, len(batch))
+		body     struct {
+			Responses []graphBatchResponse `json:"responses"`
+		}
+	)
+
+	for i, item := range batch {
+		if item.ctx.Err() != nil {
+			item.finish(nil, item.ctx.Err())
+		} else {
+			id := strconv.Itoa(i)
+			items[id] = item
+			requests = append(requests, graphBatchRequest{
+				Id:      id,
+				Method:  http.MethodGet,
+				Url:     item.url,
+				Headers: item.headers,
+			})
+		}
+	}
+
+	if len(requests) == 0 {
+		return
+	}
+
+	// the batch belongs to the trace of none of its requests, so it is linked to each of them instead
+	ctx, span := tracing.Start(context.Background(), "graph batch", tracing.SpanKindInternal, tracing.Int("azurehound.batch.size", len(requests)))
+	defer span.End()
+	for _, item := range items {
+		span.AddLink(item.span)
+	}
+
+	fail := func(err error) {
+		span.SetError(err)
+		for _, item := range items {
+			item.record(err)
+			item.finish(nil, err)
+		}
+	}
+
+	// the batch carries the broker's current token, which a claims challenge within the batch rejects
+	sent := time.Now()
+	if token, err := s.broker.Token(s.api); err != nil {
+		fail(err)
+	} else if res, err := s.RestClient.Post(ctx, fmt.Sprintf("/%s/$batch", version), map[string]interface{}{"requests": requests}, nil, nil); err != nil {
+		fail(err)
+	} else if err := rest.Decode(res.Body, &body); err != nil {
+		fail(err)
+	} else {
+		for _, response := range body.Responses {
+			if item, ok := items[response.Id]; ok {
+				delete(items, response.Id)
+				s.handle(version, sent, token, item, response)
+			}
+		}
+		for _, item := range items {
+			err := fmt.Errorf("no response to request %s in batch", item.url)
+			item.record(err)
+			item.finish(nil, err)
+		}
+	}
+}
+
+// handle hands a batch response to its caller, enqueuing the request again if it was throttled, failed on the server
+// or was met with a claims challenge
+func (s *graphBatcher) handle(version string, sent time.Time, token rest.Token, item *graphBatchItem, response graphBatchResponse) {
+	header := make(http.Header)
+	for key, value := range response.Headers {
+		header.Set(key, value)
+	}
+	s.limiter.Observe(sent, response.Status, header)
+
+	if response.Status == http.StatusTooManyRequests {
+		item.throttles++
+	}
+
+	res := &http.Response{
+		StatusCode: response.Status,
+		Header:     header,
+		Body:       io.NopCloser(bytes.NewReader(response.Body)),
+	}
+	if response.Status >= http.StatusOK && response.Status < http.StatusBadRequest {
+		item.record(nil)
+		item.finish(res, nil)
+	} else if challenge, err := rest.ParseClaimsChallenge(header); response.Status == http.StatusUnauthorized && !item.challenged && s.broker.HasCredential() && err == nil && challenge.Recoverable() {
+		item.challenged = true
+		item.span.AddEvent("claims challenge", tracing.Int("http.response.status_code", response.Status))
+
+		// the other responses in the batch need not wait for the token
+		go func() {
+			if _, err := s.broker.Refresh(s.api, token, challenge.Claims); err != nil {
+				err = fmt.Errorf("unable to satisfy claims challenge: %w", err)
+				item.record(err)
+				item.finish(nil, err)
+			} else {
+				// send once more in a batch carrying a token that satisfies the challenge
+				s.enqueue(version, item)
+			}
+		}()
+	} else if delay, ok := s.retry.Retry(item.retries+1, time.Since(item.start), res, nil); ok {
+		attributes := []tracing.Attribute{
+			tracing.Int("azurehound.attempt", item.retries+1),
+			tracing.Float64("azurehound.retry.delay_seconds", delay.Seconds()),
+			tracing.Int("http.response.status_code", response.Status),
+		}
+		if response.Status == http.StatusTooManyRequests {
+			item.span.AddEvent("throttle", attributes...)
+		}
+		item.span.AddEvent("retry", attributes...)
+
+		item.retries++
+		time.AfterFunc(delay, func() { s.enqueue(version, item) })
+	} else {
+		errRes := &rest.ErrorResponse{StatusCode: response.Status, Header: header}
+		if err := json.Unmarshal(response.Body, &errRes.Body); err != nil {
+			errRes.Body = nil
+		}
+		item.record(errRes)
+		item.finish(nil, errRes)
+	}
+}
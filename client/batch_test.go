@@ -0,0 +1,263 @@
+// Copyright (C) 2024 Specter Ops, Inc.
+//
+// This file is part of AzureHound.
+//
+// AzureHound is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// AzureHound is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bloodhoundad/azurehound/v2/client/config"
+	"github.com/bloodhoundad/azurehound/v2/client/query"
+	"github.com/bloodhoundad/azurehound/v2/client/rest"
+)
+
+func TestGraphBatch(t *testing.T) {
+	var (
+		testServer  *httptest.Server
+		mutex       sync.Mutex
+		batches     int
+		throttled   = map[string]bool{}
+		unavailable int
+	)
+
+	var mockHandler http.HandlerFunc = func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if strings.HasSuffix(r.URL.Path, "/oauth2/v2.0/token") {
+			w.Write([]byte(`{"access_token":"access","expires_in":3600}`))
+			return
+		} else if r.URL.Path != "/beta/$batch" {
+			t.Errorf("unexpected request outside of a batch: %s", r.URL)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		var body struct {
+			Requests []graphBatchRequest `json:"requests"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		} else if len(body.Requests) > graphBatchSize {
+			t.Errorf("got batch of %d requests, want at most %d", len(body.Requests), graphBatchSize)
+		}
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		batches++
+
+		responses := []graphBatchResponse{}
+		for _, request := range body.Requests {
+			response := graphBatchResponse{Id: request.Id, Status: http.StatusOK}
+			objectId := strings.Split(request.Url, "/")[2]
+			if objectId == "throttled" && !throttled[objectId] {
+				throttled[objectId] = true
+				response.Status = http.StatusTooManyRequests
+				response.Headers = map[string]string{"Retry-After": "0"}
+			} else if objectId == "unavailable" {
+				unavailable++
+				response.Status = http.StatusServiceUnavailable
+			} else if objectId == "forbidden" {
+				response.Status = http.StatusForbidden
+				response.Body = json.RawMessage(`{"error":{"code":"Authorization_RequestDenied"}}`)
+			} else if strings.Contains(request.Url, "skiptoken") {
+				response.Body = json.RawMessage(fmt.Sprintf(`{"value":["%s-2"]}`, objectId))
+			} else {
+				response.Body = json.RawMessage(fmt.Sprintf(`{"value":["%s-1"],"@odata.nextLink":"%s/beta/groups/%s/members?$skiptoken=2"}`, objectId, testServer.URL, objectId))
+			}
+			responses = append(responses, response)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"responses": responses})
+	}
+
+	testServer = httptest.NewServer(mockHandler)
+	defer testServer.Close()
+
+	clientConfig := config.Config{
+		Authority:     testServer.URL,
+		ApplicationId: "foo",
+		ClientSecret:  "bar",
+	}
+
+	broker, err := rest.NewTokenBroker(clientConfig)
+	if err != nil {
+		t.Fatalf("error initializing token broker %v", err)
+	}
+	msgraph, err := rest.NewRestClientWithBroker(testServer.URL, clientConfig, broker)
+	if err != nil {
+		t.Fatalf("error initializing rest client %v", err)
+	}
+	retry := rest.BackoffPolicy{
+		MaxAttempts: 2,
+		BaseDelay:   time.Millisecond,
+		MaxDelay:    10 * time.Millisecond,
+		StatusCodes: map[int]rest.RetryRule{
+			http.StatusTooManyRequests:    {MaxAttempts: 0},
+			http.StatusServiceUnavailable: {MaxAttempts: 2},
+		},
+	}
+	client := &azureClient{msgraph: msgraph, msgraphBatch: newGraphBatcher(msgraph, broker, url.URL{Scheme: "https", Host: "batch.graph.test"}, retry)}
+
+	objectIds := []string{"throttled", "forbidden", "unavailable"}
+	for i := 0; i < 2*graphBatchSize; i++ {
+		objectIds = append(objectIds, fmt.Sprintf("group%d", i))
+	}
+
+	var wg sync.WaitGroup
+	for _, objectId := range objectIds {
+		wg.Add(1)
+		go func(objectId string) {
+			defer wg.Done()
+			var values []string
+			for item := range client.ListAzureADGroupMembers(context.Background(), objectId, query.GraphParams{}) {
+				if item.Error != nil {
+					if objectId != "forbidden" && objectId != "unavailable" {
+						t.Errorf("%s: unexpected error: %v", objectId, item.Error)
+					}
+					return
+				} else {
+					var value string
+					json.Unmarshal(item.Ok, &value)
+					values = append(values, value)
+				}
+			}
+			if objectId == "forbidden" || objectId == "unavailable" {
+				t.Errorf("%s: expected an error but got none", objectId)
+			} else if len(values) != 2 || values[0] != objectId+"-1" || values[1] != objectId+"-2" {
+				t.Errorf("%s: got %v, want both pages", objectId, values)
+			}
+		}(objectId)
+	}
+	wg.Wait()
+
+	// the throttled responses within a batch shrink the limit of the Graph host from where it started
+	if limit := client.msgraphBatch.(*graphBatcher).limiter.Limit(); limit >= 4 {
+		t.Errorf("got limit %d after throttled batch responses, want less than 4", limit)
+	}
+
+	// a request that keeps failing is given up once the retry policy allows no more attempts
+	if unavailable != 2 {
+		t.Errorf("got %d attempts at an unavailable request, want 2", unavailable)
+	}
+
+	// 43 objects with two pages each along with retries take at least 5 batches
+	if batches < 5 || batches >= len(objectIds) {
+		t.Errorf("got %d batches for %d objects", batches, len(objectIds))
+	}
+}
+
+func TestGraphBatchClaimsChallenge(t *testing.T) {
+	var (
+		mutex         sync.Mutex
+		tokenRequests []string
+		challenges    int
+		challenge     = `{"access_token":{"nbf":{"essential":true,"value":"1604106651"}}}`
+	)
+
+	var mockHandler http.HandlerFunc = func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/tenant/oauth2/v2.0/token" {
+			r.ParseForm()
+			tokenRequests = append(tokenRequests, r.Form.Get("claims"))
+			fmt.Fprintf(w, `{"access_token":"access%d","expires_in":3600}`, len(tokenRequests))
+			return
+		}
+
+		var body struct {
+			Requests []graphBatchRequest `json:"requests"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+
+		responses := []graphBatchResponse{}
+		for _, request := range body.Requests {
+			response := graphBatchResponse{Id: request.Id, Status: http.StatusOK, Body: json.RawMessage(`{"value":["member"]}`)}
+			if objectId := strings.Split(request.Url, "/")[2]; objectId == "revoked" || r.Header.Get("Authorization") == "Bearer access1" {
+				// a request is challenged again and again when the token never satisfies the challenge
+				challenges++
+				response.Status = http.StatusUnauthorized
+				response.Headers = map[string]string{
+					"WWW-Authenticate": fmt.Sprintf(`Bearer realm="", error="insufficient_claims", claims="%s"`, base64.StdEncoding.EncodeToString([]byte(challenge))),
+				}
+				response.Body = json.RawMessage(`{"error":{"code":"InvalidAuthenticationToken"}}`)
+			}
+			responses = append(responses, response)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"responses": responses})
+	}
+
+	testServer := httptest.NewServer(mockHandler)
+	defer testServer.Close()
+
+	clientConfig := config.Config{
+		Authority:     testServer.URL,
+		Tenant:        "tenant",
+		ApplicationId: "foo",
+		ClientSecret:  "bar",
+	}
+
+	api, err := url.Parse(testServer.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	broker, err := rest.NewTokenBroker(clientConfig)
+	if err != nil {
+		t.Fatalf("error initializing token broker %v", err)
+	}
+	msgraph, err := rest.NewRestClientWithBroker(testServer.URL, clientConfig, broker)
+	if err != nil {
+		t.Fatalf("error initializing rest client %v", err)
+	}
+	client := &azureClient{msgraph: msgraph, msgraphBatch: newGraphBatcher(msgraph, broker, *api, rest.BackoffPolicy{})}
+
+	// a challenged request is sent again with a token that satisfies the challenge
+	for item := range client.ListAzureADGroupMembers(context.Background(), "group", query.GraphParams{}) {
+		if item.Error != nil {
+			t.Errorf("unexpected error: %v", item.Error)
+		}
+	}
+	if len(tokenRequests) != 2 {
+		t.Fatalf("got %d token requests, want 2", len(tokenRequests))
+	} else if !strings.Contains(tokenRequests[1], `"nbf"`) {
+		t.Errorf("got claims %s, want the challenge", tokenRequests[1])
+	}
+
+	// but only once
+	challenges = 0
+	for item := range client.ListAzureADGroupMembers(context.Background(), "revoked", query.GraphParams{}) {
+		var errRes *rest.ErrorResponse
+		if !errors.As(item.Error, &errRes) || errRes.StatusCode != http.StatusUnauthorized {
+			t.Errorf("got error %v, want the claims challenge", item.Error)
+		}
+	}
+	if challenges != 2 {
+		t.Errorf("got %d challenges, want 2", challenges)
+	}
+}
@@ -29,40 +29,60 @@ import (
 	"github.com/bloodhoundad/azurehound/v2/client/config"
 	"github.com/bloodhoundad/azurehound/v2/client/query"
 	"github.com/bloodhoundad/azurehound/v2/client/rest"
+	"github.com/bloodhoundad/azurehound/v2/metrics"
 	"github.com/bloodhoundad/azurehound/v2/models/azure"
 	"github.com/bloodhoundad/azurehound/v2/panicrecovery"
 	"github.com/bloodhoundad/azurehound/v2/pipeline"
+	"github.com/bloodhoundad/azurehound/v2/tracing"
 )
 
 func NewClient(config config.Config) (AzureClient, error) {
-	if msgraph, err := rest.NewRestClient(config.GraphUrl(), config); err != nil {
+	// Both clients share a single token broker so one credential drives Graph and Resource Manager collection alike
+	if broker, err := rest.NewTokenBroker(config); err != nil {
 		return nil, err
-	} else if resourceManager, err := rest.NewRestClient(config.ResourceManagerUrl(), config); err != nil {
+	} else {
+		return NewClientWithBroker(config, broker)
+	}
+}
+
+// NewClientWithBroker creates a client that acquires its access tokens from an existing token broker
+func NewClientWithBroker(config config.Config, broker *rest.TokenBroker) (AzureClient, error) {
+	if msgraph, err := rest.NewRestClientWithBroker(config.GraphUrl(), config, broker); err != nil {
+		return nil, err
+	} else if resourceManager, err := rest.NewRestClientWithBroker(config.ResourceManagerUrl(), config, broker); err != nil {
+		return nil, err
+	} else if graphUrl, err := url.Parse(config.GraphUrl()); err != nil {
+		return nil, err
+	} else if retry, err := rest.NewRetryPolicy(); err != nil {
 		return nil, err
 	} else {
-		if config.JWT != "" {
+		msgraphBatch := newGraphBatcher(msgraph, broker, *graphUrl, retry)
+		if config.JWT != "" && !broker.HasCredential() {
+			// Without a credential to acquire tokens for other audiences only the JWT's audience is reachable
 			if aud, err := rest.ParseAud(config.JWT); err != nil {
 				return nil, err
 			} else if aud == config.GraphUrl() {
-				return initClientViaGraph(msgraph, resourceManager)
+				return initClientViaGraph(broker, msgraph, msgraphBatch, resourceManager)
 			} else if aud == config.ResourceManagerUrl() {
 				if body, err := rest.ParseBody(config.JWT); err != nil {
 					return nil, err
 				} else {
-					return initClientViaRM(msgraph, resourceManager, body["tid"])
+					return initClientViaRM(broker, msgraph, msgraphBatch, resourceManager, body["tid"])
 				}
 			} else {
 				return nil, fmt.Errorf("error: invalid token audience")
 			}
 		} else {
-			return initClientViaGraph(msgraph, resourceManager)
+			return initClientViaGraph(broker, msgraph, msgraphBatch, resourceManager)
 		}
 	}
 }
 
-func initClientViaRM(msgraph, resourceManager rest.RestClient, tid interface{}) (AzureClient, error) {
+func initClientViaRM(broker *rest.TokenBroker, msgraph, msgraphBatch, resourceManager rest.RestClient, tid interface{}) (AzureClient, error) {
 	client := &azureClient{
+		broker:          broker,
 		msgraph:         msgraph,
+		msgraphBatch:    msgraphBatch,
 		resourceManager: resourceManager,
 	}
 	if result, err := client.GetAzureADTenants(context.Background(), true); err != nil {
@@ -78,9 +98,11 @@ func initClientViaRM(msgraph, resourceManager rest.RestClient, tid interface{})
 	}
 }
 
-func initClientViaGraph(msgraph, resourceManager rest.RestClient) (AzureClient, error) {
+func initClientViaGraph(broker *rest.TokenBroker, msgraph, msgraphBatch, resourceManager rest.RestClient) (AzureClient, error) {
 	client := &azureClient{
+		broker:          broker,
 		msgraph:         msgraph,
+		msgraphBatch:    msgraphBatch,
 		resourceManager: resourceManager,
 	}
 	if org, err := client.GetAzureADOrganization(context.Background(), nil); err != nil {
@@ -104,6 +126,13 @@ func getAzureObjectList[T any](client rest.RestClient, ctx context.Context, path
 		errResult AzureResult[T]
 		nextLink  string
 	)
+	defer func() {
+		if errResult.Error != nil {
+			// whatever was left to list is lost to the error
+			metrics.StageFromContext(ctx).Error()
+			tracing.SpanFromContext(ctx).SetError(errResult.Error)
+		}
+	}()
 
 	for {
 		var (
@@ -169,7 +198,9 @@ func getAzureObjectList[T any](client rest.RestClient, ctx context.Context, path
 }
 
 type azureClient struct {
+	broker          *rest.TokenBroker
 	msgraph         rest.RestClient
+	msgraphBatch    rest.RestClient // Batches the per-object requests made when enumerating relationships
 	resourceManager rest.RestClient
 	tenant          azure.Tenant
 }
@@ -190,6 +221,12 @@ type AzureGraphClient interface {
 	ListAzureDeviceRegisteredOwners(ctx context.Context, objectId string, params query.GraphParams) <-chan AzureResult[json.RawMessage]
 	ListAzureDevices(ctx context.Context, params query.GraphParams) <-chan AzureResult[azure.Device]
 	ListAzureADAppRoleAssignments(ctx context.Context, servicePrincipalId string, params query.GraphParams) <-chan AzureResult[azure.AppRoleAssignment]
+
+	ListAzureADAppsDelta(ctx context.Context, deltaLink string, params query.GraphParams) <-chan AzureResult[Delta[azure.Application]]
+	ListAzureADGroupsDelta(ctx context.Context, deltaLink string, params query.GraphParams) <-chan AzureResult[Delta[azure.Group]]
+	ListAzureADServicePrincipalsDelta(ctx context.Context, deltaLink string, params query.GraphParams) <-chan AzureResult[Delta[azure.ServicePrincipal]]
+	ListAzureADUsersDelta(ctx context.Context, deltaLink string, params query.GraphParams) <-chan AzureResult[Delta[azure.User]]
+	ListAzureDevicesDelta(ctx context.Context, deltaLink string, params query.GraphParams) <-chan AzureResult[Delta[azure.Device]]
 }
 
 type AzureResourceManagerClient interface {
@@ -219,6 +256,7 @@ type AzureClient interface {
 	AzureResourceManagerClient
 
 	TenantInfo() azure.Tenant
+	TokenBroker() *rest.TokenBroker
 	CloseIdleConnections()
 }
 
@@ -226,6 +264,12 @@ func (s azureClient) TenantInfo() azure.Tenant {
 	return s.tenant
 }
 
+// TokenBroker returns the broker from which the client acquires its access tokens, which acquires tokens for other
+// Azure services on behalf of the same principal
+func (s azureClient) TokenBroker() *rest.TokenBroker {
+	return s.broker
+}
+
 func (s azureClient) CloseIdleConnections() {
 	s.msgraph.CloseIdleConnections()
 	s.resourceManager.CloseIdleConnections()
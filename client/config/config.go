@@ -24,23 +24,40 @@ import (
 )
 
 type Config struct {
-	ApplicationId  string   // The Application Id that the  Azure app registration portal assigned when the app was registered.
-	Authority      string   // The Azure ActiveDirectory Authority URL
-	ClientSecret   string   // The Application Secret that was generated for the app in the app registration portal.
-	ClientCert     string   // The certificate uploaded to the app registration portal."
-	ClientKey      string   // The key for a certificate uploaded to the app registration portal."
-	ClientKeyPass  string   // The passphrase to use in conjuction with the associated key of a certificate uploaded to the app registration portal."
-	Graph          string   // The Microsoft Graph URL
-	JWT            string   // The JSON web token that will be used to authenticate requests sent to Azure APIs
-	Management     string   // The Azure ResourceManager URL
-	MgmtGroupId    []string // The Management Group Id to use as a filter
-	Password       string   // The password associated with the user principal name associated with the Azure portal.
-	ProxyUrl       string   // The forward proxy url
-	RefreshToken   string   // The refresh token that will be used to authenticate requests sent to Azure APIs
-	Region         string   // The region of the Azure Cloud deployment.
-	SubscriptionId []string // The Subscription Id(s) to use as a filter
-	Tenant         string   // The directory tenant that you want to request permission from. This can be in GUID or friendly name format
-	Username       string   // The user principal name associated with the Azure portal.
+	ApplicationId      string   // The Application Id that the  Azure app registration portal assigned when the app was registered.
+	Authority          string   // The Azure ActiveDirectory Authority URL
+	ClientSecret       string   // The Application Secret that was generated for the app in the app registration portal.
+	ClientCert         string   // The certificate uploaded to the app registration portal."
+	ClientKey          string   // The key for a certificate uploaded to the app registration portal."
+	ClientKeyPass      string   // The passphrase to use in conjuction with the associated key of a certificate uploaded to the app registration portal."
+	DeviceCode         bool     // Whether to authenticate interactively using the OAuth2 device authorization grant
+	FederatedTokenFile string   // The path to a federated token (e.g. Kubernetes workload identity) to use as the client assertion
+	Graph              string   // The Microsoft Graph URL
+	JWT                string   // The JSON web token that will be used to authenticate requests sent to Azure APIs
+	Management         string   // The Azure ResourceManager URL
+	ManagedIdentity    bool     // Whether to authenticate using the managed identity of the host
+	ManagedIdentityId  string   // The client ID of a user-assigned managed identity; the system-assigned identity is used when empty
+	MgmtGroupId        []string // The Management Group Id to use as a filter
+	Password           string   // The password associated with the user principal name associated with the Azure portal.
+	ProxyUrl           string   // The forward proxy url
+	RefreshToken       string   // The refresh token that will be used to authenticate requests sent to Azure APIs
+	Region             string   // The region of the Azure Cloud deployment.
+	SubscriptionId     []string // The Subscription Id(s) to use as a filter
+	Tenant             string   // The directory tenant that you want to request permission from. This can be in GUID or friendly name format
+	TokenCacheFile     string   // The path to the encrypted token cache; tokens are not persisted when empty
+	TokenCacheKey      string   // The passphrase (or key file contents) used to encrypt the token cache
+	Username           string   // The user principal name associated with the Azure portal.
+}
+
+// HasCredential reports whether the config holds a credential capable of acquiring tokens for any audience
+func (s Config) HasCredential() bool {
+	return s.RefreshToken != "" ||
+		s.ClientSecret != "" ||
+		(s.ClientCert != "" && s.ClientKey != "") ||
+		s.FederatedTokenFile != "" ||
+		s.DeviceCode ||
+		s.ManagedIdentity ||
+		(s.Username != "" && s.Password != "")
 }
 
 func AuthorityUrl(region string, defaultUrl string) string {
@@ -0,0 +1,177 @@
+// Copyright (C) 2024 Specter Ops, Inc.
+//
+// This file is part of AzureHound.
+//
+// AzureHound is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// AzureHound is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/bloodhoundad/azurehound/v2/client/query"
+	"github.com/bloodhoundad/azurehound/v2/client/rest"
+	"github.com/bloodhoundad/azurehound/v2/constants"
+	"github.com/bloodhoundad/azurehound/v2/metrics"
+	"github.com/bloodhoundad/azurehound/v2/models/azure"
+	"github.com/bloodhoundad/azurehound/v2/panicrecovery"
+	"github.com/bloodhoundad/azurehound/v2/pipeline"
+	"github.com/bloodhoundad/azurehound/v2/tracing"
+)
+
+// Delta is an object returned by a delta query. Removed objects carry only their ID and updated objects may carry only
+// the properties that changed. The final result of a successful delta query carries only the delta link with which to
+// request subsequent changes.
+type Delta[T any] struct {
+	Object     T
+	Properties map[string]bool // The properties returned for the object
+	Removed    bool
+	DeltaLink  string
+}
+
+// Has returns whether the property was returned for the object, as opposed to left at its zero value
+func (s Delta[T]) Has(property string) bool {
+	return s.Properties[property]
+}
+
+// ListAzureADUsersDelta https://learn.microsoft.com/en-us/graph/api/user-delta?view=graph-rest-1.0
+func (s *azureClient) ListAzureADUsersDelta(ctx context.Context, deltaLink string, params query.GraphParams) <-chan AzureResult[Delta[azure.User]] {
+	out := make(chan AzureResult[Delta[azure.User]])
+	go getAzureObjectDelta[azure.User](s.msgraph, ctx, fmt.Sprintf("/%s/users/delta", constants.GraphApiVersion), deltaLink, params, out)
+	return out
+}
+
+// ListAzureADGroupsDelta https://learn.microsoft.com/en-us/graph/api/group-delta?view=graph-rest-1.0
+func (s *azureClient) ListAzureADGroupsDelta(ctx context.Context, deltaLink string, params query.GraphParams) <-chan AzureResult[Delta[azure.Group]] {
+	out := make(chan AzureResult[Delta[azure.Group]])
+	go getAzureObjectDelta[azure.Group](s.msgraph, ctx, fmt.Sprintf("/%s/groups/delta", constants.GraphApiVersion), deltaLink, params, out)
+	return out
+}
+
+// ListAzureADServicePrincipalsDelta https://learn.microsoft.com/en-us/graph/api/serviceprincipal-delta?view=graph-rest-1.0
+func (s *azureClient) ListAzureADServicePrincipalsDelta(ctx context.Context, deltaLink string, params query.GraphParams) <-chan AzureResult[Delta[azure.ServicePrincipal]] {
+	out := make(chan AzureResult[Delta[azure.ServicePrincipal]])
+	go getAzureObjectDelta[azure.ServicePrincipal](s.msgraph, ctx, fmt.Sprintf("/%s/servicePrincipals/delta", constants.GraphApiVersion), deltaLink, params, out)
+	return out
+}
+
+// ListAzureADAppsDelta https://learn.microsoft.com/en-us/graph/api/application-delta?view=graph-rest-1.0
+func (s *azureClient) ListAzureADAppsDelta(ctx context.Context, deltaLink string, params query.GraphParams) <-chan AzureResult[Delta[azure.Application]] {
+	out := make(chan AzureResult[Delta[azure.Application]])
+	go getAzureObjectDelta[azure.Application](s.msgraph, ctx, fmt.Sprintf("/%s/applications/delta", constants.GraphApiVersion), deltaLink, params, out)
+	return out
+}
+
+// ListAzureDevicesDelta https://learn.microsoft.com/en-us/graph/api/device-delta?view=graph-rest-1.0
+func (s *azureClient) ListAzureDevicesDelta(ctx context.Context, deltaLink string, params query.GraphParams) <-chan AzureResult[Delta[azure.Device]] {
+	out := make(chan AzureResult[Delta[azure.Device]])
+	go getAzureObjectDelta[azure.Device](s.msgraph, ctx, fmt.Sprintf("/%s/devices/delta", constants.GraphApiVersion), deltaLink, params, out)
+	return out
+}
+
+// getAzureObjectDelta follows a delta query from the given delta link, or from the start when there is none, to its
+// end. If the delta link has expired the delta query is started over, enumerating every object again.
+func getAzureObjectDelta[T any](client rest.RestClient, ctx context.Context, path string, deltaLink string, params query.Params, out chan AzureResult[Delta[T]]) {
+	defer panicrecovery.PanicRecovery()
+	defer close(out)
+
+	var (
+		errResult AzureResult[Delta[T]]
+		nextLink  = deltaLink
+	)
+	defer func() {
+		if errResult.Error != nil {
+			// whatever was left to list is lost to the error
+			metrics.StageFromContext(ctx).Error()
+			tracing.SpanFromContext(ctx).SetError(errResult.Error)
+		}
+	}()
+
+	for {
+		var (
+			page struct {
+				NextLink  string            `json:"@odata.nextLink,omitempty"`
+				DeltaLink string            `json:"@odata.deltaLink,omitempty"`
+				Value     []json.RawMessage `json:"value"`
+			}
+			res *http.Response
+			err error
+		)
+
+		if nextLink != "" {
+			// the delta and next links already carry the query parameters of the initial request
+			if nextUrl, parseErr := url.Parse(nextLink); parseErr != nil {
+				errResult.Error = parseErr
+				_ = pipeline.Send(ctx.Done(), out, errResult)
+				return
+			} else if req, reqErr := rest.NewRequest(ctx, "GET", nextUrl, nil, nil, nil); reqErr != nil {
+				errResult.Error = reqErr
+				_ = pipeline.Send(ctx.Done(), out, errResult)
+				return
+			} else {
+				res, err = client.Send(req)
+			}
+		} else {
+			res, err = client.Get(ctx, path, params, nil)
+		}
+
+		var errRes *rest.ErrorResponse
+		if errors.As(err, &errRes) && errRes.StatusCode == http.StatusGone && deltaLink != "" {
+			// the delta link has expired; start over
+			deltaLink, nextLink = "", ""
+			continue
+		} else if err != nil {
+			errResult.Error = err
+			_ = pipeline.Send(ctx.Done(), out, errResult)
+			return
+		} else if err := rest.Decode(res.Body, &page); err != nil {
+			errResult.Error = err
+			_ = pipeline.Send(ctx.Done(), out, errResult)
+			return
+		}
+
+		for _, raw := range page.Value {
+			var (
+				result     AzureResult[Delta[T]]
+				properties map[string]json.RawMessage
+			)
+			if err := json.Unmarshal(raw, &result.Ok.Object); err != nil {
+				result.Error = err
+			} else if err := json.Unmarshal(raw, &properties); err != nil {
+				result.Error = err
+			} else {
+				_, result.Ok.Removed = properties["@removed"]
+				result.Ok.Properties = make(map[string]bool, len(properties))
+				for property := range properties {
+					result.Ok.Properties[property] = true
+				}
+			}
+			if ok := pipeline.Send(ctx.Done(), out, result); !ok || result.Error != nil {
+				return
+			}
+		}
+
+		if page.NextLink != "" {
+			nextLink = page.NextLink
+		} else {
+			_ = pipeline.Send(ctx.Done(), out, AzureResult[Delta[T]]{Ok: Delta[T]{DeltaLink: page.DeltaLink}})
+			return
+		}
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright (C) 2024 Specter Ops, Inc.
+//
+// This file is part of AzureHound.
+//
+// AzureHound is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// AzureHound is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bloodhoundad/azurehound/v2/client/config"
+	"github.com/bloodhoundad/azurehound/v2/client/query"
+	"github.com/bloodhoundad/azurehound/v2/client/rest"
+)
+
+func TestListAzureADUsersDelta(t *testing.T) {
+	var testServer *httptest.Server
+
+	var mockHandler http.HandlerFunc = func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if strings.HasSuffix(r.URL.Path, "/oauth2/v2.0/token") {
+			w.Write([]byte(`{"access_token":"access","expires_in":3600}`))
+		} else if r.URL.Query().Get("$deltatoken") == "expired" {
+			w.WriteHeader(http.StatusGone)
+			w.Write([]byte(`{"error":{"code":"syncStateNotFound"}}`))
+		} else if r.URL.Query().Get("$deltatoken") == "latest" {
+			fmt.Fprintf(w, `{"value":[{"id":"changed","displayName":"Changed"},{"id":"removed","@removed":{"reason":"changed"}}],"@odata.deltaLink":"%s/v1.0/users/delta?$deltatoken=next"}`, testServer.URL)
+		} else if r.URL.Query().Get("$skiptoken") == "2" {
+			fmt.Fprintf(w, `{"value":[{"id":"user2"}],"@odata.deltaLink":"%s/v1.0/users/delta?$deltatoken=latest"}`, testServer.URL)
+		} else if r.URL.Path == "/v1.0/users/delta" {
+			fmt.Fprintf(w, `{"value":[{"id":"user1"}],"@odata.nextLink":"%s/v1.0/users/delta?$skiptoken=2"}`, testServer.URL)
+		} else {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}
+
+	testServer = httptest.NewServer(mockHandler)
+	defer testServer.Close()
+
+	msgraph, err := rest.NewRestClient(testServer.URL, config.Config{
+		Authority:     testServer.URL,
+		ApplicationId: "foo",
+		ClientSecret:  "bar",
+	})
+	if err != nil {
+		t.Fatalf("error initializing rest client %v", err)
+	}
+	client := &azureClient{msgraph: msgraph}
+
+	collect := func(deltaLink string) ([]string, string) {
+		var (
+			ids  []string
+			link string
+		)
+		for item := range client.ListAzureADUsersDelta(context.Background(), deltaLink, query.GraphParams{}) {
+			if item.Error != nil {
+				t.Fatalf("unexpected error: %v", item.Error)
+			} else if item.Ok.DeltaLink != "" {
+				link = item.Ok.DeltaLink
+			} else if item.Ok.Removed {
+				ids = append(ids, "-"+item.Ok.Object.Id)
+			} else {
+				ids = append(ids, item.Ok.Object.Id)
+				if item.Ok.Object.Id == "changed" && (!item.Ok.Has("displayName") || item.Ok.Has("mail")) {
+					t.Errorf("got properties %v, want only those returned", item.Ok.Properties)
+				}
+			}
+		}
+		return ids, link
+	}
+
+	// Initial sync follows the next link to a delta link
+	if ids, link := collect(""); strings.Join(ids, ",") != "user1,user2" {
+		t.Errorf("got %v, want every user", ids)
+	} else if link != testServer.URL+"/v1.0/users/delta?$deltatoken=latest" {
+		t.Errorf("got delta link %s", link)
+	}
+
+	// Subsequent sync returns changes and removals
+	if ids, link := collect(testServer.URL + "/v1.0/users/delta?$deltatoken=latest"); strings.Join(ids, ",") != "changed,-removed" {
+		t.Errorf("got %v, want changes only", ids)
+	} else if link != testServer.URL+"/v1.0/users/delta?$deltatoken=next" {
+		t.Errorf("got delta link %s", link)
+	}
+
+	// An expired delta link starts over
+	if ids, link := collect(testServer.URL + "/v1.0/users/delta?$deltatoken=expired"); strings.Join(ids, ",") != "user1,user2" {
+		t.Errorf("got %v, want every user", ids)
+	} else if link != testServer.URL+"/v1.0/users/delta?$deltatoken=latest" {
+		t.Errorf("got delta link %s", link)
+	}
+}
@@ -50,7 +50,7 @@ func (s *azureClient) ListAzureDeviceRegisteredOwners(ctx context.Context, objec
 		path = fmt.Sprintf("/%s/devices/%s/registeredOwners", constants.GraphApiBetaVersion, objectId)
 	)
 
-	go getAzureObjectList[json.RawMessage](s.msgraph, ctx, path, params, out)
+	go getAzureObjectList[json.RawMessage](s.msgraphBatch, ctx, path, params, out)
 
 	return out
 }
@@ -54,7 +54,7 @@ func (s *azureClient) ListAzureADGroupOwners(ctx context.Context, objectId strin
 		params.Top = 99
 	}
 
-	go getAzureObjectList[json.RawMessage](s.msgraph, ctx, path, params, out)
+	go getAzureObjectList[json.RawMessage](s.msgraphBatch, ctx, path, params, out)
 
 	return out
 }
@@ -66,7 +66,7 @@ func (s *azureClient) ListAzureADGroupMembers(ctx context.Context, objectId stri
 		path = fmt.Sprintf("/%s/groups/%s/members", constants.GraphApiBetaVersion, objectId)
 	)
 
-	go getAzureObjectList[json.RawMessage](s.msgraph, ctx, path, params, out)
+	go getAzureObjectList[json.RawMessage](s.msgraphBatch, ctx, path, params, out)
 
 	return out
 }
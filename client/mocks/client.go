@@ -11,6 +11,7 @@ import (
 
 	"github.com/bloodhoundad/azurehound/v2/client"
 	"github.com/bloodhoundad/azurehound/v2/client/query"
+	"github.com/bloodhoundad/azurehound/v2/client/rest"
 	"github.com/bloodhoundad/azurehound/v2/models/azure"
 	"go.uber.org/mock/gomock"
 )
@@ -122,6 +123,20 @@ func (mr *MockAzureClientMockRecorder) ListAzureADApps(arg0, arg1 interface{}) *
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListAzureADApps", reflect.TypeOf((*MockAzureClient)(nil).ListAzureADApps), arg0, arg1)
 }
 
+// ListAzureADAppsDelta mocks base method.
+func (m *MockAzureClient) ListAzureADAppsDelta(arg0 context.Context, arg1 string, arg2 query.GraphParams) <-chan client.AzureResult[client.Delta[azure.Application]] {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListAzureADAppsDelta", arg0, arg1, arg2)
+	ret0, _ := ret[0].(<-chan client.AzureResult[client.Delta[azure.Application]])
+	return ret0
+}
+
+// ListAzureADAppsDelta indicates an expected call of ListAzureADAppsDelta.
+func (mr *MockAzureClientMockRecorder) ListAzureADAppsDelta(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListAzureADAppsDelta", reflect.TypeOf((*MockAzureClient)(nil).ListAzureADAppsDelta), arg0, arg1, arg2)
+}
+
 // ListAzureADGroupMembers mocks base method.
 func (m *MockAzureClient) ListAzureADGroupMembers(arg0 context.Context, arg1 string, arg2 query.GraphParams) <-chan client.AzureResult[json.RawMessage] {
 	m.ctrl.T.Helper()
@@ -164,6 +179,20 @@ func (mr *MockAzureClientMockRecorder) ListAzureADGroups(arg0, arg1 interface{})
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListAzureADGroups", reflect.TypeOf((*MockAzureClient)(nil).ListAzureADGroups), arg0, arg1)
 }
 
+// ListAzureADGroupsDelta mocks base method.
+func (m *MockAzureClient) ListAzureADGroupsDelta(arg0 context.Context, arg1 string, arg2 query.GraphParams) <-chan client.AzureResult[client.Delta[azure.Group]] {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListAzureADGroupsDelta", arg0, arg1, arg2)
+	ret0, _ := ret[0].(<-chan client.AzureResult[client.Delta[azure.Group]])
+	return ret0
+}
+
+// ListAzureADGroupsDelta indicates an expected call of ListAzureADGroupsDelta.
+func (mr *MockAzureClientMockRecorder) ListAzureADGroupsDelta(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListAzureADGroupsDelta", reflect.TypeOf((*MockAzureClient)(nil).ListAzureADGroupsDelta), arg0, arg1, arg2)
+}
+
 // ListAzureADRoleAssignments mocks base method.
 func (m *MockAzureClient) ListAzureADRoleAssignments(arg0 context.Context, arg1 query.GraphParams) <-chan client.AzureResult[azure.UnifiedRoleAssignment] {
 	m.ctrl.T.Helper()
@@ -220,6 +249,20 @@ func (mr *MockAzureClientMockRecorder) ListAzureADServicePrincipals(arg0, arg1 i
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListAzureADServicePrincipals", reflect.TypeOf((*MockAzureClient)(nil).ListAzureADServicePrincipals), arg0, arg1)
 }
 
+// ListAzureADServicePrincipalsDelta mocks base method.
+func (m *MockAzureClient) ListAzureADServicePrincipalsDelta(arg0 context.Context, arg1 string, arg2 query.GraphParams) <-chan client.AzureResult[client.Delta[azure.ServicePrincipal]] {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListAzureADServicePrincipalsDelta", arg0, arg1, arg2)
+	ret0, _ := ret[0].(<-chan client.AzureResult[client.Delta[azure.ServicePrincipal]])
+	return ret0
+}
+
+// ListAzureADServicePrincipalsDelta indicates an expected call of ListAzureADServicePrincipalsDelta.
+func (mr *MockAzureClientMockRecorder) ListAzureADServicePrincipalsDelta(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListAzureADServicePrincipalsDelta", reflect.TypeOf((*MockAzureClient)(nil).ListAzureADServicePrincipalsDelta), arg0, arg1, arg2)
+}
+
 // ListAzureADTenants mocks base method.
 func (m *MockAzureClient) ListAzureADTenants(arg0 context.Context, arg1 bool) <-chan client.AzureResult[azure.Tenant] {
 	m.ctrl.T.Helper()
@@ -248,6 +291,20 @@ func (mr *MockAzureClientMockRecorder) ListAzureADUsers(arg0, arg1 interface{})
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListAzureADUsers", reflect.TypeOf((*MockAzureClient)(nil).ListAzureADUsers), arg0, arg1)
 }
 
+// ListAzureADUsersDelta mocks base method.
+func (m *MockAzureClient) ListAzureADUsersDelta(arg0 context.Context, arg1 string, arg2 query.GraphParams) <-chan client.AzureResult[client.Delta[azure.User]] {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListAzureADUsersDelta", arg0, arg1, arg2)
+	ret0, _ := ret[0].(<-chan client.AzureResult[client.Delta[azure.User]])
+	return ret0
+}
+
+// ListAzureADUsersDelta indicates an expected call of ListAzureADUsersDelta.
+func (mr *MockAzureClientMockRecorder) ListAzureADUsersDelta(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListAzureADUsersDelta", reflect.TypeOf((*MockAzureClient)(nil).ListAzureADUsersDelta), arg0, arg1, arg2)
+}
+
 // ListAzureAutomationAccounts mocks base method.
 func (m *MockAzureClient) ListAzureAutomationAccounts(arg0 context.Context, arg1 string) <-chan client.AzureResult[azure.AutomationAccount] {
 	m.ctrl.T.Helper()
@@ -304,6 +361,20 @@ func (mr *MockAzureClientMockRecorder) ListAzureDevices(arg0, arg1 interface{})
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListAzureDevices", reflect.TypeOf((*MockAzureClient)(nil).ListAzureDevices), arg0, arg1)
 }
 
+// ListAzureDevicesDelta mocks base method.
+func (m *MockAzureClient) ListAzureDevicesDelta(arg0 context.Context, arg1 string, arg2 query.GraphParams) <-chan client.AzureResult[client.Delta[azure.Device]] {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListAzureDevicesDelta", arg0, arg1, arg2)
+	ret0, _ := ret[0].(<-chan client.AzureResult[client.Delta[azure.Device]])
+	return ret0
+}
+
+// ListAzureDevicesDelta indicates an expected call of ListAzureDevicesDelta.
+func (mr *MockAzureClientMockRecorder) ListAzureDevicesDelta(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListAzureDevicesDelta", reflect.TypeOf((*MockAzureClient)(nil).ListAzureDevicesDelta), arg0, arg1, arg2)
+}
+
 // ListAzureFunctionApps mocks base method.
 func (m *MockAzureClient) ListAzureFunctionApps(arg0 context.Context, arg1 string) <-chan client.AzureResult[azure.FunctionApp] {
 	m.ctrl.T.Helper()
@@ -513,3 +584,17 @@ func (mr *MockAzureClientMockRecorder) TenantInfo() *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TenantInfo", reflect.TypeOf((*MockAzureClient)(nil).TenantInfo))
 }
+
+// TokenBroker mocks base method.
+func (m *MockAzureClient) TokenBroker() *rest.TokenBroker {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "TokenBroker")
+	ret0, _ := ret[0].(*rest.TokenBroker)
+	return ret0
+}
+
+// TokenBroker indicates an expected call of TokenBroker.
+func (mr *MockAzureClientMockRecorder) TokenBroker() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TokenBroker", reflect.TypeOf((*MockAzureClient)(nil).TokenBroker))
+}
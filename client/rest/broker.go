@@ -0,0 +1,477 @@
+// Copyright (C) 2024 Specter Ops, Inc.
+//
+// This file is part of AzureHound.
+//
+// AzureHound is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// AzureHound is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bloodhoundad/azurehound/v2/client/config"
+	"github.com/bloodhoundad/azurehound/v2/constants"
+	"github.com/bloodhoundad/azurehound/v2/metrics"
+)
+
+// TokenBroker exchanges a single primary credential for access tokens to any number of audiences (e.g. Microsoft
+// Graph and Azure Resource Manager) and caches the resulting tokens per audience. Rest clients for different APIs
+// share a broker so that interactive sign in happens once and rotated refresh tokens are visible to every audience.
+type TokenBroker struct {
+	authUrl            url.URL
+	clientId           string
+	clientSecret       string
+	clientCert         string
+	clientKey          string
+	clientKeyPass      string
+	username           string
+	password           string
+	refreshToken       string
+	tenant             string
+	deviceCode         bool
+	managedIdentity    bool
+	managedIdentityId  string
+	federatedTokenFile string
+	http               *http.Client
+	retry              RetryPolicy
+	credential         bool
+	mutex              sync.Mutex // guards tokens and renewals
+	tokens             map[string]Token
+	renewals           map[string]*tokenRenewal
+	authMutex          sync.Mutex // serializes token requests since they share the refresh token
+	cache              *TokenCache
+	cacheError         func(err error) // handles failures to read or write the cache, if set
+	cachedRefresh      bool            // whether refreshToken was loaded from the cache rather than provided
+}
+
+// tokenRenewal tracks the token requests made for an audience
+type tokenRenewal struct {
+	call     *tokenCall // the request in flight, if any
+	failures int        // the number of consecutive failed requests
+	err      error      // the error of the last failed request
+	retryAt  time.Time  // when to make another request after a failure
+	timer    *time.Timer
+}
+
+// tokenCall is a single token request shared by every caller waiting on it
+type tokenCall struct {
+	claims string
+	done   chan struct{}
+	token  Token
+	err    error
+}
+
+func (s *tokenCall) wait() (Token, error) {
+	<-s.done
+	return s.token, s.err
+}
+
+// errSignInRequired is returned by background renewals that cannot proceed without prompting the user to sign in
+var errSignInRequired = errors.New("the cached refresh token was rejected and signing in again requires a foreground request")
+
+const (
+	tokenRenewalMinBackoff = time.Second
+	tokenRenewalMaxBackoff = time.Minute
+)
+
+// tokenRenewalBackoff returns how long to wait before requesting a token again after consecutive failures
+func tokenRenewalBackoff(failures int) time.Duration {
+	backoff := tokenRenewalMinBackoff
+	for i := 1; i < failures && backoff < tokenRenewalMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > tokenRenewalMaxBackoff {
+		backoff = tokenRenewalMaxBackoff
+	}
+	return backoff
+}
+
+func NewTokenBroker(config config.Config) (*TokenBroker, error) {
+	if auth, err := url.Parse(config.AuthorityUrl()); err != nil {
+		return nil, err
+	} else if http, err := NewHTTPClient(config.ProxyUrl); err != nil {
+		return nil, err
+	} else if retry, err := NewRetryPolicy(); err != nil {
+		return nil, err
+	} else {
+		broker := &TokenBroker{
+			authUrl:            *auth,
+			clientId:           config.ApplicationId,
+			clientSecret:       config.ClientSecret,
+			clientCert:         config.ClientCert,
+			clientKey:          config.ClientKey,
+			clientKeyPass:      config.ClientKeyPass,
+			username:           config.Username,
+			password:           config.Password,
+			refreshToken:       config.RefreshToken,
+			tenant:             config.Tenant,
+			deviceCode:         config.DeviceCode,
+			managedIdentity:    config.ManagedIdentity,
+			managedIdentityId:  config.ManagedIdentityId,
+			federatedTokenFile: config.FederatedTokenFile,
+			http:               http,
+			retry:              retry,
+			tokens:             make(map[string]Token),
+			renewals:           make(map[string]*tokenRenewal),
+		}
+
+		broker.credential = config.HasCredential()
+
+		if config.TokenCacheFile != "" {
+			if cache, err := SharedTokenCache(config.TokenCacheFile, []byte(config.TokenCacheKey)); err != nil {
+				return nil, err
+			} else if _, _, err := cache.Load(""); err != nil {
+				// fail fast on a wrong passphrase or corrupt cache rather than on the first request
+				return nil, err
+			} else {
+				broker.cache = cache
+			}
+		}
+
+		// An acquired JWT is only good for the audience it was issued to; any other audience requires a credential
+		if config.JWT != "" {
+			if aud, err := ParseAud(config.JWT); err != nil {
+				return nil, err
+			} else {
+				broker.tokens[aud] = newTokenFromJWT(config.JWT)
+			}
+		}
+		return broker, nil
+	}
+}
+
+// OnCacheError sets the handler of failures to read or write the token cache. These never fail a token request; the
+// token is acquired anew or simply not persisted. Must be called before the broker is used.
+func (s *TokenBroker) OnCacheError(handler func(err error)) {
+	s.cacheError = handler
+}
+
+// HasCredential reports whether the broker holds a credential capable of acquiring tokens for any audience.
+func (s *TokenBroker) HasCredential() bool {
+	return s.credential
+}
+
+// Token returns a cached, unexpired access token for the given resource, acquiring a new one if needed. Concurrent
+// callers share a single token request and tokens past their renewal time are renewed in the background.
+func (s *TokenBroker) Token(resource url.URL) (Token, error) {
+	s.mutex.Lock()
+
+	if token, ok := s.tokens[audience(resource)]; ok && (!token.IsExpired() || !s.HasCredential()) {
+		if s.HasCredential() && time.Now().After(token.renewAt()) {
+			s.renew(resource, "", false, false)
+		}
+		s.mutex.Unlock()
+		return token, nil
+	} else if !s.HasCredential() {
+		s.mutex.Unlock()
+		if len(s.tokens) > 0 {
+			return Token{}, fmt.Errorf("invalid audience")
+		} else {
+			return Token{}, fmt.Errorf("unable to authenticate. no valid credential provided")
+		}
+	} else {
+		call := s.renew(resource, "", false, true)
+		s.mutex.Unlock()
+		return call.wait()
+	}
+}
+
+// Authenticate acquires a new access token for the given resource regardless of what is cached.
+func (s *TokenBroker) Authenticate(resource url.URL) error {
+	s.mutex.Lock()
+
+	if !s.HasCredential() {
+		_, ok := s.tokens[audience(resource)]
+		s.mutex.Unlock()
+		if ok {
+			return fmt.Errorf("unable to authenticate. the provided JWT has expired")
+		} else {
+			return fmt.Errorf("unable to authenticate. no valid credential provided")
+		}
+	} else {
+		call := s.renew(resource, "", true, true)
+		s.mutex.Unlock()
+		_, err := call.wait()
+		return err
+	}
+}
+
+// Refresh acquires a new access token for the given resource after the API rejected the given token, including the
+// claims of a claims challenge, if any, in the token request. If the token has already been replaced the replacement
+// is returned instead.
+func (s *TokenBroker) Refresh(resource url.URL, rejected Token, claims string) (Token, error) {
+	s.mutex.Lock()
+
+	if token, ok := s.tokens[audience(resource)]; ok && token.accessToken != rejected.accessToken && !token.IsExpired() {
+		s.mutex.Unlock()
+		return token, nil
+	} else if !s.HasCredential() {
+		s.mutex.Unlock()
+		return Token{}, fmt.Errorf("unable to authenticate. no valid credential provided")
+	} else {
+		call := s.renew(resource, claims, true, true)
+		s.mutex.Unlock()
+		return call.wait()
+	}
+}
+
+// renew starts acquiring a new token for the resource unless an identical request is already in flight, in which case
+// that request is joined. Unless forced, no request is made while backing off from a failed request, except for a
+// foreground request after a background renewal required sign in. Only foreground requests, which a caller waits on,
+// may sign in interactively. Must be called while holding the mutex.
+func (s *TokenBroker) renew(resource url.URL, claims string, force bool, foreground bool) *tokenCall {
+	aud := audience(resource)
+	renewal, ok := s.renewals[aud]
+	if !ok {
+		renewal = &tokenRenewal{}
+		s.renewals[aud] = renewal
+	}
+
+	if renewal.call != nil && renewal.call.claims == claims {
+		return renewal.call
+	} else if !force && claims == "" && time.Now().Before(renewal.retryAt) && !(foreground && errors.Is(renewal.err, errSignInRequired)) {
+		call := &tokenCall{done: make(chan struct{}), err: renewal.err}
+		close(call.done)
+		return call
+	}
+
+	call := &tokenCall{claims: claims, done: make(chan struct{})}
+	renewal.call = call
+
+	go func() {
+		s.authMutex.Lock()
+		token, err := s.fetch(resource, claims, force, foreground)
+		s.authMutex.Unlock()
+
+		s.mutex.Lock()
+		if renewal.call == call {
+			renewal.call = nil
+		}
+		if err != nil {
+			metrics.TokenRefreshFailed()
+			renewal.failures++
+			renewal.err = err
+			renewal.retryAt = time.Now().Add(tokenRenewalBackoff(renewal.failures))
+			if current, ok := s.tokens[aud]; ok && !current.IsExpired() {
+				// keep trying in the background while the current token remains valid
+				s.schedule(resource, renewal, renewal.retryAt)
+			}
+		} else {
+			renewal.failures = 0
+			renewal.err = nil
+			renewal.retryAt = time.Time{}
+			s.tokens[aud] = token
+			s.schedule(resource, renewal, token.renewAt())
+		}
+		s.mutex.Unlock()
+
+		call.token, call.err = token, err
+		close(call.done)
+	}()
+
+	return call
+}
+
+// schedule renews the resource's token in the background at the given time. Must be called while holding the mutex.
+func (s *TokenBroker) schedule(resource url.URL, renewal *tokenRenewal, at time.Time) {
+	if renewal.timer != nil {
+		renewal.timer.Stop()
+	}
+	renewal.timer = time.AfterFunc(time.Until(at), func() {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		s.renew(resource, "", false, false)
+	})
+}
+
+// fetch returns an unexpired token from the token cache that is not yet due for renewal or else acquires a new one.
+// Must be called while holding the authMutex.
+func (s *TokenBroker) fetch(resource url.URL, claims string, force bool, foreground bool) (Token, error) {
+	if token, ok := s.loadCached(resource); ok && !force && claims == "" && time.Now().Before(token.renewAt()) {
+		return token, nil
+	} else {
+		return s.acquire(resource, claims, foreground)
+	}
+}
+
+// acquire requests a new access token from the authority. The claims requested by a claims challenge, if any, are
+// included in the token request. Must be called while holding the authMutex.
+func (s *TokenBroker) acquire(resource url.URL, challenge string, foreground bool) (Token, error) {
+	claims, err := tokenRequestClaims(challenge)
+	if err != nil {
+		return Token{}, err
+	}
+
+	var (
+		path     = url.URL{Path: fmt.Sprintf("/%s/oauth2/v2.0/token", s.tenant)}
+		endpoint = s.authUrl.ResolveReference(&path)
+		scope    = audience(resource) + "/.default"
+		body     = url.Values{}
+		token    Token
+	)
+
+	if s.clientId == "" {
+		body.Add("client_id", constants.AzPowerShellClientID)
+	} else {
+		body.Add("client_id", s.clientId)
+	}
+
+	body.Add("scope", scope)
+	body.Add("claims", claims)
+
+	if s.refreshToken != "" {
+		body.Add("grant_type", "refresh_token")
+		body.Add("refresh_token", s.refreshToken)
+		body.Set("client_id", constants.AzPowerShellClientID)
+	} else if s.clientSecret != "" {
+		body.Add("grant_type", "client_credentials")
+		body.Add("client_secret", s.clientSecret)
+	} else if s.clientCert != "" && s.clientKey != "" {
+		if clientAssertion, err := NewClientAssertion(endpoint.String(), s.clientId, s.clientCert, s.clientKey, s.clientKeyPass); err != nil {
+			return token, err
+		} else {
+			body.Add("grant_type", "client_credentials")
+			body.Add("client_assertion_type", "urn:ietf:params:oauth:client-assertion-type:jwt-bearer")
+			body.Add("client_assertion", clientAssertion)
+		}
+	} else if s.federatedTokenFile != "" {
+		if clientAssertion, err := ReadClientAssertion(s.federatedTokenFile); err != nil {
+			return token, err
+		} else {
+			body.Add("grant_type", "client_credentials")
+			body.Add("client_assertion_type", "urn:ietf:params:oauth:client-assertion-type:jwt-bearer")
+			body.Add("client_assertion", clientAssertion)
+		}
+	} else if s.deviceCode {
+		if token, err := s.deviceCodeLogin(context.Background(), resource, claims, os.Stderr); err != nil {
+			return token, err
+		} else {
+			// later audiences are redeemed from the refresh token without prompting the user again
+			s.refreshToken = token.refreshToken
+			s.storeCached(resource, token)
+			return token, nil
+		}
+	} else if s.managedIdentity {
+		if token, err := s.managedIdentityLogin(context.Background(), resource); err != nil {
+			return token, err
+		} else {
+			s.storeCached(resource, token)
+			return token, nil
+		}
+	} else if s.username != "" && s.password != "" {
+		body.Add("grant_type", "password")
+		body.Add("username", s.username)
+		body.Add("password", s.password)
+		body.Set("client_id", constants.AzPowerShellClientID)
+	}
+
+	if req, err := NewRequest(context.Background(), "POST", endpoint, body, nil, nil); err != nil {
+		return token, err
+	} else if res, err := send(s.http, req, s.retry); err != nil {
+		if s.cachedRefresh && s.deviceCode && !foreground {
+			// signing in again would prompt the user from a background renewal; leave that to the next request
+			return token, fmt.Errorf("%w: %w", errSignInRequired, err)
+		} else if s.cachedRefresh {
+			// the cached refresh token may have been revoked or expired; fall back to the primary credential
+			s.refreshToken = ""
+			s.cachedRefresh = false
+			return s.acquire(resource, challenge, foreground)
+		}
+		return token, err
+	} else {
+		defer res.Body.Close()
+		if err := json.NewDecoder(res.Body).Decode(&token); err != nil {
+			return token, err
+		} else {
+			// keep the latest refresh token since the authority may rotate it on redemption
+			if s.refreshToken != "" && token.refreshToken != "" {
+				s.refreshToken = token.refreshToken
+			}
+			s.storeCached(resource, token)
+			return token, nil
+		}
+	}
+}
+
+func (s *TokenBroker) cacheKey(resource url.URL) string {
+	clientId := s.clientId
+	if clientId == "" {
+		clientId = constants.AzPowerShellClientID
+	}
+	return TokenCacheKey(s.tenant, clientId, audience(resource))
+}
+
+// loadCached returns the persisted token for the resource, if any, and adopts its refresh token when the broker's
+// credential requires interactive sign in. Must be called while holding the authMutex.
+func (s *TokenBroker) loadCached(resource url.URL) (Token, bool) {
+	if s.cache == nil {
+		return Token{}, false
+	} else if token, ok, err := s.cache.Load(s.cacheKey(resource)); err != nil {
+		s.reportCacheError(fmt.Errorf("unable to read token cache: %w", err))
+		return Token{}, false
+	} else if !ok {
+		return Token{}, false
+	} else {
+		if s.refreshToken == "" && token.refreshToken != "" && (s.deviceCode || (s.username != "" && s.password != "")) {
+			s.refreshToken = token.refreshToken
+			s.cachedRefresh = true
+		}
+		return token, true
+	}
+}
+
+// storeCached persists the token, along with the current refresh token, for the resource. Must be called while
+// holding the authMutex.
+func (s *TokenBroker) storeCached(resource url.URL, token Token) {
+	if s.cache != nil {
+		token.refreshToken = s.refreshToken
+		if err := s.cache.Store(s.cacheKey(resource), token); err != nil {
+			s.reportCacheError(fmt.Errorf("unable to write token cache: %w", err))
+		}
+	}
+}
+
+func (s *TokenBroker) reportCacheError(err error) {
+	if s.cacheError != nil {
+		s.cacheError(err)
+	}
+}
+
+func audience(resource url.URL) string {
+	return strings.TrimSuffix(resource.String(), "/")
+}
+
+func newTokenFromJWT(jwt string) Token {
+	token := Token{
+		accessToken: jwt,
+		expires:     time.Now().AddDate(100, 0, 0),
+	}
+
+	if body, err := ParseBody(jwt); err == nil {
+		if exp, ok := body["exp"].(float64); ok {
+			token.expires = time.Unix(int64(exp), 0)
+			token.expiresIn = int(time.Until(token.expires).Seconds())
+		}
+	}
+	return token
+}
@@ -0,0 +1,353 @@
+// Copyright (C) 2024 Specter Ops, Inc.
+//
+// This file is part of AzureHound.
+//
+// AzureHound is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// AzureHound is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package rest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/bloodhoundad/azurehound/v2/config"
+)
+
+// Redacted replaces secrets in recorded requests and responses
+const Redacted = "REDACTED"
+
+var (
+	// scrubbedHeaders are the headers whose values are never recorded
+	scrubbedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "Signature", "X-Identity-Header"}
+
+	// scrubbedFields are the form fields, query parameters and JSON properties whose values are never recorded
+	scrubbedFields = map[string]bool{
+		"access_token":     true,
+		"assertion":        true,
+		"client_assertion": true,
+		"client_secret":    true,
+		"code":             true,
+		"device_code":      true,
+		"id_token":         true,
+		"password":         true,
+		"refresh_token":    true,
+		"sig":              true,
+	}
+
+	recorders      = make(map[string]*cassetteRecorder)
+	cassettes      = make(map[string]*cassettePlayer)
+	cassettesMutex sync.Mutex
+)
+
+// Interaction is a request and its response as written to a cassette, one interaction per line
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+type RecordedRequest struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body,omitempty"`
+}
+
+type RecordedResponse struct {
+	StatusCode int         `json:"status"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       string      `json:"body,omitempty"`
+}
+
+// cassetteRecorder appends every interaction made by any client recording to the same cassette
+type cassetteRecorder struct {
+	mutex   sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+}
+
+// UsesCassette returns whether HTTP interactions are recorded to or replayed from a cassette
+func UsesCassette() bool {
+	if path, ok := config.HTTPReplayFile.Value().(string); ok && path != "" {
+		return true
+	} else if path, ok := config.HTTPRecordFile.Value().(string); ok && path != "" {
+		return true
+	} else {
+		return false
+	}
+}
+
+func getRecorder(path string) (*cassetteRecorder, error) {
+	cassettesMutex.Lock()
+	defer cassettesMutex.Unlock()
+
+	if recorder, ok := recorders[path]; ok {
+		return recorder, nil
+	} else if file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600); err != nil {
+		return nil, err
+	} else {
+		recorder := &cassetteRecorder{file: file, encoder: json.NewEncoder(file)}
+		recorders[path] = recorder
+		return recorder, nil
+	}
+}
+
+func (s *cassetteRecorder) record(interaction Interaction) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.encoder.Encode(interaction)
+}
+
+// recordingTransport writes each request and its response to a cassette with secrets scrubbed
+type recordingTransport struct {
+	base     http.RoundTripper
+	recorder *cassetteRecorder
+}
+
+func (s recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil {
+		if body, err := io.ReadAll(req.Body); err != nil {
+			return nil, err
+		} else {
+			reqBody = body
+			req.Body.Close()
+			req.Body = io.NopCloser(bytes.NewReader(body))
+		}
+	}
+
+	if res, err := s.base.RoundTrip(req); err != nil {
+		return nil, err
+	} else if resBody, err := io.ReadAll(res.Body); err != nil {
+		res.Body.Close()
+		return nil, err
+	} else {
+		res.Body.Close()
+		res.Body = io.NopCloser(bytes.NewReader(resBody))
+
+		interaction := Interaction{
+			Request: RecordedRequest{
+				Method: req.Method,
+				URL:    scrubURL(*req.URL),
+				Header: scrubHeader(req.Header),
+				Body:   scrubBody(req.Header.Get("Content-Type"), reqBody),
+			},
+			Response: RecordedResponse{
+				StatusCode: res.StatusCode,
+				Header:     scrubHeader(res.Header),
+				Body:       scrubBody(res.Header.Get("Content-Type"), resBody),
+			},
+		}
+		if err := s.recorder.record(interaction); err != nil {
+			return nil, fmt.Errorf("unable to record interaction: %w", err)
+		}
+		return res, nil
+	}
+}
+
+// cassettePlayer serves recorded responses in the order they were recorded
+type cassettePlayer struct {
+	mutex        sync.Mutex
+	interactions map[string][]Interaction // Keyed by method and URL
+	last         map[string]Interaction
+}
+
+func getCassette(path string) (*cassettePlayer, error) {
+	cassettesMutex.Lock()
+	defer cassettesMutex.Unlock()
+
+	if cassette, ok := cassettes[path]; ok {
+		return cassette, nil
+	} else if cassette, err := loadCassette(path); err != nil {
+		return nil, err
+	} else {
+		cassettes[path] = cassette
+		return cassette, nil
+	}
+}
+
+func loadCassette(path string) (*cassettePlayer, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var (
+		cassette = &cassettePlayer{
+			interactions: make(map[string][]Interaction),
+			last:         make(map[string]Interaction),
+		}
+		scanner = bufio.NewScanner(file)
+		line    = 0
+	)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var interaction Interaction
+		line++
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		} else if err := json.Unmarshal(scanner.Bytes(), &interaction); err != nil {
+			return nil, fmt.Errorf("malformed interaction on line %d of %s: %w", line, path, err)
+		} else {
+			key := interaction.Request.Method + " " + interaction.Request.URL
+			cassette.interactions[key] = append(cassette.interactions[key], interaction)
+		}
+	}
+	return cassette, scanner.Err()
+}
+
+// next returns the response to the request. A request with a body is answered by the first unplayed interaction with
+// the same body, and by none once they have all been played, so that no caller is handed a response meant for
+// another. Other requests are answered in the order they were recorded, the last interaction being played again once
+// every one has been played.
+func (s *cassettePlayer) next(method string, endpoint url.URL, body string) (RecordedResponse, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key := method + " " + scrubURL(endpoint)
+	if interactions := s.interactions[key]; body != "" {
+		for i := range interactions {
+			if interactions[i].Request.Body == body {
+				s.interactions[key] = append(interactions[:i:i], interactions[i+1:]...)
+				return interactions[i].Response, nil
+			}
+		}
+		return RecordedResponse{}, fmt.Errorf("no recorded response for %s %s with this body", method, scrubURL(endpoint))
+	} else if len(interactions) == 0 {
+		if last, ok := s.last[key]; ok {
+			return last.Response, nil
+		} else {
+			return RecordedResponse{}, fmt.Errorf("no recorded response for %s %s", method, scrubURL(endpoint))
+		}
+	} else {
+		interaction := interactions[0]
+		s.interactions[key] = interactions[1:]
+		s.last[key] = interaction
+		return interaction.Response, nil
+	}
+}
+
+// replayingTransport serves responses from a cassette without touching the network
+type replayingTransport struct {
+	cassette *cassettePlayer
+}
+
+func (s replayingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		defer req.Body.Close()
+		if data, err := io.ReadAll(req.Body); err != nil {
+			return nil, err
+		} else {
+			body = data
+		}
+	}
+
+	if recorded, err := s.cassette.next(req.Method, *req.URL, scrubBody(req.Header.Get("Content-Type"), body)); err != nil {
+		return nil, err
+	} else {
+		header := recorded.Header.Clone()
+		if header == nil {
+			header = make(http.Header)
+		}
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", recorded.StatusCode, http.StatusText(recorded.StatusCode)),
+			StatusCode:    recorded.StatusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        header,
+			Body:          io.NopCloser(strings.NewReader(recorded.Body)),
+			ContentLength: int64(len(recorded.Body)),
+			Request:       req,
+		}, nil
+	}
+}
+
+func scrubHeader(header http.Header) http.Header {
+	scrubbed := header.Clone()
+	for _, name := range scrubbedHeaders {
+		if _, ok := scrubbed[name]; ok {
+			scrubbed.Set(name, Redacted)
+		}
+	}
+	return scrubbed
+}
+
+func scrubURL(endpoint url.URL) string {
+	endpoint.User = nil
+	if endpoint.RawQuery != "" {
+		query := endpoint.Query()
+		for key := range query {
+			if scrubbedFields[key] {
+				query.Set(key, Redacted)
+			}
+		}
+		endpoint.RawQuery = query.Encode()
+	}
+	return endpoint.String()
+}
+
+// scrubBody redacts secrets from form and JSON bodies
+func scrubBody(contentType string, body []byte) string {
+	if strings.HasPrefix(contentType, "application/x-www-form-urlencoded") {
+		if form, err := url.ParseQuery(string(body)); err == nil {
+			for key := range form {
+				if scrubbedFields[key] {
+					form.Set(key, Redacted)
+				}
+			}
+			return form.Encode()
+		}
+	} else if len(body) > 0 && json.Valid(body) {
+		var value interface{}
+		if err := json.Unmarshal(body, &value); err == nil && scrubJSON(value) {
+			if scrubbed, err := json.Marshal(value); err == nil {
+				return string(scrubbed)
+			}
+		}
+	}
+	return string(body)
+}
+
+// scrubJSON redacts secrets in place, returning whether there were any
+func scrubJSON(value interface{}) bool {
+	scrubbed := false
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, item := range value {
+			if _, ok := item.(string); ok && scrubbedFields[key] {
+				value[key] = Redacted
+				scrubbed = true
+			} else if scrubJSON(item) {
+				scrubbed = true
+			}
+		}
+	case []interface{}:
+		for _, item := range value {
+			if scrubJSON(item) {
+				scrubbed = true
+			}
+		}
+	}
+	return scrubbed
+}
@@ -0,0 +1,152 @@
+// Copyright (C) 2024 Specter Ops, Inc.
+//
+// This file is part of AzureHound.
+//
+// AzureHound is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// AzureHound is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package rest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bloodhoundad/azurehound/v2/client/config"
+	"github.com/bloodhoundad/azurehound/v2/client/query"
+	global "github.com/bloodhoundad/azurehound/v2/config"
+)
+
+func TestRecordReplay(t *testing.T) {
+	var (
+		cassette = filepath.Join(t.TempDir(), "cassette.jsonl")
+		pages    = 0
+	)
+
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if strings.HasSuffix(r.URL.Path, "/oauth2/v2.0/token") {
+			w.Write([]byte(`{"access_token":"secret-access-token","refresh_token":"secret-refresh-token","expires_in":3600}`))
+		} else if r.Header.Get("Authorization") != "Bearer secret-access-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+		} else {
+			pages++
+			fmt.Fprintf(w, `{"value":[{"id":"user%d"}]}`, pages)
+		}
+	}))
+
+	clientConfig := config.Config{
+		Authority:     testServer.URL,
+		ApplicationId: "foo",
+		ClientSecret:  "secret-client-secret",
+	}
+
+	get := func() []string {
+		var bodies []string
+		if client, err := NewRestClient(testServer.URL, clientConfig); err != nil {
+			t.Fatalf("error initializing rest client %v", err)
+		} else {
+			for i := 0; i < 2; i++ {
+				if res, err := client.Get(context.Background(), "/v1.0/users", query.GraphParams{Top: 1}, nil); err != nil {
+					t.Fatal(err)
+				} else if body, err := io.ReadAll(res.Body); err != nil {
+					t.Fatal(err)
+				} else {
+					res.Body.Close()
+					bodies = append(bodies, string(body))
+				}
+			}
+		}
+		return bodies
+	}
+
+	global.HTTPRecordFile.Set(cassette)
+	recorded := get()
+	global.HTTPRecordFile.Set("")
+	testServer.Close()
+
+	if content, err := os.ReadFile(cassette); err != nil {
+		t.Fatal(err)
+	} else if strings.Contains(string(content), "secret-") {
+		t.Errorf("cassette contains secrets: %s", content)
+	} else if !strings.Contains(string(content), Redacted) {
+		t.Errorf("cassette contains no redactions: %s", content)
+	}
+
+	// Responses are replayed in order without a server
+	global.HTTPReplayFile.Set(cassette)
+	defer global.HTTPReplayFile.Set("")
+	if replayed := get(); strings.Join(replayed, "") != strings.Join(recorded, "") {
+		t.Errorf("got %v, want %v", replayed, recorded)
+	}
+
+	if client, err := NewRestClient(testServer.URL, clientConfig); err != nil {
+		t.Fatalf("error initializing rest client %v", err)
+	} else if _, err := client.Get(context.Background(), "/v1.0/groups", nil, nil); err == nil || !strings.Contains(err.Error(), "no recorded response") {
+		t.Errorf("got %v, want an error for a request that was not recorded", err)
+	}
+}
+
+func TestRecordReplayManagedIdentity(t *testing.T) {
+	var (
+		cassette = filepath.Join(t.TempDir(), "cassette.jsonl")
+		requests = 0
+	)
+
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/msi/token" && r.Header.Get("X-IDENTITY-HEADER") == "secret-identity-header" {
+			w.Write([]byte(`{"access_token":"secret-access-token","expires_on":"4102444800","resource":"","token_type":"Bearer"}`))
+		} else {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	t.Setenv(identityEndpointEnv, testServer.URL+"/msi/token")
+	t.Setenv(identityHeaderEnv, "secret-identity-header")
+
+	authenticate := func() {
+		if client, err := NewRestClient(testServer.URL, config.Config{Authority: testServer.URL, ManagedIdentity: true}); err != nil {
+			t.Fatalf("error initializing rest client %v", err)
+		} else if err := client.Authenticate(); err != nil {
+			t.Fatalf("error authenticating with managed identity: %v", err)
+		}
+	}
+
+	global.HTTPRecordFile.Set(cassette)
+	authenticate()
+	global.HTTPRecordFile.Set("")
+	testServer.Close()
+
+	if content, err := os.ReadFile(cassette); err != nil {
+		t.Fatal(err)
+	} else if !strings.Contains(string(content), "/msi/token") {
+		t.Errorf("cassette is missing the managed identity request: %s", content)
+	} else if strings.Contains(string(content), "secret-") {
+		t.Errorf("cassette contains secrets: %s", content)
+	}
+
+	// The token is acquired again without the managed identity endpoint
+	global.HTTPReplayFile.Set(cassette)
+	defer global.HTTPReplayFile.Set("")
+	authenticate()
+	if requests != 1 {
+		t.Errorf("got %d requests to the managed identity endpoint, want 1", requests)
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright (C) 2024 Specter Ops, Inc.
+//
+// This file is part of AzureHound.
+//
+// AzureHound is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// AzureHound is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package rest
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// ClientCapabilities declares to the authority that AzureHound handles claims challenges, which enables Continuous
+// Access Evaluation and with it long-lived access tokens.
+var ClientCapabilities = []string{"cp1"}
+
+var authParamRegex = regexp.MustCompile(`([A-Za-z_]+)\s*=\s*"([^"]*)"`)
+
+// ClaimsChallenge is the Bearer challenge of a 401 response's WWW-Authenticate header
+// See https://learn.microsoft.com/en-us/entra/identity-platform/claims-challenge
+type ClaimsChallenge struct {
+	Error  string
+	Claims string // The decoded claims the authority must include in a new access token
+}
+
+// Recoverable reports whether acquiring a new access token may satisfy the challenge
+func (s ClaimsChallenge) Recoverable() bool {
+	return s.Claims != "" || s.Error == "insufficient_claims" || s.Error == "invalid_token"
+}
+
+// ParseClaimsChallenge parses the Bearer challenge from a WWW-Authenticate header
+func ParseClaimsChallenge(header http.Header) (ClaimsChallenge, error) {
+	var challenge ClaimsChallenge
+
+	for _, value := range header.Values("WWW-Authenticate") {
+		if !strings.HasPrefix(strings.ToLower(strings.TrimSpace(value)), "bearer") {
+			continue
+		}
+		for _, match := range authParamRegex.FindAllStringSubmatch(value, -1) {
+			switch strings.ToLower(match[1]) {
+			case "error":
+				challenge.Error = match[2]
+			case "claims":
+				if claims, err := decodeClaims(match[2]); err != nil {
+					return challenge, fmt.Errorf("malformed claims challenge: %w", err)
+				} else {
+					challenge.Claims = claims
+				}
+			}
+		}
+	}
+	return challenge, nil
+}
+
+func decodeClaims(value string) (string, error) {
+	// the claims are base64 encoded; padding is not always present
+	for _, encoding := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if claims, err := encoding.DecodeString(value); err == nil && json.Valid(claims) {
+			return string(claims), nil
+		}
+	}
+	if json.Valid([]byte(value)) {
+		return value, nil
+	}
+	return "", fmt.Errorf("unable to decode claims %q", value)
+}
+
+// tokenRequestClaims merges the claims requested by a challenge, if any, with the client capabilities
+func tokenRequestClaims(challenge string) (string, error) {
+	claims := map[string]interface{}{}
+	if challenge != "" {
+		if err := json.Unmarshal([]byte(challenge), &claims); err != nil {
+			return "", fmt.Errorf("malformed claims challenge: %w", err)
+		}
+	}
+
+	accessToken, ok := claims["access_token"].(map[string]interface{})
+	if !ok {
+		accessToken = map[string]interface{}{}
+		claims["access_token"] = accessToken
+	}
+	accessToken["xms_cc"] = map[string]interface{}{"values": ClientCapabilities}
+
+	if data, err := json.Marshal(claims); err != nil {
+		return "", err
+	} else {
+		return string(data), nil
+	}
+}
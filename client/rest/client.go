@@ -22,18 +22,17 @@ package rest
 import (
 	"bytes"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
-	"strconv"
-	"sync"
 	"time"
 
 	"github.com/bloodhoundad/azurehound/v2/client/config"
 	"github.com/bloodhoundad/azurehound/v2/client/query"
-	"github.com/bloodhoundad/azurehound/v2/constants"
+	"github.com/bloodhoundad/azurehound/v2/metrics"
+	"github.com/bloodhoundad/azurehound/v2/tracing"
 )
 
 type RestClient interface {
@@ -47,30 +46,43 @@ type RestClient interface {
 	CloseIdleConnections()
 }
 
+// ErrorResponse is returned for responses with a status code that does not warrant a retry
+type ErrorResponse struct {
+	StatusCode int
+	Header     http.Header
+	Body       map[string]interface{}
+}
+
+func (s *ErrorResponse) Error() string {
+	if s.Body == nil {
+		return fmt.Sprintf("malformed error response, status code: %d", s.StatusCode)
+	} else {
+		return fmt.Sprintf("%v", s.Body)
+	}
+}
+
 func NewRestClient(apiUrl string, config config.Config) (RestClient, error) {
-	if auth, err := url.Parse(config.AuthorityUrl()); err != nil {
+	if broker, err := NewTokenBroker(config); err != nil {
 		return nil, err
-	} else if api, err := url.Parse(apiUrl); err != nil {
+	} else {
+		return NewRestClientWithBroker(apiUrl, config, broker)
+	}
+}
+
+// NewRestClientWithBroker creates a rest client that acquires its access tokens from a shared token broker
+func NewRestClientWithBroker(apiUrl string, config config.Config, broker *TokenBroker) (RestClient, error) {
+	if api, err := url.Parse(apiUrl); err != nil {
 		return nil, err
-	} else if http, err := NewHTTPClient(config.ProxyUrl); err != nil {
+	} else if http, err := NewLimitedHTTPClient(config.ProxyUrl, *api); err != nil {
+		return nil, err
+	} else if retry, err := NewRetryPolicy(); err != nil {
 		return nil, err
 	} else {
 		client := &restClient{
 			*api,
-			*auth,
-			config.JWT,
-			config.ApplicationId,
-			config.ClientSecret,
-			config.ClientCert,
-			config.ClientKey,
-			config.ClientKeyPass,
-			config.Username,
-			config.Password,
+			broker,
 			http,
-			sync.RWMutex{},
-			config.RefreshToken,
-			config.Tenant,
-			Token{},
+			retry,
 			config.SubscriptionId,
 			config.MgmtGroupId,
 		}
@@ -79,80 +91,16 @@ func NewRestClient(apiUrl string, config config.Config) (RestClient, error) {
 }
 
 type restClient struct {
-	api           url.URL
-	authUrl       url.URL
-	jwt           string
-	clientId      string
-	clientSecret  string
-	clientCert    string
-	clientKey     string
-	clientKeyPass string
-	username      string
-	password      string
-	http          *http.Client
-	mutex         sync.RWMutex
-	refreshToken  string
-	tenant        string
-	token         Token
-	subId         []string
-	mgmtGroupId   []string
+	api         url.URL
+	broker      *TokenBroker
+	http        *http.Client
+	retry       RetryPolicy
+	subId       []string
+	mgmtGroupId []string
 }
 
 func (s *restClient) Authenticate() error {
-	var (
-		path         = url.URL{Path: fmt.Sprintf("/%s/oauth2/v2.0/token", s.tenant)}
-		endpoint     = s.authUrl.ResolveReference(&path)
-		defaultScope = url.URL{Path: "/.default"}
-		scope        = s.api.ResolveReference(&defaultScope)
-		body         = url.Values{}
-	)
-
-	if s.clientId == "" {
-		body.Add("client_id", constants.AzPowerShellClientID)
-	} else {
-		body.Add("client_id", s.clientId)
-	}
-
-	body.Add("scope", scope.ResolveReference(&defaultScope).String())
-
-	if s.refreshToken != "" {
-		body.Add("grant_type", "refresh_token")
-		body.Add("refresh_token", s.refreshToken)
-		body.Set("client_id", constants.AzPowerShellClientID)
-	} else if s.clientSecret != "" {
-		body.Add("grant_type", "client_credentials")
-		body.Add("client_secret", s.clientSecret)
-	} else if s.clientCert != "" && s.clientKey != "" {
-		if clientAssertion, err := NewClientAssertion(endpoint.String(), s.clientId, s.clientCert, s.clientKey, s.clientKeyPass); err != nil {
-			return err
-		} else {
-			body.Add("grant_type", "client_credentials")
-			body.Add("client_assertion_type", "urn:ietf:params:oauth:client-assertion-type:jwt-bearer")
-			body.Add("client_assertion", clientAssertion)
-		}
-	} else if s.username != "" && s.password != "" {
-		body.Add("grant_type", "password")
-		body.Add("username", s.username)
-		body.Add("password", s.password)
-		body.Set("client_id", constants.AzPowerShellClientID)
-	} else {
-		return fmt.Errorf("unable to authenticate. no valid credential provided")
-	}
-
-	if req, err := NewRequest(context.Background(), "POST", endpoint, body, nil, nil); err != nil {
-		return err
-	} else if res, err := s.send(req); err != nil {
-		return err
-	} else {
-		defer res.Body.Close()
-		s.mutex.Lock()
-		defer s.mutex.Unlock()
-		if err := json.NewDecoder(res.Body).Decode(&s.token); err != nil {
-			return err
-		} else {
-			return nil
-		}
-	}
+	return s.broker.Authenticate(s.api)
 }
 
 func (s *restClient) Delete(ctx context.Context, path string, body interface{}, params query.Params, headers map[string]string) (*http.Response, error) {
@@ -229,83 +177,110 @@ func (s *restClient) Put(ctx context.Context, path string, body interface{}, par
 }
 
 func (s *restClient) Send(req *http.Request) (*http.Response, error) {
-	if s.jwt != "" {
-		if aud, err := ParseAud(s.jwt); err != nil {
-			return nil, err
-		} else if aud != s.api.String() {
-			return nil, fmt.Errorf("invalid audience")
-		}
-		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", s.jwt))
+	// copy the bytes in case a claims challenge requires the request be sent again
+	body, err := CopyBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := s.broker.Token(s.api)
+	if err != nil {
+		return nil, err
 	} else {
-		if s.token.IsExpired() {
-			if err := s.Authenticate(); err != nil {
-				return nil, err
-			}
+		req.Header.Set("Authorization", token.String())
+	}
+
+	var errRes *ErrorResponse
+	if res, err := s.send(req); !errors.As(err, &errRes) || errRes.StatusCode != http.StatusUnauthorized || !s.broker.HasCredential() {
+		return res, err
+	} else if challenge, parseErr := ParseClaimsChallenge(errRes.Header); parseErr != nil || !challenge.Recoverable() {
+		return res, err
+	} else if token, err := s.broker.Refresh(s.api, token, challenge.Claims); err != nil {
+		return nil, fmt.Errorf("unable to satisfy claims challenge: %w", err)
+	} else {
+		// Retry once with an access token that satisfies the challenge
+		if body != nil {
+			req.Body = io.NopCloser(bytes.NewBuffer(body))
 		}
-		req.Header.Set("Authorization", s.token.String())
+		req.Header.Set("Authorization", token.String())
+		return s.send(req)
 	}
-	return s.send(req)
 }
 
 func (s *restClient) send(req *http.Request) (*http.Response, error) {
-	// copy the bytes in case we need to retry the request
-	if body, err := CopyBody(req); err != nil {
-		return nil, err
-	} else {
-		var (
-			res        *http.Response
-			err        error
-			maxRetries = 3
+	return send(s.http, req, s.retry)
+}
+
+// send makes the request, retrying dropped connections, throttling and server errors as the policy allows
+func send(client *http.Client, req *http.Request, policy RetryPolicy) (*http.Response, error) {
+	var (
+		counter   = &throttleCounter{RetryPolicy: policy}
+		start     = time.Now()
+		endpoint  = metrics.Endpoint(req.Method, req.URL)
+		ctx, span = tracing.Start(req.Context(), "HTTP "+req.Method, tracing.SpanKindClient,
+			tracing.String("http.request.method", req.Method),
+			tracing.String("server.address", req.URL.Host),
+			tracing.String("url.path", req.URL.Path),
+			tracing.String("azurehound.endpoint", endpoint),
 		)
-		// Try the request up to a set number of times
-		for retry := 0; retry < maxRetries; retry++ {
+	)
+	defer span.End()
+	if span != nil {
+		req = req.WithContext(ctx)
+	}
 
-			// Reusing http.Request requires rewinding the request body
-			// back to a working state
-			if body != nil && retry > 0 {
-				req.Body = io.NopCloser(bytes.NewBuffer(body))
-			}
+	res, attempts, err := SendWithRetry(client, req, counter)
+	observation := metrics.Request{
+		Endpoint:  endpoint,
+		Attempts:  attempts,
+		Throttles: counter.throttles,
+		Latency:   time.Since(start),
+		Failed:    err != nil || res.StatusCode < http.StatusOK || res.StatusCode >= http.StatusBadRequest,
+	}
+	metrics.RecordRequest(req.Context(), observation)
 
-			// Try the request
-			if res, err = s.http.Do(req); err != nil {
-				if IsClosedConnectionErr(err) {
-					fmt.Printf("remote host force closed connection while requesting %s; attempt %d/%d; trying again\n", req.URL, retry+1, maxRetries)
-					ExponentialBackoff(retry)
-					continue
-				}
-				return nil, err
-			} else if res.StatusCode < http.StatusOK || res.StatusCode >= http.StatusBadRequest {
-				// Error response code handling
-				// See official Retry guidance (https://learn.microsoft.com/en-us/azure/architecture/best-practices/retry-service-specific#retry-usage-guidance)
-				if res.StatusCode == http.StatusTooManyRequests {
-					retryAfterHeader := res.Header.Get("Retry-After")
-					if retryAfter, err := strconv.ParseInt(retryAfterHeader, 10, 64); err != nil {
-						return nil, fmt.Errorf("attempting to handle 429 but unable to parse retry-after header: %w", err)
-					} else {
-						// Wait the time indicated in the retry-after header
-						time.Sleep(time.Second * time.Duration(retryAfter))
-						continue
-					}
-				} else if res.StatusCode >= http.StatusInternalServerError {
-					// Wait the time calculated by the 5 second exponential backoff
-					ExponentialBackoff(retry)
-					continue
-				} else {
-					// Not a status code that warrants a retry
-					var errRes map[string]interface{}
-					if err := Decode(res.Body, &errRes); err != nil {
-						return nil, fmt.Errorf("malformed error response, status code: %d", res.StatusCode)
-					} else {
-						return nil, fmt.Errorf("%v", errRes)
-					}
-				}
-			} else {
-				// Response OK
-				return res, nil
-			}
+	span.SetAttributes(tracing.Int("azurehound.attempts", attempts), tracing.Int("azurehound.throttles", counter.throttles))
+	if res != nil {
+		span.SetAttributes(tracing.Int("http.response.status_code", res.StatusCode))
+	}
+	if err != nil {
+		span.SetError(err)
+	} else if observation.Failed {
+		span.SetStatus(tracing.StatusError, res.Status)
+	}
+
+	if err != nil {
+		if attempts > 1 {
+			return nil, fmt.Errorf("unable to complete the request after %d attempts: %w", attempts, err)
+		}
+		return nil, err
+	}
+
+	res.Body = metrics.CountBytes(observation.Endpoint, res.Body)
+	if observation.Failed {
+		errRes := &ErrorResponse{StatusCode: res.StatusCode, Header: res.Header}
+		if err := Decode(res.Body, &errRes.Body); err != nil {
+			errRes.Body = nil
+		}
+		if attempts > 1 {
+			return nil, fmt.Errorf("unable to complete the request after %d attempts: %w", attempts, errRes)
 		}
-		return nil, fmt.Errorf("unable to complete the request after %d attempts: %w", maxRetries, err)
+		return nil, errRes
+	}
+	return res, nil
+}
+
+// throttleCounter counts the throttled responses the policy is asked to retry
+type throttleCounter struct {
+	RetryPolicy
+	throttles int
+}
+
+func (s *throttleCounter) Retry(attempts int, elapsed time.Duration, res *http.Response, err error) (time.Duration, bool) {
+	if res != nil && res.StatusCode == http.StatusTooManyRequests {
+		s.throttles++
 	}
+	return s.RetryPolicy.Retry(attempts, elapsed, res, err)
 }
 
 func (s *restClient) CloseIdleConnections() {
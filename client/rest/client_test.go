@@ -18,10 +18,22 @@
 package rest
 
 import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
 
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/bloodhoundad/azurehound/v2/client/config"
 )
@@ -67,3 +79,508 @@ func TestClosedConnection(t *testing.T) {
 		}
 	}
 }
+
+func TestDeviceCodeLogin(t *testing.T) {
+	var polls int
+	mux := http.NewServeMux()
+	mux.HandleFunc("/tenant/oauth2/v2.0/devicecode", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"device_code":"device","user_code":"USERCODE","verification_uri":"https://microsoft.com/devicelogin","expires_in":30,"interval":1}`))
+	})
+	mux.HandleFunc("/tenant/oauth2/v2.0/token", func(w http.ResponseWriter, r *http.Request) {
+		polls++
+		r.ParseForm()
+		if r.Form.Get("grant_type") != DeviceCodeGrantType || r.Form.Get("device_code") != "device" {
+			t.Errorf("unexpected token request: %v", r.Form)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if polls == 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"authorization_pending"}`))
+		} else {
+			w.Write([]byte(`{"access_token":"access","refresh_token":"refresh","expires_in":3600}`))
+		}
+	})
+
+	testServer := httptest.NewServer(mux)
+	defer testServer.Close()
+
+	defaultConfig := config.Config{
+		Authority:  testServer.URL,
+		Tenant:     "tenant",
+		DeviceCode: true,
+	}
+
+	if client, err := NewRestClient(testServer.URL, defaultConfig); err != nil {
+		t.Fatalf("error initializing rest client %v", err)
+	} else if err := client.Authenticate(); err != nil {
+		t.Fatalf("error authenticating with device code: %v", err)
+	} else if rc := client.(*restClient); rc.broker.tokens[audience(rc.api)].accessToken != "access" || rc.broker.refreshToken != "refresh" {
+		t.Errorf("got access token %q and refresh token %q, want %q and %q", rc.broker.tokens[audience(rc.api)].accessToken, rc.broker.refreshToken, "access", "refresh")
+	} else if polls != 2 {
+		t.Errorf("got %d token requests, want %d", polls, 2)
+	}
+}
+
+func TestManagedIdentityLogin(t *testing.T) {
+	var testServer *httptest.Server
+	var mockHandler http.HandlerFunc = func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if query.Get("resource") != testServer.URL || query.Get("client_id") != "user-assigned" {
+			t.Errorf("unexpected managed identity request: %v", r.URL)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/metadata/identity/oauth2/token" && r.Header.Get("Metadata") == "true" {
+			w.Write([]byte(`{"access_token":"imds","expires_in":"3600","expires_on":"0","resource":"","token_type":"Bearer"}`))
+		} else if r.URL.Path == "/msi/token" && r.Header.Get("X-IDENTITY-HEADER") == "secret" {
+			w.Write([]byte(`{"access_token":"appservice","expires_on":"4102444800","resource":"","token_type":"Bearer"}`))
+		} else {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_request"}`))
+		}
+	}
+
+	testServer = httptest.NewServer(mockHandler)
+	defer testServer.Close()
+
+	defaultConfig := config.Config{
+		Authority:         testServer.URL,
+		ManagedIdentity:   true,
+		ManagedIdentityId: "user-assigned",
+	}
+
+	authenticate := func() Token {
+		if client, err := NewRestClient(testServer.URL, defaultConfig); err != nil {
+			t.Fatalf("error initializing rest client %v", err)
+		} else if err := client.Authenticate(); err != nil {
+			t.Fatalf("error authenticating with managed identity: %v", err)
+		} else {
+			rc := client.(*restClient)
+			return rc.broker.tokens[audience(rc.api)]
+		}
+		return Token{}
+	}
+
+	t.Setenv(imdsAuthorityHostEnv, testServer.URL)
+	if token := authenticate(); token.accessToken != "imds" || token.IsExpired() {
+		t.Errorf("got token %+v, want unexpired token %q", token, "imds")
+	}
+
+	t.Setenv(identityEndpointEnv, testServer.URL+"/msi/token")
+	t.Setenv(identityHeaderEnv, "secret")
+	if token := authenticate(); token.accessToken != "appservice" || token.IsExpired() {
+		t.Errorf("got token %+v, want unexpired token %q", token, "appservice")
+	}
+}
+
+func TestFederatedTokenFileRotation(t *testing.T) {
+	var assertions []string
+	var mockHandler http.HandlerFunc = func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		assertions = append(assertions, r.Form.Get("client_assertion"))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"access","expires_in":3600}`))
+	}
+
+	testServer := httptest.NewServer(mockHandler)
+	defer testServer.Close()
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	defaultConfig := config.Config{
+		ApplicationId:      "app",
+		Authority:          testServer.URL,
+		FederatedTokenFile: tokenFile,
+	}
+
+	if client, err := NewRestClient(testServer.URL, defaultConfig); err != nil {
+		t.Fatalf("error initializing rest client %v", err)
+	} else {
+		for _, assertion := range []string{"first", "second"} {
+			if err := os.WriteFile(tokenFile, []byte(assertion+"\n"), 0600); err != nil {
+				t.Fatal(err)
+			} else if err := client.Authenticate(); err != nil {
+				t.Fatalf("error authenticating with federated token: %v", err)
+			}
+		}
+	}
+
+	if len(assertions) != 2 || assertions[0] != "first" || assertions[1] != "second" {
+		t.Errorf("got assertions %v, want %v", assertions, []string{"first", "second"})
+	}
+}
+
+func TestTokenBrokerPerAudience(t *testing.T) {
+	var scopes []string
+	var mockHandler http.HandlerFunc = func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/tenant/oauth2/v2.0/token" {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"value":[]}`))
+			return
+		}
+		r.ParseForm()
+		if r.Form.Get("grant_type") != "refresh_token" || r.Form.Get("refresh_token") != fmt.Sprintf("refresh%d", len(scopes)) {
+			t.Errorf("unexpected token request: %v", r.Form)
+		}
+		scopes = append(scopes, r.Form.Get("scope"))
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token":"access","refresh_token":"refresh%d","expires_in":3600}`, len(scopes))
+	}
+
+	testServer := httptest.NewServer(mockHandler)
+	defer testServer.Close()
+
+	defaultConfig := config.Config{
+		Authority:    testServer.URL,
+		Tenant:       "tenant",
+		RefreshToken: "refresh0",
+	}
+
+	if broker, err := NewTokenBroker(defaultConfig); err != nil {
+		t.Fatalf("error initializing token broker %v", err)
+	} else if graph, err := NewRestClientWithBroker(testServer.URL+"/graph", defaultConfig, broker); err != nil {
+		t.Fatalf("error initializing rest client %v", err)
+	} else if rm, err := NewRestClientWithBroker(testServer.URL+"/rm", defaultConfig, broker); err != nil {
+		t.Fatalf("error initializing rest client %v", err)
+	} else {
+		for i := 0; i < 2; i++ {
+			for _, client := range []RestClient{graph, rm} {
+				if res, err := client.Get(context.Background(), "/", nil, nil); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				} else {
+					res.Body.Close()
+				}
+			}
+		}
+	}
+
+	want := []string{testServer.URL + "/graph/.default", testServer.URL + "/rm/.default"}
+	if len(scopes) != len(want) || scopes[0] != want[0] || scopes[1] != want[1] {
+		t.Errorf("got token requests for %v, want %v", scopes, want)
+	}
+}
+
+func TestClaimsChallenge(t *testing.T) {
+	var (
+		tokenRequests []string
+		apiRequests   int
+		challenge     = `{"access_token":{"nbf":{"essential":true,"value":"1604106651"}}}`
+	)
+
+	var mockHandler http.HandlerFunc = func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/tenant/oauth2/v2.0/token" {
+			r.ParseForm()
+			tokenRequests = append(tokenRequests, r.Form.Get("claims"))
+			fmt.Fprintf(w, `{"access_token":"access%d","expires_in":3600}`, len(tokenRequests))
+			return
+		}
+
+		apiRequests++
+		if body, _ := io.ReadAll(r.Body); strings.TrimSpace(string(body)) != `{"foo":"bar"}` {
+			t.Errorf("got request body %s, want the original body", body)
+		}
+		if r.Header.Get("Authorization") == "Bearer access1" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="", authorization_uri="https://login.microsoftonline.com/common/oauth2/authorize", error="insufficient_claims", claims="%s"`, base64.StdEncoding.EncodeToString([]byte(challenge))))
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":{"code":"InvalidAuthenticationToken","message":"Continuous access evaluation resulted in claims challenge"}}`))
+		} else {
+			w.Write([]byte(`{"value":[]}`))
+		}
+	}
+
+	testServer := httptest.NewServer(mockHandler)
+	defer testServer.Close()
+
+	defaultConfig := config.Config{
+		Authority:     testServer.URL,
+		Tenant:        "tenant",
+		ApplicationId: "foo",
+		ClientSecret:  "bar",
+	}
+
+	if client, err := NewRestClient(testServer.URL, defaultConfig); err != nil {
+		t.Fatalf("error initializing rest client %v", err)
+	} else if res, err := client.Post(context.Background(), "/", map[string]string{"foo": "bar"}, nil, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else {
+		res.Body.Close()
+	}
+
+	if apiRequests != 2 {
+		t.Errorf("got %d api requests, want 2", apiRequests)
+	}
+	if len(tokenRequests) != 2 {
+		t.Fatalf("got %d token requests, want 2", len(tokenRequests))
+	} else if tokenRequests[0] != `{"access_token":{"xms_cc":{"values":["cp1"]}}}` {
+		t.Errorf("got claims %s, want the client capabilities", tokenRequests[0])
+	} else if tokenRequests[1] != `{"access_token":{"nbf":{"essential":true,"value":"1604106651"},"xms_cc":{"values":["cp1"]}}}` {
+		t.Errorf("got claims %s, want the challenge and client capabilities", tokenRequests[1])
+	}
+}
+
+func TestTokenSingleFlight(t *testing.T) {
+	var requests int32
+	var mockHandler http.HandlerFunc = func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/tenant/oauth2/v2.0/token" {
+			atomic.AddInt32(&requests, 1)
+			time.Sleep(100 * time.Millisecond)
+			w.Write([]byte(`{"access_token":"access","expires_in":3600}`))
+		} else {
+			w.Write([]byte(`{"value":[]}`))
+		}
+	}
+
+	testServer := httptest.NewServer(mockHandler)
+	defer testServer.Close()
+
+	defaultConfig := config.Config{
+		Authority:     testServer.URL,
+		Tenant:        "tenant",
+		ApplicationId: "foo",
+		ClientSecret:  "bar",
+	}
+
+	if client, err := NewRestClient(testServer.URL, defaultConfig); err != nil {
+		t.Fatalf("error initializing rest client %v", err)
+	} else {
+		var wg sync.WaitGroup
+		for i := 0; i < 200; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if res, err := client.Get(context.Background(), "/", nil, nil); err != nil {
+					t.Errorf("unexpected error: %v", err)
+				} else {
+					res.Body.Close()
+				}
+			}()
+		}
+		wg.Wait()
+	}
+
+	if requests != 1 {
+		t.Errorf("got %d token requests, want 1", requests)
+	}
+}
+
+func TestTokenRenewal(t *testing.T) {
+	var (
+		requests int32
+		fail     int32 = 1
+	)
+	var mockHandler http.HandlerFunc = func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&requests, 1)
+		w.Header().Set("Content-Type", "application/json")
+		if atomic.LoadInt32(&fail) == 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"temporarily_unavailable"}`))
+		} else {
+			fmt.Fprintf(w, `{"access_token":"access%d","expires_in":3600}`, n)
+		}
+	}
+
+	testServer := httptest.NewServer(mockHandler)
+	defer testServer.Close()
+
+	defaultConfig := config.Config{
+		Authority:     testServer.URL,
+		Tenant:        "tenant",
+		ApplicationId: "foo",
+		ClientSecret:  "bar",
+	}
+
+	resource, _ := url.Parse(testServer.URL)
+	broker, err := NewTokenBroker(defaultConfig)
+	if err != nil {
+		t.Fatalf("error initializing token broker %v", err)
+	}
+
+	// failed requests are not repeated until the backoff elapses
+	for i := 0; i < 3; i++ {
+		if _, err := broker.Token(*resource); err == nil {
+			t.Error("expected an error but got none")
+		}
+	}
+	if requests != 1 {
+		t.Errorf("got %d token requests while backing off, want 1", requests)
+	}
+
+	atomic.StoreInt32(&fail, 0)
+	if err := broker.Authenticate(*resource); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// a token past its renewal time is still returned while a new one is requested in the background
+	broker.mutex.Lock()
+	broker.tokens[audience(*resource)] = Token{accessToken: "old", expiresIn: 3600, expires: time.Now().Add(10 * time.Minute)}
+	broker.mutex.Unlock()
+
+	if token, err := broker.Token(*resource); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if token.accessToken != "old" {
+		t.Errorf("got token %q, want %q", token.accessToken, "old")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if token, _ := broker.Token(*resource); token.accessToken == "access3" {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("expected the token to be renewed in the background")
+}
+
+func TestTokenRenewalSignIn(t *testing.T) {
+	var deviceCodes int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/tenant/oauth2/v2.0/devicecode", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&deviceCodes, 1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"device_code":"device","user_code":"USERCODE","verification_uri":"https://microsoft.com/devicelogin","expires_in":30,"interval":1}`))
+	})
+	mux.HandleFunc("/tenant/oauth2/v2.0/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		w.Header().Set("Content-Type", "application/json")
+		if r.Form.Get("grant_type") == "refresh_token" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+		} else {
+			w.Write([]byte(`{"access_token":"access","refresh_token":"refresh","expires_in":3600}`))
+		}
+	})
+
+	testServer := httptest.NewServer(mux)
+	defer testServer.Close()
+
+	resource, _ := url.Parse(testServer.URL)
+	broker, err := NewTokenBroker(config.Config{Authority: testServer.URL, Tenant: "tenant", DeviceCode: true})
+	if err != nil {
+		t.Fatalf("error initializing token broker %v", err)
+	}
+	broker.refreshToken = "revoked"
+	broker.cachedRefresh = true
+
+	// a background renewal never prompts the user when the cached refresh token is rejected
+	broker.mutex.Lock()
+	call := broker.renew(*resource, "", false, false)
+	broker.mutex.Unlock()
+	if _, err := call.wait(); !errors.Is(err, errSignInRequired) {
+		t.Errorf("got error %v, want %v", err, errSignInRequired)
+	} else if atomic.LoadInt32(&deviceCodes) != 0 {
+		t.Errorf("got %d device code requests from a background renewal, want 0", deviceCodes)
+	}
+
+	// the next foreground request signs in again without waiting out the backoff
+	if token, err := broker.Token(*resource); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if token.accessToken != "access" || broker.refreshToken != "refresh" {
+		t.Errorf("got access token %q and refresh token %q, want %q and %q", token.accessToken, broker.refreshToken, "access", "refresh")
+	} else if atomic.LoadInt32(&deviceCodes) != 1 {
+		t.Errorf("got %d device code requests, want 1", deviceCodes)
+	}
+}
+
+func TestTokenCache(t *testing.T) {
+	var requests int
+	var mockHandler http.HandlerFunc = func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"access","expires_in":3600}`))
+	}
+
+	testServer := httptest.NewServer(mockHandler)
+	defer testServer.Close()
+
+	cacheFile := filepath.Join(t.TempDir(), "tokens")
+	defaultConfig := config.Config{
+		ApplicationId:  "app",
+		Authority:      testServer.URL,
+		ClientSecret:   "secret",
+		Tenant:         "tenant",
+		TokenCacheFile: cacheFile,
+		TokenCacheKey:  "passphrase",
+	}
+	resource, _ := url.Parse("https://graph.microsoft.com")
+
+	// simulate two separate runs
+	for run := 0; run < 2; run++ {
+		if broker, err := NewTokenBroker(defaultConfig); err != nil {
+			t.Fatalf("error initializing token broker %v", err)
+		} else if token, err := broker.Token(*resource); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if token.accessToken != "access" {
+			t.Errorf("got access token %q, want %q", token.accessToken, "access")
+		}
+	}
+
+	if requests != 1 {
+		t.Errorf("got %d token requests, want %d", requests, 1)
+	}
+
+	// brokers persisting to the same file share its cache
+	if first, err := NewTokenBroker(defaultConfig); err != nil {
+		t.Fatalf("error initializing token broker %v", err)
+	} else if second, err := NewTokenBroker(defaultConfig); err != nil {
+		t.Fatalf("error initializing token broker %v", err)
+	} else if first.cache != second.cache {
+		t.Error("expected brokers on the same file to share its token cache")
+	}
+	if tmp, _ := filepath.Glob(cacheFile + ".*"); len(tmp) != 0 {
+		t.Errorf("got temporary files %v left behind", tmp)
+	}
+
+	defaultConfig.TokenCacheKey = "wrong"
+	if _, err := NewTokenBroker(defaultConfig); err != ErrTokenCacheDecrypt {
+		t.Errorf("got error %v, want %v", err, ErrTokenCacheDecrypt)
+	}
+
+	// a cache that becomes unreadable is reported to the handler without failing the token request
+	var cacheErrors []error
+	defaultConfig.TokenCacheKey = "passphrase"
+	if broker, err := NewTokenBroker(defaultConfig); err != nil {
+		t.Fatalf("error initializing token broker %v", err)
+	} else if err := os.WriteFile(cacheFile, []byte("corrupt"), 0600); err != nil {
+		t.Fatal(err)
+	} else {
+		broker.OnCacheError(func(err error) {
+			cacheErrors = append(cacheErrors, err)
+		})
+		if _, err := broker.Token(*resource); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if len(cacheErrors) == 0 {
+			t.Error("expected the cache error to be reported")
+		}
+	}
+}
+
+func TestReadMSALRefreshToken(t *testing.T) {
+	cacheFile := filepath.Join(t.TempDir(), "msal_token_cache.json")
+	cache := `{
+		"Account": {
+			"a": {"home_account_id": "oid1.tid1", "environment": "login.microsoftonline.com", "realm": "tid1", "username": "alice@contoso.com"},
+			"b": {"home_account_id": "oid2.tid2", "environment": "login.microsoftonline.com", "realm": "tid2", "username": "bob@fabrikam.com"}
+		},
+		"RefreshToken": {
+			"a-old": {"home_account_id": "oid1.tid1", "client_id": "04b07795-8ddb-461a-bbee-02f9e1bf7b46", "family_id": "1", "secret": "old", "last_modification_time": "1"},
+			"a-new": {"home_account_id": "oid1.tid1", "client_id": "04b07795-8ddb-461a-bbee-02f9e1bf7b46", "family_id": "1", "secret": "new", "last_modification_time": "2"},
+			"b": {"home_account_id": "oid2.tid2", "client_id": "04b07795-8ddb-461a-bbee-02f9e1bf7b46", "family_id": "1", "secret": "bob", "last_modification_time": "3"}
+		}
+	}`
+	if err := os.WriteFile(cacheFile, []byte(cache), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for tenant, want := range map[string]string{"tid1": "new", "contoso.com": "new", "TID2": "bob"} {
+		if token, err := ReadMSALRefreshToken(cacheFile, tenant, ""); err != nil {
+			t.Errorf("unexpected error for tenant %s: %v", tenant, err)
+		} else if token != want {
+			t.Errorf("got refresh token %q for tenant %s, want %q", token, tenant, want)
+		}
+	}
+
+	if _, err := ReadMSALRefreshToken(cacheFile, "tid3", ""); err == nil {
+		t.Error("expected an error for a tenant without a signed in account")
+	}
+}
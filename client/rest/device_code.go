@@ -0,0 +1,150 @@
+// Copyright (C) 2024 Specter Ops, Inc.
+//
+// This file is part of AzureHound.
+//
+// AzureHound is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// AzureHound is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package rest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/bloodhoundad/azurehound/v2/constants"
+)
+
+const (
+	DeviceCodeGrantType string = "urn:ietf:params:oauth:grant-type:device_code"
+
+	defaultDeviceCodeInterval = 5 * time.Second
+)
+
+type deviceCodeResponse struct {
+	DeviceCode      string `json:"device_code"`      // The code used to poll the token endpoint
+	UserCode        string `json:"user_code"`        // The code the user enters at the verification URL
+	VerificationUri string `json:"verification_uri"` // The URL the user visits to sign in
+	ExpiresIn       int    `json:"expires_in"`       // How long the device code is valid in seconds
+	Interval        int    `json:"interval"`         // How long to wait between polling requests in seconds
+	Message         string `json:"message"`          // Human readable sign in instructions
+}
+
+type oauthErrorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// deviceCodeLogin requests a device code, prints the sign in instructions to w and polls the token endpoint until
+// the user completes (or declines) sign in or the device code expires.
+func (s *TokenBroker) deviceCodeLogin(ctx context.Context, resource url.URL, claims string, w io.Writer) (Token, error) {
+	var (
+		devicePath    = url.URL{Path: fmt.Sprintf("/%s/oauth2/v2.0/devicecode", s.tenant)}
+		deviceUrl     = s.authUrl.ResolveReference(&devicePath)
+		tokenPath     = url.URL{Path: fmt.Sprintf("/%s/oauth2/v2.0/token", s.tenant)}
+		tokenUrl      = s.authUrl.ResolveReference(&tokenPath)
+		scope         = audience(resource) + "/.default"
+		body          = url.Values{}
+		deviceCodeRes deviceCodeResponse
+	)
+
+	body.Add("client_id", constants.AzPowerShellClientID)
+	body.Add("scope", fmt.Sprintf("%s offline_access", scope))
+	body.Add("claims", claims)
+
+	if req, err := NewRequest(ctx, http.MethodPost, deviceUrl, body, nil, nil); err != nil {
+		return Token{}, err
+	} else if res, err := send(s.http, req, s.retry); err != nil {
+		return Token{}, err
+	} else if err := Decode(res.Body, &deviceCodeRes); err != nil {
+		return Token{}, err
+	}
+
+	if deviceCodeRes.Message != "" {
+		fmt.Fprintln(w, deviceCodeRes.Message)
+	} else {
+		fmt.Fprintf(w, "To sign in, use a web browser to open the page %s and enter the code %s to authenticate.\n", deviceCodeRes.VerificationUri, deviceCodeRes.UserCode)
+	}
+
+	var (
+		interval = time.Duration(deviceCodeRes.Interval) * time.Second
+		expires  = time.Now().Add(time.Duration(deviceCodeRes.ExpiresIn) * time.Second)
+		poll     = url.Values{}
+	)
+
+	if interval <= 0 {
+		interval = defaultDeviceCodeInterval
+	}
+
+	poll.Add("grant_type", DeviceCodeGrantType)
+	poll.Add("client_id", constants.AzPowerShellClientID)
+	poll.Add("device_code", deviceCodeRes.DeviceCode)
+
+	for time.Now().Before(expires) {
+		select {
+		case <-ctx.Done():
+			return Token{}, ctx.Err()
+		case <-time.After(interval):
+		}
+
+		if token, pending, err := s.pollDeviceCode(ctx, tokenUrl, poll); err != nil {
+			return Token{}, err
+		} else if pending == "slow_down" {
+			interval += defaultDeviceCodeInterval
+		} else if pending == "" {
+			return token, nil
+		}
+	}
+	return Token{}, fmt.Errorf("device code expired before sign in was completed")
+}
+
+// pollDeviceCode makes a single device code token request. If the user has not yet completed sign in the returned
+// string holds the pending reason ("authorization_pending" or "slow_down").
+func (s *TokenBroker) pollDeviceCode(ctx context.Context, endpoint *url.URL, body url.Values) (Token, string, error) {
+	var token Token
+
+	if req, err := NewRequest(ctx, http.MethodPost, endpoint, body, nil, nil); err != nil {
+		return token, "", err
+	} else if res, err := s.http.Do(req); err != nil {
+		return token, "", err
+	} else if data, err := io.ReadAll(res.Body); err != nil {
+		res.Body.Close()
+		return token, "", err
+	} else {
+		res.Body.Close()
+		if res.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(bytes.NewReader(data)).Decode(&token); err != nil {
+				return token, "", err
+			} else {
+				return token, "", nil
+			}
+		}
+
+		var errRes oauthErrorResponse
+		if err := json.Unmarshal(data, &errRes); err != nil {
+			return token, "", fmt.Errorf("malformed error response, status code: %d", res.StatusCode)
+		}
+
+		switch errRes.Error {
+		case "authorization_pending", "slow_down":
+			return token, errRes.Error, nil
+		default:
+			return token, "", fmt.Errorf("device code authentication failed: %s: %s", errRes.Error, errRes.ErrorDescription)
+		}
+	}
+}
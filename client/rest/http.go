@@ -22,6 +22,7 @@ import (
 	"context"
 	"crypto/tls"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/cookiejar"
@@ -62,10 +63,54 @@ func NewHTTPClient(proxyUrl string) (*http.Client, error) {
 		}
 	}
 
-	return &http.Client{
-		Jar:       jar,
-		Transport: transport,
-	}, nil
+	if base, err := cassetteTransport(transport); err != nil {
+		return nil, err
+	} else {
+		return &http.Client{
+			Jar:       jar,
+			Transport: base,
+		}, nil
+	}
+}
+
+// cassetteTransport wraps the transport to record its requests to the configured cassette or replaces it with one
+// replaying them, leaving it as is when neither is configured
+func cassetteTransport(transport http.RoundTripper) (http.RoundTripper, error) {
+	if path, ok := config.HTTPReplayFile.Value().(string); ok && path != "" {
+		if cassette, err := getCassette(path); err != nil {
+			return nil, fmt.Errorf("unable to load cassette: %w", err)
+		} else {
+			return replayingTransport{cassette}, nil
+		}
+	} else if path, ok := config.HTTPRecordFile.Value().(string); ok && path != "" {
+		if recorder, err := getRecorder(path); err != nil {
+			return nil, fmt.Errorf("unable to create cassette: %w", err)
+		} else {
+			return recordingTransport{base: transport, recorder: recorder}, nil
+		}
+	} else {
+		return transport, nil
+	}
+}
+
+// APILimiter returns the limiter shared by every client of the API host. Its ceiling is --streamCount, which is also the
+// number of workers each collection step runs, so the limit never grows past the concurrency the steps can offer.
+func APILimiter(host string) *Limiter {
+	return HostLimiter(host, config.ColStreamCount.Value().(int))
+}
+
+// NewLimitedHTTPClient returns an http client that adapts its concurrency to the throttling signals of the API host
+// using the limiter it shares with every other client of that host
+func NewLimitedHTTPClient(proxyUrl string, api url.URL) (*http.Client, error) {
+	if client, err := NewHTTPClient(proxyUrl); err != nil {
+		return nil, err
+	} else {
+		client.Transport = limitedTransport{
+			base:    client.Transport,
+			limiter: APILimiter(api.Host),
+		}
+		return client, nil
+	}
 }
 
 func NewRequest(
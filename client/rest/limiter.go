@@ -0,0 +1,251 @@
+// Copyright (C) 2024 Specter Ops, Inc.
+//
+// This file is part of AzureHound.
+//
+// AzureHound is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// AzureHound is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package rest
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	limiterInitial      = 4               // The number of concurrent requests a new limiter starts with
+	limiterMin          = 1               // The lower bound of a limiter
+	limiterDefaultMax   = 25              // The upper bound of a limiter when none is configured
+	limiterDecrease     = 0.5             // The factor by which the limit shrinks when the API pushes back
+	limiterDefaultPause = 1 * time.Second // How long to pause after a throttled response without a Retry-After header
+
+	remainingHeaderPrefix = "X-Ms-Ratelimit-Remaining-"
+	resourceUnitHeader    = "X-Ms-Resource-Unit"
+)
+
+var (
+	limiters      = make(map[string]*Limiter)
+	limitersMutex sync.Mutex
+)
+
+// HostLimiter returns the limiter shared by every client of the API host, creating it with the given upper bound when
+// it does not exist yet
+func HostLimiter(host string, max int) *Limiter {
+	limitersMutex.Lock()
+	defer limitersMutex.Unlock()
+
+	if limiter, ok := limiters[host]; ok {
+		return limiter
+	} else {
+		limiter := NewLimiter(max)
+		limiters[host] = limiter
+		return limiter
+	}
+}
+
+// Limiter adapts the number of concurrent requests to an API using additive increase, multiplicative decrease (AIMD).
+//
+// The limit doubles every round of successful requests until the API first pushes back, after which it grows by one
+// request per round. A round is as many requests as the limit and requests that cost more than one resource unit
+// count for less. The limit halves on a throttled response or when a response reports less remaining quota than
+// there are requests in flight, and new requests wait out any Retry-After.
+type Limiter struct {
+	mutex       sync.Mutex
+	limit       float64
+	max         float64
+	inFlight    int
+	slowStart   bool
+	decreasedAt time.Time
+	pausedUntil time.Time
+	changed     chan struct{}
+}
+
+func NewLimiter(max int) *Limiter {
+	if max < 1 {
+		max = limiterDefaultMax
+	}
+	return &Limiter{
+		limit:     math.Min(limiterInitial, float64(max)),
+		max:       float64(max),
+		slowStart: true,
+		changed:   make(chan struct{}),
+	}
+}
+
+// Limit returns the number of requests currently allowed in flight
+func (s *Limiter) Limit() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return int(s.limit)
+}
+
+// Acquire waits until another request may be sent and returns the function with which to report its outcome
+func (s *Limiter) Acquire(ctx context.Context) (func(*http.Response, error), error) {
+	for {
+		s.mutex.Lock()
+		var (
+			now     = time.Now()
+			changed = s.changed
+			timer   *time.Timer
+			wait    <-chan time.Time
+		)
+		if now.Before(s.pausedUntil) {
+			timer = time.NewTimer(s.pausedUntil.Sub(now))
+			wait = timer.C
+		} else if s.inFlight < int(s.limit) {
+			s.inFlight++
+			s.mutex.Unlock()
+			return func(res *http.Response, err error) { s.release(now, res, err) }, nil
+		}
+		s.mutex.Unlock()
+
+		select {
+		case <-ctx.Done():
+			if timer != nil {
+				timer.Stop()
+			}
+			return nil, ctx.Err()
+		case <-changed:
+		case <-wait:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+func (s *Limiter) release(sentAt time.Time, res *http.Response, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	defer s.notify()
+
+	s.inFlight--
+	if err != nil || res == nil {
+		// connection errors say nothing about the API's capacity
+		return
+	} else if !s.pushBack(sentAt, res.StatusCode, res.Header) && res.StatusCode < http.StatusBadRequest {
+		cost := 1.0
+		if units, err := strconv.ParseFloat(res.Header.Get(resourceUnitHeader), 64); err == nil && units > 1 {
+			cost = units
+		}
+		if s.slowStart {
+			s.limit += 1 / cost
+		} else {
+			s.limit += 1 / (s.limit * cost)
+		}
+		s.limit = math.Min(s.limit, s.max)
+	}
+}
+
+// Observe adapts the limit to the push-back in a response that was not sent through Acquire, such as one of the
+// responses within a JSON batch sent at the time. A success is not counted since the request that carried it was.
+func (s *Limiter) Observe(sentAt time.Time, status int, header http.Header) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	defer s.notify()
+
+	s.pushBack(sentAt, status, header)
+}
+
+// pushBack pauses and shrinks the limit when the response is throttled or reports less remaining quota than there are
+// requests in flight, returning whether it did. Must be called while holding the mutex.
+func (s *Limiter) pushBack(sentAt time.Time, status int, header http.Header) bool {
+	if status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable {
+		pause := limiterDefaultPause
+		if retryAfter, ok := ParseRetryAfter(header.Get("Retry-After")); ok {
+			pause = retryAfter
+		}
+		if until := time.Now().Add(pause); until.After(s.pausedUntil) {
+			s.pausedUntil = until
+		}
+		s.decrease(sentAt)
+		return true
+	} else if remaining, ok := remainingQuota(header); ok && remaining <= s.inFlight {
+		s.decrease(sentAt)
+		return true
+	} else {
+		return false
+	}
+}
+
+// decrease shrinks the limit once per round of requests; responses to requests sent before the last decrease do not
+// reflect it yet
+func (s *Limiter) decrease(sentAt time.Time) {
+	if sentAt.Before(s.decreasedAt) {
+		return
+	}
+	s.slowStart = false
+	s.decreasedAt = time.Now()
+	s.limit = math.Max(s.limit*limiterDecrease, limiterMin)
+}
+
+// notify wakes the requests waiting on the limiter. Must be called while holding the mutex.
+func (s *Limiter) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// remainingQuota returns the lowest quota reported by the x-ms-ratelimit-remaining-* headers of Azure Resource Manager
+// See https://learn.microsoft.com/en-us/azure/azure-resource-manager/management/request-limits-and-throttling
+func remainingQuota(header http.Header) (int, bool) {
+	var (
+		lowest = math.MaxInt
+		found  = false
+	)
+	for key, values := range header {
+		if !strings.HasPrefix(http.CanonicalHeaderKey(key), remainingHeaderPrefix) || len(values) == 0 {
+			continue
+		} else if remaining, err := strconv.Atoi(values[0]); err == nil && remaining < lowest {
+			lowest, found = remaining, true
+		}
+	}
+	return lowest, found
+}
+
+// ParseRetryAfter parses a Retry-After header given either in seconds or as an HTTP date
+func ParseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	} else if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds >= 0 {
+		return time.Duration(seconds * float64(time.Second)), true
+	} else if date, err := http.ParseTime(value); err == nil {
+		if delay := time.Until(date); delay > 0 {
+			return delay, true
+		} else {
+			return 0, true
+		}
+	} else {
+		return 0, false
+	}
+}
+
+// limitedTransport holds each request until the limiter of its API host allows it to be sent
+type limitedTransport struct {
+	base    http.RoundTripper
+	limiter *Limiter
+}
+
+func (s limitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if release, err := s.limiter.Acquire(req.Context()); err != nil {
+		return nil, err
+	} else {
+		res, err := s.base.RoundTrip(req)
+		release(res, err)
+		return res, err
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright (C) 2024 Specter Ops, Inc.
+//
+// This file is part of AzureHound.
+//
+// AzureHound is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// AzureHound is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package rest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	var (
+		capacity  atomic.Int32
+		inFlight  atomic.Int32
+		throttled atomic.Int32
+		limiter   = NewLimiter(20)
+	)
+
+	// the fake server throttles every request beyond its capacity
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer inFlight.Add(-1)
+		if current := inFlight.Add(1); capacity.Load() > 0 && current > capacity.Load() {
+			throttled.Add(1)
+			w.Header().Set("Retry-After", "0.01")
+			w.WriteHeader(http.StatusTooManyRequests)
+		} else {
+			time.Sleep(2 * time.Millisecond)
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer testServer.Close()
+
+	client := &http.Client{Transport: limitedTransport{base: http.DefaultTransport, limiter: limiter}}
+	run := func(requests int) {
+		var wg sync.WaitGroup
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < requests/50; j++ {
+					if res, err := client.Get(testServer.URL); err != nil {
+						t.Error(err)
+					} else {
+						res.Body.Close()
+					}
+				}
+			}()
+		}
+		wg.Wait()
+	}
+
+	// Concurrency grows to the maximum while requests succeed
+	run(500)
+	if limit := limiter.Limit(); limit != 20 {
+		t.Errorf("got limit %d after successful requests, want 20", limit)
+	} else if throttled.Load() != 0 {
+		t.Errorf("got %d throttled requests from a server without a capacity", throttled.Load())
+	}
+
+	// Concurrency shrinks when the server pushes back
+	capacity.Store(5)
+	run(500)
+	if throttled.Load() == 0 {
+		t.Error("expected the server to throttle requests but it did not")
+	} else if limit := limiter.Limit(); limit >= 20 {
+		t.Errorf("got limit %d after throttled requests, want less than 20", limit)
+	}
+
+	// and settles near the capacity of the server
+	throttled.Store(0)
+	run(500)
+	if count := throttled.Load(); count > 100 {
+		t.Errorf("got %d of 500 requests throttled after the limiter adapted", count)
+	}
+}
+
+func TestLimiterHeaders(t *testing.T) {
+	limiter := NewLimiter(8)
+	limiter.limit = 8
+
+	release := func(header http.Header, status int) {
+		if release, err := limiter.Acquire(context.Background()); err != nil {
+			t.Fatal(err)
+		} else {
+			release(&http.Response{StatusCode: status, Header: header}, nil)
+		}
+	}
+
+	// A response reporting less remaining quota than requests in flight shrinks the limit
+	release(http.Header{"X-Ms-Ratelimit-Remaining-Subscription-Reads": {"0"}}, http.StatusOK)
+	if limit := limiter.Limit(); limit != 4 {
+		t.Errorf("got limit %d, want 4", limit)
+	}
+
+	// Requests that cost more resource units grow the limit more slowly
+	limiter.limit = 4
+	release(http.Header{"X-Ms-Resource-Unit": {"4"}}, http.StatusOK)
+	if limiter.limit != 4+1.0/16 {
+		t.Errorf("got limit %f, want %f", limiter.limit, 4+1.0/16)
+	}
+
+	// A throttled response pauses new requests for as long as Retry-After asks
+	start := time.Now()
+	release(http.Header{"Retry-After": {"0.2"}}, http.StatusTooManyRequests)
+	release(http.Header{}, http.StatusOK)
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("request sent %s after a throttled response, want at least 200ms", elapsed)
+	}
+
+	// A throttled response observed outside of Acquire pauses and shrinks the limit too
+	limiter.limit = 8
+	limiter.decreasedAt = time.Time{}
+	limiter.Observe(time.Now(), http.StatusTooManyRequests, http.Header{"Retry-After": {"60"}})
+	if limit := limiter.Limit(); limit != 4 {
+		t.Errorf("got limit %d after an observed throttled response, want 4", limit)
+	} else if until := time.Until(limiter.pausedUntil); until < 59*time.Second {
+		t.Errorf("paused for %s after an observed throttled response, want 60s", until)
+	}
+
+	// A canceled request stops waiting
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	limiter.pausedUntil = time.Now().Add(time.Hour)
+	if _, err := limiter.Acquire(ctx); err == nil {
+		t.Error("expected an error acquiring a paused limiter with a canceled context")
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	if delay, ok := ParseRetryAfter("120"); !ok || delay != 2*time.Minute {
+		t.Errorf("got %s, want 2m", delay)
+	}
+	if delay, ok := ParseRetryAfter(time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)); !ok || delay <= 58*time.Second || delay > time.Minute {
+		t.Errorf("got %s, want about 1m", delay)
+	}
+	if delay, ok := ParseRetryAfter(time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat)); !ok || delay != 0 {
+		t.Errorf("got %s, want 0 for a date in the past", delay)
+	}
+	if _, ok := ParseRetryAfter("soon"); ok {
+		t.Error("expected a malformed Retry-After to be rejected")
+	}
+}
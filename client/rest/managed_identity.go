@@ -0,0 +1,165 @@
+// Copyright (C) 2024 Specter Ops, Inc.
+//
+// This file is part of AzureHound.
+//
+// AzureHound is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// AzureHound is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+const (
+	// Instance Metadata Service (https://learn.microsoft.com/en-us/entra/identity/managed-identities-azure-resources/how-to-use-vm-token)
+	imdsDefaultHost = "http://169.254.169.254"
+	imdsTokenPath   = "/metadata/identity/oauth2/token"
+	imdsApiVersion  = "2018-02-01"
+
+	// App Service, Container Apps and Functions (https://learn.microsoft.com/en-us/azure/app-service/overview-managed-identity#rest-endpoint-reference)
+	identityEndpointEnv   = "IDENTITY_ENDPOINT"
+	identityHeaderEnv     = "IDENTITY_HEADER"
+	identityApiVersion    = "2019-08-01"
+	imdsAuthorityHostEnv  = "AZURE_POD_IDENTITY_AUTHORITY_HOST"
+	managedIdentityMaxTry = 3
+)
+
+type managedIdentityResponse struct {
+	AccessToken string      `json:"access_token"` // The requested access token
+	ExpiresIn   json.Number `json:"expires_in"`   // How long the access token is valid in seconds; IMDS only
+	ExpiresOn   json.Number `json:"expires_on"`   // When the access token expires in seconds since epoch
+	Resource    string      `json:"resource"`     // The audience of the access token
+	TokenType   string      `json:"token_type"`   // Indicates the token type value
+}
+
+func (s managedIdentityResponse) toToken() Token {
+	token := Token{
+		accessToken: s.AccessToken,
+	}
+
+	if expiresIn, err := s.ExpiresIn.Int64(); err == nil {
+		token.expiresIn = int(expiresIn)
+		token.expires = time.Now().Add(time.Duration(expiresIn) * time.Second)
+	} else if expiresOn, err := s.ExpiresOn.Int64(); err == nil {
+		token.expires = time.Unix(expiresOn, 0)
+		token.expiresIn = int(time.Until(token.expires).Seconds())
+	}
+	return token
+}
+
+// newManagedIdentityRequest builds a token request for the managed identity endpoint available on the current host.
+// The App Service style IDENTITY_ENDPOINT is preferred when present, otherwise the Instance Metadata Service is used.
+func newManagedIdentityRequest(ctx context.Context, resource string, clientId string) (*http.Request, error) {
+	var (
+		params  = map[string]string{"resource": resource}
+		headers = map[string]string{}
+		rawUrl  string
+	)
+
+	if endpoint, secret := os.Getenv(identityEndpointEnv), os.Getenv(identityHeaderEnv); endpoint != "" && secret != "" {
+		rawUrl = endpoint
+		params["api-version"] = identityApiVersion
+		headers["X-IDENTITY-HEADER"] = secret
+	} else {
+		host := imdsDefaultHost
+		if override := os.Getenv(imdsAuthorityHostEnv); override != "" {
+			host = override
+		}
+		rawUrl = host + imdsTokenPath
+		params["api-version"] = imdsApiVersion
+		headers["Metadata"] = "true"
+	}
+
+	if clientId != "" {
+		params["client_id"] = clientId
+	}
+
+	if endpoint, err := url.Parse(rawUrl); err != nil {
+		return nil, err
+	} else {
+		return NewRequest(ctx, http.MethodGet, endpoint, nil, params, headers)
+	}
+}
+
+// newManagedIdentityHTTPClient returns a client that never uses the configured forward proxy; managed identity
+// endpoints are only reachable from the host itself. Its requests are still recorded to or replayed from a cassette.
+func newManagedIdentityHTTPClient() (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	if base, err := cassetteTransport(transport); err != nil {
+		return nil, err
+	} else {
+		return &http.Client{
+			Transport: base,
+			Timeout:   30 * time.Second,
+		}, nil
+	}
+}
+
+func (s *TokenBroker) managedIdentityLogin(ctx context.Context, resource url.URL) (Token, error) {
+	var (
+		response managedIdentityResponse
+		err      error
+	)
+
+	client, err := newManagedIdentityHTTPClient()
+	if err != nil {
+		return Token{}, err
+	}
+
+	for retry := 0; retry < managedIdentityMaxTry; retry++ {
+		var (
+			req *http.Request
+			res *http.Response
+		)
+
+		if req, err = newManagedIdentityRequest(ctx, audience(resource), s.managedIdentityId); err != nil {
+			return Token{}, err
+		} else if res, err = client.Do(req); err != nil {
+			return Token{}, fmt.Errorf("unable to reach managed identity endpoint: %w", err)
+		} else if res.StatusCode == http.StatusOK {
+			if err := Decode(res.Body, &response); err != nil {
+				return Token{}, err
+			} else {
+				return response.toToken(), nil
+			}
+		} else {
+			var errRes map[string]interface{}
+			if decodeErr := Decode(res.Body, &errRes); decodeErr != nil {
+				err = fmt.Errorf("managed identity endpoint returned status code: %d", res.StatusCode)
+			} else {
+				err = fmt.Errorf("managed identity endpoint returned status code %d: %v", res.StatusCode, errRes)
+			}
+
+			// IMDS returns 404 while the identity is still being provisioned; see
+			// https://learn.microsoft.com/en-us/entra/identity/managed-identities-azure-resources/how-to-use-vm-token#error-handling
+			if res.StatusCode == http.StatusNotFound || res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= http.StatusInternalServerError {
+				if retryAfter, ok := ParseRetryAfter(res.Header.Get("Retry-After")); ok {
+					time.Sleep(retryAfter)
+				} else {
+					ExponentialBackoff(retry)
+				}
+				continue
+			}
+			return Token{}, err
+		}
+	}
+	return Token{}, fmt.Errorf("unable to acquire managed identity token after %d attempts: %w", managedIdentityMaxTry, err)
+}
@@ -0,0 +1,97 @@
+// Copyright (C) 2024 Specter Ops, Inc.
+//
+// This file is part of AzureHound.
+//
+// AzureHound is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// AzureHound is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// MSAL family of client IDs; refresh tokens issued to one family member may be redeemed by any other
+const msalFamilyId = "1"
+
+type msalCache struct {
+	Account      map[string]msalAccount      `json:"Account"`
+	RefreshToken map[string]msalRefreshToken `json:"RefreshToken"`
+}
+
+type msalAccount struct {
+	HomeAccountId string `json:"home_account_id"` // <object id>.<home tenant id>
+	Environment   string `json:"environment"`
+	Realm         string `json:"realm"` // The tenant the account signed into
+	Username      string `json:"username"`
+}
+
+type msalRefreshToken struct {
+	HomeAccountId        string `json:"home_account_id"`
+	Environment          string `json:"environment"`
+	ClientId             string `json:"client_id"`
+	FamilyId             string `json:"family_id"`
+	Secret               string `json:"secret"`
+	LastModificationTime string `json:"last_modification_time"`
+}
+
+// ReadMSALRefreshToken returns the refresh token from an unencrypted MSAL token cache belonging to the account signed
+// into the given tenant. The tenant may be a GUID or a verified domain. When more than one account matches, username
+// is used to choose between them.
+func ReadMSALRefreshToken(path string, tenant string, username string) (string, error) {
+	var cache msalCache
+
+	if content, err := os.ReadFile(path); err != nil {
+		return "", fmt.Errorf("unable to read MSAL token cache: %w", err)
+	} else if err := json.Unmarshal(content, &cache); err != nil {
+		return "", fmt.Errorf("malformed MSAL token cache: %w", err)
+	}
+
+	homeAccountIds := map[string]bool{}
+	for _, account := range cache.Account {
+		if username != "" && !strings.EqualFold(account.Username, username) {
+			continue
+		} else if strings.EqualFold(account.Realm, tenant) || strings.HasSuffix(strings.ToLower(account.Username), "@"+strings.ToLower(tenant)) {
+			homeAccountIds[account.HomeAccountId] = true
+		}
+	}
+
+	if len(homeAccountIds) == 0 {
+		return "", fmt.Errorf("no account signed into tenant %s found in MSAL token cache %s; run 'az login --tenant %s'", tenant, path, tenant)
+	} else if len(homeAccountIds) > 1 {
+		return "", fmt.Errorf("more than one account signed into tenant %s found in MSAL token cache; specify one with --username", tenant)
+	}
+
+	var (
+		match    msalRefreshToken
+		modified int64 = -1
+	)
+	for _, refreshToken := range cache.RefreshToken {
+		if !homeAccountIds[refreshToken.HomeAccountId] || refreshToken.FamilyId != msalFamilyId || refreshToken.Secret == "" {
+			continue
+		} else if lastModified, _ := strconv.ParseInt(refreshToken.LastModificationTime, 10, 64); lastModified > modified {
+			match = refreshToken
+			modified = lastModified
+		}
+	}
+
+	if match.Secret == "" {
+		return "", fmt.Errorf("no refresh token for tenant %s found in MSAL token cache %s", tenant, path)
+	} else {
+		return match.Secret, nil
+	}
+}
@@ -0,0 +1,234 @@
+// Copyright (C) 2024 Specter Ops, Inc.
+//
+// This file is part of AzureHound.
+//
+// AzureHound is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// AzureHound is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package rest
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bloodhoundad/azurehound/v2/config"
+	"github.com/bloodhoundad/azurehound/v2/tracing"
+)
+
+const (
+	retryBaseDelay = 1 * time.Second  // The backoff before the first retry, doubling with each attempt
+	retryMaxDelay  = 60 * time.Second // The upper bound of the backoff between two attempts
+)
+
+// RetryPolicy decides whether a failed request is sent again and how long to wait before doing so
+type RetryPolicy interface {
+	// Retry is given the number of attempts made so far, the time since the first attempt and the outcome of the last
+	// attempt. It returns how long to wait before the next attempt, or false to give up.
+	Retry(attempts int, elapsed time.Duration, res *http.Response, err error) (time.Duration, bool)
+}
+
+// BackoffPolicy retries dropped connections and the configured status codes with an exponential backoff and full
+// jitter, honoring the Retry-After header of a response when there is one.
+// See https://aws.amazon.com/blogs/architecture/exponential-backoff-and-jitter/
+type BackoffPolicy struct {
+	MaxAttempts int               // The number of attempts after which to give up
+	MaxElapsed  time.Duration     // The time since the first attempt after which to give up; 0 for no limit
+	BaseDelay   time.Duration     // The backoff before the first retry
+	MaxDelay    time.Duration     // The upper bound of the backoff between two attempts
+	StatusCodes map[int]RetryRule // The response status codes to retry
+}
+
+// RetryRule is the rule for retrying responses with a particular status code
+type RetryRule struct {
+	MaxAttempts int // The number of attempts after which to give up; 0 to retry until the max elapsed time
+}
+
+// NewRetryPolicy returns the retry policy configured with the retryMaxAttempts, retryMaxElapsed and retryStatusCodes
+// options
+func NewRetryPolicy() (*BackoffPolicy, error) {
+	policy := &BackoffPolicy{
+		MaxAttempts: config.ColRetryMaxAttempts.Value().(int),
+		MaxElapsed:  time.Duration(config.ColRetryMaxElapsed.Value().(int)) * time.Second,
+		BaseDelay:   retryBaseDelay,
+		MaxDelay:    retryMaxDelay,
+	}
+
+	// fall back to the defaults when collection options have not been initialized
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = config.ColRetryMaxAttempts.Default.(int)
+	}
+	if policy.MaxElapsed <= 0 {
+		policy.MaxElapsed = time.Duration(config.ColRetryMaxElapsed.Default.(int)) * time.Second
+	}
+
+	rules := config.ColRetryStatusCodes.Value().([]string)
+	if len(rules) == 0 {
+		rules = config.ColRetryStatusCodes.Default.([]string)
+	}
+	if statusCodes, err := ParseRetryRules(rules, policy.MaxAttempts); err != nil {
+		return nil, err
+	} else {
+		policy.StatusCodes = statusCodes
+		return policy, nil
+	}
+}
+
+// ParseRetryRules parses rules of the form CODE[=ATTEMPTS] where CODE is a status code such as 503 or a class of
+// status codes such as 5xx. Rules without a number of attempts use the given default.
+func ParseRetryRules(rules []string, maxAttempts int) (map[int]RetryRule, error) {
+	statusCodes := make(map[int]RetryRule)
+	for _, rule := range rules {
+		var (
+			code, attempts, hasAttempts = strings.Cut(strings.TrimSpace(rule), "=")
+			retryRule                   = RetryRule{MaxAttempts: maxAttempts}
+		)
+
+		if hasAttempts {
+			if n, err := strconv.Atoi(attempts); err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid number of attempts in retry rule %q", rule)
+			} else {
+				retryRule.MaxAttempts = n
+			}
+		}
+
+		if class := strings.TrimSuffix(strings.ToLower(code), "xx"); len(class) == 1 && class != code {
+			if n, err := strconv.Atoi(class); err != nil || n < 1 || n > 5 {
+				return nil, fmt.Errorf("invalid status code class in retry rule %q", rule)
+			} else {
+				for status := n * 100; status < (n+1)*100; status++ {
+					// a specific status code takes precedence over its class
+					if _, ok := statusCodes[status]; !ok {
+						statusCodes[status] = retryRule
+					}
+				}
+			}
+		} else if status, err := strconv.Atoi(code); err != nil || status < 100 || status > 599 {
+			return nil, fmt.Errorf("invalid status code in retry rule %q", rule)
+		} else {
+			statusCodes[status] = retryRule
+		}
+	}
+	return statusCodes, nil
+}
+
+func (s BackoffPolicy) Retry(attempts int, elapsed time.Duration, res *http.Response, err error) (time.Duration, bool) {
+	maxAttempts := s.MaxAttempts
+	if err != nil {
+		if !IsClosedConnectionErr(err) {
+			return 0, false
+		}
+	} else if rule, ok := s.StatusCodes[res.StatusCode]; !ok {
+		return 0, false
+	} else {
+		maxAttempts = rule.MaxAttempts
+	}
+
+	if maxAttempts > 0 && attempts >= maxAttempts {
+		return 0, false
+	}
+
+	var delay time.Duration
+	if retryAfter, ok := retryAfter(res); ok {
+		delay = retryAfter
+	} else {
+		// full jitter: a random delay between zero and the exponential backoff
+		backoff := s.MaxDelay
+		if shift := attempts - 1; shift < 32 && s.BaseDelay<<shift < s.MaxDelay && s.BaseDelay<<shift > 0 {
+			backoff = s.BaseDelay << shift
+		}
+		if backoff > 0 {
+			delay = time.Duration(rand.Int63n(int64(backoff)))
+		}
+	}
+
+	if s.MaxElapsed > 0 && elapsed+delay > s.MaxElapsed {
+		return 0, false
+	} else {
+		return delay, true
+	}
+}
+
+func retryAfter(res *http.Response) (time.Duration, bool) {
+	if res == nil {
+		return 0, false
+	} else {
+		return ParseRetryAfter(res.Header.Get("Retry-After"))
+	}
+}
+
+// SendWithRetry makes the request with the client, sending it again for as long as the policy allows. The response to the last
+// attempt is returned whatever its status code; the caller decides what an unsuccessful response means.
+func SendWithRetry(client *http.Client, req *http.Request, policy RetryPolicy) (*http.Response, int, error) {
+	// copy the bytes in case we need to retry the request
+	body, err := CopyBody(req)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var (
+		start    = time.Now()
+		attempts = 0
+	)
+	for {
+		// Reusing http.Request requires rewinding the request body back to a working state
+		if body != nil && attempts > 0 {
+			req.Body = io.NopCloser(bytes.NewBuffer(body))
+		}
+
+		attempts++
+		res, err := client.Do(req)
+		if err == nil && res.StatusCode >= http.StatusOK && res.StatusCode < http.StatusBadRequest {
+			return res, attempts, nil
+		} else if delay, ok := policy.Retry(attempts, time.Since(start), res, err); !ok {
+			return res, attempts, err
+		} else {
+			traceRetry(req, attempts, delay, res, err)
+			if res != nil {
+				// drain the body so the connection can be reused
+				io.Copy(io.Discard, res.Body)
+				res.Body.Close()
+			}
+
+			select {
+			case <-req.Context().Done():
+				return nil, attempts, req.Context().Err()
+			case <-time.After(delay):
+			}
+		}
+	}
+}
+
+// traceRetry records the retry of a request, and whether it was throttled, on the span of the request context
+func traceRetry(req *http.Request, attempts int, delay time.Duration, res *http.Response, err error) {
+	span := tracing.SpanFromContext(req.Context())
+	if span == nil {
+		return
+	}
+
+	attributes := []tracing.Attribute{tracing.Int("azurehound.attempt", attempts), tracing.Float64("azurehound.retry.delay_seconds", delay.Seconds())}
+	if err != nil {
+		attributes = append(attributes, tracing.String("error.message", err.Error()))
+	} else if res != nil {
+		attributes = append(attributes, tracing.Int("http.response.status_code", res.StatusCode))
+	}
+	if res != nil && res.StatusCode == http.StatusTooManyRequests {
+		span.AddEvent("throttle", attributes...)
+	}
+	span.AddEvent("retry", attributes...)
+}
@@ -0,0 +1,209 @@
+// Copyright (C) 2024 Specter Ops, Inc.
+//
+// This file is part of AzureHound.
+//
+// AzureHound is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// AzureHound is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package rest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+
+	"github.com/bloodhoundad/azurehound/v2/tracing"
+)
+
+func TestParseRetryRules(t *testing.T) {
+	if rules, err := ParseRetryRules([]string{"429=0", "503=2", "5xx"}, 5); err != nil {
+		t.Fatal(err)
+	} else if len(rules) != 101 {
+		t.Errorf("got %d rules, want 101", len(rules))
+	} else if rules[429].MaxAttempts != 0 {
+		t.Errorf("got %d attempts for 429, want 0", rules[429].MaxAttempts)
+	} else if rules[503].MaxAttempts != 2 {
+		t.Errorf("got %d attempts for 503 which should take precedence over 5xx, want 2", rules[503].MaxAttempts)
+	} else if rules[500].MaxAttempts != 5 {
+		t.Errorf("got %d attempts for 500, want the default of 5", rules[500].MaxAttempts)
+	} else if _, ok := rules[404]; ok {
+		t.Error("got a rule for 404 which was not configured")
+	}
+
+	for _, rule := range []string{"abc", "600", "429=-1", "429=x", "9xx", "x"} {
+		if _, err := ParseRetryRules([]string{rule}, 5); err == nil {
+			t.Errorf("expected retry rule %q to be rejected", rule)
+		}
+	}
+}
+
+func TestBackoffPolicy(t *testing.T) {
+	policy := BackoffPolicy{
+		MaxAttempts: 3,
+		MaxElapsed:  time.Minute,
+		BaseDelay:   time.Second,
+		MaxDelay:    4 * time.Second,
+		StatusCodes: map[int]RetryRule{
+			http.StatusTooManyRequests: {MaxAttempts: 0},
+			http.StatusBadGateway:      {MaxAttempts: 3},
+		},
+	}
+	response := func(status int, retryAfter string) *http.Response {
+		res := &http.Response{StatusCode: status, Header: http.Header{}}
+		if retryAfter != "" {
+			res.Header.Set("Retry-After", retryAfter)
+		}
+		return res
+	}
+
+	// Full jitter keeps each delay between zero and the capped exponential backoff
+	for attempts := 1; attempts < 3; attempts++ {
+		for i := 0; i < 100; i++ {
+			backoff := time.Second << (attempts - 1)
+			if delay, ok := policy.Retry(attempts, 0, response(http.StatusBadGateway, ""), nil); !ok {
+				t.Fatalf("attempt %d: expected a retry", attempts)
+			} else if delay < 0 || delay >= backoff {
+				t.Fatalf("attempt %d: got delay %s, want less than %s", attempts, delay, backoff)
+			}
+		}
+	}
+
+	if _, ok := policy.Retry(3, 0, response(http.StatusBadGateway, ""), nil); ok {
+		t.Error("expected no retry once the max attempts are spent")
+	}
+	if _, ok := policy.Retry(1, 0, response(http.StatusBadRequest, ""), nil); ok {
+		t.Error("expected no retry for a status code without a rule")
+	}
+	if _, ok := policy.Retry(1, 0, nil, errors.New("dial tcp: connection refused")); ok {
+		t.Error("expected no retry for an error other than a closed connection")
+	}
+	if _, ok := policy.Retry(1, 0, nil, fmt.Errorf("Post \"https://example.com\": EOF")); !ok {
+		t.Error("expected a retry for a closed connection")
+	}
+
+	// Throttled requests are retried regardless of attempts until the max elapsed time
+	if delay, ok := policy.Retry(10, 0, response(http.StatusTooManyRequests, "30"), nil); !ok || delay != 30*time.Second {
+		t.Errorf("got delay %s, want the 30s asked for by Retry-After", delay)
+	}
+	if _, ok := policy.Retry(10, 45*time.Second, response(http.StatusTooManyRequests, "30"), nil); ok {
+		t.Error("expected no retry past the max elapsed time")
+	}
+	retryAt := time.Now().Add(10 * time.Second).UTC().Format(http.TimeFormat)
+	if delay, ok := policy.Retry(1, 0, response(http.StatusTooManyRequests, retryAt), nil); !ok || delay <= 8*time.Second || delay > 10*time.Second {
+		t.Errorf("got delay %s, want about 10s for a Retry-After date", delay)
+	}
+}
+
+func TestSendWithRetry(t *testing.T) {
+	var attempts int
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			// an HTTP date in the past asks for an immediate retry
+			w.Header().Set("Retry-After", time.Now().Add(-time.Second).UTC().Format(http.TimeFormat))
+			w.WriteHeader(http.StatusTooManyRequests)
+		} else if attempts == 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		} else {
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer testServer.Close()
+
+	policy := BackoffPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   time.Millisecond,
+		MaxDelay:    time.Millisecond,
+		StatusCodes: map[int]RetryRule{
+			http.StatusTooManyRequests:    {MaxAttempts: 3},
+			http.StatusServiceUnavailable: {MaxAttempts: 3},
+		},
+	}
+
+	if req, err := http.NewRequest(http.MethodGet, testServer.URL, nil); err != nil {
+		t.Fatal(err)
+	} else if res, n, err := SendWithRetry(http.DefaultClient, req, policy); err != nil {
+		t.Fatal(err)
+	} else if res.StatusCode != http.StatusOK || n != 3 {
+		t.Errorf("got status %d after %d attempts, want 200 after 3", res.StatusCode, n)
+	}
+
+	// The last response is returned once the policy gives up
+	attempts = 1
+	policy.StatusCodes[http.StatusServiceUnavailable] = RetryRule{MaxAttempts: 1}
+	if req, err := http.NewRequest(http.MethodGet, testServer.URL, nil); err != nil {
+		t.Fatal(err)
+	} else if res, n, err := SendWithRetry(http.DefaultClient, req, policy); err != nil {
+		t.Fatal(err)
+	} else if res.StatusCode != http.StatusServiceUnavailable || n != 1 {
+		t.Errorf("got status %d after %d attempts, want 503 after 1", res.StatusCode, n)
+	}
+}
+
+func TestSendTracing(t *testing.T) {
+	var attempts int
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		} else {
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer testServer.Close()
+
+	exporter := &tracing.MemoryExporter{}
+	tracing.Init(exporter, logr.Discard())
+	defer tracing.Shutdown(context.Background())
+
+	policy := BackoffPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   time.Millisecond,
+		MaxDelay:    time.Millisecond,
+		StatusCodes: map[int]RetryRule{http.StatusTooManyRequests: {MaxAttempts: 3}},
+	}
+
+	ctx, parent := tracing.Start(context.Background(), "list users", tracing.SpanKindInternal)
+	if req, err := http.NewRequestWithContext(ctx, http.MethodGet, testServer.URL+"/v1.0/users", nil); err != nil {
+		t.Fatal(err)
+	} else if _, err := send(http.DefaultClient, req, policy); err != nil {
+		t.Fatal(err)
+	}
+	parent.End()
+	if err := tracing.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := exporter.Spans()
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want the request and its parent", len(spans))
+	}
+	span := spans[0]
+	if span.Name != "HTTP GET" || span.Kind != tracing.SpanKindClient || span.ParentID != spans[1].SpanID || span.TraceID != spans[1].TraceID {
+		t.Errorf("got span %+v, want a client span that is a child of the stage", span)
+	}
+	var events []string
+	for _, event := range span.Events {
+		events = append(events, event.Name)
+	}
+	if fmt.Sprint(events) != "[throttle retry]" {
+		t.Errorf("got events %v, want the throttled attempt and its retry", events)
+	}
+}
@@ -25,6 +25,7 @@ import (
 
 type Token struct {
 	accessToken  string
+	refreshToken string
 	expiresIn    int
 	extExpiresIn int
 	expires      time.Time
@@ -34,6 +35,34 @@ func (s Token) IsExpired() bool {
 	return time.Now().After(s.expires.Add(-10 * time.Second))
 }
 
+// tokenRenewalFraction is the fraction of an access token's lifetime after which it is renewed
+const tokenRenewalFraction = 0.75
+
+// renewAt returns when the access token should be renewed
+func (s Token) renewAt() time.Time {
+	lifetime := time.Duration(s.expiresIn) * time.Second
+	if lifetime <= 0 {
+		return s.expires
+	} else {
+		return s.expires.Add(-lifetime + time.Duration(float64(lifetime)*tokenRenewalFraction))
+	}
+}
+
+// RefreshToken returns the refresh token issued alongside the access token, if any
+func (s Token) RefreshToken() string {
+	return s.refreshToken
+}
+
+// Expires returns when the access token expires
+func (s Token) Expires() time.Time {
+	return s.expires
+}
+
+// Claims returns the decoded claims of the access token
+func (s Token) Claims() (map[string]interface{}, error) {
+	return ParseBody(s.accessToken)
+}
+
 func (s Token) String() string {
 	return fmt.Sprintf("Bearer %s", s.accessToken)
 }
@@ -41,6 +70,7 @@ func (s Token) String() string {
 func (s *Token) UnmarshalJSON(data []byte) error {
 	var res struct {
 		AccessToken  string `json:"access_token"`   // The token to use in calls to Microsoft Graph API
+		RefreshToken string `json:"refresh_token"`  // The token to use to acquire new access tokens; only issued for delegated flows
 		ExpiresIn    int    `json:"expires_in"`     // How long the access token is valid in seconds
 		ExtExpiresIn int    `json:"ext_expires_in"` // How long the access token is valid in seconds
 		TokenType    string `json:"token_type"`     // Indicates the token type value. The only type currently supported by Azure AD is `bearer`
@@ -50,6 +80,7 @@ func (s *Token) UnmarshalJSON(data []byte) error {
 		return err
 	} else {
 		s.accessToken = res.AccessToken
+		s.refreshToken = res.RefreshToken
 		s.expiresIn = res.ExpiresIn
 		s.extExpiresIn = res.ExtExpiresIn
 		s.expires = time.Now().Add(time.Duration(res.ExpiresIn) * time.Second)
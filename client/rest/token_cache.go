@@ -0,0 +1,259 @@
+// Copyright (C) 2024 Specter Ops, Inc.
+//
+// This file is part of AzureHound.
+//
+// AzureHound is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// AzureHound is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package rest
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	tokenCacheVersion = 1
+	tokenCacheSaltLen = 16
+
+	// scrypt parameters recommended for interactive logins (https://pkg.go.dev/golang.org/x/crypto/scrypt#Key)
+	scryptN      = 32768
+	scryptR      = 8
+	scryptP      = 1
+	scryptKeyLen = 32
+)
+
+var ErrTokenCacheDecrypt = errors.New("unable to decrypt token cache; wrong passphrase or key file?")
+
+var (
+	tokenCaches      = make(map[tokenCacheId]*TokenCache)
+	tokenCachesMutex sync.Mutex
+)
+
+// TokenCache persists access and refresh tokens across runs in a file encrypted with AES-256-GCM using a key derived
+// from a user supplied passphrase.
+type TokenCache struct {
+	path       string
+	passphrase []byte
+	mutex      sync.Mutex
+	salt       []byte
+	key        []byte
+}
+
+type tokenCacheId struct {
+	path       string
+	passphrase string
+}
+
+type tokenCacheFile struct {
+	Version int    `json:"version"`
+	Salt    []byte `json:"salt"`
+	Nonce   []byte `json:"nonce"`
+	Data    []byte `json:"data"`
+}
+
+type cachedToken struct {
+	AccessToken  string    `json:"accessToken"`
+	RefreshToken string    `json:"refreshToken,omitempty"`
+	Expires      time.Time `json:"expires"`
+}
+
+func NewTokenCache(path string, passphrase []byte) (*TokenCache, error) {
+	if path == "" {
+		return nil, fmt.Errorf("token cache path is required")
+	} else if len(passphrase) == 0 {
+		return nil, fmt.Errorf("a passphrase or key file is required to encrypt the token cache")
+	} else {
+		return &TokenCache{
+			path:       path,
+			passphrase: passphrase,
+		}, nil
+	}
+}
+
+// SharedTokenCache returns the token cache shared by every broker persisting to the file with the passphrase, creating
+// it when it does not exist yet. Brokers with caches of their own on the same file would overwrite each other's tokens.
+func SharedTokenCache(path string, passphrase []byte) (*TokenCache, error) {
+	tokenCachesMutex.Lock()
+	defer tokenCachesMutex.Unlock()
+
+	if abs, err := filepath.Abs(path); err != nil {
+		return nil, err
+	} else if cache, ok := tokenCaches[tokenCacheId{abs, string(passphrase)}]; ok {
+		return cache, nil
+	} else if cache, err := NewTokenCache(path, passphrase); err != nil {
+		return nil, err
+	} else {
+		tokenCaches[tokenCacheId{abs, string(passphrase)}] = cache
+		return cache, nil
+	}
+}
+
+// TokenCacheKey identifies a cached token by the tenant, client and audience it was issued for
+func TokenCacheKey(tenant, clientId, audience string) string {
+	return fmt.Sprintf("%s|%s|%s", tenant, clientId, audience)
+}
+
+// Load returns the cached token for the given key. Expired access tokens are still returned since their refresh token
+// may be redeemed for a new one.
+func (s *TokenCache) Load(key string) (Token, bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if entries, err := s.read(); err != nil {
+		return Token{}, false, err
+	} else if entry, ok := entries[key]; !ok {
+		return Token{}, false, nil
+	} else {
+		return Token{
+			accessToken:  entry.AccessToken,
+			refreshToken: entry.RefreshToken,
+			expiresIn:    int(time.Until(entry.Expires).Seconds()),
+			expires:      entry.Expires,
+		}, true, nil
+	}
+}
+
+// Store saves the token under the given key, replacing any existing entry
+func (s *TokenCache) Store(key string, token Token) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if entries, err := s.read(); err != nil {
+		return err
+	} else {
+		entries[key] = cachedToken{
+			AccessToken:  token.accessToken,
+			RefreshToken: token.refreshToken,
+			Expires:      token.expires,
+		}
+		return s.write(entries)
+	}
+}
+
+func (s *TokenCache) deriveKey(salt []byte) ([]byte, error) {
+	if s.key != nil && string(s.salt) == string(salt) {
+		return s.key, nil
+	} else if key, err := scrypt.Key(s.passphrase, salt, scryptN, scryptR, scryptP, scryptKeyLen); err != nil {
+		return nil, err
+	} else {
+		s.salt = salt
+		s.key = key
+		return key, nil
+	}
+}
+
+func (s *TokenCache) read() (map[string]cachedToken, error) {
+	var (
+		file    tokenCacheFile
+		entries = make(map[string]cachedToken)
+	)
+
+	if content, err := os.ReadFile(s.path); errors.Is(err, os.ErrNotExist) {
+		return entries, nil
+	} else if err != nil {
+		return nil, err
+	} else if err := json.Unmarshal(content, &file); err != nil {
+		return nil, fmt.Errorf("malformed token cache: %w", err)
+	} else if file.Version != tokenCacheVersion {
+		return nil, fmt.Errorf("unsupported token cache version: %d", file.Version)
+	} else if key, err := s.deriveKey(file.Salt); err != nil {
+		return nil, err
+	} else if gcm, err := newGCM(key); err != nil {
+		return nil, err
+	} else if plaintext, err := gcm.Open(nil, file.Nonce, file.Data, nil); err != nil {
+		return nil, ErrTokenCacheDecrypt
+	} else if err := json.Unmarshal(plaintext, &entries); err != nil {
+		return nil, fmt.Errorf("malformed token cache: %w", err)
+	} else {
+		return entries, nil
+	}
+}
+
+func (s *TokenCache) write(entries map[string]cachedToken) error {
+	salt := s.salt
+	if salt == nil {
+		salt = make([]byte, tokenCacheSaltLen)
+		if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+			return err
+		}
+	}
+
+	if plaintext, err := json.Marshal(entries); err != nil {
+		return err
+	} else if key, err := s.deriveKey(salt); err != nil {
+		return err
+	} else if gcm, err := newGCM(key); err != nil {
+		return err
+	} else {
+		nonce := make([]byte, gcm.NonceSize())
+		if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+			return err
+		}
+
+		file := tokenCacheFile{
+			Version: tokenCacheVersion,
+			Salt:    salt,
+			Nonce:   nonce,
+			Data:    gcm.Seal(nil, nonce, plaintext, nil),
+		}
+
+		if content, err := json.Marshal(file); err != nil {
+			return err
+		} else if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+			return err
+		} else {
+			// write to a temporary file first so a crash never leaves a truncated cache behind
+			return writeFileAtomic(s.path, content)
+		}
+	}
+}
+
+// writeFileAtomic replaces the file with one holding the content, readable by the current user alone. The temporary file
+// is unique so concurrent writers never write into the same one.
+func writeFileAtomic(path string, content []byte) error {
+	if tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp"); err != nil {
+		return err
+	} else if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	} else if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	} else if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	} else {
+		return nil
+	}
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if block, err := aes.NewCipher(key); err != nil {
+		return nil, err
+	} else {
+		return cipher.NewGCM(block)
+	}
+}
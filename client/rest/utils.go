@@ -28,6 +28,7 @@ import (
 	"io"
 	"math"
 	"net/http"
+	"os"
 	"strings"
 	"time"
 
@@ -76,6 +77,18 @@ func NewClientAssertion(tokenUrl string, clientId string, clientCert string, sig
 	}
 }
 
+// ReadClientAssertion reads a federated client assertion (e.g. a Kubernetes projected service account token or CI OIDC
+// token) from the given file. The file is read on every call since the issuer rotates it before it expires.
+func ReadClientAssertion(path string) (string, error) {
+	if content, err := os.ReadFile(path); err != nil {
+		return "", fmt.Errorf("Unable to read federated token file: %w", err)
+	} else if assertion := strings.TrimSpace(string(content)); assertion == "" {
+		return "", fmt.Errorf("Federated token file %s is empty", path)
+	} else {
+		return assertion, nil
+	}
+}
+
 func ParseBody(accessToken string) (map[string]interface{}, error) {
 	var (
 		body  = make(map[string]interface{})
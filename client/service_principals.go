@@ -54,7 +54,7 @@ func (s *azureClient) ListAzureADServicePrincipalOwners(ctx context.Context, obj
 		params.Top = 999
 	}
 
-	go getAzureObjectList[json.RawMessage](s.msgraph, ctx, path, params, out)
+	go getAzureObjectList[json.RawMessage](s.msgraphBatch, ctx, path, params, out)
 
 	return out
 }
@@ -0,0 +1,529 @@
+// Copyright (C) 2024 Specter Ops, Inc.
+//
+// This file is part of AzureHound.
+//
+// AzureHound is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// AzureHound is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/bloodhoundad/azurehound/v2/client"
+	"github.com/bloodhoundad/azurehound/v2/config"
+	"github.com/bloodhoundad/azurehound/v2/models"
+	"github.com/bloodhoundad/azurehound/v2/panicrecovery"
+	"github.com/bloodhoundad/azurehound/v2/pipeline"
+	"github.com/bloodhoundad/azurehound/v2/sinks"
+)
+
+// checkpointInterval is how often the output is synced to disk and the progress written so far journaled
+const checkpointInterval = 5 * time.Second
+
+// checkpoint journals the progress of a collection so that it can be resumed once interrupted. The journal records the
+// length of the output synced to disk, the stages written in full and the objects each stage has written: for the
+// stages that list relationships one parent object at a time, the parents whose relationships have been written.
+//
+// Only what has reached the output is journaled, so resuming truncates the output to the journaled length and repeats
+// whatever was lost beyond it.
+type checkpoint struct {
+	path        string // the journal
+	output      string
+	format      sinks.Format
+	compression sinks.Compression
+	journal     *os.File
+	mutex       sync.RWMutex
+	collected   map[string]map[string]bool // the keys of the objects written, by stage
+	completed   map[string]bool            // the stages written in full
+	offset      int64                      // the length of the output when last journaled
+	count       int                        // the number of objects in the output when last journaled
+	complete    bool                       // whether the collection finished
+}
+
+// checkpointRecord is a line of the journal. The first line names the output the journal is for and how it is written;
+// every line after it adds the progress made since the line before.
+type checkpointRecord struct {
+	Output      string              `json:"output,omitempty"`
+	Format      sinks.Format        `json:"format,omitempty"`
+	Compression sinks.Compression   `json:"compression,omitempty"`
+	Offset      int64               `json:"offset"`
+	Count       int                 `json:"count"`
+	Collected   map[string][]string `json:"collected,omitempty"`
+	Completed   []string            `json:"completed,omitempty"`
+	Complete    bool                `json:"complete,omitempty"`
+}
+
+// openCheckpoint begins the journal of the collection of a tenant into the output, or continues it when resuming
+func openCheckpoint(dir, tenantId, output string, format sinks.Format, compression sinks.Compression, resume bool) (*checkpoint, error) {
+	cp := &checkpoint{
+		path:        filepath.Join(dir, tenantId+".jsonl"),
+		output:      output,
+		format:      format,
+		compression: compression,
+		collected:   make(map[string]map[string]bool),
+		completed:   make(map[string]bool),
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	} else if !resume {
+		if journal, err := os.OpenFile(cp.path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600); err != nil {
+			return nil, err
+		} else {
+			cp.journal = journal
+			return cp, cp.append(checkpointRecord{Output: output, Format: format, Compression: compression})
+		}
+	} else if err := cp.load(); err != nil {
+		return nil, err
+	} else if journal, err := os.OpenFile(cp.path, os.O_WRONLY|os.O_APPEND, 0600); err != nil {
+		return nil, err
+	} else {
+		cp.journal = journal
+		return cp, nil
+	}
+}
+
+func (s *checkpoint) load() error {
+	file, err := os.Open(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("there is no collection to resume in %s", filepath.Dir(s.path))
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 64*1024*1024)
+	for line := 0; scanner.Scan(); line++ {
+		var record checkpointRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			// the last line is cut short when interrupted while journaling
+			break
+		} else if line == 0 && record.Output != s.output {
+			return fmt.Errorf("the collection journaled in %s was written to %s, not %s", filepath.Dir(s.path), record.Output, s.output)
+		} else if line == 0 && (record.Format != s.format || record.Compression != s.compression) {
+			return fmt.Errorf("the collection journaled in %s was written as %s compressed with %s, not %s with %s", filepath.Dir(s.path), record.Format, record.Compression, s.format, s.compression)
+		} else {
+			s.add(record)
+		}
+	}
+	return scanner.Err()
+}
+
+func (s *checkpoint) add(record checkpointRecord) {
+	s.mutex.Lock()
+
+	s.offset, s.count = record.Offset, record.Count
+	s.complete = s.complete || record.Complete
+	for _, stage := range record.Completed {
+		s.completed[stage] = true
+	}
+	s.mutex.Unlock()
+
+	for stage, parents := range record.Collected {
+		for _, parent := range parents {
+			s.markCollected(stage, parent)
+		}
+	}
+}
+
+func (s *checkpoint) markCollected(stage, parent string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.collected[stage]; !ok {
+		s.collected[stage] = make(map[string]bool)
+	}
+	s.collected[stage][parent] = true
+}
+
+func (s *checkpoint) append(record checkpointRecord) error {
+	if data, err := json.Marshal(record); err != nil {
+		return err
+	} else if _, err := s.journal.Write(append(data, '\n')); err != nil {
+		return err
+	} else {
+		return s.journal.Sync()
+	}
+}
+
+// wasCollected returns whether the object with the key, or the relationships of the parent with the key, have been
+// written by the stage
+func (s *checkpoint) wasCollected(stage, parent string) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.completed[stage] || s.collected[stage][parent]
+}
+
+// wasCompleted returns whether the stage has been written in full
+func (s *checkpoint) wasCompleted(stage string) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.completed[stage]
+}
+
+func (s *checkpoint) close() error {
+	return s.journal.Close()
+}
+
+type checkpointKey struct{}
+
+func withCheckpoint(ctx context.Context, cp *checkpoint) context.Context {
+	return context.WithValue(ctx, checkpointKey{}, cp)
+}
+
+// checkpointFromContext returns the checkpoint of the collection, or nil when it is not journaled
+func checkpointFromContext(ctx context.Context) *checkpoint {
+	cp, _ := ctx.Value(checkpointKey{}).(*checkpoint)
+	return cp
+}
+
+// checkpointItem carries an object to the output along with the stage that listed it. An item without an object marks
+// the end of the stage.
+type checkpointItem struct {
+	stage string
+	item  interface{}
+	end   bool
+}
+
+// checkpointed marks the objects the stage sends to the output so that its progress can be journaled once they are
+// written. Objects of a stage written in full by a previous run are dropped.
+func checkpointed(ctx context.Context, stage string, stream <-chan interface{}) <-chan interface{} {
+	cp := checkpointFromContext(ctx)
+	if cp == nil {
+		return stream
+	}
+
+	var (
+		out     = make(chan interface{})
+		written = cp.wasCompleted(stage)
+	)
+
+	go func() {
+		defer panicrecovery.PanicRecovery()
+		defer close(out)
+
+		for item := range pipeline.OrDone(ctx.Done(), stream) {
+			if written {
+				continue
+			} else if ok := pipeline.Send(ctx.Done(), out, interface{}(checkpointItem{stage: stage, item: item})); !ok {
+				return
+			}
+		}
+		if ctx.Err() == nil && !written {
+			pipeline.Send(ctx.Done(), out, interface{}(checkpointItem{stage: stage, end: true}))
+		}
+	}()
+
+	return out
+}
+
+// uncollected drops the parent objects whose relationships every one of the stages has already written
+func uncollected[T any](ctx context.Context, parents <-chan T, stages ...string) <-chan T {
+	cp := checkpointFromContext(ctx)
+	if cp == nil {
+		return parents
+	}
+
+	return pipeline.Filter(ctx.Done(), parents, func(item T) bool {
+		if parent, ok := parentKey(item); !ok {
+			return true
+		} else {
+			for _, stage := range stages {
+				if !cp.wasCollected(stage, parent) {
+					return true
+				}
+			}
+			return false
+		}
+	})
+}
+
+// wrapped is implemented by the wrappers of collected objects
+type wrapped interface {
+	wrappedData() interface{}
+}
+
+func (s AzureWrapper) wrappedData() interface{} {
+	return s.Data
+}
+
+func (s azureWrapper[T]) wrappedData() interface{} {
+	return s.Data
+}
+
+// parentKey returns the key by which the relationships listed for the parent object are journaled. This must match
+// the key relationshipKey returns for those relationships.
+func parentKey(item interface{}) (string, bool) {
+	wrapper, ok := item.(wrapped)
+	if !ok {
+		return "", false
+	}
+
+	switch data := wrapper.wrappedData().(type) {
+	case models.App:
+		// app owners are listed for the app ID rather than the object ID
+		return data.AppId, true
+	case models.Device:
+		return data.Id, true
+	case models.Group:
+		return data.Id, true
+	case models.ServicePrincipal:
+		return data.Id, true
+	case models.Role:
+		return data.Id, true
+	case models.ManagementGroup:
+		return data.Id, true
+	case models.Subscription:
+		return data.Id, true
+	case models.ResourceGroup:
+		return data.Id, true
+	case models.KeyVault:
+		return data.Id, true
+	case models.VirtualMachine:
+		return data.Id, true
+	case models.FunctionApp:
+		return data.Id, true
+	case models.WebApp:
+		return data.Id, true
+	case models.AutomationAccount:
+		return data.Id, true
+	case models.ContainerRegistry:
+		return data.Id, true
+	case models.LogicApp:
+		return data.Id, true
+	case models.ManagedCluster:
+		return data.Id, true
+	case models.VMScaleSet:
+		return data.Id, true
+	default:
+		return "", false
+	}
+}
+
+// relationshipKey returns the key of the parent object of relationships listed one parent at a time
+func relationshipKey(item interface{}) (string, bool) {
+	wrapper, ok := item.(wrapped)
+	if !ok {
+		return "", false
+	}
+
+	switch data := wrapper.wrappedData().(type) {
+	case models.AppOwners:
+		return data.AppId, true
+	case models.DeviceOwners:
+		return data.DeviceId, true
+	case models.GroupMembers:
+		return data.GroupId, true
+	case models.GroupOwners:
+		return data.GroupId, true
+	case models.ServicePrincipalOwners:
+		return data.ServicePrincipalId, true
+	case models.RoleAssignments:
+		return data.RoleDefinitionId, true
+	case models.ManagementGroupOwners:
+		return data.ManagementGroupId, true
+	case models.ManagementGroupUserAccessAdmins:
+		return data.ManagementGroupId, true
+	case models.SubscriptionOwners:
+		return data.SubscriptionId, true
+	case models.SubscriptionUserAccessAdmins:
+		return data.SubscriptionId, true
+	case models.ResourceGroupOwners:
+		return data.ResourceGroupId, true
+	case models.ResourceGroupUserAccessAdmins:
+		return data.ResourceGroupId, true
+	case models.KeyVaultOwners:
+		return data.KeyVaultId, true
+	case models.KeyVaultUserAccessAdmins:
+		return data.KeyVaultId, true
+	case models.KeyVaultContributors:
+		return data.KeyVaultId, true
+	case models.KeyVaultKVContributors:
+		return data.KeyVaultId, true
+	case models.VirtualMachineOwners:
+		return data.VirtualMachineId, true
+	case models.VirtualMachineAvereContributors:
+		return data.VirtualMachineId, true
+	case models.VirtualMachineContributors:
+		return data.VirtualMachineId, true
+	case models.VirtualMachineAdminLogins:
+		return data.VirtualMachineId, true
+	case models.VirtualMachineUserAccessAdmins:
+		return data.VirtualMachineId, true
+	case models.AzureRoleAssignments:
+		return data.ObjectId, true
+	default:
+		return "", false
+	}
+}
+
+// writeCheckpointed writes the stream to the output of the checkpoint, continuing from where the journal left off and
+// journaling progress as the objects of each stage are written
+func writeCheckpointed(ctx context.Context, cp *checkpoint, stream <-chan interface{}) error {
+	var (
+		writer  *sinks.FileWriter
+		err     error
+		ticker  = time.NewTicker(checkpointInterval)
+		pending = checkpointRecord{Collected: make(map[string][]string)}
+	)
+	defer ticker.Stop()
+
+	if cp.offset > 0 {
+		log.Info("resuming collection", "output", cp.output, "objects", cp.count)
+		writer, err = sinks.ResumeFile(cp.output, cp.format, cp.compression, cp.offset, cp.count)
+	} else {
+		writer, err = sinks.CreateFile(cp.output, cp.format, cp.compression)
+	}
+	if err != nil {
+		return err
+	}
+
+	journal := func(complete bool) error {
+		if offset, count, err := writer.Sync(); err != nil {
+			return err
+		} else {
+			pending.Offset, pending.Count, pending.Complete = offset, count, complete
+			if err := cp.append(pending); err != nil {
+				return err
+			}
+			cp.add(pending)
+			pending = checkpointRecord{Collected: make(map[string][]string)}
+			return nil
+		}
+	}
+
+	for item := range pipeline.OrDone(ctx.Done(), stream) {
+		if marked, ok := item.(checkpointItem); !ok {
+			if err := writeJson(writer, item); err != nil {
+				return err
+			}
+		} else if marked.end {
+			pending.Completed = append(pending.Completed, marked.stage)
+			if err := journal(false); err != nil {
+				return err
+			}
+		} else if data, err := json.Marshal(marked.item); err != nil {
+			return err
+		} else if key := journalKey(marked.item, data); !cp.wasCollected(marked.stage, key) {
+			// an object is listed again when its stage was cut off, or a parent when only some of the stages sharing it
+			// were journaled, but written once per stage
+			if err := writer.Write(string(data)); err != nil {
+				return err
+			}
+			pending.Collected[marked.stage] = append(pending.Collected[marked.stage], key)
+			cp.markCollected(marked.stage, key)
+		}
+
+		select {
+		case <-ticker.C:
+			if err := journal(false); err != nil {
+				return err
+			}
+		default:
+		}
+	}
+
+	// the collection is journaled complete only once the output has been closed, so that resuming an output cut off
+	// while closing writes its closing metadata again
+	if err := journal(false); err != nil {
+		return err
+	} else if err := writer.Close(); err != nil {
+		return err
+	} else if ctx.Err() != nil {
+		return cp.close()
+	} else {
+		complete := checkpointRecord{Offset: cp.offset, Count: cp.count, Complete: true}
+		if err := cp.append(complete); err != nil {
+			return err
+		}
+		cp.add(complete)
+		return cp.close()
+	}
+}
+
+// journalKey returns the key by which the written object is journaled: the key of the parent of relationships listed one
+// parent at a time, otherwise the kind and ID of the object or, for an object without an ID, a digest of it
+func journalKey(item interface{}, data []byte) string {
+	var object struct {
+		Kind string `json:"kind"`
+		Data struct {
+			Id string `json:"id"`
+		} `json:"data"`
+	}
+
+	if parent, ok := relationshipKey(item); ok {
+		return parent
+	} else if err := json.Unmarshal(data, &object); err == nil && object.Data.Id != "" {
+		return object.Kind + " " + object.Data.Id
+	} else {
+		digest := sha256.Sum256(data)
+		return hex.EncodeToString(digest[:16])
+	}
+}
+
+func writeJson(writer *sinks.FileWriter, item interface{}) error {
+	if bytes, err := json.Marshal(item); err != nil {
+		return err
+	} else {
+		return writer.Write(string(bytes))
+	}
+}
+
+// collect writes the objects listed from the tenant to the file at the path, to --storage or, without either, to the
+// console. With --checkpoint-dir the progress of the collection is journaled so that it can be resumed with --resume.
+func collect(ctx context.Context, azClient client.AzureClient, path string, list func(ctx context.Context, client client.AzureClient) <-chan interface{}) {
+	var (
+		dir, _     = config.CheckpointDir.Value().(string)
+		resume     = config.Resume.Value().(bool)
+		storage, _ = config.Storage.Value().(string)
+	)
+
+	if storage != "" {
+		if dir != "" {
+			exit(fmt.Errorf("--%s is not supported with --%s", config.CheckpointDir.Name, config.Storage.Name))
+		}
+		outputStreamToStorage(ctx, list(ctx, azClient), azClient.TokenBroker(), azClient.TenantInfo().TenantId)
+	} else if dir == "" {
+		if resume {
+			exit(fmt.Errorf("--%s requires --%s", config.Resume.Name, config.CheckpointDir.Name))
+		}
+		outputStreamTo(ctx, list(ctx, azClient), path)
+	} else if path == "" {
+		exit(fmt.Errorf("--%s requires --%s", config.CheckpointDir.Name, config.OutputFile.Name))
+	} else if splitOptions().Enabled() {
+		exit(fmt.Errorf("--%s is not supported with split output", config.CheckpointDir.Name))
+	} else if cp, err := openCheckpoint(dir, azClient.TenantInfo().TenantId, path, outputFormat(), outputCompression(path), resume); err != nil {
+		exit(fmt.Errorf("failed to open checkpoint: %w", err))
+	} else if cp.complete {
+		cp.close()
+		log.Info("the journaled collection already completed", "tenantId", azClient.TenantInfo().TenantId, "output", path)
+	} else {
+		ctx = withCheckpoint(ctx, cp)
+		if err := writeCheckpointed(ctx, cp, list(ctx, azClient)); err != nil {
+			exit(fmt.Errorf("failed to write stream to file: %w", err))
+		}
+		commitDeltaState(ctx)
+	}
+}
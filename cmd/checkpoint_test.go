@@ -0,0 +1,220 @@
+// Copyright (C) 2024 Specter Ops, Inc.
+//
+// This file is part of AzureHound.
+//
+// AzureHound is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// AzureHound is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bloodhoundad/azurehound/v2/client"
+	"github.com/bloodhoundad/azurehound/v2/enums"
+	"github.com/bloodhoundad/azurehound/v2/internal/fakeazure"
+	"github.com/bloodhoundad/azurehound/v2/models"
+	"github.com/bloodhoundad/azurehound/v2/sinks"
+)
+
+// TestCheckpoint interrupts a collection from a fake tenant and resumes it, expecting the output to hold every object
+// of testdata/list-all.golden with none written twice
+func TestCheckpoint(t *testing.T) {
+	server := fakeazure.NewServer(fakeazure.NewFixtures(1))
+	defer server.Close()
+
+	azClient, err := client.NewClient(server.ClientConfig())
+	if err != nil {
+		t.Fatalf("error initializing azure client: %v", err)
+	}
+	defer azClient.CloseIdleConnections()
+
+	// listing loads the delta state once, which the delta tests set up themselves
+	defer func() {
+		deltaState = nil
+		deltaStateOnce = sync.Once{}
+	}()
+
+	var (
+		dir      = t.TempDir()
+		output   = filepath.Join(dir, "output.json")
+		tenantId = azClient.TenantInfo().TenantId
+	)
+
+	// interrupt the collection part way
+	if cp, err := openCheckpoint(dir, tenantId, output, sinks.FormatJSON, sinks.CompressionNone, false); err != nil {
+		t.Fatal(err)
+	} else {
+		ctx, cancel := context.WithCancel(withCheckpoint(context.Background(), cp))
+		defer cancel()
+
+		var (
+			stream      = listAll(ctx, azClient)
+			interrupted = make(chan interface{})
+		)
+		go func() {
+			defer close(interrupted)
+			count := 0
+			for item := range stream {
+				if count++; count == 30 {
+					cancel()
+				}
+				select {
+				case interrupted <- item:
+				case <-ctx.Done():
+				}
+			}
+		}()
+		if err := writeCheckpointed(ctx, cp, interrupted); err != nil {
+			t.Fatal(err)
+		} else if cp.complete {
+			t.Fatal("got a complete journal for an interrupted collection")
+		}
+	}
+
+	// the partial output is valid
+	if _, count := readOutput(t, output); count == 0 {
+		t.Error("got no objects in the partial output")
+	}
+
+	// resume it
+	if cp, err := openCheckpoint(dir, tenantId, output, sinks.FormatJSON, sinks.CompressionNone, true); err != nil {
+		t.Fatal(err)
+	} else if cp.complete {
+		t.Fatal("got a complete journal before resuming")
+	} else {
+		ctx := withCheckpoint(context.Background(), cp)
+		if err := writeCheckpointed(ctx, cp, listAll(ctx, azClient)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	lines, count := readOutput(t, output)
+	if count != len(lines) {
+		t.Errorf("got a meta count of %d for %d objects", count, len(lines))
+	}
+
+	written := make(map[string]int)
+	for _, line := range lines {
+		if written[line]++; written[line] > 1 {
+			t.Errorf("got an object written twice: %s", line)
+		}
+	}
+
+	if golden, err := os.ReadFile(filepath.Join("testdata", "list-all.golden")); err != nil {
+		t.Fatal(err)
+	} else {
+		expected := strings.Split(strings.TrimSpace(string(golden)), "\n")
+		for _, line := range expected {
+			if written[line] == 0 {
+				t.Errorf("missing from the resumed output: %s", line)
+			}
+		}
+		if len(written) != len(expected) {
+			t.Errorf("got %d distinct objects, want %d", len(written), len(expected))
+		}
+	}
+
+	if cp, err := openCheckpoint(dir, tenantId, output, sinks.FormatJSON, sinks.CompressionNone, true); err != nil {
+		t.Fatal(err)
+	} else if !cp.complete {
+		t.Error("got an incomplete journal after resuming")
+	} else {
+		cp.close()
+	}
+
+	if _, err := openCheckpoint(dir, tenantId, filepath.Join(dir, "other.json"), sinks.FormatJSON, sinks.CompressionNone, true); err == nil {
+		t.Error("got no error resuming into a different output")
+	}
+}
+
+// TestCheckpointUnclosed resumes a collection cut off while its output was being closed, expecting the closing metadata
+// to be written again rather than the collection to be taken as complete
+func TestCheckpointUnclosed(t *testing.T) {
+	var (
+		dir    = t.TempDir()
+		output = filepath.Join(dir, "output.json")
+		items  = []interface{}{
+			checkpointItem{stage: "users", item: AzureWrapper{Kind: enums.KindAZUser, Data: models.User{}}},
+			checkpointItem{stage: "users", end: true},
+		}
+		write = func(resume bool) {
+			stream := make(chan interface{}, len(items))
+			for _, item := range items {
+				stream <- item
+			}
+			close(stream)
+
+			if cp, err := openCheckpoint(dir, "tenant", output, sinks.FormatJSON, sinks.CompressionNone, resume); err != nil {
+				t.Fatal(err)
+			} else if err := writeCheckpointed(context.Background(), cp, stream); err != nil {
+				t.Fatal(err)
+			}
+		}
+	)
+
+	write(false)
+
+	// cut the output and the journal off as if the process had died while closing the output
+	journal := filepath.Join(dir, "tenant.jsonl")
+	if data, err := os.ReadFile(journal); err != nil {
+		t.Fatal(err)
+	} else if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); !strings.Contains(lines[len(lines)-1], `"complete":true`) {
+		t.Fatalf("got journal %s, want the collection journaled complete last", data)
+	} else if err := os.WriteFile(journal, []byte(strings.Join(lines[:len(lines)-1], "\n")+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	} else if cp, err := openCheckpoint(dir, "tenant", output, sinks.FormatJSON, sinks.CompressionNone, true); err != nil {
+		t.Fatal(err)
+	} else if cp.complete {
+		t.Fatal("got a complete journal for an output that was not closed")
+	} else if err := os.Truncate(output, cp.offset); err != nil {
+		t.Fatal(err)
+	} else {
+		cp.close()
+	}
+
+	write(true)
+
+	if lines, count := readOutput(t, output); len(lines) != 1 || count != 1 {
+		t.Errorf("got %d objects with a meta count of %d, want the one user", len(lines), count)
+	}
+}
+
+func readOutput(t *testing.T, path string) ([]string, int) {
+	var decoded struct {
+		Data []json.RawMessage `json:"data"`
+		Meta models.Meta       `json:"meta"`
+	}
+	if data, err := os.ReadFile(path); err != nil {
+		t.Fatal(err)
+	} else if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("got invalid output: %v\n%s", err, data)
+	}
+
+	lines := make([]string, 0, len(decoded.Data))
+	for _, raw := range decoded.Data {
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, raw); err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, compact.String())
+	}
+	return lines, decoded.Meta.Count
+}
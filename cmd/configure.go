@@ -76,14 +76,16 @@ func configure() error {
 		return err
 	} else if tenantId, err := prompt("Directory (tenant) ID", validateGuid, false); err != nil {
 		return err
-	} else if appId, err := prompt("Application (client) ID", validateGuid, false); err != nil {
-		return err
 	} else if _, authMethod, err := choose("Authentication Method", enums.AuthMethods(), 0); err != nil {
 		return err
+	} else if appId, err := promptAppId(authMethod); err != nil {
+		return err
 	} else {
 		config.AzRegion.Set(region)
 		config.AzTenant.Set(tenantId)
-		config.AzAppId.Set(appId)
+		if appId != "" {
+			config.AzAppId.Set(appId)
+		}
 
 		if authMethod == enums.Certificate {
 			if genCert = confirm("Generate Certificate and Key", true); genCert {
@@ -114,6 +116,28 @@ func configure() error {
 				config.AzUsername.Set(upn)
 				config.AzPassword.Set(password)
 			}
+		} else if authMethod == enums.DeviceCode {
+			config.AzDeviceCode.Set(true)
+		} else if authMethod == enums.FederatedToken {
+			if tokenFile, err := prompt("Federated Token File", validateFile, false); err != nil {
+				return err
+			} else {
+				config.AzFederatedTokenFile.Set(tokenFile)
+			}
+		} else if authMethod == enums.AzureCLI {
+			if cachePath, err := prompt("MSAL Token Cache Path", validateFile, false); err != nil {
+				return err
+			} else {
+				config.AzCli.Set(true)
+				config.AzCliCache.Set(cachePath)
+			}
+		} else if authMethod == enums.ManagedIdentity {
+			if clientId, err := prompt("User-assigned Managed Identity Client ID (optional)", validateOptionalGuid, false); err != nil {
+				return err
+			} else {
+				config.AzManagedIdentity.Set(true)
+				config.AzManagedIdentityId.Set(clientId)
+			}
 		} else if secret, err := prompt("Client Secret", nil, true); err != nil {
 			return err
 		} else {
@@ -193,6 +217,25 @@ func configure() error {
 	return nil
 }
 
+// promptAppId asks for the application (client) ID when the authentication method authenticates as an app registration.
+// The other methods sign in through a well-known public client or as a managed identity and need none.
+func promptAppId(authMethod enums.AuthMethod) (string, error) {
+	if requiresAppId(authMethod) {
+		return prompt("Application (client) ID", validateGuid, false)
+	} else {
+		return "", nil
+	}
+}
+
+func requiresAppId(authMethod enums.AuthMethod) bool {
+	switch authMethod {
+	case enums.Certificate, enums.Secret, enums.FederatedToken:
+		return true
+	default:
+		return false
+	}
+}
+
 func prompt(label string, validator func(string) error, isSensitive bool) (string, error) {
 	p := promptui.Prompt{
 		Label:    label,
@@ -235,6 +278,23 @@ func validateGuid(input string) error {
 	return err
 }
 
+func validateOptionalGuid(input string) error {
+	if input == "" {
+		return nil
+	}
+	return validateGuid(input)
+}
+
+func validateFile(input string) error {
+	if info, err := os.Stat(input); err != nil {
+		return err
+	} else if info.IsDir() {
+		return fmt.Errorf("%s is a directory", input)
+	} else {
+		return nil
+	}
+}
+
 func validatePem(input string) error {
 	if content, err := ioutil.ReadFile(input); err != nil {
 		return err
@@ -0,0 +1,37 @@
+// Copyright (C) 2024 Specter Ops, Inc.
+//
+// This file is part of AzureHound.
+//
+// AzureHound is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// AzureHound is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"testing"
+
+	"github.com/bloodhoundad/azurehound/v2/enums"
+)
+
+func TestRequiresAppId(t *testing.T) {
+	required := map[enums.AuthMethod]bool{
+		enums.Certificate:    true,
+		enums.Secret:         true,
+		enums.FederatedToken: true,
+	}
+	for _, authMethod := range enums.AuthMethods() {
+		if got := requiresAppId(authMethod); got != required[authMethod] {
+			t.Errorf("%s: got %v, want %v", authMethod, got, required[authMethod])
+		}
+	}
+}
@@ -0,0 +1,212 @@
+// Copyright (C) 2024 Specter Ops, Inc.
+//
+// This file is part of AzureHound.
+//
+// AzureHound is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// AzureHound is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/bloodhoundad/azurehound/v2/client"
+	"github.com/bloodhoundad/azurehound/v2/client/query"
+	"github.com/bloodhoundad/azurehound/v2/config"
+	"github.com/bloodhoundad/azurehound/v2/panicrecovery"
+	"github.com/bloodhoundad/azurehound/v2/pipeline"
+)
+
+var (
+	deltaState     *deltaStateFile
+	deltaStateOnce sync.Once
+)
+
+// deltaStateFile holds the delta links of the last completed run of each delta query, keyed by tenant and collector.
+// Delta links from the current run are held aside until its output has been written.
+type deltaStateFile struct {
+	path    string
+	mutex   sync.Mutex
+	links   map[string]map[string]string
+	pending map[string]map[string]string
+}
+
+// getDeltaState returns the delta state configured with --delta-state or nil when collecting in full
+func getDeltaState() *deltaStateFile {
+	deltaStateOnce.Do(func() {
+		if path, ok := config.DeltaStateFile.Value().(string); ok && path != "" {
+			if state, err := loadDeltaState(path); err != nil {
+				exit(fmt.Errorf("failed to load delta state: %w", err))
+			} else {
+				deltaState = state
+			}
+		}
+	})
+	return deltaState
+}
+
+// checkDeltaState rejects --delta-state for output that BloodHound ingests as it is collected. A delta run lists
+// tombstones of deleted objects and only the changed properties of others, which ingest would take for whole objects.
+func checkDeltaState(destination string) error {
+	if path, ok := config.DeltaStateFile.Value().(string); ok && path != "" {
+		return fmt.Errorf("--%s is not supported with %s", config.DeltaStateFile.Name, destination)
+	}
+	return nil
+}
+
+func loadDeltaState(path string) (*deltaStateFile, error) {
+	state := &deltaStateFile{
+		path:    path,
+		links:   make(map[string]map[string]string),
+		pending: make(map[string]map[string]string),
+	}
+
+	if content, err := os.ReadFile(path); errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	} else if err != nil {
+		return nil, err
+	} else if err := json.Unmarshal(content, &state.links); err != nil {
+		return nil, fmt.Errorf("malformed delta state: %w", err)
+	} else {
+		return state, nil
+	}
+}
+
+func (s *deltaStateFile) link(tenantId, collector string) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.links[tenantId][collector]
+}
+
+func (s *deltaStateFile) update(tenantId, collector, link string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.pending[tenantId]; !ok {
+		s.pending[tenantId] = make(map[string]string)
+	}
+	s.pending[tenantId][collector] = link
+}
+
+// commit saves the delta links of the current run so that the next run resumes from them
+func (s *deltaStateFile) commit() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for tenantId, links := range s.pending {
+		if _, ok := s.links[tenantId]; !ok {
+			s.links[tenantId] = make(map[string]string)
+		}
+		for collector, link := range links {
+			s.links[tenantId][collector] = link
+		}
+	}
+	s.pending = make(map[string]map[string]string)
+
+	if content, err := json.MarshalIndent(s.links, "", "  "); err != nil {
+		return err
+	} else if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	} else {
+		// write to a temporary file first so a crash never leaves a truncated state behind
+		tmp := s.path + ".tmp"
+		if err := os.WriteFile(tmp, content, 0600); err != nil {
+			return err
+		} else {
+			return os.Rename(tmp, s.path)
+		}
+	}
+}
+
+// discard drops the delta links of the current run so that the next run repeats its changes
+func (s *deltaStateFile) discard() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.pending = make(map[string]map[string]string)
+}
+
+// commitDeltaState saves the delta links of a run whose output has been written in full
+func commitDeltaState(ctx context.Context) {
+	if state := getDeltaState(); state == nil {
+		return
+	} else if ctx.Err() != nil {
+		state.discard()
+	} else if err := state.commit(); err != nil {
+		log.Error(err, "unable to save delta state; the next run will repeat the changes of this one")
+	}
+}
+
+// listDelta returns the objects of a full enumeration or, when --delta-state is set, the objects changed or removed
+// since the previous run. The delta link of a completed delta query is held for commitDeltaState.
+func listDelta[T any](
+	ctx context.Context,
+	azClient client.AzureClient,
+	collector string,
+	list func(ctx context.Context, params query.GraphParams) <-chan client.AzureResult[T],
+	listParams query.GraphParams,
+	delta func(ctx context.Context, deltaLink string, params query.GraphParams) <-chan client.AzureResult[client.Delta[T]],
+	deltaParams query.GraphParams,
+) <-chan client.AzureResult[client.Delta[T]] {
+	out := make(chan client.AzureResult[client.Delta[T]])
+
+	go func() {
+		defer panicrecovery.PanicRecovery()
+		defer close(out)
+
+		if state := getDeltaState(); state == nil {
+			for item := range list(ctx, listParams) {
+				if ok := pipeline.Send(ctx.Done(), out, client.AzureResult[client.Delta[T]]{Error: item.Error, Ok: client.Delta[T]{Object: item.Ok}}); !ok {
+					return
+				}
+			}
+		} else {
+			tenantId := azClient.TenantInfo().TenantId
+			for item := range delta(ctx, state.link(tenantId, collector), deltaParams) {
+				if item.Error == nil && item.Ok.DeltaLink != "" {
+					state.update(tenantId, collector, item.Ok.DeltaLink)
+				} else if ok := pipeline.Send(ctx.Done(), out, item); !ok {
+					return
+				}
+			}
+		}
+	}()
+
+	return out
+}
+
+// deletable is implemented by the wrappers of collected objects, which may mark an object removed
+type deletable interface {
+	wasDeleted() bool
+}
+
+func (s AzureWrapper) wasDeleted() bool {
+	return s.Deleted
+}
+
+func (s azureWrapper[T]) wasDeleted() bool {
+	return s.Deleted
+}
+
+// existing drops the objects removed since the previous incremental collection from a stream of parents, since removed
+// objects have no relationships left to collect
+func existing[T any](ctx context.Context, parents <-chan T) <-chan T {
+	return pipeline.Filter(ctx.Done(), parents, func(item T) bool {
+		wrapper, ok := any(item).(deletable)
+		return !ok || !wrapper.wasDeleted()
+	})
+}
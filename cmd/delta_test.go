@@ -0,0 +1,223 @@
+// Copyright (C) 2024 Specter Ops, Inc.
+//
+// This file is part of AzureHound.
+//
+// AzureHound is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// AzureHound is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/bloodhoundad/azurehound/v2/client"
+	"github.com/bloodhoundad/azurehound/v2/client/mocks"
+	"github.com/bloodhoundad/azurehound/v2/client/query"
+	"github.com/bloodhoundad/azurehound/v2/config"
+	"github.com/bloodhoundad/azurehound/v2/enums"
+	"github.com/bloodhoundad/azurehound/v2/models"
+	"github.com/bloodhoundad/azurehound/v2/models/azure"
+	"go.uber.org/mock/gomock"
+)
+
+func TestListUsersDelta(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "delta.json")
+
+	state, err := loadDeltaState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.links["tenant"] = map[string]string{"users": "previous"}
+	deltaStateOnce.Do(func() { deltaState = state })
+	defer func() {
+		deltaState = nil
+		deltaStateOnce = sync.Once{}
+	}()
+
+	mockClient := mocks.NewMockAzureClient(ctrl)
+	mockChannel := make(chan client.AzureResult[client.Delta[azure.User]])
+	mockClient.EXPECT().TenantInfo().Return(azure.Tenant{TenantId: "tenant"}).AnyTimes()
+	mockClient.EXPECT().ListAzureADUsersDelta(gomock.Any(), "previous", gomock.Any()).Return(mockChannel)
+
+	go func() {
+		defer close(mockChannel)
+		mockChannel <- client.AzureResult[client.Delta[azure.User]]{
+			Ok: client.Delta[azure.User]{Object: azure.User{DirectoryObject: azure.DirectoryObject{Id: "changed"}}},
+		}
+		mockChannel <- client.AzureResult[client.Delta[azure.User]]{
+			Ok: client.Delta[azure.User]{Object: azure.User{DirectoryObject: azure.DirectoryObject{Id: "removed"}}, Removed: true},
+		}
+		mockChannel <- client.AzureResult[client.Delta[azure.User]]{
+			Ok: client.Delta[azure.User]{DeltaLink: "next"},
+		}
+	}()
+
+	var results []AzureWrapper
+	for result := range listUsers(ctx, mockClient) {
+		if wrapper, ok := result.(AzureWrapper); !ok {
+			t.Fatalf("failed type assertion: got %T, want %T", result, AzureWrapper{})
+		} else {
+			results = append(results, wrapper)
+		}
+	}
+
+	if len(results) != 2 {
+		t.Fatalf("got %d results, want 2", len(results))
+	} else if results[0].Deleted {
+		t.Error("changed user marked as deleted")
+	} else if !results[1].Deleted {
+		t.Error("removed user not marked as deleted")
+	}
+
+	// The delta link is only saved once the output has been written
+	if link := state.link("tenant", "users"); link != "previous" {
+		t.Errorf("got delta link %s before commit, want previous", link)
+	}
+
+	commitDeltaState(ctx)
+
+	if reloaded, err := loadDeltaState(path); err != nil {
+		t.Fatal(err)
+	} else if link := reloaded.link("tenant", "users"); link != "next" {
+		t.Errorf("got delta link %s after commit, want next", link)
+	}
+}
+
+func TestCheckDeltaState(t *testing.T) {
+	defer config.DeltaStateFile.Set("")
+
+	config.DeltaStateFile.Set("")
+	if err := checkDeltaState("start"); err != nil {
+		t.Errorf("got %v when collecting in full", err)
+	}
+
+	// tombstones and partial objects must never reach ingest
+	config.DeltaStateFile.Set(filepath.Join(t.TempDir(), "delta.json"))
+	if err := checkDeltaState("start"); err == nil {
+		t.Error("got no error for --delta-state with start")
+	} else if err := checkDeltaState("--upload"); err == nil {
+		t.Error("got no error for --delta-state with --upload")
+	}
+}
+
+func TestListGroupsDelta(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	ctx := context.Background()
+	state, err := loadDeltaState(filepath.Join(t.TempDir(), "delta.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	deltaStateOnce.Do(func() { deltaState = state })
+	defer func() {
+		deltaState = nil
+		deltaStateOnce = sync.Once{}
+	}()
+
+	mockClient := mocks.NewMockAzureClient(ctrl)
+	mockChannel := make(chan client.AzureResult[client.Delta[azure.Group]])
+	mockClient.EXPECT().TenantInfo().Return(azure.Tenant{TenantId: "tenant"}).AnyTimes()
+	mockClient.EXPECT().ListAzureADGroupsDelta(gomock.Any(), "", gomock.Any()).DoAndReturn(
+		func(_ context.Context, _ string, params query.GraphParams) <-chan client.AzureResult[client.Delta[azure.Group]] {
+			if !slices.Contains(params.Select, "securityEnabled") {
+				t.Errorf("got select %v, want securityEnabled", params.Select)
+			}
+			return mockChannel
+		})
+
+	go func() {
+		defer close(mockChannel)
+		mockChannel <- client.AzureResult[client.Delta[azure.Group]]{
+			Ok: client.Delta[azure.Group]{
+				Object:     azure.Group{DirectoryObject: azure.DirectoryObject{Id: "security"}, SecurityEnabled: true},
+				Properties: map[string]bool{"id": true, "securityEnabled": true},
+			},
+		}
+		mockChannel <- client.AzureResult[client.Delta[azure.Group]]{
+			Ok: client.Delta[azure.Group]{
+				Object:     azure.Group{DirectoryObject: azure.DirectoryObject{Id: "no-longer-security"}},
+				Properties: map[string]bool{"id": true, "securityEnabled": true},
+			},
+		}
+		mockChannel <- client.AzureResult[client.Delta[azure.Group]]{
+			Ok: client.Delta[azure.Group]{
+				Object:     azure.Group{DirectoryObject: azure.DirectoryObject{Id: "renamed"}, DisplayName: "renamed"},
+				Properties: map[string]bool{"id": true, "displayName": true},
+			},
+		}
+	}()
+
+	deleted := map[string]bool{}
+	for result := range listGroups(ctx, mockClient) {
+		if wrapper, ok := result.(AzureWrapper); !ok {
+			t.Fatalf("failed type assertion: got %T, want %T", result, AzureWrapper{})
+		} else {
+			deleted[wrapper.Data.(models.Group).Id] = wrapper.Deleted
+		}
+	}
+
+	if len(deleted) != 3 {
+		t.Fatalf("got %v, want every group", deleted)
+	} else if deleted["security"] {
+		t.Error("security group marked as deleted")
+	} else if !deleted["no-longer-security"] {
+		t.Error("group no longer security enabled not marked as deleted")
+	} else if deleted["renamed"] {
+		t.Error("group updated without securityEnabled marked as deleted")
+	}
+}
+
+func TestExisting(t *testing.T) {
+	var (
+		ctx     = context.Background()
+		parents = make(chan interface{}, 3)
+		apps    = make(chan azureWrapper[models.App], 2)
+	)
+
+	parents <- AzureWrapper{Kind: enums.KindAZGroup, Data: models.Group{Group: azure.Group{DirectoryObject: azure.DirectoryObject{Id: "kept"}}}}
+	parents <- AzureWrapper{Kind: enums.KindAZGroup, Data: models.Group{Group: azure.Group{DirectoryObject: azure.DirectoryObject{Id: "removed"}}}, Deleted: true}
+	parents <- "not a wrapper"
+	close(parents)
+
+	var kept []interface{}
+	for item := range existing(ctx, parents) {
+		kept = append(kept, item)
+	}
+	if len(kept) != 2 || kept[0].(AzureWrapper).Data.(models.Group).Id != "kept" || kept[1] != "not a wrapper" {
+		t.Errorf("got %v, want the objects that were not removed", kept)
+	}
+
+	apps <- azureWrapper[models.App]{Kind: enums.KindAZApp, Deleted: true}
+	apps <- azureWrapper[models.App]{Kind: enums.KindAZApp}
+	close(apps)
+
+	count := 0
+	for app := range existing(ctx, apps) {
+		if app.Deleted {
+			t.Error("got a removed app")
+		}
+		count++
+	}
+	if count != 1 {
+		t.Errorf("got %d apps, want 1", count)
+	}
+}
@@ -57,7 +57,7 @@ func listAppRoleAssignmentsCmdImpl(cmd *cobra.Command, args []string) {
 	servicePrincipals := listServicePrincipals(ctx, azClient)
 	stream := listAppRoleAssignments(ctx, azClient, servicePrincipals)
 	panicrecovery.HandleBubbledPanic(ctx, stop, log)
-	outputStream(ctx, stream)
+	outputStream(ctx, azClient, stream)
 	duration := time.Since(start)
 	log.Info("collection completed", "duration", duration.String())
 }
@@ -53,7 +53,7 @@ func listAppsCmdImpl(cmd *cobra.Command, args []string) {
 	start := time.Now()
 	stream := listApps(ctx, azClient)
 	panicrecovery.HandleBubbledPanic(ctx, stop, log)
-	outputStream(ctx, stream)
+	outputStream(ctx, azClient, stream)
 	duration := time.Since(start)
 	log.Info("collection completed", "duration", duration.String())
 }
@@ -65,21 +65,22 @@ func listApps(ctx context.Context, client client.AzureClient) <-chan azureWrappe
 		defer panicrecovery.PanicRecovery()
 		defer close(out)
 		count := 0
-		for item := range client.ListAzureADApps(ctx, query.GraphParams{}) {
+		for item := range listDelta(ctx, client, "apps", client.ListAzureADApps, query.GraphParams{}, client.ListAzureADAppsDelta, query.GraphParams{}) {
 			if item.Error != nil {
 				log.Error(item.Error, "unable to continue processing applications")
 				return
 			} else {
 				log.V(2).Info("found application", "app", item)
 				count++
-				if ok := pipeline.Send(ctx.Done(), out, NewAzureWrapper(
-					enums.KindAZApp,
-					models.App{
-						Application: item.Ok,
+				if ok := pipeline.Send(ctx.Done(), out, azureWrapper[models.App]{
+					Kind: enums.KindAZApp,
+					Data: models.App{
+						Application: item.Ok.Object,
 						TenantId:    client.TenantInfo().TenantId,
 						TenantName:  client.TenantInfo().DisplayName,
 					},
-				)); !ok {
+					Deleted: item.Ok.Removed,
+				}); !ok {
 					return
 				}
 			}
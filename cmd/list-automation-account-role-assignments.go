@@ -57,7 +57,7 @@ func listAutomationAccountRoleAssignmentImpl(cmd *cobra.Command, args []string)
 	subscriptions := listSubscriptions(ctx, azClient)
 	stream := listAutomationAccountRoleAssignments(ctx, azClient, listAutomationAccounts(ctx, azClient, subscriptions))
 	panicrecovery.HandleBubbledPanic(ctx, stop, log)
-	outputStream(ctx, stream)
+	outputStream(ctx, azClient, stream)
 	duration := time.Since(start)
 	log.Info("collection completed", "duration", duration.String())
 }
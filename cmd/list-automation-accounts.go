@@ -55,7 +55,7 @@ func listAutomationAccountsCmdImpl(cmd *cobra.Command, args []string) {
 	start := time.Now()
 	stream := listAutomationAccounts(ctx, azClient, listSubscriptions(ctx, azClient))
 	panicrecovery.HandleBubbledPanic(ctx, stop, log)
-	outputStream(ctx, stream)
+	outputStream(ctx, azClient, stream)
 	duration := time.Since(start)
 	log.Info("collection completed", "duration", duration.String())
 }
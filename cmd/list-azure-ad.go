@@ -25,6 +25,8 @@ import (
 	"time"
 
 	"github.com/bloodhoundad/azurehound/v2/client"
+	"github.com/bloodhoundad/azurehound/v2/config"
+	"github.com/bloodhoundad/azurehound/v2/models"
 	"github.com/bloodhoundad/azurehound/v2/panicrecovery"
 	"github.com/bloodhoundad/azurehound/v2/pipeline"
 	"github.com/spf13/cobra"
@@ -54,9 +56,8 @@ func listAzureADCmdImpl(cmd *cobra.Command, args []string) {
 	azClient := connectAndCreateClient()
 	log.Info("collecting azure ad objects...")
 	start := time.Now()
-	stream := listAllAD(ctx, azClient)
 	panicrecovery.HandleBubbledPanic(ctx, stop, log)
-	outputStream(ctx, stream)
+	collect(ctx, azClient, config.OutputFile.Value().(string), listAllAD)
 	duration := time.Since(start)
 	log.Info("collection completed", "duration", duration.String())
 }
@@ -81,50 +82,78 @@ func listAllAD(ctx context.Context, client client.AzureClient) <-chan interface{
 	)
 
 	// Enumerate Apps, AppOwners and AppMembers
-	appChans := pipeline.TeeFixed(ctx.Done(), listApps(ctx, client), 2)
+	appChans := pipeline.TeeFixed(ctx.Done(), stage(ctx, "apps", func(ctx context.Context) <-chan azureWrapper[models.App] {
+		return listApps(ctx, client)
+	}), 2)
 	apps := pipeline.ToAny(ctx.Done(), appChans[0])
-	appOwners := pipeline.ToAny(ctx.Done(), listAppOwners(ctx, client, appChans[1]))
+	appOwners := pipeline.ToAny(ctx.Done(), stage(ctx, "app-owners", func(ctx context.Context) <-chan azureWrapper[models.AppOwners] {
+		return listAppOwners(ctx, client, uncollected(ctx, existing(ctx, appChans[1]), "app-owners"))
+	}))
 
 	// Enumerate Devices and DeviceOwners
-	pipeline.Tee(ctx.Done(), listDevices(ctx, client), devices, devices2)
-	deviceOwners := listDeviceOwners(ctx, client, devices2)
+	pipeline.Tee(ctx.Done(), stage(ctx, "devices", func(ctx context.Context) <-chan interface{} {
+		return listDevices(ctx, client)
+	}), devices, devices2)
+	deviceOwners := stage(ctx, "device-owners", func(ctx context.Context) <-chan interface{} {
+		return listDeviceOwners(ctx, client, uncollected(ctx, existing(ctx, devices2), "device-owners"))
+	})
 
 	// Enumerate Groups, GroupOwners and GroupMembers
-	pipeline.Tee(ctx.Done(), listGroups(ctx, client), groups, groups2, groups3)
-	groupOwners := listGroupOwners(ctx, client, groups2)
-	groupMembers := listGroupMembers(ctx, client, groups3)
+	pipeline.Tee(ctx.Done(), stage(ctx, "groups", func(ctx context.Context) <-chan interface{} {
+		return listGroups(ctx, client)
+	}), groups, groups2, groups3)
+	groupOwners := stage(ctx, "group-owners", func(ctx context.Context) <-chan interface{} {
+		return listGroupOwners(ctx, client, uncollected(ctx, existing(ctx, groups2), "group-owners"))
+	})
+	groupMembers := stage(ctx, "group-members", func(ctx context.Context) <-chan interface{} {
+		return listGroupMembers(ctx, client, uncollected(ctx, existing(ctx, groups3), "group-members"))
+	})
 
 	// Enumerate ServicePrincipals and ServicePrincipalOwners
-	pipeline.Tee(ctx.Done(), listServicePrincipals(ctx, client), servicePrincipals, servicePrincipals2, servicePrincipals3)
-	servicePrincipalOwners := listServicePrincipalOwners(ctx, client, servicePrincipals2)
+	pipeline.Tee(ctx.Done(), stage(ctx, "service-principals", func(ctx context.Context) <-chan interface{} {
+		return listServicePrincipals(ctx, client)
+	}), servicePrincipals, servicePrincipals2, servicePrincipals3)
+	servicePrincipalOwners := stage(ctx, "service-principal-owners", func(ctx context.Context) <-chan interface{} {
+		return listServicePrincipalOwners(ctx, client, uncollected(ctx, existing(ctx, servicePrincipals2), "service-principal-owners"))
+	})
 
 	// Enumerate Tenants
-	pipeline.Tee(ctx.Done(), listTenants(ctx, client), tenants)
+	pipeline.Tee(ctx.Done(), stage(ctx, "tenants", func(ctx context.Context) <-chan interface{} {
+		return listTenants(ctx, client)
+	}), tenants)
 
 	// Enumerate Users
-	users := listUsers(ctx, client)
+	users := stage(ctx, "users", func(ctx context.Context) <-chan interface{} {
+		return listUsers(ctx, client)
+	})
 
 	// Enumerate Roles and RoleAssignments
-	pipeline.Tee(ctx.Done(), listRoles(ctx, client), roles, roles2)
-	roleAssignments := listRoleAssignments(ctx, client, roles2)
+	pipeline.Tee(ctx.Done(), stage(ctx, "roles", func(ctx context.Context) <-chan interface{} {
+		return listRoles(ctx, client)
+	}), roles, roles2)
+	roleAssignments := stage(ctx, "role-assignments", func(ctx context.Context) <-chan interface{} {
+		return listRoleAssignments(ctx, client, uncollected(ctx, roles2, "role-assignments"))
+	})
 
 	// Enumerate AppRoleAssignments
-	appRoleAssignments := listAppRoleAssignments(ctx, client, servicePrincipals3)
+	appRoleAssignments := stage(ctx, "app-role-assignments", func(ctx context.Context) <-chan interface{} {
+		return listAppRoleAssignments(ctx, client, existing(ctx, servicePrincipals3))
+	})
 
 	return pipeline.Mux(ctx.Done(),
-		appOwners,
-		appRoleAssignments,
-		apps,
-		deviceOwners,
-		devices,
-		groupMembers,
-		groupOwners,
-		groups,
-		roleAssignments,
-		roles,
-		servicePrincipalOwners,
-		servicePrincipals,
-		tenants,
-		users,
+		checkpointed(ctx, "app-owners", appOwners),
+		checkpointed(ctx, "app-role-assignments", appRoleAssignments),
+		checkpointed(ctx, "apps", apps),
+		checkpointed(ctx, "device-owners", deviceOwners),
+		checkpointed(ctx, "devices", devices),
+		checkpointed(ctx, "group-members", groupMembers),
+		checkpointed(ctx, "group-owners", groupOwners),
+		checkpointed(ctx, "groups", groups),
+		checkpointed(ctx, "role-assignments", roleAssignments),
+		checkpointed(ctx, "roles", roles),
+		checkpointed(ctx, "service-principal-owners", servicePrincipalOwners),
+		checkpointed(ctx, "service-principals", servicePrincipals),
+		checkpointed(ctx, "tenants", tenants),
+		checkpointed(ctx, "users", users),
 	)
 }
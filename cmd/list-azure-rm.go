@@ -25,6 +25,7 @@ import (
 	"time"
 
 	"github.com/bloodhoundad/azurehound/v2/client"
+	"github.com/bloodhoundad/azurehound/v2/config"
 	"github.com/bloodhoundad/azurehound/v2/enums"
 	"github.com/bloodhoundad/azurehound/v2/models"
 	"github.com/bloodhoundad/azurehound/v2/panicrecovery"
@@ -56,9 +57,8 @@ func listAzureRMCmdImpl(cmd *cobra.Command, args []string) {
 	azClient := connectAndCreateClient()
 	log.Info("collecting azure resource management objects...")
 	start := time.Now()
-	stream := listAllRM(ctx, azClient)
 	panicrecovery.HandleBubbledPanic(ctx, stop, log)
-	outputStream(ctx, stream)
+	collect(ctx, azClient, config.OutputFile.Value().(string), listAllRM)
 	duration := time.Since(start)
 	log.Info("collection completed", "duration", duration.String())
 }
@@ -130,8 +130,12 @@ func listAllRM(ctx context.Context, client client.AzureClient) <-chan interface{
 	)
 
 	// Enumerate entities
-	pipeline.Tee(ctx.Done(), listManagementGroups(ctx, client), mgmtGroups, mgmtGroups2, mgmtGroups3)
-	pipeline.Tee(ctx.Done(), listSubscriptions(ctx, client),
+	pipeline.Tee(ctx.Done(), stage(ctx, "management-groups", func(ctx context.Context) <-chan interface{} {
+		return listManagementGroups(ctx, client)
+	}), mgmtGroups, mgmtGroups2, mgmtGroups3)
+	pipeline.Tee(ctx.Done(), stage(ctx, "subscriptions", func(ctx context.Context) <-chan interface{} {
+		return listSubscriptions(ctx, client)
+	}),
 		subscriptions,
 		subscriptions2,
 		subscriptions3,
@@ -145,107 +149,185 @@ func listAllRM(ctx context.Context, client client.AzureClient) <-chan interface{
 		subscriptions11,
 		subscriptions12,
 	)
-	pipeline.Tee(ctx.Done(), listResourceGroups(ctx, client, subscriptions2), resourceGroups, resourceGroups2)
-	pipeline.Tee(ctx.Done(), listKeyVaults(ctx, client, subscriptions3), keyVaults, keyVaults2, keyVaults3)
-	pipeline.Tee(ctx.Done(), listVirtualMachines(ctx, client, subscriptions4), virtualMachines, virtualMachines2)
-	pipeline.Tee(ctx.Done(), listFunctionApps(ctx, client, subscriptions6), functionApps, functionApps2)
-	pipeline.Tee(ctx.Done(), listWebApps(ctx, client, subscriptions7), webApps, webApps2)
-	pipeline.Tee(ctx.Done(), listAutomationAccounts(ctx, client, subscriptions8), automationAccounts, automationAccounts2)
-	pipeline.Tee(ctx.Done(), listContainerRegistries(ctx, client, subscriptions9), containerRegistries, containerRegistries2)
-	pipeline.Tee(ctx.Done(), listLogicApps(ctx, client, subscriptions10), logicApps, logicApps2)
-	pipeline.Tee(ctx.Done(), listManagedClusters(ctx, client, subscriptions11), managedClusters, managedClusters2)
-	pipeline.Tee(ctx.Done(), listVMScaleSets(ctx, client, subscriptions12), vmScaleSets, vmScaleSets2)
+	pipeline.Tee(ctx.Done(), stage(ctx, "resource-groups", func(ctx context.Context) <-chan interface{} {
+		return listResourceGroups(ctx, client, subscriptions2)
+	}), resourceGroups, resourceGroups2)
+	pipeline.Tee(ctx.Done(), stage(ctx, "key-vaults", func(ctx context.Context) <-chan interface{} {
+		return listKeyVaults(ctx, client, subscriptions3)
+	}), keyVaults, keyVaults2, keyVaults3)
+	pipeline.Tee(ctx.Done(), stage(ctx, "virtual-machines", func(ctx context.Context) <-chan interface{} {
+		return listVirtualMachines(ctx, client, subscriptions4)
+	}), virtualMachines, virtualMachines2)
+	pipeline.Tee(ctx.Done(), stage(ctx, "function-apps", func(ctx context.Context) <-chan interface{} {
+		return listFunctionApps(ctx, client, subscriptions6)
+	}), functionApps, functionApps2)
+	pipeline.Tee(ctx.Done(), stage(ctx, "web-apps", func(ctx context.Context) <-chan interface{} {
+		return listWebApps(ctx, client, subscriptions7)
+	}), webApps, webApps2)
+	pipeline.Tee(ctx.Done(), stage(ctx, "automation-accounts", func(ctx context.Context) <-chan interface{} {
+		return listAutomationAccounts(ctx, client, subscriptions8)
+	}), automationAccounts, automationAccounts2)
+	pipeline.Tee(ctx.Done(), stage(ctx, "container-registries", func(ctx context.Context) <-chan interface{} {
+		return listContainerRegistries(ctx, client, subscriptions9)
+	}), containerRegistries, containerRegistries2)
+	pipeline.Tee(ctx.Done(), stage(ctx, "logic-apps", func(ctx context.Context) <-chan interface{} {
+		return listLogicApps(ctx, client, subscriptions10)
+	}), logicApps, logicApps2)
+	pipeline.Tee(ctx.Done(), stage(ctx, "managed-clusters", func(ctx context.Context) <-chan interface{} {
+		return listManagedClusters(ctx, client, subscriptions11)
+	}), managedClusters, managedClusters2)
+	pipeline.Tee(ctx.Done(), stage(ctx, "vm-scale-sets", func(ctx context.Context) <-chan interface{} {
+		return listVMScaleSets(ctx, client, subscriptions12)
+	}), vmScaleSets, vmScaleSets2)
 
 	// Enumerate Relationships
 	// ManagementGroups: Descendants, Owners and UserAccessAdmins
-	mgmtGroupDescendants := listManagementGroupDescendants(ctx, client, mgmtGroups2)
-	pipeline.Tee(ctx.Done(), listManagementGroupRoleAssignments(ctx, client, mgmtGroups3), mgmtGroupRoleAssignments1, mgmtGroupRoleAssignments2)
-	mgmtGroupOwners := listManagementGroupOwners(ctx, mgmtGroupRoleAssignments1)
-	mgmtGroupUserAccessAdmins := listManagementGroupUserAccessAdmins(ctx, mgmtGroupRoleAssignments2)
+	mgmtGroupDescendants := stage(ctx, "management-group-descendants", func(ctx context.Context) <-chan interface{} {
+		return listManagementGroupDescendants(ctx, client, mgmtGroups2)
+	})
+	pipeline.Tee(ctx.Done(), stage(ctx, "management-group-role-assignments", func(ctx context.Context) <-chan azureWrapper[models.ManagementGroupRoleAssignments] {
+		return listManagementGroupRoleAssignments(ctx, client, uncollected(ctx, mgmtGroups3, "management-group-owners", "management-group-user-access-admins"))
+	}), mgmtGroupRoleAssignments1, mgmtGroupRoleAssignments2)
+	mgmtGroupOwners := stage(ctx, "management-group-owners", func(ctx context.Context) <-chan any {
+		return listManagementGroupOwners(ctx, mgmtGroupRoleAssignments1)
+	})
+	mgmtGroupUserAccessAdmins := stage(ctx, "management-group-user-access-admins", func(ctx context.Context) <-chan any {
+		return listManagementGroupUserAccessAdmins(ctx, mgmtGroupRoleAssignments2)
+	})
 
 	// Subscriptions: Owners and UserAccessAdmins
-	pipeline.Tee(ctx.Done(), listSubscriptionRoleAssignments(ctx, client, subscriptions5), subscriptionRoleAssignments1, subscriptionRoleAssignments2)
-	subscriptionOwners := listSubscriptionOwners(ctx, client, subscriptionRoleAssignments1)
-	subscriptionUserAccessAdmins := listSubscriptionUserAccessAdmins(ctx, client, subscriptionRoleAssignments2)
+	pipeline.Tee(ctx.Done(), stage(ctx, "subscription-role-assignments", func(ctx context.Context) <-chan interface{} {
+		return listSubscriptionRoleAssignments(ctx, client, uncollected(ctx, subscriptions5, "subscription-owners", "subscription-user-access-admins"))
+	}), subscriptionRoleAssignments1, subscriptionRoleAssignments2)
+	subscriptionOwners := stage(ctx, "subscription-owners", func(ctx context.Context) <-chan interface{} {
+		return listSubscriptionOwners(ctx, client, subscriptionRoleAssignments1)
+	})
+	subscriptionUserAccessAdmins := stage(ctx, "subscription-user-access-admins", func(ctx context.Context) <-chan interface{} {
+		return listSubscriptionUserAccessAdmins(ctx, client, subscriptionRoleAssignments2)
+	})
 
 	// ResourceGroups: Owners and UserAccessAdmins
-	pipeline.Tee(ctx.Done(), listResourceGroupRoleAssignments(ctx, client, resourceGroups2), resourceGroupRoleAssignments1, resourceGroupRoleAssignments2)
-	resourceGroupOwners := listResourceGroupOwners(ctx, resourceGroupRoleAssignments1)
-	resourceGroupUserAccessAdmins := listResourceGroupUserAccessAdmins(ctx, resourceGroupRoleAssignments2)
+	pipeline.Tee(ctx.Done(), stage(ctx, "resource-group-role-assignments", func(ctx context.Context) <-chan azureWrapper[models.ResourceGroupRoleAssignments] {
+		return listResourceGroupRoleAssignments(ctx, client, uncollected(ctx, resourceGroups2, "resource-group-owners", "resource-group-user-access-admins"))
+	}), resourceGroupRoleAssignments1, resourceGroupRoleAssignments2)
+	resourceGroupOwners := stage(ctx, "resource-group-owners", func(ctx context.Context) <-chan any {
+		return listResourceGroupOwners(ctx, resourceGroupRoleAssignments1)
+	})
+	resourceGroupUserAccessAdmins := stage(ctx, "resource-group-user-access-admins", func(ctx context.Context) <-chan any {
+		return listResourceGroupUserAccessAdmins(ctx, resourceGroupRoleAssignments2)
+	})
 
 	// KeyVaults: AccessPolicies, Owners, UserAccessAdmins, Contributors and KVContributors
-	pipeline.Tee(ctx.Done(), listKeyVaultRoleAssignments(ctx, client, keyVaults2), keyVaultRoleAssignments1, keyVaultRoleAssignments2, keyVaultRoleAssignments3, keyVaultRoleAssignments4)
-	keyVaultAccessPolicies := listKeyVaultAccessPolicies(ctx, client, keyVaults3, []enums.KeyVaultAccessType{enums.GetCerts, enums.GetKeys, enums.GetCerts})
-	keyVaultOwners := listKeyVaultOwners(ctx, keyVaultRoleAssignments1)
-	keyVaultUserAccessAdmins := listKeyVaultUserAccessAdmins(ctx, keyVaultRoleAssignments2)
-	keyVaultContributors := listKeyVaultContributors(ctx, keyVaultRoleAssignments3)
-	keyVaultKVContributors := listKeyVaultKVContributors(ctx, keyVaultRoleAssignments4)
+	pipeline.Tee(ctx.Done(), stage(ctx, "key-vault-role-assignments", func(ctx context.Context) <-chan azureWrapper[models.KeyVaultRoleAssignments] {
+		return listKeyVaultRoleAssignments(ctx, client, uncollected(ctx, keyVaults2, "key-vault-owners", "key-vault-user-access-admins", "key-vault-contributors", "key-vault-kvcontributors"))
+	}), keyVaultRoleAssignments1, keyVaultRoleAssignments2, keyVaultRoleAssignments3, keyVaultRoleAssignments4)
+	keyVaultAccessPolicies := stage(ctx, "key-vault-access-policies", func(ctx context.Context) <-chan interface{} {
+		return listKeyVaultAccessPolicies(ctx, client, keyVaults3, []enums.KeyVaultAccessType{enums.GetCerts, enums.GetKeys, enums.GetCerts})
+	})
+	keyVaultOwners := stage(ctx, "key-vault-owners", func(ctx context.Context) <-chan any {
+		return listKeyVaultOwners(ctx, keyVaultRoleAssignments1)
+	})
+	keyVaultUserAccessAdmins := stage(ctx, "key-vault-user-access-admins", func(ctx context.Context) <-chan any {
+		return listKeyVaultUserAccessAdmins(ctx, keyVaultRoleAssignments2)
+	})
+	keyVaultContributors := stage(ctx, "key-vault-contributors", func(ctx context.Context) <-chan any {
+		return listKeyVaultContributors(ctx, keyVaultRoleAssignments3)
+	})
+	keyVaultKVContributors := stage(ctx, "key-vault-kvcontributors", func(ctx context.Context) <-chan any {
+		return listKeyVaultKVContributors(ctx, keyVaultRoleAssignments4)
+	})
 
 	// VirtualMachines: Owners, AvereContributors, Contributors, AdminLogins and UserAccessAdmins
-	pipeline.Tee(ctx.Done(), listVirtualMachineRoleAssignments(ctx, client, virtualMachines2), virtualMachineRoleAssignments1, virtualMachineRoleAssignments2, virtualMachineRoleAssignments3, virtualMachineRoleAssignments4, virtualMachineRoleAssignments5)
-	virtualMachineOwners := listVirtualMachineOwners(ctx, virtualMachineRoleAssignments1)
-	virtualMachineAvereContributors := listVirtualMachineAvereContributors(ctx, virtualMachineRoleAssignments2)
-	virtualMachineContributors := listVirtualMachineContributors(ctx, virtualMachineRoleAssignments3)
-	virtualMachineAdminLogins := listVirtualMachineAdminLogins(ctx, virtualMachineRoleAssignments4)
-	virtualMachineUserAccessAdmins := listVirtualMachineUserAccessAdmins(ctx, virtualMachineRoleAssignments5)
+	pipeline.Tee(ctx.Done(), stage(ctx, "virtual-machine-role-assignments", func(ctx context.Context) <-chan azureWrapper[models.VirtualMachineRoleAssignments] {
+		return listVirtualMachineRoleAssignments(ctx, client, uncollected(ctx, virtualMachines2, "virtual-machine-owners", "virtual-machine-avere-contributors", "virtual-machine-contributors", "virtual-machine-admin-logins", "virtual-machine-user-access-admins"))
+	}), virtualMachineRoleAssignments1, virtualMachineRoleAssignments2, virtualMachineRoleAssignments3, virtualMachineRoleAssignments4, virtualMachineRoleAssignments5)
+	virtualMachineOwners := stage(ctx, "virtual-machine-owners", func(ctx context.Context) <-chan any {
+		return listVirtualMachineOwners(ctx, virtualMachineRoleAssignments1)
+	})
+	virtualMachineAvereContributors := stage(ctx, "virtual-machine-avere-contributors", func(ctx context.Context) <-chan any {
+		return listVirtualMachineAvereContributors(ctx, virtualMachineRoleAssignments2)
+	})
+	virtualMachineContributors := stage(ctx, "virtual-machine-contributors", func(ctx context.Context) <-chan any {
+		return listVirtualMachineContributors(ctx, virtualMachineRoleAssignments3)
+	})
+	virtualMachineAdminLogins := stage(ctx, "virtual-machine-admin-logins", func(ctx context.Context) <-chan any {
+		return listVirtualMachineAdminLogins(ctx, virtualMachineRoleAssignments4)
+	})
+	virtualMachineUserAccessAdmins := stage(ctx, "virtual-machine-user-access-admins", func(ctx context.Context) <-chan any {
+		return listVirtualMachineUserAccessAdmins(ctx, virtualMachineRoleAssignments5)
+	})
 
 	// Enumerate Function App Role Assignments
-	functionAppRoleAssignments := listFunctionAppRoleAssignments(ctx, client, functionApps2)
+	functionAppRoleAssignments := stage(ctx, "function-app-role-assignments", func(ctx context.Context) <-chan interface{} {
+		return listFunctionAppRoleAssignments(ctx, client, uncollected(ctx, functionApps2, "function-app-role-assignments"))
+	})
 
 	// Enumerate Web App Role Assignments
-	webAppRoleAssignments := listWebAppRoleAssignments(ctx, client, webApps2)
+	webAppRoleAssignments := stage(ctx, "web-app-role-assignments", func(ctx context.Context) <-chan interface{} {
+		return listWebAppRoleAssignments(ctx, client, uncollected(ctx, webApps2, "web-app-role-assignments"))
+	})
 
 	// Enumerate Automation Account Role Assignments
-	automationAccountRoleAssignments := listAutomationAccountRoleAssignments(ctx, client, automationAccounts2)
+	automationAccountRoleAssignments := stage(ctx, "automation-account-role-assignments", func(ctx context.Context) <-chan interface{} {
+		return listAutomationAccountRoleAssignments(ctx, client, uncollected(ctx, automationAccounts2, "automation-account-role-assignments"))
+	})
 
 	// Enumerate Container Registry Role Assignments
-	containerRegistryRoleAssignments := listContainerRegistryRoleAssignments(ctx, client, containerRegistries2)
+	containerRegistryRoleAssignments := stage(ctx, "container-registry-role-assignments", func(ctx context.Context) <-chan interface{} {
+		return listContainerRegistryRoleAssignments(ctx, client, uncollected(ctx, containerRegistries2, "container-registry-role-assignments"))
+	})
 
 	// Enumerate Logic Apps Role Assignments
-	logicAppRoleAssignments := listLogicAppRoleAssignments(ctx, client, logicApps2)
+	logicAppRoleAssignments := stage(ctx, "logic-app-role-assignments", func(ctx context.Context) <-chan interface{} {
+		return listLogicAppRoleAssignments(ctx, client, uncollected(ctx, logicApps2, "logic-app-role-assignments"))
+	})
 
 	// Enumerate Managed Cluster Role Assignments
-	managedClusterRoleAssignments := listManagedClusterRoleAssignments(ctx, client, managedClusters2)
+	managedClusterRoleAssignments := stage(ctx, "managed-cluster-role-assignments", func(ctx context.Context) <-chan interface{} {
+		return listManagedClusterRoleAssignments(ctx, client, uncollected(ctx, managedClusters2, "managed-cluster-role-assignments"))
+	})
 
 	// Enumerate VM Scale Set Role Assignments
-	vmScaleSetRoleAssignments := listVMScaleSetRoleAssignments(ctx, client, vmScaleSets2)
+	vmScaleSetRoleAssignments := stage(ctx, "vm-scale-set-role-assignments", func(ctx context.Context) <-chan interface{} {
+		return listVMScaleSetRoleAssignments(ctx, client, uncollected(ctx, vmScaleSets2, "vm-scale-set-role-assignments"))
+	})
 
 	return pipeline.Mux(ctx.Done(),
-		automationAccounts,
-		automationAccountRoleAssignments,
-		containerRegistries,
-		containerRegistryRoleAssignments,
-		functionApps,
-		functionAppRoleAssignments,
-		keyVaultAccessPolicies,
-		keyVaultContributors,
-		keyVaultKVContributors,
-		keyVaultOwners,
-		keyVaultUserAccessAdmins,
-		keyVaults,
-		logicApps,
-		logicAppRoleAssignments,
-		managedClusters,
-		managedClusterRoleAssignments,
-		mgmtGroupDescendants,
-		mgmtGroupOwners,
-		mgmtGroupUserAccessAdmins,
-		mgmtGroups,
-		resourceGroupOwners,
-		resourceGroupUserAccessAdmins,
-		resourceGroups,
-		subscriptionOwners,
-		subscriptionUserAccessAdmins,
-		subscriptions,
-		virtualMachineAdminLogins,
-		virtualMachineAvereContributors,
-		virtualMachineContributors,
-		virtualMachineOwners,
-		virtualMachineUserAccessAdmins,
-		virtualMachines,
-		vmScaleSets,
-		vmScaleSetRoleAssignments,
-		webApps,
-		webAppRoleAssignments,
+		checkpointed(ctx, "automation-accounts", automationAccounts),
+		checkpointed(ctx, "automation-account-role-assignments", automationAccountRoleAssignments),
+		checkpointed(ctx, "container-registries", containerRegistries),
+		checkpointed(ctx, "container-registry-role-assignments", containerRegistryRoleAssignments),
+		checkpointed(ctx, "function-apps", functionApps),
+		checkpointed(ctx, "function-app-role-assignments", functionAppRoleAssignments),
+		checkpointed(ctx, "key-vault-access-policies", keyVaultAccessPolicies),
+		checkpointed(ctx, "key-vault-contributors", keyVaultContributors),
+		checkpointed(ctx, "key-vault-kvcontributors", keyVaultKVContributors),
+		checkpointed(ctx, "key-vault-owners", keyVaultOwners),
+		checkpointed(ctx, "key-vault-user-access-admins", keyVaultUserAccessAdmins),
+		checkpointed(ctx, "key-vaults", keyVaults),
+		checkpointed(ctx, "logic-apps", logicApps),
+		checkpointed(ctx, "logic-app-role-assignments", logicAppRoleAssignments),
+		checkpointed(ctx, "managed-clusters", managedClusters),
+		checkpointed(ctx, "managed-cluster-role-assignments", managedClusterRoleAssignments),
+		checkpointed(ctx, "management-group-descendants", mgmtGroupDescendants),
+		checkpointed(ctx, "management-group-owners", mgmtGroupOwners),
+		checkpointed(ctx, "management-group-user-access-admins", mgmtGroupUserAccessAdmins),
+		checkpointed(ctx, "management-groups", mgmtGroups),
+		checkpointed(ctx, "resource-group-owners", resourceGroupOwners),
+		checkpointed(ctx, "resource-group-user-access-admins", resourceGroupUserAccessAdmins),
+		checkpointed(ctx, "resource-groups", resourceGroups),
+		checkpointed(ctx, "subscription-owners", subscriptionOwners),
+		checkpointed(ctx, "subscription-user-access-admins", subscriptionUserAccessAdmins),
+		checkpointed(ctx, "subscriptions", subscriptions),
+		checkpointed(ctx, "virtual-machine-admin-logins", virtualMachineAdminLogins),
+		checkpointed(ctx, "virtual-machine-avere-contributors", virtualMachineAvereContributors),
+		checkpointed(ctx, "virtual-machine-contributors", virtualMachineContributors),
+		checkpointed(ctx, "virtual-machine-owners", virtualMachineOwners),
+		checkpointed(ctx, "virtual-machine-user-access-admins", virtualMachineUserAccessAdmins),
+		checkpointed(ctx, "virtual-machines", virtualMachines),
+		checkpointed(ctx, "vm-scale-sets", vmScaleSets),
+		checkpointed(ctx, "vm-scale-set-role-assignments", vmScaleSetRoleAssignments),
+		checkpointed(ctx, "web-apps", webApps),
+		checkpointed(ctx, "web-app-role-assignments", webAppRoleAssignments),
 	)
 }
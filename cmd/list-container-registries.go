@@ -59,7 +59,7 @@ func listContainerRegistriesCmdImpl(cmd *cobra.Command, args []string) {
 		start := time.Now()
 		stream := listContainerRegistries(ctx, azClient, listSubscriptions(ctx, azClient))
 		panicrecovery.HandleBubbledPanic(ctx, stop, log)
-		outputStream(ctx, stream)
+		outputStream(ctx, azClient, stream)
 		duration := time.Since(start)
 		log.Info("collection completed", "duration", duration.String())
 	}
@@ -56,7 +56,7 @@ func listDeviceOwnersCmdImpl(cmd *cobra.Command, args []string) {
 	start := time.Now()
 	stream := listDeviceOwners(ctx, azClient, listDevices(ctx, azClient))
 	panicrecovery.HandleBubbledPanic(ctx, stop, log)
-	outputStream(ctx, stream)
+	outputStream(ctx, azClient, stream)
 	duration := time.Since(start)
 	log.Info("collection completed", "duration", duration.String())
 }
@@ -53,7 +53,7 @@ func listDevicesCmdImpl(cmd *cobra.Command, args []string) {
 	start := time.Now()
 	stream := listDevices(ctx, azClient)
 	panicrecovery.HandleBubbledPanic(ctx, stop, log)
-	outputStream(ctx, stream)
+	outputStream(ctx, azClient, stream)
 	duration := time.Since(start)
 	log.Info("collection completed", "duration", duration.String())
 }
@@ -65,7 +65,7 @@ func listDevices(ctx context.Context, client client.AzureClient) <-chan interfac
 		defer panicrecovery.PanicRecovery()
 		defer close(out)
 		count := 0
-		for item := range client.ListAzureDevices(ctx, query.GraphParams{}) {
+		for item := range listDelta(ctx, client, "devices", client.ListAzureDevices, query.GraphParams{}, client.ListAzureDevicesDelta, query.GraphParams{}) {
 			if item.Error != nil {
 				log.Error(item.Error, "unable to continue processing devices")
 				return
@@ -75,10 +75,11 @@ func listDevices(ctx context.Context, client client.AzureClient) <-chan interfac
 				if ok := pipeline.SendAny(ctx.Done(), out, AzureWrapper{
 					Kind: enums.KindAZDevice,
 					Data: models.Device{
-						Device:     item.Ok,
+						Device:     item.Ok.Object,
 						TenantId:   client.TenantInfo().TenantId,
 						TenantName: client.TenantInfo().DisplayName,
 					},
+					Deleted: item.Ok.Removed,
 				}); !ok {
 					return
 				}
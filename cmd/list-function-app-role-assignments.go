@@ -57,7 +57,7 @@ func listFunctionAppRoleAssignmentImpl(cmd *cobra.Command, args []string) {
 	subscriptions := listSubscriptions(ctx, azClient)
 	stream := listFunctionAppRoleAssignments(ctx, azClient, listFunctionApps(ctx, azClient, subscriptions))
 	panicrecovery.HandleBubbledPanic(ctx, stop, log)
-	outputStream(ctx, stream)
+	outputStream(ctx, azClient, stream)
 	duration := time.Since(start)
 	log.Info("collection completed", "duration", duration.String())
 }
@@ -58,7 +58,7 @@ func listGroupMembersCmdImpl(cmd *cobra.Command, _ []string) {
 	log.Info("collecting azure group members...")
 	start := time.Now()
 	stream := listGroupMembers(ctx, azClient, listGroups(ctx, azClient))
-	outputStream(ctx, stream)
+	outputStream(ctx, azClient, stream)
 	duration := time.Since(start)
 	log.Info("collection completed", "duration", duration.String())
 }
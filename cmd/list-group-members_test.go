@@ -21,10 +21,16 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
 
 	"github.com/bloodhoundad/azurehound/v2/client"
 	"github.com/bloodhoundad/azurehound/v2/client/mocks"
+	"github.com/bloodhoundad/azurehound/v2/config"
+	"github.com/bloodhoundad/azurehound/v2/internal/fakeazure"
 	"github.com/bloodhoundad/azurehound/v2/models"
 	"github.com/bloodhoundad/azurehound/v2/models/azure"
 	"go.uber.org/mock/gomock"
@@ -100,3 +106,52 @@ func TestListGroupMembers(t *testing.T) {
 		t.Errorf("got %v, want %v", len(data.Members), 1)
 	}
 }
+
+// TestListGroupMembersReplay records the batched requests for the members of each group and replays them without a
+// server, expecting every group to be given its own members again
+func TestListGroupMembersReplay(t *testing.T) {
+	var (
+		server   = fakeazure.NewServer(fakeazure.NewFixtures(1))
+		cassette = filepath.Join(t.TempDir(), "cassette.jsonl")
+		ctx      = context.Background()
+	)
+
+	list := func() string {
+		if azClient, err := client.NewClient(server.ClientConfig()); err != nil {
+			t.Fatalf("error initializing azure client: %v", err)
+			return ""
+		} else {
+			defer azClient.CloseIdleConnections()
+
+			var lines []string
+			for item := range listGroupMembers(ctx, azClient, listGroups(ctx, azClient)) {
+				if data, err := json.Marshal(item); err != nil {
+					t.Fatal(err)
+				} else {
+					lines = append(lines, string(data))
+				}
+			}
+			sort.Strings(lines)
+			return strings.Join(lines, "\n")
+		}
+	}
+
+	config.HTTPRecordFile.Set(cassette)
+	recorded := list()
+	config.HTTPRecordFile.Set("")
+	server.Close()
+
+	if content, err := os.ReadFile(cassette); err != nil {
+		t.Fatal(err)
+	} else if !strings.Contains(string(content), "/$batch") {
+		t.Error("got no batched requests in the cassette")
+	} else if strings.Count(recorded, "AZGroupMember") != 3 {
+		t.Errorf("got recorded output %s, want the members of 3 groups", recorded)
+	}
+
+	config.HTTPReplayFile.Set(cassette)
+	defer config.HTTPReplayFile.Set("")
+	if replayed := list(); replayed != recorded {
+		t.Errorf("got replayed output %s, want %s", replayed, recorded)
+	}
+}
@@ -55,7 +55,7 @@ func listGroupOwnersCmdImpl(cmd *cobra.Command, args []string) {
 	log.Info("collecting azure group owners...")
 	start := time.Now()
 	stream := listGroupOwners(ctx, azClient, listGroups(ctx, azClient))
-	outputStream(ctx, stream)
+	outputStream(ctx, azClient, stream)
 	duration := time.Since(start)
 	log.Info("collection completed", "duration", duration.String())
 }
@@ -53,7 +53,7 @@ func listGroupsCmdImpl(cmd *cobra.Command, _ []string) {
 	start := time.Now()
 	stream := listGroups(ctx, azClient)
 	panicrecovery.HandleBubbledPanic(ctx, stop, log)
-	outputStream(ctx, stream)
+	outputStream(ctx, azClient, stream)
 	duration := time.Since(start)
 	log.Info("collection completed", "duration", duration.String())
 }
@@ -61,11 +61,25 @@ func listGroupsCmdImpl(cmd *cobra.Command, _ []string) {
 func listGroups(ctx context.Context, client client.AzureClient) <-chan interface{} {
 	out := make(chan interface{})
 
+	// delta queries do not support filtering on securityEnabled, so it is selected to tell which groups to collect
+	deltaParams := query.GraphParams{Select: []string{
+		"createdDateTime",
+		"description",
+		"displayName",
+		"groupTypes",
+		"isAssignableToRole",
+		"mailEnabled",
+		"onPremisesSecurityIdentifier",
+		"onPremisesSyncEnabled",
+		"securityEnabled",
+		"id",
+	}}
+
 	go func() {
 		defer panicrecovery.PanicRecovery()
 		defer close(out)
 		count := 0
-		for item := range client.ListAzureADGroups(ctx, query.GraphParams{Filter: "securityEnabled eq true"}) {
+		for item := range listDelta(ctx, client, "groups", client.ListAzureADGroups, query.GraphParams{Filter: "securityEnabled eq true"}, client.ListAzureADGroupsDelta, deltaParams) {
 			if item.Error != nil {
 				log.Error(item.Error, "unable to continue processing groups")
 				return
@@ -73,13 +87,16 @@ func listGroups(ctx context.Context, client client.AzureClient) <-chan interface
 				log.V(2).Info("found group", "group", item)
 				count++
 				group := models.Group{
-					Group:      item.Ok,
+					Group:      item.Ok.Object,
 					TenantId:   client.TenantInfo().TenantId,
 					TenantName: client.TenantInfo().DisplayName,
 				}
+				// a group that is no longer security enabled leaves the collection as if it were removed, while an update
+				// that leaves securityEnabled out did not change it
 				if ok := pipeline.SendAny(ctx.Done(), out, AzureWrapper{
-					Kind: enums.KindAZGroup,
-					Data: group,
+					Kind:    enums.KindAZGroup,
+					Data:    group,
+					Deleted: item.Ok.Removed || (item.Ok.Has("securityEnabled") && !item.Ok.Object.SecurityEnabled),
 				}); !ok {
 					return
 				}
@@ -62,7 +62,7 @@ func listKeyVaultAccessPoliciesCmdImpl(cmd *cobra.Command, args []string) {
 			log.Info("applying access type filters", "filters", filters)
 		}
 		stream := listKeyVaultAccessPolicies(ctx, azClient, listKeyVaults(ctx, azClient, subscriptions), filters)
-		outputStream(ctx, stream)
+		outputStream(ctx, azClient, stream)
 		duration := time.Since(start)
 		log.Info("collection completed", "duration", duration.String())
 	}
@@ -56,7 +56,7 @@ func listKeyVaultContributorsCmdImpl(cmd *cobra.Command, args []string) {
 	kvRoleAssignments := listKeyVaultRoleAssignments(ctx, azClient, keyVaults)
 	panicrecovery.HandleBubbledPanic(ctx, stop, log)
 	stream := listKeyVaultContributors(ctx, kvRoleAssignments)
-	outputStream(ctx, stream)
+	outputStream(ctx, azClient, stream)
 	duration := time.Since(start)
 	log.Info("collection completed", "duration", duration.String())
 }
@@ -56,7 +56,7 @@ func listKeyVaultKVContributorsCmdImpl(cmd *cobra.Command, args []string) {
 	kvRoleAssignments := listKeyVaultRoleAssignments(ctx, azClient, keyVaults)
 	panicrecovery.HandleBubbledPanic(ctx, stop, log)
 	stream := listKeyVaultKVContributors(ctx, kvRoleAssignments)
-	outputStream(ctx, stream)
+	outputStream(ctx, azClient, stream)
 	duration := time.Since(start)
 	log.Info("collection completed", "duration", duration.String())
 }
@@ -61,7 +61,7 @@ func listLogicAppRoleAssignmentImpl(cmd *cobra.Command, args []string) {
 		subscriptions := listSubscriptions(ctx, azClient)
 		stream := listLogicAppRoleAssignments(ctx, azClient, listLogicApps(ctx, azClient, subscriptions))
 		panicrecovery.HandleBubbledPanic(ctx, stop, log)
-		outputStream(ctx, stream)
+		outputStream(ctx, azClient, stream)
 		duration := time.Since(start)
 		log.Info("collection completed", "duration", duration.String())
 	}
@@ -55,7 +55,7 @@ func listManagementGroupDescendantsCmdImpl(cmd *cobra.Command, args []string) {
 	start := time.Now()
 	stream := listManagementGroupDescendants(ctx, azClient, listManagementGroups(ctx, azClient))
 	panicrecovery.HandleBubbledPanic(ctx, stop, log)
-	outputStream(ctx, stream)
+	outputStream(ctx, azClient, stream)
 	duration := time.Since(start)
 	log.Info("collection completed", "duration", duration.String())
 }
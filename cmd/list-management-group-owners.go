@@ -55,7 +55,7 @@ func listManagementGroupOwnersCmdImpl(cmd *cobra.Command, args []string) {
 	roleAssignments := listManagementGroupRoleAssignments(ctx, azClient, managementGroups)
 	panicrecovery.HandleBubbledPanic(ctx, stop, log)
 	stream := listManagementGroupOwners(ctx, roleAssignments)
-	outputStream(ctx, stream)
+	outputStream(ctx, azClient, stream)
 	duration := time.Since(start)
 	log.Info("collection completed", "duration", duration.String())
 }
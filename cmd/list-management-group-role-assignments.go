@@ -56,7 +56,7 @@ func listManagementGroupRoleAssignmentsCmdImpl(cmd *cobra.Command, args []string
 	managementGroups := listManagementGroups(ctx, azClient)
 	stream := listManagementGroupRoleAssignments(ctx, azClient, managementGroups)
 	panicrecovery.HandleBubbledPanic(ctx, stop, log)
-	outputStream(ctx, stream)
+	outputStream(ctx, azClient, stream)
 	duration := time.Since(start)
 	log.Info("collection completed", "duration", duration.String())
 }
@@ -54,7 +54,7 @@ func listManagementGroupsCmdImpl(cmd *cobra.Command, args []string) {
 
 	stream := listManagementGroups(ctx, azClient)
 	panicrecovery.HandleBubbledPanic(ctx, stop, log)
-	outputStream(ctx, stream)
+	outputStream(ctx, azClient, stream)
 	duration := time.Since(start)
 	log.Info("collection completed", "duration", duration.String())
 }
@@ -57,7 +57,7 @@ func listResourceGroupRoleAssignmentsCmdImpl(cmd *cobra.Command, args []string)
 	resourceGroups := listResourceGroups(ctx, azClient, subscriptions)
 	stream := listResourceGroupRoleAssignments(ctx, azClient, resourceGroups)
 	panicrecovery.HandleBubbledPanic(ctx, stop, log)
-	outputStream(ctx, stream)
+	outputStream(ctx, azClient, stream)
 	duration := time.Since(start)
 	log.Info("collection completed", "duration", duration.String())
 }
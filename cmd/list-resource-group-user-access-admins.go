@@ -56,7 +56,7 @@ func listResourceGroupUserAccessAdminsCmdImpl(cmd *cobra.Command, args []string)
 	roleAssignments := listResourceGroupRoleAssignments(ctx, azClient, resourceGroups)
 	panicrecovery.HandleBubbledPanic(ctx, stop, log)
 	stream := listResourceGroupUserAccessAdmins(ctx, roleAssignments)
-	outputStream(ctx, stream)
+	outputStream(ctx, azClient, stream)
 	duration := time.Since(start)
 	log.Info("collection completed", "duration", duration.String())
 }
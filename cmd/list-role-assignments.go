@@ -57,7 +57,7 @@ func listRoleAssignmentsCmdImpl(cmd *cobra.Command, args []string) {
 	roles := listRoles(ctx, azClient)
 	stream := listRoleAssignments(ctx, azClient, roles)
 	panicrecovery.HandleBubbledPanic(ctx, stop, log)
-	outputStream(ctx, stream)
+	outputStream(ctx, azClient, stream)
 	duration := time.Since(start)
 	log.Info("collection completed", "duration", duration.String())
 }
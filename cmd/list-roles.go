@@ -53,7 +53,7 @@ func listRolesCmdImpl(cmd *cobra.Command, args []string) {
 	start := time.Now()
 	stream := listRoles(ctx, azClient)
 	panicrecovery.HandleBubbledPanic(ctx, stop, log)
-	outputStream(ctx, stream)
+	outputStream(ctx, azClient, stream)
 	duration := time.Since(start)
 	log.Info("collection completed", "duration", duration.String())
 }
@@ -31,7 +31,7 @@ import (
 )
 
 func init() {
-	config.Init(listRootCmd, append(config.AzureConfig, config.OutputFile))
+	config.Init(listRootCmd, append(config.AzureConfig, config.OutputFile, config.OutputFormat, config.OutputCompression, config.SplitByKind, config.MaxFileObjects, config.MaxFileSize, config.UploadUrl, config.UploadTokenId, config.UploadToken, config.Storage, config.StorageEndpoint, config.StorageRegion, config.DeltaStateFile, config.CheckpointDir, config.Resume))
 	rootCmd.AddCommand(listRootCmd)
 }
 
@@ -39,7 +39,8 @@ var listRootCmd = &cobra.Command{
 	Use:               "list",
 	Short:             "Lists Azure Objects",
 	Run:               listCmdImpl,
-	PersistentPreRunE: persistentPreRunE,
+	PersistentPreRunE: listPersistentPreRunE,
+	PersistentPostRun: persistentPostRun,
 	SilenceUsage:      true,
 }
 
@@ -52,11 +53,22 @@ func listCmdImpl(cmd *cobra.Command, args []string) {
 	defer gracefulShutdown(stop)
 
 	log.V(1).Info("testing connections")
-	azClient := connectAndCreateClient()
+	azClients := connectAndCreateClients(ctx)
 	log.Info("collecting azure objects...")
 	start := time.Now()
-	stream := listAll(ctx, azClient)
-	outputStream(ctx, stream)
+	if len(azClients) == 1 {
+		collect(ctx, azClients[0], config.OutputFile.Value().(string), listAll)
+	} else if config.OutputFile.Value().(string) == "" && config.Storage.Value().(string) == "" && config.UploadUrl.Value().(string) == "" {
+		// the documents of each tenant would be indistinguishable on the console
+		exit(fmt.Errorf("collecting multiple tenants requires --%s, --%s or --%s", config.OutputFile.Name, config.Storage.Name, config.UploadUrl.Name))
+	} else {
+		// keep each tenant's output separate
+		for _, azClient := range azClients {
+			tenantId := azClient.TenantInfo().TenantId
+			log.Info("collecting tenant", "tenantId", tenantId)
+			collect(ctx, azClient, tenantOutputFile(config.OutputFile.Value().(string), tenantId), listAll)
+		}
+	}
 	duration := time.Since(start)
 	log.Info("collection completed", "duration", duration.String())
 }
@@ -0,0 +1,173 @@
+// Copyright (C) 2024 Specter Ops, Inc.
+//
+// This file is part of AzureHound.
+//
+// AzureHound is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// AzureHound is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+
+	"github.com/bloodhoundad/azurehound/v2/client"
+	"github.com/bloodhoundad/azurehound/v2/enums"
+	"github.com/bloodhoundad/azurehound/v2/internal/fakeazure"
+	"github.com/bloodhoundad/azurehound/v2/metrics"
+	"github.com/bloodhoundad/azurehound/v2/tracing"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+func init() {
+	setupLogger()
+}
+
+// TestListAll collects everything from a fake tenant while the server throttles and fails some requests, comparing the
+// output to testdata/list-all.golden. Run with -update to regenerate the golden file after intended changes.
+func TestListAll(t *testing.T) {
+	server := fakeazure.NewServer(fakeazure.NewFixtures(1))
+	defer server.Close()
+
+	server.Inject(fakeazure.Fault{Path: "/v1.0/users", Status: http.StatusTooManyRequests, Count: 2})
+	server.Inject(fakeazure.Fault{Path: "/beta/$batch", Status: http.StatusServiceUnavailable, Count: 1})
+	server.Inject(fakeazure.Fault{Path: "/members", Status: http.StatusTooManyRequests, Count: 1})
+	server.Inject(fakeazure.Fault{Path: "/providers/Microsoft.KeyVault/vaults", Status: http.StatusInternalServerError, Count: 1})
+	server.Inject(fakeazure.Fault{Path: "/resourcegroups", Status: http.StatusTooManyRequests, Count: 1})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	azClient, err := client.NewClient(server.ClientConfig())
+	if err != nil {
+		t.Fatalf("error initializing azure client: %v", err)
+	}
+	defer azClient.CloseIdleConnections()
+
+	metrics.Reset()
+	defer metrics.Reset()
+
+	exporter := &tracing.MemoryExporter{}
+	tracing.Init(exporter, logr.Discard())
+	defer tracing.Shutdown(context.Background())
+
+	var lines []string
+	for item := range listAll(ctx, azClient) {
+		if data, err := json.Marshal(item); err != nil {
+			t.Fatal(err)
+		} else {
+			lines = append(lines, string(data))
+		}
+	}
+	sort.Strings(lines)
+	actual := strings.Join(lines, "\n") + "\n"
+
+	golden := filepath.Join("testdata", "list-all.golden")
+	if *update {
+		if err := os.MkdirAll("testdata", 0755); err != nil {
+			t.Fatal(err)
+		} else if err := os.WriteFile(golden, []byte(actual), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if expected, err := os.ReadFile(golden); err != nil {
+		t.Fatal(err)
+	} else if actual != string(expected) {
+		t.Errorf("output differs from %s; run the test with -update if the change is intended\ngot:\n%s", golden, actual)
+	}
+
+	if requests := server.Requests("/v1.0/users"); requests < 3 {
+		t.Errorf("got %d requests for users, want the two throttled requests to be retried", requests)
+	}
+
+	summary := metrics.Current().Summary()
+	if len(summary.Stages) == 0 {
+		t.Error("got no statistics for the list stages")
+	}
+	for _, stage := range summary.Stages {
+		if stage.Name == "users" && stage.Objects[enums.KindAZUser] != 5 {
+			t.Errorf("got %d users emitted by the users stage, want 5", stage.Objects[enums.KindAZUser])
+		} else if stage.Name == "group-members" && stage.Requests == 0 {
+			t.Error("got no requests for the group-members stage, want its batched requests attributed to it")
+		} else if stage.Errors != 0 {
+			t.Errorf("got %d errors in the %s stage, want none", stage.Errors, stage.Name)
+		}
+	}
+	var memberThrottles int64
+	for _, endpoint := range summary.Endpoints {
+		if strings.HasSuffix(endpoint.Endpoint, "/v1.0/users") && endpoint.Throttles != 2 {
+			t.Errorf("got %d throttled requests for users, want 2", endpoint.Throttles)
+		} else if strings.HasSuffix(endpoint.Endpoint, "/beta/groups/{id}/members") {
+			memberThrottles += endpoint.Throttles
+		}
+	}
+	if memberThrottles != 1 {
+		t.Errorf("got %d throttled requests for group members, want the one throttled within a batch", memberThrottles)
+	}
+
+	if err := tracing.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	var (
+		usersStage, membersStage  tracing.SpanData
+		throttles, batchThrottles int
+		batches                   int
+		spans                     = map[tracing.SpanID]tracing.SpanData{}
+	)
+	for _, span := range exporter.Spans() {
+		spans[span.SpanID] = span
+		if span.Name == "list users" {
+			usersStage = span
+		} else if span.Name == "list group-members" {
+			membersStage = span
+		}
+	}
+	for _, span := range exporter.Spans() {
+		for _, event := range span.Events {
+			if event.Name == "throttle" && span.ParentID == usersStage.SpanID {
+				throttles++
+			} else if event.Name == "throttle" && span.ParentID == membersStage.SpanID {
+				batchThrottles++
+			}
+		}
+		if span.Name == "graph batch" {
+			batches++
+			if len(span.Links) == 0 {
+				t.Error("got a batch linked to none of its requests")
+			}
+		}
+		for _, link := range span.Links {
+			if linked, ok := spans[link.SpanID]; !ok || linked.TraceID != link.TraceID {
+				t.Errorf("got a batch linked to the unknown span %s", link.SpanID)
+			}
+		}
+	}
+	if !usersStage.SpanID.IsValid() || throttles != 2 {
+		t.Errorf("got %d throttle events on the requests of the users stage, want 2", throttles)
+	} else if batches == 0 {
+		t.Error("got no spans for the batches of requests")
+	} else if !membersStage.SpanID.IsValid() || batchThrottles != 1 {
+		t.Errorf("got %d throttle events on the batched requests of the group-members stage, want 1", batchThrottles)
+	}
+}
@@ -53,7 +53,7 @@ func listServicePrincipalsCmdImpl(cmd *cobra.Command, args []string) {
 	start := time.Now()
 	stream := listServicePrincipals(ctx, azClient)
 	panicrecovery.HandleBubbledPanic(ctx, stop, log)
-	outputStream(ctx, stream)
+	outputStream(ctx, azClient, stream)
 	duration := time.Since(start)
 	log.Info("collection completed", "duration", duration.String())
 }
@@ -65,7 +65,7 @@ func listServicePrincipals(ctx context.Context, client client.AzureClient) <-cha
 		defer panicrecovery.PanicRecovery()
 		defer close(out)
 		count := 0
-		for item := range client.ListAzureADServicePrincipals(ctx, query.GraphParams{}) {
+		for item := range listDelta(ctx, client, "service-principals", client.ListAzureADServicePrincipals, query.GraphParams{}, client.ListAzureADServicePrincipalsDelta, query.GraphParams{}) {
 			if item.Error != nil {
 				log.Error(item.Error, "unable to continue processing service principals")
 				return
@@ -75,10 +75,11 @@ func listServicePrincipals(ctx context.Context, client client.AzureClient) <-cha
 				if ok := pipeline.SendAny(ctx.Done(), out, AzureWrapper{
 					Kind: enums.KindAZServicePrincipal,
 					Data: models.ServicePrincipal{
-						ServicePrincipal: item.Ok,
+						ServicePrincipal: item.Ok.Object,
 						TenantId:         client.TenantInfo().TenantId,
 						TenantName:       client.TenantInfo().DisplayName,
 					},
+					Deleted: item.Ok.Removed,
 				}); !ok {
 					return
 				}
@@ -57,7 +57,7 @@ func listStorageAccountRoleAssignmentsImpl(cmd *cobra.Command, args []string) {
 	subscriptions := listSubscriptions(ctx, azClient)
 	stream := listStorageAccountRoleAssignments(ctx, azClient, listStorageAccounts(ctx, azClient, subscriptions))
 	panicrecovery.HandleBubbledPanic(ctx, stop, log)
-	outputStream(ctx, stream)
+	outputStream(ctx, azClient, stream)
 	duration := time.Since(start)
 	log.Info("collection completed", "duration", duration.String())
 }
@@ -57,7 +57,7 @@ func listStorageContainersCmdImpl(cmd *cobra.Command, args []string) {
 	storageAccounts := listStorageAccounts(ctx, azClient, subscriptions)
 	stream := listStorageContainers(ctx, azClient, storageAccounts)
 	panicrecovery.HandleBubbledPanic(ctx, stop, log)
-	outputStream(ctx, stream)
+	outputStream(ctx, azClient, stream)
 	duration := time.Since(start)
 	log.Info("collection completed", "duration", duration.String())
 }
@@ -66,10 +66,9 @@ func listStorageContainers(ctx context.Context, client client.AzureClient, stora
 	var (
 		out = make(chan interface{})
 		ids = make(chan interface{})
-		// The original size of the demuxxer cascaded into error messages for a lot of collection steps.
-		// Decreasing the demuxxer size only here is sufficient to prevent the cascade
-		// The error message with higher values for size is
-		// "The request was throttled."
+		// Listing containers used to cascade into "The request was throttled." errors for a lot of collection steps.
+		// The streams only bound concurrency; the limiter shared by every request to Resource Manager halves it and
+		// waits out Retry-After only after the API throttles a request, which the retry policy then sends again.
 		// See issue #7: https://github.com/bloodhoundad/azurehound/issues/7
 		streams = pipeline.Demux(ctx.Done(), ids, config.ColStreamCount.Value().(int))
 		wg      sync.WaitGroup
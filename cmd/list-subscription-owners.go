@@ -57,7 +57,7 @@ func listSubscriptionOwnersCmdImpl(cmd *cobra.Command, args []string) {
 	roleAssignments := listSubscriptionRoleAssignments(ctx, azClient, subscriptions)
 	stream := listSubscriptionOwners(ctx, azClient, roleAssignments)
 	panicrecovery.HandleBubbledPanic(ctx, stop, log)
-	outputStream(ctx, stream)
+	outputStream(ctx, azClient, stream)
 	duration := time.Since(start)
 	log.Info("collection completed", "duration", duration.String())
 }
@@ -56,7 +56,7 @@ func listSubscriptionRoleAssignmentsCmdImpl(cmd *cobra.Command, args []string) {
 	subscriptions := listSubscriptions(ctx, azClient)
 	stream := listSubscriptionRoleAssignments(ctx, azClient, subscriptions)
 	panicrecovery.HandleBubbledPanic(ctx, stop, log)
-	outputStream(ctx, stream)
+	outputStream(ctx, azClient, stream)
 	duration := time.Since(start)
 	log.Info("collection completed", "duration", duration.String())
 }
@@ -57,7 +57,7 @@ func listSubscriptionUserAccessAdminsCmdImpl(cmd *cobra.Command, args []string)
 	roleAssignments := listSubscriptionRoleAssignments(ctx, azClient, subscriptions)
 	stream := listSubscriptionUserAccessAdmins(ctx, azClient, roleAssignments)
 	panicrecovery.HandleBubbledPanic(ctx, stop, log)
-	outputStream(ctx, stream)
+	outputStream(ctx, azClient, stream)
 	duration := time.Since(start)
 	log.Info("collection completed", "duration", duration.String())
 }
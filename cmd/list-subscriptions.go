@@ -56,7 +56,7 @@ func listSubscriptionsCmdImpl(cmd *cobra.Command, args []string) {
 	start := time.Now()
 	stream := listSubscriptions(ctx, azClient)
 	panicrecovery.HandleBubbledPanic(ctx, stop, log)
-	outputStream(ctx, stream)
+	outputStream(ctx, azClient, stream)
 	duration := time.Since(start)
 	log.Info("collection completed", "duration", duration.String())
 }
@@ -52,7 +52,7 @@ func listTenantsCmdImpl(cmd *cobra.Command, args []string) {
 	start := time.Now()
 	stream := listTenants(ctx, azClient)
 	panicrecovery.HandleBubbledPanic(ctx, stop, log)
-	outputStream(ctx, stream)
+	outputStream(ctx, azClient, stream)
 	duration := time.Since(start)
 	log.Info("collection completed", "duration", duration.String())
 }
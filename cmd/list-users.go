@@ -53,7 +53,7 @@ func listUsersCmdImpl(cmd *cobra.Command, _ []string) {
 	start := time.Now()
 	stream := listUsers(ctx, azClient)
 	panicrecovery.HandleBubbledPanic(ctx, stop, log)
-	outputStream(ctx, stream)
+	outputStream(ctx, azClient, stream)
 	duration := time.Since(start)
 	log.Info("collection completed", "duration", duration.String())
 }
@@ -79,7 +79,7 @@ func listUsers(ctx context.Context, client client.AzureClient) <-chan interface{
 		defer panicrecovery.PanicRecovery()
 		defer close(out)
 		count := 0
-		for item := range client.ListAzureADUsers(ctx, params) {
+		for item := range listDelta(ctx, client, "users", client.ListAzureADUsers, params, client.ListAzureADUsersDelta, params) {
 			if item.Error != nil {
 				log.Error(item.Error, "unable to continue processing users")
 				return
@@ -87,13 +87,14 @@ func listUsers(ctx context.Context, client client.AzureClient) <-chan interface{
 				log.V(2).Info("found user", "user", item)
 				count++
 				user := models.User{
-					User:       item.Ok,
+					User:       item.Ok.Object,
 					TenantId:   client.TenantInfo().TenantId,
 					TenantName: client.TenantInfo().DisplayName,
 				}
 				if ok := pipeline.SendAny(ctx.Done(), out, AzureWrapper{
-					Kind: enums.KindAZUser,
-					Data: user,
+					Kind:    enums.KindAZUser,
+					Data:    user,
+					Deleted: item.Ok.Removed,
 				}); !ok {
 					return
 				}
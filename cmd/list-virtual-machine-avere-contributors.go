@@ -56,7 +56,7 @@ func listVirtualMachineAvereContributorsCmdImpl(cmd *cobra.Command, args []strin
 	vmRoleAssignments := listVirtualMachineRoleAssignments(ctx, azClient, vms)
 	panicrecovery.HandleBubbledPanic(ctx, stop, log)
 	stream := listVirtualMachineAvereContributors(ctx, vmRoleAssignments)
-	outputStream(ctx, stream)
+	outputStream(ctx, azClient, stream)
 	duration := time.Since(start)
 	log.Info("collection completed", "duration", duration.String())
 }
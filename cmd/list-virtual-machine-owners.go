@@ -56,7 +56,7 @@ func listVirtualMachineOwnersCmdImpl(cmd *cobra.Command, args []string) {
 	vmRoleAssignments := listVirtualMachineRoleAssignments(ctx, azClient, vms)
 	panicrecovery.HandleBubbledPanic(ctx, stop, log)
 	stream := listVirtualMachineOwners(ctx, vmRoleAssignments)
-	outputStream(ctx, stream)
+	outputStream(ctx, azClient, stream)
 	duration := time.Since(start)
 	log.Info("collection completed", "duration", duration.String())
 }
@@ -59,7 +59,7 @@ func listVMScaleSetsCmdImpl(cmd *cobra.Command, args []string) {
 		start := time.Now()
 		stream := listVMScaleSets(ctx, azClient, listSubscriptions(ctx, azClient))
 		panicrecovery.HandleBubbledPanic(ctx, stop, log)
-		outputStream(ctx, stream)
+		outputStream(ctx, azClient, stream)
 		duration := time.Since(start)
 		log.Info("collection completed", "duration", duration.String())
 	}
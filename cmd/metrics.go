@@ -0,0 +1,85 @@
+// Copyright (C) 2024 Specter Ops, Inc.
+//
+// This file is part of AzureHound.
+//
+// AzureHound is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// AzureHound is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/bloodhoundad/azurehound/v2/metrics"
+	"github.com/bloodhoundad/azurehound/v2/panicrecovery"
+)
+
+// readyCheckinTimeout is how long the service remains ready without a successful checkin with BloodHound Enterprise;
+// the service checks in every few seconds, so this tolerates a handful of failures in a row
+const readyCheckinTimeout = time.Minute
+
+// newMetricsMux serves the Prometheus metrics at /metrics, liveness at /healthz and readiness at /readyz. The service
+// is ready once connected is true and for as long as it keeps checking in.
+func newMetricsMux(connected func() bool) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !connected() {
+			http.Error(w, "not connected to bloodhound enterprise", http.StatusServiceUnavailable)
+		} else if since := time.Since(metrics.LastCheckin()); since > readyCheckinTimeout {
+			http.Error(w, fmt.Sprintf("no successful checkin with bloodhound enterprise for %s", since.Round(time.Second)), http.StatusServiceUnavailable)
+		} else {
+			fmt.Fprintln(w, "ok")
+		}
+	})
+	return mux
+}
+
+// serveMetrics listens on the address, serving the metrics and health checks until the context is done
+func serveMetrics(ctx context.Context, addr string, connected func() bool) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{
+		Handler:           newMetricsMux(connected),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		defer panicrecovery.PanicRecovery()
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	go func() {
+		defer panicrecovery.PanicRecovery()
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error(err, "metrics server stopped unexpectedly", "address", addr)
+		}
+	}()
+
+	log.Info("serving metrics", "address", listener.Addr().String())
+	return nil
+}
@@ -0,0 +1,56 @@
+// Copyright (C) 2024 Specter Ops, Inc.
+//
+// This file is part of AzureHound.
+//
+// AzureHound is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// AzureHound is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/bloodhoundad/azurehound/v2/metrics"
+)
+
+func TestMetricsMux(t *testing.T) {
+	var (
+		connected atomic.Bool
+		mux       = newMetricsMux(connected.Load)
+	)
+
+	status := func(path string) int {
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, httptest.NewRequest("GET", path, nil))
+		return recorder.Code
+	}
+
+	if code := status("/healthz"); code != http.StatusOK {
+		t.Errorf("got %d from /healthz, want %d", code, http.StatusOK)
+	}
+	if code := status("/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("got %d from /readyz before connecting, want %d", code, http.StatusServiceUnavailable)
+	}
+
+	connected.Store(true)
+	metrics.Checkin()
+	if code := status("/readyz"); code != http.StatusOK {
+		t.Errorf("got %d from /readyz after checking in, want %d", code, http.StatusOK)
+	}
+	if code := status("/metrics"); code != http.StatusOK {
+		t.Errorf("got %d from /metrics, want %d", code, http.StatusOK)
+	}
+}
@@ -0,0 +1,431 @@
+// Copyright (C) 2024 Specter Ops, Inc.
+//
+// This file is part of AzureHound.
+//
+// AzureHound is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// AzureHound is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bloodhoundad/azurehound/v2/client"
+	"github.com/bloodhoundad/azurehound/v2/client/query"
+	"github.com/bloodhoundad/azurehound/v2/client/rest"
+	"github.com/bloodhoundad/azurehound/v2/config"
+	"github.com/bloodhoundad/azurehound/v2/models/azure"
+	"github.com/bloodhoundad/azurehound/v2/panicrecovery"
+	"github.com/bloodhoundad/azurehound/v2/pipeline"
+	"github.com/spf13/cobra"
+)
+
+const (
+	preflightOk        = "ok"
+	preflightEmpty     = "empty"
+	preflightForbidden = "forbidden"
+	preflightError     = "error"
+	preflightSkipped   = "skipped"
+)
+
+func init() {
+	config.Init(preflightCmd, config.AzureConfig)
+	rootCmd.AddCommand(preflightCmd)
+}
+
+var preflightCmd = &cobra.Command{
+	Use:     "preflight",
+	Aliases: []string{"whoami"},
+	Short:   "Inspects the access tokens and probes the permissions of each collector",
+	Long: "Acquires access tokens for Microsoft Graph and Azure Resource Manager, prints their claims and requests a " +
+		"single object from each collector to report which collectors will be empty or forbidden. Resource Manager " +
+		"collectors are probed in the first visible subscription only.",
+	Run:               preflightCmdImpl,
+	PersistentPreRunE: persistentPreRunE,
+	SilenceUsage:      true,
+}
+
+type preflightResult struct {
+	Collector string
+	Status    string
+	Detail    string
+}
+
+type preflightResults []preflightResult
+
+func preflightCmdImpl(cmd *cobra.Command, args []string) {
+	if len(args) > 0 {
+		exit(fmt.Errorf("unsupported subcommand: %v", args))
+	}
+
+	ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, os.Kill)
+	defer gracefulShutdown(stop)
+
+	log.V(1).Info("testing connections")
+	if err := checkSingleTenant(); err != nil {
+		exit(err)
+	} else if err := testConnections(); err != nil {
+		exit(fmt.Errorf("failed to test connections: %w", err))
+	} else if clientConfig, err := newClientConfig(config.AzTenant.Value().(string)); err != nil {
+		exit(fmt.Errorf("failed to create new Azure client: %w", err))
+	} else if broker, err := newTokenBroker(clientConfig); err != nil {
+		exit(fmt.Errorf("failed to create new Azure client: %w", err))
+	} else {
+		for _, api := range []string{clientConfig.GraphUrl(), clientConfig.ResourceManagerUrl()} {
+			if resource, err := url.Parse(api); err != nil {
+				exit(err)
+			} else if token, err := broker.Token(*resource); err != nil {
+				fmt.Fprintf(os.Stdout, "%s\n  unable to acquire access token: %v\n\n", api, err)
+			} else {
+				printTokenClaims(os.Stdout, api, token)
+			}
+		}
+
+		if azClient, err := client.NewClientWithBroker(clientConfig, broker); err != nil {
+			exit(fmt.Errorf("failed to create new Azure client: %w", err))
+		} else {
+			defer azClient.CloseIdleConnections()
+
+			log.Info("probing collectors...")
+			results := preflight(ctx, azClient)
+			results.print(os.Stdout)
+
+			if failed := results.count(preflightForbidden) + results.count(preflightError); failed > 0 {
+				exit(fmt.Errorf("%d collectors failed preflight", failed))
+			}
+		}
+	}
+}
+
+func printTokenClaims(w io.Writer, api string, token rest.Token) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	defer tw.Flush()
+
+	fmt.Fprintf(tw, "%s\n", api)
+	if claims, err := token.Claims(); err != nil {
+		fmt.Fprintf(tw, "  unable to parse access token: %v\n\n", err)
+	} else {
+		var (
+			principal = firstClaim(claims, "upn", "unique_name", "app_displayname")
+			lifetime  = time.Until(token.Expires()).Round(time.Second)
+		)
+
+		fmt.Fprintf(tw, "  Tenant\t%s\n", firstClaim(claims, "tid"))
+		fmt.Fprintf(tw, "  Object ID\t%s\n", firstClaim(claims, "oid"))
+		fmt.Fprintf(tw, "  Principal\t%s\n", principal)
+		fmt.Fprintf(tw, "  Application ID\t%s\n", firstClaim(claims, "appid", "azp"))
+		fmt.Fprintf(tw, "  Roles\t%s\n", firstClaim(claims, "roles"))
+		fmt.Fprintf(tw, "  Scopes\t%s\n", firstClaim(claims, "scp"))
+		fmt.Fprintf(tw, "  Expires\t%s (%s)\n\n", token.Expires().Format(time.RFC3339), lifetime)
+	}
+}
+
+// firstClaim formats the first of the given claims present in the token
+func firstClaim(claims map[string]interface{}, names ...string) string {
+	for _, name := range names {
+		switch value := claims[name].(type) {
+		case string:
+			if value != "" {
+				return value
+			}
+		case []interface{}:
+			values := make([]string, 0, len(value))
+			for _, v := range value {
+				values = append(values, fmt.Sprint(v))
+			}
+			sort.Strings(values)
+			return strings.Join(values, " ")
+		}
+	}
+	return "-"
+}
+
+// preflight requests the first object of each collector run by listAllAD and listAllRM. Collectors that enumerate the
+// children of another collector's objects are probed with the first parent found.
+func preflight(ctx context.Context, azClient client.AzureClient) preflightResults {
+	var (
+		results  preflightResults
+		graphTop = query.GraphParams{Top: 1}
+	)
+
+	// Azure AD
+	probe(ctx, &results, "tenants", func(ctx context.Context) <-chan client.AzureResult[struct{}] {
+		return dropResults(ctx, azClient.ListAzureADTenants(ctx, true))
+	})
+	probe(ctx, &results, "users", func(ctx context.Context) <-chan client.AzureResult[struct{}] {
+		return dropResults(ctx, azClient.ListAzureADUsers(ctx, graphTop))
+	})
+	if app, ok := probe(ctx, &results, "apps", func(ctx context.Context) <-chan client.AzureResult[string] {
+		return ids(ctx, azClient.ListAzureADApps(ctx, graphTop), func(app azure.Application) string { return app.Id })
+	}); ok {
+		probe(ctx, &results, "app-owners", func(ctx context.Context) <-chan client.AzureResult[struct{}] {
+			return dropResults(ctx, azClient.ListAzureADAppOwners(ctx, app, graphTop))
+		})
+	} else {
+		results.skip("apps", "app-owners")
+	}
+	if device, ok := probe(ctx, &results, "devices", func(ctx context.Context) <-chan client.AzureResult[string] {
+		return ids(ctx, azClient.ListAzureDevices(ctx, graphTop), func(device azure.Device) string { return device.Id })
+	}); ok {
+		probe(ctx, &results, "device-owners", func(ctx context.Context) <-chan client.AzureResult[struct{}] {
+			return dropResults(ctx, azClient.ListAzureDeviceRegisteredOwners(ctx, device, graphTop))
+		})
+	} else {
+		results.skip("devices", "device-owners")
+	}
+	if group, ok := probe(ctx, &results, "groups", func(ctx context.Context) <-chan client.AzureResult[string] {
+		return ids(ctx, azClient.ListAzureADGroups(ctx, graphTop), func(group azure.Group) string { return group.Id })
+	}); ok {
+		probe(ctx, &results, "group-owners", func(ctx context.Context) <-chan client.AzureResult[struct{}] {
+			return dropResults(ctx, azClient.ListAzureADGroupOwners(ctx, group, graphTop))
+		})
+		probe(ctx, &results, "group-members", func(ctx context.Context) <-chan client.AzureResult[struct{}] {
+			return dropResults(ctx, azClient.ListAzureADGroupMembers(ctx, group, graphTop))
+		})
+	} else {
+		results.skip("groups", "group-owners", "group-members")
+	}
+	if servicePrincipal, ok := probe(ctx, &results, "service-principals", func(ctx context.Context) <-chan client.AzureResult[string] {
+		return ids(ctx, azClient.ListAzureADServicePrincipals(ctx, graphTop), func(sp azure.ServicePrincipal) string { return sp.Id })
+	}); ok {
+		probe(ctx, &results, "service-principal-owners", func(ctx context.Context) <-chan client.AzureResult[struct{}] {
+			return dropResults(ctx, azClient.ListAzureADServicePrincipalOwners(ctx, servicePrincipal, graphTop))
+		})
+		probe(ctx, &results, "app-role-assignments", func(ctx context.Context) <-chan client.AzureResult[struct{}] {
+			return dropResults(ctx, azClient.ListAzureADAppRoleAssignments(ctx, servicePrincipal, graphTop))
+		})
+	} else {
+		results.skip("service-principals", "service-principal-owners", "app-role-assignments")
+	}
+	if role, ok := probe(ctx, &results, "roles", func(ctx context.Context) <-chan client.AzureResult[string] {
+		return ids(ctx, azClient.ListAzureADRoles(ctx, query.GraphParams{}), func(role azure.Role) string { return role.Id })
+	}); ok {
+		probe(ctx, &results, "role-assignments", func(ctx context.Context) <-chan client.AzureResult[struct{}] {
+			params := query.GraphParams{Filter: fmt.Sprintf("roleDefinitionId eq '%s'", role), Expand: "directoryScope", Top: 1}
+			return dropResults(ctx, azClient.ListAzureADRoleAssignments(ctx, params))
+		})
+	} else {
+		results.skip("roles", "role-assignments")
+	}
+
+	// Azure Resource Manager
+	if managementGroup, ok := probe(ctx, &results, "management-groups", func(ctx context.Context) <-chan client.AzureResult[azure.ManagementGroup] {
+		return azClient.ListAzureManagementGroups(ctx, "")
+	}); ok {
+		probe(ctx, &results, "management-group-descendants", func(ctx context.Context) <-chan client.AzureResult[struct{}] {
+			return dropResults(ctx, azClient.ListAzureManagementGroupDescendants(ctx, managementGroup.Name, 1))
+		})
+		probeRoleAssignments(ctx, &results, azClient, "management-group", managementGroup.Id, "atScope()")
+	} else {
+		results.skip("management-groups", roleAssignmentCollectors("management-group")...)
+		results.skip("management-groups", "management-group-descendants")
+	}
+
+	var (
+		subscriptionCollectors = []string{"resource-groups", "key-vaults", "virtual-machines", "function-apps", "web-apps", "automation-accounts", "container-registries", "logic-apps", "managed-clusters", "vm-scale-sets"}
+		resourceCollectors     = []string{"resource-group", "key-vault", "virtual-machine", "function-app", "web-app", "automation-account", "container-registry", "logic-app", "managed-cluster", "vm-scale-set"}
+
+		// collectors that read a property of another collector's objects share its outcome
+		derivedCollectors = map[string][]string{"key-vaults": {"key-vault-access-policies"}}
+	)
+	if subscription, ok := probe(ctx, &results, "subscriptions", func(ctx context.Context) <-chan client.AzureResult[azure.Subscription] {
+		return azClient.ListAzureSubscriptions(ctx)
+	}); !ok {
+		results.skip("subscriptions", roleAssignmentCollectors("subscription")...)
+		results.skip("subscriptions", subscriptionCollectors...)
+		for _, collector := range subscriptionCollectors {
+			results.skip("subscriptions", derivedCollectors[collector]...)
+		}
+		for _, collector := range resourceCollectors {
+			results.skip("subscriptions", roleAssignmentCollectors(collector)...)
+		}
+	} else {
+		probeRoleAssignments(ctx, &results, azClient, "subscription", subscription.Id, "atScope()")
+
+		id := subscription.SubscriptionId
+		lists := []func(ctx context.Context) <-chan client.AzureResult[string]{
+			func(ctx context.Context) <-chan client.AzureResult[string] {
+				return ids(ctx, azClient.ListAzureResourceGroups(ctx, id, query.RMParams{Top: 1}), func(v azure.ResourceGroup) string { return v.Id })
+			},
+			func(ctx context.Context) <-chan client.AzureResult[string] {
+				return ids(ctx, azClient.ListAzureKeyVaults(ctx, id, query.RMParams{Top: 1}), func(v azure.KeyVault) string { return v.Id })
+			},
+			func(ctx context.Context) <-chan client.AzureResult[string] {
+				return ids(ctx, azClient.ListAzureVirtualMachines(ctx, id, query.RMParams{}), func(v azure.VirtualMachine) string { return v.Id })
+			},
+			func(ctx context.Context) <-chan client.AzureResult[string] {
+				return ids(ctx, azClient.ListAzureFunctionApps(ctx, id), func(v azure.FunctionApp) string { return v.Id })
+			},
+			func(ctx context.Context) <-chan client.AzureResult[string] {
+				return ids(ctx, azClient.ListAzureWebApps(ctx, id), func(v azure.WebApp) string { return v.Id })
+			},
+			func(ctx context.Context) <-chan client.AzureResult[string] {
+				return ids(ctx, azClient.ListAzureAutomationAccounts(ctx, id), func(v azure.AutomationAccount) string { return v.Id })
+			},
+			func(ctx context.Context) <-chan client.AzureResult[string] {
+				return ids(ctx, azClient.ListAzureContainerRegistries(ctx, id), func(v azure.ContainerRegistry) string { return v.Id })
+			},
+			func(ctx context.Context) <-chan client.AzureResult[string] {
+				return ids(ctx, azClient.ListAzureLogicApps(ctx, id, "", 1), func(v azure.LogicApp) string { return v.Id })
+			},
+			func(ctx context.Context) <-chan client.AzureResult[string] {
+				return ids(ctx, azClient.ListAzureManagedClusters(ctx, id), func(v azure.ManagedCluster) string { return v.Id })
+			},
+			func(ctx context.Context) <-chan client.AzureResult[string] {
+				return ids(ctx, azClient.ListAzureVMScaleSets(ctx, id), func(v azure.VMScaleSet) string { return v.Id })
+			},
+		}
+
+		for i, list := range lists {
+			resource, ok := probe(ctx, &results, subscriptionCollectors[i], list)
+			results.derive(derivedCollectors[subscriptionCollectors[i]]...)
+			if ok {
+				probeRoleAssignments(ctx, &results, azClient, resourceCollectors[i], resource, "")
+			} else {
+				results.skip(subscriptionCollectors[i], roleAssignmentCollectors(resourceCollectors[i])...)
+			}
+		}
+	}
+
+	return results
+}
+
+// probe records the outcome of requesting the first object from a collector and returns that object, if any
+func probe[T any](ctx context.Context, results *preflightResults, collector string, list func(ctx context.Context) <-chan client.AzureResult[T]) (T, bool) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var zero T
+	for item := range list(ctx) {
+		if item.Error != nil {
+			results.add(collector, preflightStatus(item.Error), item.Error.Error())
+			return zero, false
+		} else {
+			results.add(collector, preflightOk, "")
+			return item.Ok, true
+		}
+	}
+	results.add(collector, preflightEmpty, "")
+	return zero, false
+}
+
+// probeRoleAssignments probes the role assignments of a resource. The owner, contributor and user access admin
+// collectors are derived from the same role assignments and share their outcome.
+func probeRoleAssignments(ctx context.Context, results *preflightResults, azClient client.AzureClient, resource, resourceId, filter string) {
+	collectors := roleAssignmentCollectors(resource)
+	probe(ctx, results, collectors[0], func(ctx context.Context) <-chan client.AzureResult[struct{}] {
+		return dropResults(ctx, azClient.ListRoleAssignmentsForResource(ctx, resourceId, filter, ""))
+	})
+	results.derive(collectors[1:]...)
+}
+
+func roleAssignmentCollectors(resource string) []string {
+	collectors := []string{resource + "-role-assignments"}
+	switch resource {
+	case "management-group", "subscription", "resource-group":
+		collectors = append(collectors, resource+"-owners", resource+"-user-access-admins")
+	case "key-vault":
+		collectors = append(collectors, "key-vault-owners", "key-vault-user-access-admins", "key-vault-contributors", "key-vault-kvcontributors")
+	case "virtual-machine":
+		collectors = append(collectors, "virtual-machine-owners", "virtual-machine-user-access-admins", "virtual-machine-contributors", "virtual-machine-avere-contributors", "virtual-machine-admin-logins")
+	}
+	return collectors
+}
+
+func preflightStatus(err error) string {
+	var errRes *rest.ErrorResponse
+	if errors.As(err, &errRes) && (errRes.StatusCode == http.StatusUnauthorized || errRes.StatusCode == http.StatusForbidden) {
+		return preflightForbidden
+	} else {
+		return preflightError
+	}
+}
+
+// ids maps a stream of objects to their IDs
+func ids[T any](ctx context.Context, in <-chan client.AzureResult[T], id func(T) string) <-chan client.AzureResult[string] {
+	return mapResults(ctx, in, id)
+}
+
+// dropResults drops the objects of a stream, keeping only whether each result succeeded
+func dropResults[T any](ctx context.Context, in <-chan client.AzureResult[T]) <-chan client.AzureResult[struct{}] {
+	return mapResults(ctx, in, func(T) struct{} { return struct{}{} })
+}
+
+func mapResults[T, U any](ctx context.Context, in <-chan client.AzureResult[T], fn func(T) U) <-chan client.AzureResult[U] {
+	out := make(chan client.AzureResult[U])
+	go func() {
+		defer panicrecovery.PanicRecovery()
+		defer close(out)
+		for item := range in {
+			result := client.AzureResult[U]{Error: item.Error}
+			if item.Error == nil {
+				result.Ok = fn(item.Ok)
+			}
+			if ok := pipeline.Send(ctx.Done(), out, result); !ok {
+				return
+			}
+		}
+	}()
+	return out
+}
+
+func (s *preflightResults) add(collector, status, detail string) {
+	*s = append(*s, preflightResult{collector, status, detail})
+}
+
+// derive records the outcome of the last probe for the collectors derived from the same objects
+func (s *preflightResults) derive(collectors ...string) {
+	derived := (*s)[len(*s)-1]
+	for _, collector := range collectors {
+		s.add(collector, derived.Status, derived.Detail)
+	}
+}
+
+func (s *preflightResults) skip(parent string, collectors ...string) {
+	for _, collector := range collectors {
+		s.add(collector, preflightSkipped, fmt.Sprintf("no %s found to probe", parent))
+	}
+}
+
+func (s preflightResults) count(status string) int {
+	count := 0
+	for _, result := range s {
+		if result.Status == status {
+			count++
+		}
+	}
+	return count
+}
+
+func (s preflightResults) print(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "COLLECTOR\tSTATUS\tDETAIL")
+	for _, result := range s {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", result.Collector, result.Status, result.Detail)
+	}
+	tw.Flush()
+
+	fmt.Fprintf(w, "\n%d ok, %d empty, %d forbidden, %d error, %d skipped\n",
+		s.count(preflightOk), s.count(preflightEmpty), s.count(preflightForbidden), s.count(preflightError), s.count(preflightSkipped))
+}
@@ -0,0 +1,153 @@
+// Copyright (C) 2024 Specter Ops, Inc.
+//
+// This file is part of AzureHound.
+//
+// AzureHound is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// AzureHound is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/bloodhoundad/azurehound/v2/client"
+	"github.com/bloodhoundad/azurehound/v2/client/mocks"
+	"github.com/bloodhoundad/azurehound/v2/client/rest"
+	"github.com/bloodhoundad/azurehound/v2/config"
+	"github.com/bloodhoundad/azurehound/v2/internal/fakeazure"
+	"github.com/bloodhoundad/azurehound/v2/models/azure"
+	"go.uber.org/mock/gomock"
+)
+
+func TestPreflightProbe(t *testing.T) {
+	var (
+		ctx     = context.Background()
+		results preflightResults
+	)
+
+	stream := func(items ...client.AzureResult[string]) func(ctx context.Context) <-chan client.AzureResult[string] {
+		return func(ctx context.Context) <-chan client.AzureResult[string] {
+			out := make(chan client.AzureResult[string])
+			go func() {
+				defer close(out)
+				for _, item := range items {
+					select {
+					case out <- item:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}()
+			return out
+		}
+	}
+
+	if id, ok := probe(ctx, &results, "ok", stream(client.AzureResult[string]{Ok: "foo"}, client.AzureResult[string]{Ok: "bar"})); !ok || id != "foo" {
+		t.Errorf("got %v, %v, want foo, true", id, ok)
+	}
+	if _, ok := probe(ctx, &results, "empty", stream()); ok {
+		t.Error("expected empty collector to return false")
+	}
+	forbidden := &rest.ErrorResponse{StatusCode: http.StatusForbidden, Body: map[string]interface{}{"error": "Authorization_RequestDenied"}}
+	if _, ok := probe(ctx, &results, "forbidden", stream(client.AzureResult[string]{Error: fmt.Errorf("wrapped: %w", forbidden)})); ok {
+		t.Error("expected forbidden collector to return false")
+	}
+	if _, ok := probe(ctx, &results, "error", stream(client.AzureResult[string]{Error: fmt.Errorf("I'm an error")})); ok {
+		t.Error("expected failed collector to return false")
+	}
+
+	want := []string{preflightOk, preflightEmpty, preflightForbidden, preflightError}
+	if len(results) != len(want) {
+		t.Fatalf("got %d results, want %d", len(results), len(want))
+	}
+	for i, result := range results {
+		if result.Status != want[i] {
+			t.Errorf("%s: got %s, want %s", result.Collector, result.Status, want[i])
+		}
+	}
+}
+
+func TestPreflightRoleAssignments(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var (
+		ctx         = context.Background()
+		results     preflightResults
+		mockClient  = mocks.NewMockAzureClient(ctrl)
+		mockChannel = make(chan client.AzureResult[azure.RoleAssignment])
+	)
+
+	mockClient.EXPECT().ListRoleAssignmentsForResource(gomock.Any(), "foo", "", "").Return(mockChannel)
+	close(mockChannel)
+
+	probeRoleAssignments(ctx, &results, mockClient, "key-vault", "foo", "")
+
+	if len(results) != 5 {
+		t.Fatalf("got %d results, want 5", len(results))
+	}
+	for _, result := range results {
+		if result.Status != preflightEmpty {
+			t.Errorf("%s: got %s, want %s", result.Collector, result.Status, preflightEmpty)
+		}
+	}
+}
+
+func TestPreflight(t *testing.T) {
+	server := fakeazure.NewServer(fakeazure.NewFixtures(1))
+	defer server.Close()
+
+	server.Inject(fakeazure.Fault{Path: "/providers/Microsoft.KeyVault/vaults", Status: http.StatusForbidden, Count: 1})
+
+	azClient, err := client.NewClient(server.ClientConfig())
+	if err != nil {
+		t.Fatalf("error initializing azure client: %v", err)
+	}
+	defer azClient.CloseIdleConnections()
+
+	statuses := map[string]string{}
+	for _, result := range preflight(context.Background(), azClient) {
+		statuses[result.Collector] = result.Status
+	}
+
+	if status := statuses["users"]; status != preflightOk {
+		t.Errorf("users: got %s, want %s", status, preflightOk)
+	}
+	// key vault access policies are read from the key vaults themselves
+	for _, collector := range []string{"key-vaults", "key-vault-access-policies"} {
+		if status := statuses[collector]; status != preflightForbidden {
+			t.Errorf("%s: got %s, want %s", collector, status, preflightForbidden)
+		}
+	}
+}
+
+func TestCheckSingleTenant(t *testing.T) {
+	if err := checkSingleTenant(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	config.AzTenants.Set([]string{"contoso.onmicrosoft.com"})
+	if err := checkSingleTenant(); err == nil {
+		t.Errorf("expected an error with --%s", config.AzTenants.Name)
+	}
+	config.AzTenants.Set([]string{})
+
+	config.AzAllTenants.Set(true)
+	if err := checkSingleTenant(); err == nil {
+		t.Errorf("expected an error with --%s", config.AzAllTenants.Name)
+	}
+	config.AzAllTenants.Set(false)
+}
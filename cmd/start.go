@@ -40,9 +40,11 @@ import (
 	"github.com/bloodhoundad/azurehound/v2/client/rest"
 	"github.com/bloodhoundad/azurehound/v2/config"
 	"github.com/bloodhoundad/azurehound/v2/constants"
+	"github.com/bloodhoundad/azurehound/v2/metrics"
 	"github.com/bloodhoundad/azurehound/v2/models"
 	"github.com/bloodhoundad/azurehound/v2/panicrecovery"
 	"github.com/bloodhoundad/azurehound/v2/pipeline"
+	"github.com/bloodhoundad/azurehound/v2/tracing"
 )
 
 const (
@@ -51,6 +53,9 @@ const (
 
 var ErrExceededRetryLimit = errors.New("exceeded max retry limit for ingest batch, proceeding with next batch...")
 
+// bheRetryPolicy decides which requests to BloodHound Enterprise are retried and when
+var bheRetryPolicy rest.RetryPolicy
+
 func init() {
 	configs := append(config.AzureConfig, config.BloodHoundEnterpriseConfig...)
 	configs = append(configs, config.CollectionConfig...)
@@ -80,11 +85,27 @@ func start(ctx context.Context) {
 			fmt.Println(string(stacktrace[:length]))
 		}
 	}()
+	defer shutdownTracing()
 	defer gracefulShutdown(stop)
 
+	if err := checkDeltaState("start"); err != nil {
+		exit(err)
+	} else if retryPolicy, err := rest.NewRetryPolicy(); err != nil {
+		exit(fmt.Errorf("invalid retry policy: %w", err))
+	} else {
+		bheRetryPolicy = retryPolicy
+	}
+
+	var connected atomic.Bool
+	if addr, ok := config.MetricsAddress.Value().(string); ok && addr != "" {
+		if err := serveMetrics(ctx, addr, connected.Load); err != nil {
+			exit(fmt.Errorf("unable to serve metrics: %w", err))
+		}
+	}
+
 	log.V(1).Info("testing connections")
-	if azClient := connectAndCreateClient(); azClient == nil {
-		exit(fmt.Errorf("azClient is unexpectedly nil"))
+	if azClients := connectAndCreateClients(ctx); len(azClients) == 0 {
+		exit(fmt.Errorf("azClients is unexpectedly empty"))
 	} else if bheInstance, err := url.Parse(config.BHEUrl.Value().(string)); err != nil {
 		exit(fmt.Errorf("unable to parse BHE url: %w", err))
 	} else if bheClient, err := newSigningHttpClient(BHEAuthSignature, config.BHETokenId.Value().(string), config.BHEToken.Value().(string), config.Proxy.Value().(string)); err != nil {
@@ -95,6 +116,8 @@ func start(ctx context.Context) {
 		exit(fmt.Errorf("failed to end orphaned job: %w", err))
 	} else {
 		log.Info("connected successfully! waiting for jobs...")
+		metrics.Checkin()
+		connected.Store(true)
 		ticker := time.NewTicker(5 * time.Second)
 		defer ticker.Stop()
 
@@ -116,7 +139,9 @@ func start(ctx context.Context) {
 						defer panicrecovery.PanicRecovery()
 						defer jobQueued.Unlock()
 						defer bheClient.CloseIdleConnections()
-						defer azClient.CloseIdleConnections()
+						for _, azClient := range azClients {
+							defer azClient.CloseIdleConnections()
+						}
 
 						ctx, stop := context.WithCancel(ctx)
 						panicrecovery.HandleBubbledPanic(ctx, stop, log)
@@ -143,8 +168,10 @@ func start(ctx context.Context) {
 								log.V(2).Info("there are no jobs for azurehound to complete at this time")
 							} else {
 								defer currentJobID.Store(0)
+								defer metrics.SetJob(0)
 								queuedJobID := executableJobs[0].ID
 								currentJobID.Store(int64(queuedJobID))
+								metrics.SetJob(int64(queuedJobID))
 								// Notify BHE instance of job start
 								if err := startJob(ctx, *bheInstance, bheClient, queuedJobID); err != nil {
 									log.Error(err, "failed to start job, will retry on next heartbeat")
@@ -152,18 +179,36 @@ func start(ctx context.Context) {
 								}
 
 								start := time.Now()
-
-								// Batch data out for ingestion
-								stream := listAll(ctx, azClient)
-								batches := pipeline.Batch(ctx.Done(), stream, config.ColBatchSize.Value().(int), 10*time.Second)
-								hasIngestErr := ingest(ctx, *bheInstance, bheClient, batches)
+								metrics.Reset()
+
+								ctx, span := tracing.Start(ctx, "job", tracing.SpanKindInternal, tracing.Int("azurehound.job.id", queuedJobID))
+								defer span.End()
+
+								// Batch data out for ingestion; tenants are collected one after another so no batch spans tenants
+								hasIngestErr := false
+								for _, azClient := range azClients {
+									log.V(1).Info("collecting tenant", "tenantId", azClient.TenantInfo().TenantId)
+									stream := listAll(ctx, azClient)
+									batches := pipeline.Batch(ctx.Done(), stream, config.ColBatchSize.Value().(int), 10*time.Second)
+									if ingest(ctx, *bheInstance, bheClient, batches) {
+										hasIngestErr = true
+										// keep the previous delta links so the changes that failed to ingest are collected again
+										if state := getDeltaState(); state != nil {
+											state.discard()
+										}
+									} else {
+										commitDeltaState(ctx)
+									}
+								}
 
 								// Notify BHE instance of job end
 								duration := time.Since(start)
+								reportStats()
 
 								message := "Collection completed successfully"
 								if hasIngestErr {
 									message = "Collection completed with errors during ingest"
+									span.SetStatus(tracing.StatusError, message)
 								}
 								if err := endJob(ctx, *bheInstance, bheClient, models.JobStatusComplete, message); err != nil {
 									log.Error(err, "failed to end job")
@@ -186,7 +231,6 @@ func ingest(ctx context.Context, bheUrl url.URL, bheClient *http.Client, in <-ch
 
 	var (
 		hasErrors           = false
-		maxRetries          = 3
 		unrecoverableErrMsg = fmt.Sprintf("ending current ingest job due to unrecoverable error while requesting %v", endpoint)
 	)
 
@@ -209,58 +253,67 @@ func ingest(ctx context.Context, bheUrl url.URL, bheClient *http.Client, in <-ch
 		}
 		gw.Close()
 
-		if req, err := http.NewRequestWithContext(ctx, "POST", endpoint.String(), &body); err != nil {
+		batchCtx, span := tracing.Start(ctx, "ingest", tracing.SpanKindClient,
+			tracing.String("http.request.method", "POST"),
+			tracing.String("server.address", endpoint.Host),
+			tracing.String("url.path", endpoint.Path),
+			tracing.Int("azurehound.batch.size", len(data)),
+		)
+		if req, err := http.NewRequestWithContext(batchCtx, "POST", endpoint.String(), &body); err != nil {
 			log.Error(err, unrecoverableErrMsg)
+			metrics.IngestBatch(true)
+			span.SetError(err)
+			span.End()
 			return true
 		} else {
 			req.Header.Set("User-Agent", constants.UserAgent())
 			req.Header.Set("Accept", "application/json")
 			req.Header.Set("Content-Encoding", "gzip")
-			for retry := 0; retry < maxRetries; retry++ {
-				// No retries on regular err cases, only on HTTP 504 Gateway Timeout and HTTP 503 Service Unavailable
-				if response, err := bheClient.Do(req); err != nil {
-					if rest.IsClosedConnectionErr(err) {
-						// try again on force closed connection
-						log.Error(err, fmt.Sprintf("remote host force closed connection while requesting %s; attempt %d/%d; trying again", req.URL, retry+1, maxRetries))
-						rest.ExponentialBackoff(retry)
-
-						if retry == maxRetries-1 {
-							log.Error(ErrExceededRetryLimit, "")
-							hasErrors = true
-						}
-
-						continue
-					}
-					log.Error(err, unrecoverableErrMsg)
-					return true
-				} else if response.StatusCode == http.StatusGatewayTimeout || response.StatusCode == http.StatusServiceUnavailable || response.StatusCode == http.StatusBadGateway {
-					serverError := fmt.Errorf("received server error %d while requesting %v; attempt %d/%d; trying again", response.StatusCode, endpoint, retry+1, maxRetries)
-					log.Error(serverError, "")
-
-					rest.ExponentialBackoff(retry)
+			response, attempts, err := rest.SendWithRetry(bheClient, req, bheRetryPolicy)
+			span.SetAttributes(tracing.Int("azurehound.attempts", attempts))
+			if response != nil {
+				span.SetAttributes(tracing.Int("http.response.status_code", response.StatusCode))
+			}
 
-					if retry == maxRetries-1 {
-						log.Error(ErrExceededRetryLimit, "")
-						hasErrors = true
-					}
-					if err := response.Body.Close(); err != nil {
-						log.Error(fmt.Errorf("failed to close ingest body: %w", err), unrecoverableErrMsg)
-					}
+			if err != nil {
+				span.SetError(err)
+				span.End()
+				if rest.IsClosedConnectionErr(err) {
+					// the remote host kept closing the connection; give up on this batch
+					log.Error(err, fmt.Sprintf("remote host force closed connection while requesting %s; attempts %d", req.URL, attempts))
+					log.Error(ErrExceededRetryLimit, "")
+					metrics.IngestBatch(true)
+					hasErrors = true
 					continue
-				} else if response.StatusCode != http.StatusAccepted {
-					if bodyBytes, err := io.ReadAll(response.Body); err != nil {
-						log.Error(fmt.Errorf("received unexpected response code from %v: %s; failure reading response body", endpoint, response.Status), unrecoverableErrMsg)
-					} else {
-						log.Error(fmt.Errorf("received unexpected response code from %v: %s %s", req.URL, response.Status, bodyBytes), unrecoverableErrMsg)
-					}
-					if err := response.Body.Close(); err != nil {
-						log.Error(fmt.Errorf("failed to close ingest body: %w", err), unrecoverableErrMsg)
-					}
-					return true
+				}
+				log.Error(err, unrecoverableErrMsg)
+				metrics.IngestBatch(true)
+				return true
+			} else if response.StatusCode != http.StatusAccepted {
+				span.SetStatus(tracing.StatusError, response.Status)
+				span.End()
+				if bodyBytes, err := io.ReadAll(response.Body); err != nil {
+					log.Error(fmt.Errorf("received unexpected response code from %v: %s; failure reading response body", endpoint, response.Status), unrecoverableErrMsg)
 				} else {
-					if err := response.Body.Close(); err != nil {
-						log.Error(fmt.Errorf("failed to close ingest body: %w", err), unrecoverableErrMsg)
-					}
+					log.Error(fmt.Errorf("received unexpected response code from %v: %s %s", req.URL, response.Status, bodyBytes), unrecoverableErrMsg)
+				}
+				if err := response.Body.Close(); err != nil {
+					log.Error(fmt.Errorf("failed to close ingest body: %w", err), unrecoverableErrMsg)
+				}
+				if attempts > 1 {
+					// the status was retried until the retry policy gave up; proceed with the next batch
+					log.Error(ErrExceededRetryLimit, "")
+					metrics.IngestBatch(true)
+					hasErrors = true
+					continue
+				}
+				metrics.IngestBatch(true)
+				return true
+			} else {
+				metrics.IngestBatch(false)
+				span.End()
+				if err := response.Body.Close(); err != nil {
+					log.Error(fmt.Errorf("failed to close ingest body: %w", err), unrecoverableErrMsg)
 				}
 			}
 		}
@@ -270,55 +323,19 @@ func ingest(ctx context.Context, bheUrl url.URL, bheClient *http.Client, in <-ch
 
 // TODO: create/use a proper bloodhound client
 func do(bheClient *http.Client, req *http.Request) (*http.Response, error) {
-	var (
-		res        *http.Response
-		maxRetries = 3
-	)
-
-	// copy the bytes in case we need to retry the request
-	if body, err := rest.CopyBody(req); err != nil {
-		return nil, err
-	} else {
-		for retry := 0; retry < maxRetries; retry++ {
-			// Reusing http.Request requires rewinding the request body
-			// back to a working state
-			if body != nil && retry > 0 {
-				req.Body = io.NopCloser(bytes.NewBuffer(body))
-			}
-
-			if res, err = bheClient.Do(req); err != nil {
-				if rest.IsClosedConnectionErr(err) {
-					// try again on force closed connections
-					log.Error(err, fmt.Sprintf("remote host force closed connection while requesting %s; attempt %d/%d; trying again", req.URL, retry+1, maxRetries))
-					rest.ExponentialBackoff(retry)
-					continue
-				}
-				// normal client error, dont attempt again
-				return nil, err
-			} else if res.StatusCode < http.StatusOK || res.StatusCode >= http.StatusBadRequest {
-				if res.StatusCode >= http.StatusInternalServerError {
-					// Internal server error, backoff and try again.
-					serverError := fmt.Errorf("received server error %d while requesting %v", res.StatusCode, req.URL)
-					log.Error(serverError, fmt.Sprintf("attempt %d/%d; trying again", retry+1, maxRetries))
-
-					rest.ExponentialBackoff(retry)
-					continue
-				}
-				// bad request we do not need to retry
-				var body json.RawMessage
-				defer res.Body.Close()
-				if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
-					return nil, fmt.Errorf("received unexpected response code from %v: %s; failure reading response body", req.URL, res.Status)
-				} else {
-					return nil, fmt.Errorf("received unexpected response code from %v: %s %s", req.URL, res.Status, body)
-				}
-			} else {
-				return res, nil
-			}
+	if res, attempts, err := rest.SendWithRetry(bheClient, req, bheRetryPolicy); err != nil {
+		return nil, fmt.Errorf("unable to complete request to url=%s; attempts=%d: %w", req.URL, attempts, err)
+	} else if res.StatusCode < http.StatusOK || res.StatusCode >= http.StatusBadRequest {
+		var body json.RawMessage
+		defer res.Body.Close()
+		if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+			return nil, fmt.Errorf("received unexpected response code from %v: %s; failure reading response body; attempts=%d", req.URL, res.Status, attempts)
+		} else {
+			return nil, fmt.Errorf("received unexpected response code from %v: %s %s; attempts=%d", req.URL, res.Status, body, attempts)
 		}
+	} else {
+		return res, nil
 	}
-
-	return nil, fmt.Errorf("unable to complete request to url=%s; attempts=%d;", req.URL, maxRetries)
 }
 
 type basicResponse[T any] struct {
@@ -337,6 +354,7 @@ func getAvailableJobs(ctx context.Context, bheUrl url.URL, bheClient *http.Clien
 		return nil, err
 	} else {
 		defer res.Body.Close()
+		metrics.Checkin()
 		if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
 			return nil, err
 		} else {
@@ -354,6 +372,7 @@ func checkin(ctx context.Context, bheUrl url.URL, bheClient *http.Client) error
 		return err
 	} else {
 		res.Body.Close()
+		metrics.Checkin()
 		return nil
 	}
 }
@@ -0,0 +1,103 @@
+// Copyright (C) 2024 Specter Ops, Inc.
+//
+// This file is part of AzureHound.
+//
+// AzureHound is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// AzureHound is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/bloodhoundad/azurehound/v2/config"
+	"github.com/bloodhoundad/azurehound/v2/enums"
+	"github.com/bloodhoundad/azurehound/v2/metrics"
+	"github.com/bloodhoundad/azurehound/v2/panicrecovery"
+	"github.com/bloodhoundad/azurehound/v2/pipeline"
+	"github.com/bloodhoundad/azurehound/v2/tracing"
+)
+
+// kinded is implemented by the wrappers of collected objects
+type kinded interface {
+	wrappedKind() enums.Kind
+}
+
+func (s AzureWrapper) wrappedKind() enums.Kind {
+	return s.Kind
+}
+
+func (s azureWrapper[T]) wrappedKind() enums.Kind {
+	return s.Kind
+}
+
+// stage runs the list stage with statistics and a span of its own, recording how long it runs, the requests it makes
+// and the objects it emits by kind
+func stage[T any](ctx context.Context, name string, list func(ctx context.Context) <-chan T) <-chan T {
+	var (
+		stageCtx, stats = metrics.WithStage(ctx, name)
+		spanCtx, span   = tracing.Start(stageCtx, "list "+name, tracing.SpanKindInternal, tracing.String("azurehound.stage", name))
+		stream          = list(spanCtx)
+		out             = make(chan T)
+	)
+
+	go func() {
+		defer panicrecovery.PanicRecovery()
+		defer close(out)
+		defer span.End()
+		defer stats.Done()
+
+		var objects int64
+		defer func() { span.SetAttributes(tracing.Int64("azurehound.objects", objects)) }()
+
+		for item := range pipeline.OrDone(ctx.Done(), stream) {
+			objects++
+			if wrapper, ok := any(item).(kinded); ok {
+				stats.Emit(wrapper.wrappedKind())
+			}
+			if ok := pipeline.Send(ctx.Done(), out, item); !ok {
+				return
+			}
+		}
+	}()
+
+	return out
+}
+
+// reportStats prints a summary of the current run to stderr, also writing it as JSON when asked to
+func reportStats() {
+	summary := metrics.Current().Summary()
+
+	fmt.Fprintln(os.Stderr)
+	if err := summary.WriteTable(os.Stderr); err != nil {
+		log.Error(err, "unable to print collection statistics")
+	}
+
+	if path, ok := config.StatsFile.Value().(string); ok && path != "" {
+		if err := summary.WriteFile(path); err != nil {
+			log.Error(err, "unable to write collection statistics", "path", path)
+		} else {
+			log.V(1).Info("wrote collection statistics", "path", path)
+		}
+	}
+}
+
+func persistentPostRun(cmd *cobra.Command, args []string) {
+	reportStats()
+	tracing.SpanFromContext(cmd.Context()).End()
+	shutdownTracing()
+}
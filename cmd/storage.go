@@ -0,0 +1,118 @@
+// Copyright (C) 2024 Specter Ops, Inc.
+//
+// This file is part of AzureHound.
+//
+// AzureHound is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// AzureHound is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/bloodhoundad/azurehound/v2/client/rest"
+	"github.com/bloodhoundad/azurehound/v2/config"
+	"github.com/bloodhoundad/azurehound/v2/sinks"
+)
+
+// storageResource is the audience of access tokens for Azure Storage in every cloud
+var storageResource = url.URL{Scheme: "https", Host: "storage.azure.com"}
+
+// outputStreamToStorage uploads the stream to an object of the object storage given by --storage, named for the tenant
+// and the time at which the upload began. Blob Storage without a SAS token is accessed with a token from the broker.
+func outputStreamToStorage[T any](ctx context.Context, stream <-chan T, broker *rest.TokenBroker, tenantId string) {
+	if path := config.OutputFile.Value().(string); path != "" {
+		exit(fmt.Errorf("only one of --%s and --%s may be given", config.Storage.Name, config.OutputFile.Name))
+	} else if upload := config.UploadUrl.Value().(string); upload != "" {
+		exit(fmt.Errorf("only one of --%s and --%s may be given", config.Storage.Name, config.UploadUrl.Name))
+	} else if splitOptions().Enabled() {
+		exit(fmt.Errorf("split output is not supported with --%s", config.Storage.Name))
+	}
+
+	var (
+		format      = outputFormat()
+		compression = outputCompression("")
+		name        = storageObjectName(tenantId, format, compression, time.Now())
+	)
+
+	if storage, err := newStorage(config.Storage.Value().(string), broker); err != nil {
+		exit(fmt.Errorf("failed to create object storage client: %w", err))
+	} else {
+		log.Info("uploading to object storage", "name", name)
+		if err := sinks.WriteToStorage(ctx, storage, name, format, compression, stream); err != nil {
+			exit(fmt.Errorf("failed to upload stream: %w", err))
+		}
+		log.Info("uploaded to object storage", "name", name)
+	}
+	commitDeltaState(ctx)
+}
+
+// storageObjectName returns the name of the object to which the collection of the tenant begun at the time is uploaded,
+// e.g. azurehound-<tenantId>-20240102T150405Z.json.gz
+func storageObjectName(tenantId string, format sinks.Format, compression sinks.Compression, now time.Time) string {
+	name := fmt.Sprintf("azurehound-%s-%s.%s", tenantId, now.UTC().Format("20060102T150405Z"), format)
+	switch compression {
+	case sinks.CompressionGzip:
+		return name + ".gz"
+	case sinks.CompressionZstd:
+		return name + ".zst"
+	default:
+		return name
+	}
+}
+
+// newStorage returns the S3 bucket of an s3:// URL or otherwise the Azure Blob Storage container of the URL, accessed
+// with the URL's SAS token or else with a token acquired from the broker
+func newStorage(storageUrl string, broker *rest.TokenBroker) (sinks.Storage, error) {
+	if parsed, err := url.Parse(storageUrl); err != nil {
+		return nil, err
+	} else if httpClient, err := rest.NewHTTPClient(config.Proxy.Value().(string)); err != nil {
+		return nil, err
+	} else if retry, err := rest.NewRetryPolicy(); err != nil {
+		return nil, err
+	} else if parsed.Scheme == "s3" {
+		region := config.StorageRegion.Value().(string)
+		if region == "" {
+			region = os.Getenv(config.AwsRegionEnv)
+		}
+		if region == "" {
+			region = "us-east-1"
+		}
+
+		return sinks.NewS3Storage(httpClient, retry, sinks.S3Options{
+			Bucket:          parsed.Host,
+			Prefix:          parsed.Path,
+			Region:          region,
+			Endpoint:        config.StorageEndpoint.Value().(string),
+			AccessKeyId:     os.Getenv(config.AwsAccessKeyIdEnv),
+			SecretAccessKey: os.Getenv(config.AwsSecretAccessKeyEnv),
+			SessionToken:    os.Getenv(config.AwsSessionTokenEnv),
+		})
+	} else if parsed.Scheme != "https" && parsed.Scheme != "http" {
+		return nil, fmt.Errorf("unsupported object storage %s: must be s3:// or an Azure Blob Storage URL", parsed.Redacted())
+	} else if parsed.Query().Get("sig") != "" {
+		return sinks.NewBlobStorage(httpClient, retry, *parsed, nil)
+	} else {
+		return sinks.NewBlobStorage(httpClient, retry, *parsed, func() (string, error) {
+			if token, err := broker.Token(storageResource); err != nil {
+				return "", err
+			} else {
+				return token.String(), nil
+			}
+		})
+	}
+}
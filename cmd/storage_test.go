@@ -0,0 +1,142 @@
+// Copyright (C) 2024 Specter Ops, Inc.
+//
+// This file is part of AzureHound.
+//
+// AzureHound is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// AzureHound is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	client_config "github.com/bloodhoundad/azurehound/v2/client/config"
+	"github.com/bloodhoundad/azurehound/v2/client/mocks"
+	"github.com/bloodhoundad/azurehound/v2/client/rest"
+	"github.com/bloodhoundad/azurehound/v2/config"
+	"github.com/bloodhoundad/azurehound/v2/models/azure"
+	"github.com/bloodhoundad/azurehound/v2/sinks"
+	"go.uber.org/mock/gomock"
+)
+
+func TestStorageObjectName(t *testing.T) {
+	var (
+		tenantId = "6c12b0b0-b2cc-4a73-8252-0b94bfca2145"
+		now      = time.Date(2024, 1, 2, 15, 4, 5, 0, time.FixedZone("EST", -5*60*60))
+	)
+
+	if name := storageObjectName(tenantId, sinks.FormatJSON, sinks.CompressionNone, now); name != "azurehound-"+tenantId+"-20240102T200405Z.json" {
+		t.Errorf("got name %s", name)
+	}
+	if name := storageObjectName(tenantId, sinks.FormatNDJSON, sinks.CompressionGzip, now); name != "azurehound-"+tenantId+"-20240102T200405Z.ndjson.gz" {
+		t.Errorf("got name %s", name)
+	}
+	if name := storageObjectName(tenantId, sinks.FormatJSON, sinks.CompressionZstd, now); name != "azurehound-"+tenantId+"-20240102T200405Z.json.zst" {
+		t.Errorf("got name %s", name)
+	}
+}
+
+func TestNewStorageBroker(t *testing.T) {
+	var (
+		mutex  sync.Mutex
+		scopes []string
+		blobs  []string
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if strings.HasSuffix(r.URL.Path, "/oauth2/v2.0/token") {
+			r.ParseForm()
+			scopes = append(scopes, r.Form.Get("scope"))
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"access_token":"storage-token","expires_in":3600}`))
+		} else if r.Header.Get("Authorization") != "Bearer storage-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+		} else {
+			blobs = append(blobs, r.URL.Path)
+			w.WriteHeader(http.StatusCreated)
+		}
+	}))
+	defer server.Close()
+
+	broker, err := rest.NewTokenBroker(client_config.Config{
+		Authority:     server.URL,
+		Tenant:        "tenant",
+		ApplicationId: "app",
+		ClientSecret:  "secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a container without a SAS token is accessed with a storage token from the client's broker
+	stream := make(chan string, 1)
+	stream <- `{"kind":"AZUser","data":{"id":"1"}}`
+	close(stream)
+	if storage, err := newStorage(server.URL+"/devstoreaccount1/container", broker); err != nil {
+		t.Fatal(err)
+	} else if err := sinks.WriteToStorage(context.Background(), storage, "output.json", sinks.FormatJSON, sinks.CompressionNone, stream); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(scopes) != 1 || !strings.HasPrefix(scopes[0], "https://storage.azure.com/") {
+		t.Errorf("got token requests for %v, want one for Azure Storage", scopes)
+	}
+	if len(blobs) == 0 {
+		t.Error("got no blobs uploaded")
+	}
+}
+
+func TestOutputStreamToStorageTenant(t *testing.T) {
+	var (
+		mutex sync.Mutex
+		blobs []string
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		blobs = append(blobs, r.URL.Path)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockClient := mocks.NewMockAzureClient(ctrl)
+	mockClient.EXPECT().TenantInfo().Return(azure.Tenant{TenantId: "6c12b0b0-b2cc-4a73-8252-0b94bfca2145"}).AnyTimes()
+	mockClient.EXPECT().TokenBroker().Return(nil).AnyTimes()
+
+	// the tenant may be given by domain, but objects are named for its ID
+	config.AzTenant.Set("contoso.onmicrosoft.com")
+	config.Storage.Set(server.URL + "/devstoreaccount1/container?sv=2021-08-06&sp=cw&sig=signature")
+	defer config.AzTenant.Set("")
+	defer config.Storage.Set("")
+
+	stream := make(chan string, 1)
+	stream <- `{"kind":"AZUser","data":{"id":"1"}}`
+	close(stream)
+	outputStream(context.Background(), mockClient, stream)
+
+	if len(blobs) == 0 || !strings.HasPrefix(blobs[0], "/devstoreaccount1/container/azurehound-6c12b0b0-b2cc-4a73-8252-0b94bfca2145-") {
+		t.Errorf("got blobs %v, want them named for the tenant ID", blobs)
+	}
+}
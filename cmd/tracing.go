@@ -0,0 +1,75 @@
+// Copyright (C) 2024 Specter Ops, Inc.
+//
+// This file is part of AzureHound.
+//
+// AzureHound is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// AzureHound is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/bloodhoundad/azurehound/v2/config"
+	"github.com/bloodhoundad/azurehound/v2/tracing"
+)
+
+// setupTracing begins exporting traces when a collector or file is configured
+func setupTracing() error {
+	endpoint, _ := config.TraceEndpoint.Value().(string)
+	path, _ := config.TraceFile.Value().(string)
+
+	if endpoint != "" && path != "" {
+		return fmt.Errorf("only one of --%s and --%s may be given", config.TraceEndpoint.Name, config.TraceFile.Name)
+	} else if endpoint != "" {
+		if exporter, err := tracing.NewOTLPExporter(endpoint); err != nil {
+			return err
+		} else {
+			tracing.Init(exporter, log)
+			log.V(1).Info("exporting traces", "endpoint", endpoint)
+		}
+	} else if path != "" {
+		if exporter, err := tracing.NewFileExporter(path); err != nil {
+			return fmt.Errorf("unable to open trace file: %w", err)
+		} else {
+			tracing.Init(exporter, log)
+			log.V(1).Info("writing traces", "path", path)
+		}
+	}
+	return nil
+}
+
+// shutdownTracing exports the spans that have ended, giving up after a few seconds
+func shutdownTracing() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := tracing.Shutdown(ctx); err != nil {
+		log.Error(err, "unable to export remaining spans")
+	}
+}
+
+// listPersistentPreRunE starts a root span for the list command so that the spans of its stages and requests share a
+// trace
+func listPersistentPreRunE(cmd *cobra.Command, args []string) error {
+	if err := persistentPreRunE(cmd, args); err != nil {
+		return err
+	} else {
+		ctx, _ := tracing.Start(cmd.Context(), cmd.CommandPath(), tracing.SpanKindInternal)
+		cmd.SetContext(ctx)
+		return nil
+	}
+}
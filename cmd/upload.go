@@ -0,0 +1,162 @@
+// Copyright (C) 2024 Specter Ops, Inc.
+//
+// This file is part of AzureHound.
+//
+// AzureHound is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// AzureHound is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/bloodhoundad/azurehound/v2/client/rest"
+	"github.com/bloodhoundad/azurehound/v2/config"
+	"github.com/bloodhoundad/azurehound/v2/models"
+	"github.com/bloodhoundad/azurehound/v2/pipeline"
+	"github.com/bloodhoundad/azurehound/v2/tracing"
+)
+
+const (
+	// uploadBatchSize is the number of objects in each file uploaded to BloodHound CE unless --max-file-objects is given
+	uploadBatchSize = 10000
+
+	// uploadBatchTimeout is how long a partial batch waits for more objects before it is uploaded anyway
+	uploadBatchTimeout = 30 * time.Second
+)
+
+// outputStreamToBloodHound uploads the stream to the BloodHound CE instance given by --upload
+func outputStreamToBloodHound[T any](ctx context.Context, stream <-chan T) {
+	batchSize := uploadBatchSize
+	if maxObjects := config.MaxFileObjects.Value().(int); maxObjects > 0 {
+		batchSize = maxObjects
+	}
+
+	if tokenId, token := config.UploadTokenId.Value().(string), config.UploadToken.Value().(string); tokenId == "" || token == "" {
+		exit(fmt.Errorf("--%s requires --%s and --%s", config.UploadUrl.Name, config.UploadTokenId.Name, config.UploadToken.Name))
+	} else if err := checkDeltaState("--" + config.UploadUrl.Name); err != nil {
+		exit(err)
+	} else if bhUrl, err := url.Parse(config.UploadUrl.Value().(string)); err != nil {
+		exit(fmt.Errorf("unable to parse BloodHound url: %w", err))
+	} else if bhClient, err := newSigningHttpClient(BHEAuthSignature, tokenId, token, config.Proxy.Value().(string)); err != nil {
+		exit(fmt.Errorf("failed to create new signing HTTP client: %w", err))
+	} else if retryPolicy, err := rest.NewRetryPolicy(); err != nil {
+		exit(fmt.Errorf("invalid retry policy: %w", err))
+	} else {
+		bheRetryPolicy = retryPolicy
+		if err := uploadStream(ctx, stream, *bhUrl, bhClient, batchSize); err != nil {
+			exit(fmt.Errorf("failed to upload stream: %w", err))
+		}
+	}
+	commitDeltaState(ctx)
+}
+
+// uploadStream starts a BloodHound CE file upload job, uploads the objects of the stream as they are collected in
+// files of up to batchSize objects and ends the job so that the files are ingested
+func uploadStream[T any](ctx context.Context, stream <-chan T, bhUrl url.URL, bhClient *http.Client, batchSize int) error {
+	job, err := startFileUpload(ctx, bhUrl, bhClient)
+	if err != nil {
+		return err
+	}
+	log.Info("started file upload job", "id", job.ID)
+
+	var (
+		result  error
+		files   int
+		objects int
+	)
+	for batch := range pipeline.Batch(ctx.Done(), stream, batchSize, uploadBatchTimeout) {
+		if err := uploadFile(ctx, bhUrl, bhClient, job.ID, batch); err != nil {
+			result = err
+			break
+		}
+		files++
+		objects += len(batch)
+		log.V(1).Info("uploaded file", "id", job.ID, "objects", len(batch))
+	}
+
+	// end the job even when interrupted so that the files already uploaded are ingested
+	if err := endFileUpload(context.WithoutCancel(ctx), bhUrl, bhClient, job.ID); err != nil && result == nil {
+		result = err
+	} else if result == nil {
+		log.Info("ended file upload job", "id", job.ID, "files", files, "objects", objects)
+	}
+	return result
+}
+
+func startFileUpload(ctx context.Context, bhUrl url.URL, bhClient *http.Client) (models.FileUploadJob, error) {
+	var (
+		endpoint = bhUrl.ResolveReference(&url.URL{Path: "/api/v2/file-upload/start"})
+		response basicResponse[models.FileUploadJob]
+	)
+
+	if req, err := rest.NewRequest(ctx, "POST", endpoint, nil, nil, nil); err != nil {
+		return response.Data, err
+	} else if res, err := do(bhClient, req); err != nil {
+		return response.Data, err
+	} else {
+		defer res.Body.Close()
+		if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+			return response.Data, err
+		} else {
+			return response.Data, nil
+		}
+	}
+}
+
+// uploadFile uploads the batch as a file in the format read by BloodHound
+func uploadFile[T any](ctx context.Context, bhUrl url.URL, bhClient *http.Client, jobId int64, batch []T) error {
+	var (
+		endpoint = bhUrl.ResolveReference(&url.URL{Path: fmt.Sprintf("/api/v2/file-upload/%d", jobId)})
+		file     = models.IngestRequest{
+			Meta: models.Meta{Type: "azure", Version: 5, Count: len(batch)},
+			Data: batch,
+		}
+	)
+
+	ctx, span := tracing.Start(ctx, "upload", tracing.SpanKindClient,
+		tracing.String("http.request.method", "POST"),
+		tracing.String("server.address", endpoint.Host),
+		tracing.String("url.path", endpoint.Path),
+		tracing.Int("azurehound.batch.size", len(batch)),
+	)
+	defer span.End()
+
+	if req, err := rest.NewRequest(ctx, "POST", endpoint, file, nil, nil); err != nil {
+		span.SetError(err)
+		return err
+	} else if res, err := do(bhClient, req); err != nil {
+		span.SetError(err)
+		return err
+	} else {
+		span.SetAttributes(tracing.Int("http.response.status_code", res.StatusCode))
+		return res.Body.Close()
+	}
+}
+
+func endFileUpload(ctx context.Context, bhUrl url.URL, bhClient *http.Client, jobId int64) error {
+	endpoint := bhUrl.ResolveReference(&url.URL{Path: fmt.Sprintf("/api/v2/file-upload/%d/end", jobId)})
+
+	if req, err := rest.NewRequest(ctx, "POST", endpoint, nil, nil, nil); err != nil {
+		return err
+	} else if res, err := do(bhClient, req); err != nil {
+		return err
+	} else {
+		return res.Body.Close()
+	}
+}
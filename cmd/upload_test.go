@@ -0,0 +1,104 @@
+// Copyright (C) 2024 Specter Ops, Inc.
+//
+// This file is part of AzureHound.
+//
+// AzureHound is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// AzureHound is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bloodhoundad/azurehound/v2/client/rest"
+	"github.com/bloodhoundad/azurehound/v2/enums"
+	"github.com/bloodhoundad/azurehound/v2/models"
+)
+
+func TestUploadStream(t *testing.T) {
+	var (
+		mutex    sync.Mutex
+		requests []string
+		counts   []int
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		requests = append(requests, r.URL.Path)
+
+		if r.Header.Get("Authorization") != BHEAuthSignature+" token-id" || r.Header.Get("Signature") == "" || r.Header.Get("RequestDate") == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch {
+		case r.URL.Path == "/api/v2/file-upload/start":
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(basicResponse[models.FileUploadJob]{Data: models.FileUploadJob{ID: 7}})
+		case r.URL.Path == "/api/v2/file-upload/7":
+			var file struct {
+				Meta models.Meta
+				Data []AzureWrapper
+			}
+			if err := json.NewDecoder(r.Body).Decode(&file); err != nil || file.Meta.Count != len(file.Data) || file.Meta.Type != "azure" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			counts = append(counts, file.Meta.Count)
+			w.WriteHeader(http.StatusAccepted)
+		case r.URL.Path == "/api/v2/file-upload/7/end":
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	if policy, err := rest.NewRetryPolicy(); err != nil {
+		t.Fatal(err)
+	} else {
+		bheRetryPolicy = policy
+	}
+
+	bhUrl, _ := url.Parse(server.URL)
+	bhClient, err := newSigningHttpClient(BHEAuthSignature, "token-id", "token", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream := make(chan AzureWrapper)
+	go func() {
+		defer close(stream)
+		for i := 0; i < 5; i++ {
+			stream <- AzureWrapper{Kind: enums.KindAZUser, Data: map[string]int{"id": i}}
+		}
+	}()
+
+	if err := uploadStream(context.Background(), stream, *bhUrl, bhClient, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"/api/v2/file-upload/start", "/api/v2/file-upload/7", "/api/v2/file-upload/7", "/api/v2/file-upload/7", "/api/v2/file-upload/7/end"}
+	if strings.Join(requests, " ") != strings.Join(expected, " ") {
+		t.Errorf("got requests %v, want %v", requests, expected)
+	} else if len(counts) != 3 || counts[0] != 2 || counts[1] != 2 || counts[2] != 1 {
+		t.Errorf("got files of %v objects, want 2, 2 and 1", counts)
+	}
+}
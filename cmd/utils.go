@@ -25,6 +25,7 @@ import (
 	"crypto/sha256"
 	"crypto/tls"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/fs"
@@ -35,6 +36,7 @@ import (
 	"path"
 	"path/filepath"
 	"runtime/pprof"
+	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
@@ -54,6 +56,7 @@ import (
 func exit(err error) {
 	log.Error(err, "encountered unrecoverable error")
 	log.GetSink()
+	shutdownTracing()
 	os.Exit(1)
 }
 
@@ -74,6 +77,10 @@ func persistentPreRunE(cmd *cobra.Command, args []string) error {
 		log = *logr
 		config.CheckCollectionConfigSanity(log)
 
+		if err := setupTracing(); err != nil {
+			return err
+		}
+
 		if config.ConfigFileUsed() != "" {
 			log.V(1).Info(fmt.Sprintf("Config File: %v", config.ConfigFileUsed()))
 		}
@@ -95,7 +102,10 @@ func gracefulShutdown(stop context.CancelFunc) {
 }
 
 func testConnections() error {
-	if _, err := dial(config.AzAuthUrl.Value().(string)); err != nil {
+	if replay, ok := config.HTTPReplayFile.Value().(string); ok && replay != "" {
+		// responses are replayed from a cassette; the network is never used
+		return nil
+	} else if _, err := dial(config.AzAuthUrl.Value().(string)); err != nil {
 		return fmt.Errorf("unable to connect to %s: %w", config.AzAuthUrl.Value(), err)
 	} else if _, err := dial(config.AzGraphUrl.Value().(string)); err != nil {
 		return fmt.Errorf("unable to connect to %s: %w", config.AzGraphUrl.Value(), err)
@@ -216,6 +226,32 @@ func dial(targetUrl string) (string, error) {
 }
 
 func newAzureClient() (client.AzureClient, error) {
+	return newAzureClientForTenant(config.AzTenant.Value().(string))
+}
+
+func newAzureClientForTenant(tenant string) (client.AzureClient, error) {
+	if config, err := newClientConfig(tenant); err != nil {
+		return nil, err
+	} else if broker, err := newTokenBroker(config); err != nil {
+		return nil, err
+	} else {
+		return client.NewClientWithBroker(config, broker)
+	}
+}
+
+// newTokenBroker creates a token broker that logs the failures of its token cache
+func newTokenBroker(config client_config.Config) (*rest.TokenBroker, error) {
+	if broker, err := rest.NewTokenBroker(config); err != nil {
+		return nil, err
+	} else {
+		broker.OnCacheError(func(err error) {
+			log.Error(err, "token cache unavailable")
+		})
+		return broker, nil
+	}
+}
+
+func newClientConfig(tenant string) (client_config.Config, error) {
 	var (
 		certFile   = config.AzCert.Value()
 		keyFile    = config.AzKey.Value()
@@ -225,7 +261,7 @@ func newAzureClient() (client.AzureClient, error) {
 
 	if file, ok := certFile.(string); ok && file != "" {
 		if content, err := os.ReadFile(certFile.(string)); err != nil {
-			return nil, fmt.Errorf("unable to read provided certificate: %w", err)
+			return client_config.Config{}, fmt.Errorf("unable to read provided certificate: %w", err)
 		} else {
 			clientCert = string(content)
 		}
@@ -233,32 +269,69 @@ func newAzureClient() (client.AzureClient, error) {
 
 	if file, ok := keyFile.(string); ok && file != "" {
 		if content, err := os.ReadFile(keyFile.(string)); err != nil {
-			return nil, fmt.Errorf("unable to read provided key file: %w", err)
+			return client_config.Config{}, fmt.Errorf("unable to read provided key file: %w", err)
 		} else {
 			clientKey = string(content)
 		}
 	}
 
-	config := client_config.Config{
-		ApplicationId:  config.AzAppId.Value().(string),
-		Authority:      config.AzAuthUrl.Value().(string),
-		ClientSecret:   config.AzSecret.Value().(string),
-		ClientCert:     clientCert,
-		ClientKey:      clientKey,
-		ClientKeyPass:  config.AzKeyPass.Value().(string),
-		Graph:          config.AzGraphUrl.Value().(string),
-		JWT:            config.JWT.Value().(string),
-		Management:     config.AzMgmtUrl.Value().(string),
-		MgmtGroupId:    config.AzMgmtGroupId.Value().([]string),
-		Password:       config.AzPassword.Value().(string),
-		ProxyUrl:       config.Proxy.Value().(string),
-		RefreshToken:   config.RefreshToken.Value().(string),
-		Region:         config.AzRegion.Value().(string),
-		SubscriptionId: config.AzSubId.Value().([]string),
-		Tenant:         config.AzTenant.Value().(string),
-		Username:       config.AzUsername.Value().(string),
-	}
-	return client.NewClient(config)
+	var (
+		appId              = config.AzAppId.Value().(string)
+		federatedTokenFile = config.AzFederatedTokenFile.Value().(string)
+	)
+
+	refreshToken := config.RefreshToken.Value().(string)
+	if refreshToken == "" && config.AzCli.Value().(bool) {
+		if token, err := rest.ReadMSALRefreshToken(config.AzCliCache.Value().(string), tenant, config.AzUsername.Value().(string)); err != nil {
+			return client_config.Config{}, err
+		} else {
+			refreshToken = token
+		}
+	}
+
+	tokenCacheKey := config.AzTokenCachePassphrase.Value().(string)
+	if keyFile := config.AzTokenCacheKeyFile.Value().(string); keyFile != "" {
+		if content, err := os.ReadFile(keyFile); err != nil {
+			return client_config.Config{}, fmt.Errorf("unable to read provided token cache key file: %w", err)
+		} else {
+			tokenCacheKey = string(content)
+		}
+	}
+
+	clientConfig := client_config.Config{
+		ApplicationId:      appId,
+		Authority:          config.AzAuthUrl.Value().(string),
+		ClientSecret:       config.AzSecret.Value().(string),
+		ClientCert:         clientCert,
+		ClientKey:          clientKey,
+		ClientKeyPass:      config.AzKeyPass.Value().(string),
+		DeviceCode:         config.AzDeviceCode.Value().(bool),
+		FederatedTokenFile: federatedTokenFile,
+		Graph:              config.AzGraphUrl.Value().(string),
+		JWT:                config.JWT.Value().(string),
+		Management:         config.AzMgmtUrl.Value().(string),
+		ManagedIdentity:    config.AzManagedIdentity.Value().(bool),
+		ManagedIdentityId:  config.AzManagedIdentityId.Value().(string),
+		MgmtGroupId:        config.AzMgmtGroupId.Value().([]string),
+		Password:           config.AzPassword.Value().(string),
+		ProxyUrl:           config.Proxy.Value().(string),
+		RefreshToken:       refreshToken,
+		Region:             config.AzRegion.Value().(string),
+		SubscriptionId:     config.AzSubId.Value().([]string),
+		Tenant:             tenant,
+		TokenCacheFile:     config.AzTokenCache.Value().(string),
+		TokenCacheKey:      tokenCacheKey,
+		Username:           config.AzUsername.Value().(string),
+	}
+
+	// fall back to the environment injected by Azure Workload Identity for Kubernetes, unless another credential was chosen
+	if !clientConfig.HasCredential() && clientConfig.JWT == "" {
+		clientConfig.FederatedTokenFile = os.Getenv(config.AzureFederatedTokenFileEnv)
+	}
+	if clientConfig.FederatedTokenFile != "" && clientConfig.ApplicationId == "" {
+		clientConfig.ApplicationId = os.Getenv(config.AzureClientIdEnv)
+	}
+	return clientConfig, nil
 }
 
 func newSigningHttpClient(signature, tokenId, token, proxyUrl string) (*http.Client, error) {
@@ -424,13 +497,15 @@ func setupLogger() {
 
 // deprecated: use azureWrapper instead
 type AzureWrapper struct {
-	Kind enums.Kind  `json:"kind"`
-	Data interface{} `json:"data"`
+	Kind    enums.Kind  `json:"kind"`
+	Data    interface{} `json:"data"`
+	Deleted bool        `json:"deleted,omitempty"` // Marks an object deleted since the previous incremental collection
 }
 
 type azureWrapper[T any] struct {
-	Kind enums.Kind `json:"kind"`
-	Data T          `json:"data"`
+	Kind    enums.Kind `json:"kind"`
+	Data    T          `json:"data"`
+	Deleted bool       `json:"deleted,omitempty"` // Marks an object deleted since the previous incremental collection
 }
 
 func NewAzureWrapper[T any](kind enums.Kind, data T) azureWrapper[T] {
@@ -440,14 +515,90 @@ func NewAzureWrapper[T any](kind enums.Kind, data T) azureWrapper[T] {
 	}
 }
 
-func outputStream[T any](ctx context.Context, stream <-chan T) {
+func outputStream[T any](ctx context.Context, azClient client.AzureClient, stream <-chan T) {
+	if storage, _ := config.Storage.Value().(string); storage != "" {
+		outputStreamToStorage(ctx, stream, azClient.TokenBroker(), azClient.TenantInfo().TenantId)
+	} else {
+		outputStreamTo(ctx, stream, config.OutputFile.Value().(string))
+	}
+}
+
+func outputStreamTo[T any](ctx context.Context, stream <-chan T, path string) {
+	if upload, _ := config.UploadUrl.Value().(string); upload != "" {
+		if path != "" {
+			exit(fmt.Errorf("only one of --%s and --%s may be given", config.UploadUrl.Name, config.OutputFile.Name))
+		}
+		outputStreamToBloodHound(ctx, stream)
+		return
+	}
+
+	format := outputFormat()
+	if split := splitOptions(); split.Enabled() {
+		if path == "" {
+			exit(fmt.Errorf("split output requires --%s", config.OutputFile.Name))
+		} else if format != sinks.FormatJSON {
+			exit(fmt.Errorf("split output is always written as %s", sinks.FormatJSON))
+		} else if compression, _ := config.OutputCompression.Value().(string); compression != "" {
+			exit(fmt.Errorf("split output is compressed in its zip; --%s is not supported", config.OutputCompression.Name))
+		} else if err := sinks.WriteToZip(ctx, path, split, formatObjects(ctx, stream)); err != nil {
+			exit(fmt.Errorf("failed to write stream to zip: %w", err))
+		}
+		commitDeltaState(ctx)
+		return
+	}
+
 	formatted := pipeline.FormatJson(ctx.Done(), stream)
-	if path := config.OutputFile.Value().(string); path != "" {
-		if err := sinks.WriteToFile(ctx, path, formatted); err != nil {
+	if path != "" {
+		if err := sinks.WriteToFile(ctx, path, format, outputCompression(path), formatted); err != nil {
 			exit(fmt.Errorf("failed to write stream to file: %w", err))
 		}
 	} else {
-		sinks.WriteToConsole(ctx, formatted)
+		sinks.WriteToConsole(ctx, format, formatted)
+	}
+	commitDeltaState(ctx)
+}
+
+// formatObjects formats the items of the stream as JSON, keeping their kind so that split output can be divided by it
+func formatObjects[T any](ctx context.Context, stream <-chan T) <-chan sinks.Object {
+	return pipeline.Map(ctx.Done(), stream, func(item T) sinks.Object {
+		if data, err := json.Marshal(item); err != nil {
+			panic(err)
+		} else if wrapper, ok := any(item).(kinded); ok {
+			return sinks.Object{Kind: wrapper.wrappedKind(), Data: string(data)}
+		} else {
+			return sinks.Object{Data: string(data)}
+		}
+	})
+}
+
+func splitOptions() sinks.SplitOptions {
+	options := sinks.SplitOptions{
+		ByKind:     config.SplitByKind.Value().(bool),
+		MaxObjects: config.MaxFileObjects.Value().(int),
+		MaxBytes:   int64(config.MaxFileSize.Value().(int)) * 1024 * 1024,
+	}
+	if options.MaxObjects < 0 || options.MaxBytes < 0 {
+		exit(fmt.Errorf("--%s and --%s may not be negative", config.MaxFileObjects.Name, config.MaxFileSize.Name))
+	}
+	return options
+}
+
+// outputCompression returns the compression of the output written to the path
+func outputCompression(path string) sinks.Compression {
+	if compression, err := sinks.ParseCompression(config.OutputCompression.Value().(string), path); err != nil {
+		exit(err)
+		return ""
+	} else {
+		return compression
+	}
+}
+
+func outputFormat() sinks.Format {
+	if format, err := sinks.ParseFormat(config.OutputFormat.Value().(string)); err != nil {
+		exit(err)
+		return ""
+	} else {
+		return format
 	}
 }
 
@@ -476,6 +627,24 @@ func mgmtGroupRoleAssignmentFilter(roleId string) func(models.ManagementGroupRol
 }
 
 func connectAndCreateClient() client.AzureClient {
+	if err := checkSingleTenant(); err != nil {
+		exit(err)
+	}
+	return connectAndCreateHomeClient()
+}
+
+// checkSingleTenant rejects --tenants and --all-tenants, which commands that handle a single tenant would otherwise
+// silently ignore
+func checkSingleTenant() error {
+	if len(config.AzTenants.Value().([]string)) > 0 || config.AzAllTenants.Value().(bool) {
+		return fmt.Errorf("--%s and --%s are only supported when listing all objects or running as a service", config.AzTenants.Name, config.AzAllTenants.Name)
+	} else {
+		return nil
+	}
+}
+
+// connectAndCreateHomeClient returns a client for the tenant provided by --tenant
+func connectAndCreateHomeClient() client.AzureClient {
 	log.V(1).Info("testing connections")
 	if err := testConnections(); err != nil {
 		exit(fmt.Errorf("failed to test connections: %w", err))
@@ -487,3 +656,62 @@ func connectAndCreateClient() client.AzureClient {
 
 	panic("unexpectedly failed to create azClient without error")
 }
+
+// connectAndCreateClients returns a client for every tenant to collect. This is the tenant provided by --tenant
+// followed by any tenants provided by --tenants or, with --all-tenants, every tenant visible to the principal.
+func connectAndCreateClients(ctx context.Context) []client.AzureClient {
+	azClients := tenantClients(ctx, connectAndCreateHomeClient(), config.AzTenants.Value().([]string), config.AzAllTenants.Value().(bool), newAzureClientForTenant)
+	if len(azClients) > 1 {
+		log.Info("collecting multiple tenants", "count", len(azClients))
+	}
+	return azClients
+}
+
+// tenantClients returns the client of the home tenant followed by a client for each other tenant given or, when
+// discovering, visible to the principal. Tenants given by domain are resolved to their ID, so each tenant is collected
+// once however it was given.
+func tenantClients(ctx context.Context, azClient client.AzureClient, tenants []string, discover bool, newClient func(tenant string) (client.AzureClient, error)) []client.AzureClient {
+	var (
+		azClients = []client.AzureClient{azClient}
+		seen      = map[string]bool{azClient.TenantInfo().TenantId: true}
+	)
+
+	if discover {
+		for item := range azClient.ListAzureADTenants(ctx, true) {
+			if item.Error != nil {
+				exit(fmt.Errorf("failed to discover tenants: %w", item.Error))
+			} else {
+				tenants = append(tenants, item.Ok.TenantId)
+			}
+		}
+	}
+
+	for _, tenant := range tenants {
+		if seen[tenant] {
+			continue
+		} else if tenantClient, err := newClient(tenant); err != nil {
+			log.Error(err, "unable to create Azure client for tenant; skipping", "tenant", tenant)
+		} else if tenantId := tenantClient.TenantInfo().TenantId; seen[tenantId] {
+			tenantClient.CloseIdleConnections()
+		} else {
+			seen[tenantId] = true
+			azClients = append(azClients, tenantClient)
+		}
+	}
+	return azClients
+}
+
+// tenantOutputFile returns the output path for a single tenant when collecting multiple tenants (e.g. output.json
+// becomes output-<tenantId>.json)
+func tenantOutputFile(path string, tenantId string) string {
+	if path == "" {
+		return ""
+	} else {
+		// keep the extension of compressed output whole (e.g. output.json.gz becomes output-<tenantId>.json.gz)
+		ext := filepath.Ext(path)
+		if sinks.CompressionFor(path) != sinks.CompressionNone {
+			ext = filepath.Ext(strings.TrimSuffix(path, ext)) + ext
+		}
+		return fmt.Sprintf("%s-%s%s", strings.TrimSuffix(path, ext), tenantId, ext)
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright (C) 2024 Specter Ops, Inc.
+//
+// This file is part of AzureHound.
+//
+// AzureHound is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// AzureHound is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/bloodhoundad/azurehound/v2/client"
+	"github.com/bloodhoundad/azurehound/v2/client/mocks"
+	"github.com/bloodhoundad/azurehound/v2/config"
+	"github.com/bloodhoundad/azurehound/v2/models/azure"
+	"go.uber.org/mock/gomock"
+)
+
+func TestNewClientConfigWorkloadIdentity(t *testing.T) {
+	t.Setenv(config.AzureFederatedTokenFileEnv, "/var/run/secrets/azure/tokens/azure-identity-token")
+	t.Setenv(config.AzureClientIdEnv, "workload-client-id")
+
+	// without a credential of its own the workload identity is used
+	if clientConfig, err := newClientConfig("tenant"); err != nil {
+		t.Fatal(err)
+	} else if clientConfig.FederatedTokenFile == "" || clientConfig.ApplicationId != "workload-client-id" {
+		t.Errorf("got federated token file %q for app %q, want the workload identity", clientConfig.FederatedTokenFile, clientConfig.ApplicationId)
+	}
+
+	// a credential chosen explicitly is never replaced by it
+	for _, option := range []config.Config{config.AzDeviceCode, config.AzManagedIdentity} {
+		option.Set(true)
+		if clientConfig, err := newClientConfig("tenant"); err != nil {
+			t.Fatal(err)
+		} else if clientConfig.FederatedTokenFile != "" {
+			t.Errorf("got federated token file %q with --%s", clientConfig.FederatedTokenFile, option.Name)
+		}
+		option.Set(false)
+	}
+}
+
+func TestTenantOutputFile(t *testing.T) {
+	for path, expected := range map[string]string{
+		"":                       "",
+		"output.json":            "output-tenant.json",
+		"dir/output.ndjson":      "dir/output-tenant.ndjson",
+		"output.json.gz":         "output-tenant.json.gz",
+		"output.json.zst":        "output-tenant.json.zst",
+		"output":                 "output-tenant",
+		"dir.d/output.json.zstd": "dir.d/output-tenant.json.zstd",
+	} {
+		if actual := tenantOutputFile(path, "tenant"); actual != expected {
+			t.Errorf("got %q for %q, want %q", actual, path, expected)
+		}
+	}
+}
+
+func TestTenantClients(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	newMockClient := func(tenantId string) *mocks.MockAzureClient {
+		mockClient := mocks.NewMockAzureClient(ctrl)
+		mockClient.EXPECT().TenantInfo().Return(azure.Tenant{TenantId: tenantId}).AnyTimes()
+		mockClient.EXPECT().CloseIdleConnections().AnyTimes()
+		return mockClient
+	}
+
+	var (
+		home      = newMockClient("home-id")
+		discovery = make(chan client.AzureResult[azure.Tenant], 3)
+		created   []string
+	)
+	discovery <- client.AzureResult[azure.Tenant]{Ok: azure.Tenant{TenantId: "home-id"}}
+	discovery <- client.AzureResult[azure.Tenant]{Ok: azure.Tenant{TenantId: "fabrikam-id"}}
+	discovery <- client.AzureResult[azure.Tenant]{Ok: azure.Tenant{TenantId: "northwind-id"}}
+	close(discovery)
+	home.EXPECT().ListAzureADTenants(gomock.Any(), true).Return(discovery)
+
+	// tenants given by domain resolve to the ID of a tenant already collected
+	domains := map[string]string{"contoso.onmicrosoft.com": "home-id", "fabrikam.onmicrosoft.com": "fabrikam-id"}
+	newClient := func(tenant string) (client.AzureClient, error) {
+		created = append(created, tenant)
+		if tenant == "unreachable" {
+			return nil, fmt.Errorf("unreachable tenant")
+		} else if tenantId, ok := domains[tenant]; ok {
+			return newMockClient(tenantId), nil
+		} else {
+			return newMockClient(tenant), nil
+		}
+	}
+
+	var (
+		azClients = tenantClients(context.Background(), home, []string{"contoso.onmicrosoft.com", "fabrikam.onmicrosoft.com", "unreachable"}, true, newClient)
+		tenantIds []string
+	)
+	for _, azClient := range azClients {
+		tenantIds = append(tenantIds, azClient.TenantInfo().TenantId)
+	}
+
+	if fmt.Sprint(tenantIds) != "[home-id fabrikam-id northwind-id]" {
+		t.Errorf("got tenants %v, want each tenant once with the home tenant first", tenantIds)
+	} else if fmt.Sprint(created) != "[contoso.onmicrosoft.com fabrikam.onmicrosoft.com unreachable northwind-id]" {
+		t.Errorf("got clients created for %v", created)
+	}
+}
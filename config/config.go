@@ -39,6 +39,11 @@ var (
 	// - $HOME/.config/azurehound/config.json (Unix/Darwin)
 	// - %USERPROFILE%\.config\azurehound\config.json (Windows)
 	DefaultConfigFile = filepath.Join(homeDir, ".config", "azurehound", "config.json")
+
+	// DefaultMSALCacheFile is the path to the token cache written by Azure CLI and Azure PowerShell on Linux.
+	//
+	// - $HOME/.azure/msal_token_cache.json
+	DefaultMSALCacheFile = filepath.Join(homeDir, ".azure", "msal_token_cache.json")
 )
 
 func SystemConfigDirs() []string {
@@ -69,6 +74,20 @@ func SystemConfigDirs() []string {
 
 const EnvPrefix string = "AZUREHOUND"
 
+// Environment variables set by Azure Workload Identity for Kubernetes
+const (
+	AzureClientIdEnv           string = "AZURE_CLIENT_ID"
+	AzureFederatedTokenFileEnv string = "AZURE_FEDERATED_TOKEN_FILE"
+)
+
+// Environment variables holding the AWS credentials and region with which to upload to an S3 bucket
+const (
+	AwsAccessKeyIdEnv     string = "AWS_ACCESS_KEY_ID"
+	AwsSecretAccessKeyEnv string = "AWS_SECRET_ACCESS_KEY"
+	AwsSessionTokenEnv    string = "AWS_SESSION_TOKEN"
+	AwsRegionEnv          string = "AWS_REGION"
+)
+
 var AzRegions = []string{
 	constants.China,
 	constants.Cloud,
@@ -135,6 +154,37 @@ var (
 		Default:    "",
 	}
 
+	HTTPRecordFile = Config{
+		Name:       "record",
+		Usage:      "The path to a cassette file in which to record every HTTP request and response, with credentials and tokens redacted",
+		Persistent: true,
+		Default:    "",
+	}
+	HTTPReplayFile = Config{
+		Name:       "replay",
+		Usage:      "The path to a cassette file recorded with --record from which to replay HTTP responses instead of making requests. Authentication options must select the same flow as the recording, though their values need not match.",
+		Persistent: true,
+		Default:    "",
+	}
+	StatsFile = Config{
+		Name:       "stats",
+		Usage:      "The path to a file in which to write the request and collection statistics summarized when a run finishes, as JSON",
+		Persistent: true,
+		Default:    "",
+	}
+	TraceEndpoint = Config{
+		Name:       "trace-endpoint",
+		Usage:      "The URL of an OpenTelemetry collector (e.g. http://localhost:4318) to which to export traces of jobs, list stages and requests using OTLP over HTTP",
+		Persistent: true,
+		Default:    "",
+	}
+	TraceFile = Config{
+		Name:       "trace-file",
+		Usage:      "The path to a file in which to write traces of jobs, list stages and requests as OTLP JSON, one export request per line, when there is no collector",
+		Persistent: true,
+		Default:    "",
+	}
+
 	// Azure Configurations
 	AzAppId = Config{
 		Name:       "app",
@@ -186,6 +236,20 @@ var (
 		Persistent: true,
 		Default:    "",
 	}
+	AzTenants = Config{
+		Name:       "tenants",
+		Shorthand:  "",
+		Usage:      "Additional tenants to collect in a single run of list or start. Each tenant is collected with its own client.",
+		Persistent: true,
+		Default:    []string{},
+	}
+	AzAllTenants = Config{
+		Name:       "all-tenants",
+		Shorthand:  "",
+		Usage:      "Collect every tenant visible to the authenticated principal in a single run of list or start.",
+		Persistent: true,
+		Default:    false,
+	}
 	AzAuthUrl = Config{
 		Name:       "auth",
 		Shorthand:  "",
@@ -221,6 +285,69 @@ var (
 		Persistent: true,
 		Default:    "",
 	}
+	AzDeviceCode = Config{
+		Name:       "device-code",
+		Shorthand:  "",
+		Usage:      "Authenticate interactively using the device code flow",
+		Persistent: true,
+		Default:    false,
+	}
+	AzManagedIdentity = Config{
+		Name:       "managed-identity",
+		Shorthand:  "",
+		Usage:      "Authenticate using the managed identity assigned to the host.",
+		Persistent: true,
+		Default:    false,
+	}
+	AzManagedIdentityId = Config{
+		Name:       "managed-identity-id",
+		Shorthand:  "",
+		Usage:      "The client ID of the user-assigned managed identity to authenticate as. Uses the system-assigned identity if not set.",
+		Persistent: true,
+		Default:    "",
+	}
+	AzFederatedTokenFile = Config{
+		Name:       "federated-token-file",
+		Shorthand:  "",
+		Usage:      fmt.Sprintf("The path to a federated token to use as the client assertion (defaults to $%s).", AzureFederatedTokenFileEnv),
+		Persistent: true,
+		Default:    "",
+	}
+	AzCli = Config{
+		Name:       "az-cli",
+		Shorthand:  "",
+		Usage:      "Authenticate using the refresh token of an existing Azure CLI or Azure PowerShell session.",
+		Persistent: true,
+		Default:    false,
+	}
+	AzCliCache = Config{
+		Name:       "az-cli-cache",
+		Shorthand:  "",
+		Usage:      fmt.Sprintf("The path to the MSAL token cache written by Azure CLI or Azure PowerShell (default: %s)", DefaultMSALCacheFile),
+		Persistent: true,
+		Default:    DefaultMSALCacheFile,
+	}
+	AzTokenCache = Config{
+		Name:       "token-cache",
+		Shorthand:  "",
+		Usage:      "The path to an encrypted file in which to persist tokens across runs. Requires --token-cache-passphrase or --token-cache-key-file.",
+		Persistent: true,
+		Default:    "",
+	}
+	AzTokenCachePassphrase = Config{
+		Name:       "token-cache-passphrase",
+		Shorthand:  "",
+		Usage:      "The passphrase used to encrypt the token cache.",
+		Persistent: true,
+		Default:    "",
+	}
+	AzTokenCacheKeyFile = Config{
+		Name:       "token-cache-key-file",
+		Shorthand:  "",
+		Usage:      "The path to a key file used to encrypt the token cache.",
+		Persistent: true,
+		Default:    "",
+	}
 	AzSubId = Config{
 		Name:       "subscriptionId",
 		Shorthand:  "b",
@@ -300,7 +427,7 @@ var (
 	ColStreamCount = Config{
 		Name:       "streamCount",
 		Shorthand:  "",
-		Usage:      "The number of threads to use when collecting various resources.",
+		Usage:      "The number of workers each collection step runs and so the ceiling on concurrent requests to each Azure API. Concurrency starts lower, grows up to this ceiling and shrinks when the API throttles requests.",
 		Persistent: true,
 		Required:   false,
 		Default:    25,
@@ -308,6 +435,37 @@ var (
 		MaxValue:   50,
 	}
 
+	ColRetryMaxAttempts = Config{
+		Name:       "retryMaxAttempts",
+		Shorthand:  "",
+		Usage:      "The maximum number of attempts at a request that failed with a retryable status code or a dropped connection.",
+		Persistent: true,
+		Required:   false,
+		Default:    5,
+		MinValue:   1,
+		MaxValue:   20,
+	}
+
+	ColRetryMaxElapsed = Config{
+		Name:       "retryMaxElapsed",
+		Shorthand:  "",
+		Usage:      "The maximum number of seconds to spend retrying a single request.",
+		Persistent: true,
+		Required:   false,
+		Default:    300,
+		MinValue:   1,
+		MaxValue:   3600,
+	}
+
+	ColRetryStatusCodes = Config{
+		Name:       "retryStatusCodes",
+		Shorthand:  "",
+		Usage:      "The response status codes to retry, as a code (e.g. 503) or class (e.g. 5xx) optionally followed by the maximum number of attempts for that status (e.g. 429=0). 0 attempts retries until retryMaxElapsed.",
+		Persistent: true,
+		Required:   false,
+		Default:    []string{"429=0", "5xx"},
+	}
+
 	// Command specific configurations
 	KeyVaultAccessTypes = Config{
 		Name:       "access-types",
@@ -317,6 +475,38 @@ var (
 		Default:    []enums.KeyVaultAccessType{},
 	}
 
+	DeltaStateFile = Config{
+		Name:       "delta-state",
+		Shorthand:  "",
+		Usage:      "The path to a file in which to keep delta query state for incremental collection of users, groups, service principals, apps and devices. The first run collects every object; later runs only those changed or deleted since.",
+		Persistent: true,
+		Default:    "",
+	}
+
+	CheckpointDir = Config{
+		Name:       "checkpoint-dir",
+		Shorthand:  "",
+		Usage:      "The path to a directory in which to journal the progress of a collection and its output, so that it can be resumed with --resume if interrupted. Supported by list, list az-ad and list az-rm. Requires --output.",
+		Persistent: true,
+		Default:    "",
+	}
+
+	Resume = Config{
+		Name:       "resume",
+		Shorthand:  "",
+		Usage:      "Resume the collection journaled in --checkpoint-dir, skipping the objects whose relationships were already written and appending to the partial output. Objects of a listing that was interrupted part way may be written twice.",
+		Persistent: true,
+		Default:    false,
+	}
+
+	MetricsAddress = Config{
+		Name:       "metrics-addr",
+		Shorthand:  "",
+		Usage:      "The address (e.g. :9090) on which to serve Prometheus metrics at /metrics and health checks at /healthz and /readyz while running as a service. Not served when empty.",
+		Persistent: true,
+		Default:    "",
+	}
+
 	OutputFile = Config{
 		Name:       "output",
 		Shorthand:  "o",
@@ -325,6 +515,94 @@ var (
 		Default:    "",
 	}
 
+	OutputFormat = Config{
+		Name:       "output-format",
+		Shorthand:  "",
+		Usage:      "The format in which to output data: json, a single document as read by BloodHound, or ndjson, a metadata line followed by one object per line. The metadata of ndjson written to a file, including the count, is written to <output>.meta.json once the collection completes.",
+		Persistent: true,
+		Default:    "json",
+	}
+
+	OutputCompression = Config{
+		Name:       "output-compression",
+		Shorthand:  "",
+		Usage:      "Compress output files as they are written: none, gzip or zstd. Chosen by the extension of --output when not given, .gz for gzip and .zst for zstd.",
+		Persistent: true,
+		Default:    "",
+	}
+
+	UploadUrl = Config{
+		Name:       "upload",
+		Shorthand:  "",
+		Usage:      "The URL of a BloodHound CE instance to which to upload the collected data through its file upload API, as it is collected, instead of writing it to --output. Requires --upload-token-id and --upload-token.",
+		Persistent: true,
+		Default:    "",
+	}
+
+	UploadTokenId = Config{
+		Name:       "upload-token-id",
+		Shorthand:  "",
+		Usage:      "The ID of the BloodHound CE API token with which to upload.",
+		Persistent: true,
+		Default:    "",
+	}
+
+	UploadToken = Config{
+		Name:       "upload-token",
+		Shorthand:  "",
+		Usage:      "The BloodHound CE API token with which to upload.",
+		Persistent: true,
+		Default:    "",
+	}
+
+	Storage = Config{
+		Name:       "storage",
+		Shorthand:  "",
+		Usage:      "The URL of object storage to which to upload the collected data, as it is collected, instead of writing it to --output: s3://<bucket>/<prefix> for an S3-compatible bucket, with the credentials in AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN, or https://<account>.blob.core.windows.net/<container>/<prefix> for Azure Blob Storage, authorized by a SAS token in its query or by the AzureHound credentials. Objects are named for the tenant and the time of collection.",
+		Persistent: true,
+		Default:    "",
+	}
+
+	StorageEndpoint = Config{
+		Name:       "storage-endpoint",
+		Shorthand:  "",
+		Usage:      "The URL of an S3-compatible service, such as MinIO, to which to upload an s3:// --storage instead of AWS.",
+		Persistent: true,
+		Default:    "",
+	}
+
+	StorageRegion = Config{
+		Name:       "storage-region",
+		Shorthand:  "",
+		Usage:      "The region of an s3:// --storage bucket (default: AWS_REGION or us-east-1)",
+		Persistent: true,
+		Default:    "",
+	}
+
+	SplitByKind = Config{
+		Name:       "split-by-kind",
+		Shorthand:  "",
+		Usage:      "Write each kind of object to files of its own, packaged in a zip at --output ready for upload to BloodHound CE.",
+		Persistent: true,
+		Default:    false,
+	}
+
+	MaxFileObjects = Config{
+		Name:       "max-file-objects",
+		Shorthand:  "",
+		Usage:      "Start a new file once a file holds this many objects, packaging the files in a zip at --output ready for upload to BloodHound CE. 0 is unlimited. Also the number of objects in each file uploaded with --upload.",
+		Persistent: true,
+		Default:    0,
+	}
+
+	MaxFileSize = Config{
+		Name:       "max-file-size",
+		Shorthand:  "",
+		Usage:      "Start a new file before a file grows beyond this many megabytes, packaging the files in a zip at --output ready for upload to BloodHound CE. 0 is unlimited.",
+		Persistent: true,
+		Default:    0,
+	}
+
 	GlobalConfig = []Config{
 		ConfigFile,
 		VerbosityLevel,
@@ -334,6 +612,11 @@ var (
 		Proxy,
 		RefreshToken,
 		Pprof,
+		HTTPRecordFile,
+		HTTPReplayFile,
+		StatsFile,
+		TraceEndpoint,
+		TraceFile,
 	}
 
 	AzureConfig = []Config{
@@ -344,11 +627,22 @@ var (
 		AzKeyPass,
 		AzRegion,
 		AzTenant,
+		AzTenants,
+		AzAllTenants,
 		AzAuthUrl,
 		AzGraphUrl,
 		AzMgmtUrl,
 		AzUsername,
 		AzPassword,
+		AzDeviceCode,
+		AzManagedIdentity,
+		AzManagedIdentityId,
+		AzFederatedTokenFile,
+		AzCli,
+		AzCliCache,
+		AzTokenCache,
+		AzTokenCachePassphrase,
+		AzTokenCacheKeyFile,
 		AzSubId,
 		AzMgmtGroupId,
 	}
@@ -364,6 +658,10 @@ var (
 		ColMaxConnsPerHost,
 		ColMaxIdleConnsPerHost,
 		ColStreamCount,
+		ColRetryMaxAttempts,
+		ColRetryMaxElapsed,
+		ColRetryStatusCodes,
+		MetricsAddress,
 	}
 )
 
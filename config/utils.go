@@ -55,6 +55,8 @@ func CheckCollectionConfigSanity(log logr.Logger) {
 	useSaneIntValues(ColMaxConnsPerHost, log)
 	useSaneIntValues(ColMaxIdleConnsPerHost, log)
 	useSaneIntValues(ColStreamCount, log)
+	useSaneIntValues(ColRetryMaxAttempts, log)
+	useSaneIntValues(ColRetryMaxElapsed, log)
 }
 
 func useSaneIntValues(c config.Config, log logr.Logger) {
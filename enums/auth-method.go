@@ -23,6 +23,10 @@ const (
 	Certificate      string = "Certificate"
 	Secret           string = "Client Secret"
 	UsernamePassword string = "Username and Password"
+	DeviceCode       string = "Device Code"
+	ManagedIdentity  string = "Managed Identity"
+	FederatedToken   string = "Workload Identity Federation"
+	AzureCLI         string = "Azure CLI Session"
 )
 
 func AuthMethods() []AuthMethod {
@@ -30,5 +34,9 @@ func AuthMethods() []AuthMethod {
 		Certificate,
 		Secret,
 		UsernamePassword,
+		DeviceCode,
+		ManagedIdentity,
+		FederatedToken,
+		AzureCLI,
 	}
 }
@@ -1,12 +1,13 @@
 module github.com/bloodhoundad/azurehound/v2
 
-go 1.20
+go 1.22
 
 require (
 	github.com/go-logr/logr v1.2.0
 	github.com/gofrs/uuid v4.1.0+incompatible
 	github.com/golang-jwt/jwt v3.2.2+incompatible
 	github.com/judwhite/go-svc v1.2.1
+	github.com/klauspost/compress v1.18.0
 	github.com/manifoldco/promptui v0.9.0
 	github.com/rs/zerolog v1.26.0
 	github.com/spf13/cobra v1.8.1
@@ -15,6 +16,7 @@ require (
 	github.com/stretchr/testify v1.7.0
 	github.com/youmark/pkcs8 v0.0.0-20201027041543-1326539a0a0a
 	go.uber.org/mock v0.2.0
+	golang.org/x/crypto v0.21.0
 	golang.org/x/net v0.23.0
 	golang.org/x/sys v0.18.0
 )
@@ -33,7 +35,6 @@ require (
 	github.com/spf13/cast v1.4.1 // indirect
 	github.com/spf13/jwalterweatherman v1.1.0 // indirect
 	github.com/subosito/gotenv v1.2.0 // indirect
-	golang.org/x/crypto v0.21.0 // indirect
 	golang.org/x/mod v0.8.0 // indirect
 	golang.org/x/text v0.14.0 // indirect
 	golang.org/x/tools v0.6.0 // indirect
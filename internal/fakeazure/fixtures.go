@@ -0,0 +1,310 @@
+// Copyright (C) 2024 Specter Ops, Inc.
+//
+// This file is part of AzureHound.
+//
+// AzureHound is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// AzureHound is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package fakeazure
+
+import (
+	"fmt"
+	"math/rand"
+
+	"github.com/bloodhoundad/azurehound/v2/constants"
+)
+
+const defaultDomain = "contoso.onmicrosoft.com"
+
+// Fixtures are the objects served by the fake server, keyed by the path of the collection they are listed from
+type Fixtures struct {
+	TenantId    string
+	Collections map[string][]map[string]interface{}
+
+	rand *rand.Rand
+}
+
+// NewFixtures generates a small tenant with a subscription and a few of every kind of object AzureHound collects.
+// The same seed always generates the same fixtures.
+func NewFixtures(seed int64) *Fixtures {
+	s := &Fixtures{
+		Collections: make(map[string][]map[string]interface{}),
+		rand:        rand.New(rand.NewSource(seed)),
+	}
+	s.TenantId = s.uuid()
+	s.generateDirectory()
+	s.generateResources()
+	return s
+}
+
+// Add appends objects to the collection at the path
+func (s *Fixtures) Add(path string, objects ...map[string]interface{}) {
+	s.Collections[path] = append(s.Collections[path], objects...)
+}
+
+// uuid returns a random version 4 UUID drawn from the seeded source
+func (s *Fixtures) uuid() string {
+	b := make([]byte, 16)
+	s.rand.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+func directoryObject(odataType string, id string, displayName string) map[string]interface{} {
+	return map[string]interface{}{
+		"@odata.type": odataType,
+		"id":          id,
+		"displayName": displayName,
+	}
+}
+
+func (s *Fixtures) generateDirectory() {
+	s.Add("/v1.0/organization", map[string]interface{}{
+		"id":          s.TenantId,
+		"displayName": "Contoso",
+		"tenantType":  "AAD",
+		"verifiedDomains": []map[string]interface{}{
+			{"name": defaultDomain, "isDefault": true, "isInitial": true, "type": "Managed"},
+		},
+	})
+	s.Add("/tenants", map[string]interface{}{
+		"id":             "/tenants/" + s.TenantId,
+		"tenantId":       s.TenantId,
+		"displayName":    "Contoso",
+		"defaultDomain":  defaultDomain,
+		"domains":        []string{defaultDomain},
+		"tenantCategory": "Home",
+		"tenantType":     "AAD",
+	})
+
+	var users []map[string]interface{}
+	for i := 0; i < 5; i++ {
+		name := fmt.Sprintf("user%d", i)
+		user := directoryObject("#microsoft.graph.user", s.uuid(), name)
+		user["userPrincipalName"] = name + "@" + defaultDomain
+		user["accountEnabled"] = true
+		users = append(users, user)
+	}
+	s.Add("/v1.0/users", users...)
+
+	for i := 0; i < 4; i++ {
+		group := directoryObject("#microsoft.graph.group", s.uuid(), fmt.Sprintf("group%d", i))
+		// the last group is a distribution list that is filtered out of collection
+		group["securityEnabled"] = i < 3
+		group["mailEnabled"] = i == 3
+		s.Add("/v1.0/groups", group)
+
+		path := fmt.Sprintf("/beta/groups/%s", group["id"])
+		s.Add(path+"/owners", users[s.rand.Intn(len(users))])
+		for _, j := range s.rand.Perm(len(users))[:1+s.rand.Intn(len(users))] {
+			s.Add(path+"/members", users[j])
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		var (
+			name = fmt.Sprintf("app%d", i)
+			app  = directoryObject("#microsoft.graph.application", s.uuid(), name)
+			sp   = directoryObject("#microsoft.graph.servicePrincipal", s.uuid(), name)
+		)
+		app["appId"] = s.uuid()
+		sp["appId"] = app["appId"]
+		sp["appOwnerOrganizationId"] = s.TenantId
+		sp["servicePrincipalType"] = "Application"
+		sp["accountEnabled"] = true
+		s.Add("/v1.0/applications", app)
+		s.Add(fmt.Sprintf("/beta/applications/%s/owners", app["id"]), users[i])
+		s.Add(fmt.Sprintf("/beta/servicePrincipals/%s/owners", sp["id"]), users[i+1])
+
+		// only service principals exposing app roles have app role assignments collected
+		if i > 0 {
+			appRoleId := s.uuid()
+			sp["appRoles"] = []map[string]interface{}{
+				{"id": appRoleId, "value": "Data.Read", "displayName": "Read data", "isEnabled": true, "allowedMemberTypes": []string{"User"}},
+			}
+			s.Add(fmt.Sprintf("/v1.0/servicePrincipals/%s/appRoleAssignedTo", sp["id"]), map[string]interface{}{
+				"id":                   s.uuid(),
+				"appRoleId":            appRoleId,
+				"principalId":          users[i]["id"],
+				"principalDisplayName": users[i]["displayName"],
+				"principalType":        "User",
+				"resourceId":           sp["id"],
+				"resourceDisplayName":  name,
+			})
+		}
+		s.Add("/v1.0/servicePrincipals", sp)
+	}
+
+	for i := 0; i < 3; i++ {
+		device := directoryObject("#microsoft.graph.device", s.uuid(), fmt.Sprintf("device%d", i))
+		device["deviceId"] = s.uuid()
+		device["operatingSystem"] = "Windows"
+		s.Add("/v1.0/devices", device)
+		s.Add(fmt.Sprintf("/beta/devices/%s/registeredOwners", device["id"]), users[i])
+	}
+
+	for i, templateId := range []string{constants.GlobalAdministratorRoleID, constants.ApplicationAdministratorRoleID} {
+		role := directoryObject("#microsoft.graph.unifiedRoleDefinition", templateId, fmt.Sprintf("role%d", i))
+		role["templateId"] = templateId
+		role["isBuiltIn"] = true
+		role["isEnabled"] = true
+		s.Add("/v1.0/roleManagement/directory/roleDefinitions", role)
+		s.Add("/v1.0/roleManagement/directory/roleAssignments", map[string]interface{}{
+			"id":               s.uuid(),
+			"roleDefinitionId": templateId,
+			"principalId":      users[i]["id"],
+			"directoryScopeId": "/",
+		})
+	}
+}
+
+func (s *Fixtures) generateResources() {
+	var (
+		subscriptionId  = s.uuid()
+		subscription    = "/subscriptions/" + subscriptionId
+		resourceGroup   = subscription + "/resourceGroups/rg0"
+		managementGroup = "/providers/Microsoft.Management/managementGroups/" + s.TenantId
+		principals      = s.Collections["/v1.0/users"]
+	)
+
+	// roleAssignments grants each principal the role on the resource
+	roleAssignments := func(resourceId string, roles ...string) {
+		path := resourceId + "/providers/Microsoft.Authorization/roleAssignments"
+		s.Collections[path] = []map[string]interface{}{}
+		for i, role := range roles {
+			name := s.uuid()
+			s.Add(path, map[string]interface{}{
+				"id":   path + "/" + name,
+				"name": name,
+				"type": "Microsoft.Authorization/roleAssignments",
+				"properties": map[string]interface{}{
+					"principalId":      principals[i%len(principals)]["id"],
+					"roleDefinitionId": subscription + "/providers/Microsoft.Authorization/roleDefinitions/" + role,
+					"scope":            resourceId,
+				},
+			})
+		}
+	}
+
+	s.Add("/providers/Microsoft.Management/managementGroups", map[string]interface{}{
+		"id":   managementGroup,
+		"name": s.TenantId,
+		"type": "Microsoft.Management/managementGroups",
+		"properties": map[string]interface{}{
+			"displayName": "Tenant Root Group",
+			"tenantId":    s.TenantId,
+		},
+	})
+	s.Add(managementGroup+"/descendants", map[string]interface{}{
+		"id":   subscription,
+		"name": subscriptionId,
+		"type": "Microsoft.Management/managementGroups/subscriptions",
+		"properties": map[string]interface{}{
+			"display_name": "subscription0",
+			"parent":       map[string]interface{}{"id": managementGroup},
+		},
+	})
+	roleAssignments(managementGroup, constants.OwnerRoleID, constants.UserAccessAdminRoleID)
+
+	s.Add("/subscriptions", map[string]interface{}{
+		"id":             subscription,
+		"subscriptionId": subscriptionId,
+		"tenantId":       s.TenantId,
+		"displayName":    "subscription0",
+		"state":          "Enabled",
+	})
+	roleAssignments(subscription, constants.OwnerRoleID, constants.UserAccessAdminRoleID, constants.ContributorRoleID)
+
+	s.Add(subscription+"/resourcegroups", map[string]interface{}{
+		"id":         resourceGroup,
+		"name":       "rg0",
+		"type":       "Microsoft.Resources/resourceGroups",
+		"location":   "eastus",
+		"properties": map[string]interface{}{"provisioningState": "Succeeded"},
+	})
+	roleAssignments(resourceGroup, constants.OwnerRoleID, constants.UserAccessAdminRoleID)
+
+	resources := []struct {
+		provider   string
+		count      int
+		kind       func(i int) string
+		roles      []string
+		properties func(i int) map[string]interface{}
+	}{
+		{
+			provider: "Microsoft.KeyVault/vaults",
+			count:    2,
+			roles:    []string{constants.OwnerRoleID, constants.ContributorRoleID, constants.KeyVaultContributorRoleID, constants.UserAccessAdminRoleID},
+			properties: func(i int) map[string]interface{} {
+				return map[string]interface{}{
+					"tenantId": s.TenantId,
+					"accessPolicies": []map[string]interface{}{{
+						"tenantId": s.TenantId,
+						"objectId": principals[i]["id"],
+						"permissions": map[string]interface{}{
+							"keys":         []string{"Get", "List"},
+							"secrets":      []string{"Get"},
+							"certificates": []string{"List"},
+						},
+					}},
+				}
+			},
+		},
+		{
+			provider: "Microsoft.Compute/virtualMachines",
+			count:    2,
+			roles:    []string{constants.OwnerRoleID, constants.AvereContributorRoleID, constants.ContributorRoleID, constants.VirtualMachineAdministratorLoginRoleID, constants.UserAccessAdminRoleID},
+			properties: func(i int) map[string]interface{} {
+				return map[string]interface{}{"vmId": s.uuid()}
+			},
+		},
+		{provider: "Microsoft.Compute/virtualMachineScaleSets", count: 1, roles: []string{constants.ContributorRoleID}},
+		{provider: "Microsoft.Automation/automationAccounts", count: 1, roles: []string{constants.ContributorRoleID}},
+		{provider: "Microsoft.ContainerRegistry/registries", count: 1, roles: []string{constants.OwnerRoleID}},
+		{provider: "Microsoft.Logic/workflows", count: 1, roles: []string{constants.ContributorRoleID}},
+		{provider: "Microsoft.ContainerService/managedClusters", count: 1, roles: []string{constants.ContributorRoleID}},
+		{
+			provider: "Microsoft.Web/sites",
+			count:    2,
+			kind: func(i int) string {
+				return []string{"functionapp", "app"}[i]
+			},
+			roles: []string{constants.OwnerRoleID},
+		},
+	}
+
+	for _, resource := range resources {
+		s.Collections[subscription+"/providers/"+resource.provider] = []map[string]interface{}{}
+		for i := 0; i < resource.count; i++ {
+			var (
+				name   = fmt.Sprintf("resource%d", i)
+				id     = resourceGroup + "/providers/" + resource.provider + "/" + name
+				object = map[string]interface{}{
+					"id":       id,
+					"name":     name,
+					"type":     resource.provider,
+					"location": "eastus",
+				}
+			)
+			if resource.kind != nil {
+				object["kind"] = resource.kind(i)
+			}
+			if resource.properties != nil {
+				object["properties"] = resource.properties(i)
+			}
+			s.Add(subscription+"/providers/"+resource.provider, object)
+			roleAssignments(id, resource.roles...)
+		}
+	}
+}
@@ -0,0 +1,275 @@
+// Copyright (C) 2024 Specter Ops, Inc.
+//
+// This file is part of AzureHound.
+//
+// AzureHound is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// AzureHound is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package fakeazure serves the Microsoft Graph, Azure Resource Manager and token endpoints AzureHound calls from
+// seeded fixture data, paging responses and injecting throttling and server errors like the real APIs do.
+package fakeazure
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bloodhoundad/azurehound/v2/client/config"
+)
+
+const (
+	DefaultPageSize = 2 // Small enough that every collection with more than a couple objects is paged
+	AccessToken     = "fake-access-token"
+)
+
+// Fault fails requests whose path contains Path with Status, Count times
+type Fault struct {
+	Path   string
+	Status int
+	Count  int
+}
+
+// Server is a fake Azure API server backed by fixtures
+type Server struct {
+	*httptest.Server
+	Fixtures *Fixtures
+	PageSize int
+
+	mutex    sync.Mutex
+	faults   []*Fault
+	requests map[string]int
+}
+
+// NewServer starts a server serving the fixtures. Close the server when done.
+func NewServer(fixtures *Fixtures) *Server {
+	server := &Server{
+		Fixtures: fixtures,
+		PageSize: DefaultPageSize,
+		requests: make(map[string]int),
+	}
+	server.Server = httptest.NewServer(http.HandlerFunc(server.serveHTTP))
+	return server
+}
+
+// ClientConfig returns a client configuration that authenticates with a client secret and sends both Graph and
+// Resource Manager requests to the server
+func (s *Server) ClientConfig() config.Config {
+	return config.Config{
+		ApplicationId: "00000000-0000-0000-0000-000000000000",
+		Authority:     s.URL,
+		ClientSecret:  "fake-client-secret",
+		Graph:         s.URL,
+		Management:    s.URL,
+		Tenant:        s.Fixtures.TenantId,
+	}
+}
+
+// Inject fails the next requests matching the fault. Throttling and unavailable responses ask to be retried
+// immediately.
+func (s *Server) Inject(fault Fault) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.faults = append(s.faults, &fault)
+}
+
+// Requests returns the number of requests made to the path, including those within batches
+func (s *Server) Requests(path string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.requests[path]
+}
+
+func (s *Server) fault(path string) (int, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.requests[path]++
+	for _, fault := range s.faults {
+		if fault.Count > 0 && strings.Contains(path, fault.Path) {
+			fault.Count--
+			return fault.Status, true
+		}
+	}
+	return 0, false
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if status, ok := s.fault(r.URL.Path); ok {
+		w.Header().Set("Retry-After", "0")
+		writeJSON(w, status, errorBody(status))
+	} else if r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/oauth2/v2.0/token") {
+		s.serveToken(w, r)
+	} else if r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/$batch") {
+		s.serveBatch(w, r)
+	} else if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, errorBody(http.StatusMethodNotAllowed))
+	} else if r.Header.Get("Authorization") != "Bearer "+AccessToken {
+		writeJSON(w, http.StatusUnauthorized, errorBody(http.StatusUnauthorized))
+	} else {
+		s.serveList(w, r)
+	}
+}
+
+func (s *Server) serveToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorBody(http.StatusBadRequest))
+	} else if r.PostForm.Get("client_secret") == "" && r.PostForm.Get("client_assertion") == "" && r.PostForm.Get("refresh_token") == "" {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+	} else {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"token_type":     "Bearer",
+			"access_token":   AccessToken,
+			"expires_in":     3600,
+			"ext_expires_in": 3600,
+		})
+	}
+}
+
+// serveList serves a page of a collection, filtered by simple $filter equality expressions
+func (s *Server) serveList(w http.ResponseWriter, r *http.Request) {
+	var (
+		graph    = strings.HasPrefix(r.URL.Path, "/v1.0/") || strings.HasPrefix(r.URL.Path, "/beta/")
+		query    = r.URL.Query()
+		pageSize = s.PageSize
+		skip     = 0
+	)
+
+	objects, ok := s.Fixtures.Collections[strings.TrimSuffix(r.URL.Path, "/")]
+	if !ok {
+		writeJSON(w, http.StatusNotFound, errorBody(http.StatusNotFound))
+		return
+	}
+
+	if filter := query.Get("$filter"); filter != "" {
+		objects = filterObjects(objects, filter)
+	}
+	if top, err := strconv.Atoi(query.Get("$top")); err == nil && top > 0 && top < pageSize {
+		pageSize = top
+	}
+	if skipToken, err := strconv.Atoi(query.Get("$skiptoken")); err == nil {
+		skip = skipToken
+	}
+
+	var (
+		end  = skip + pageSize
+		body = map[string]interface{}{}
+	)
+	if skip > len(objects) {
+		skip = len(objects)
+	}
+	if end < len(objects) {
+		next := url.URL{Scheme: "http", Host: r.Host, Path: r.URL.Path}
+		query.Set("$skiptoken", strconv.Itoa(end))
+		next.RawQuery = query.Encode()
+		if graph {
+			body["@odata.nextLink"] = next.String()
+		} else {
+			body["nextLink"] = next.String()
+		}
+	} else {
+		end = len(objects)
+	}
+	body["value"] = objects[skip:end]
+	writeJSON(w, http.StatusOK, body)
+}
+
+// serveBatch serves each request within a JSON batch as if it were sent on its own
+// See https://learn.microsoft.com/en-us/graph/json-batching
+func (s *Server) serveBatch(w http.ResponseWriter, r *http.Request) {
+	var (
+		version = strings.TrimSuffix(r.URL.Path, "/$batch")
+		batch   struct {
+			Requests []struct {
+				Id      string            `json:"id"`
+				Method  string            `json:"method"`
+				Url     string            `json:"url"`
+				Headers map[string]string `json:"headers"`
+			} `json:"requests"`
+		}
+		responses = []map[string]interface{}{}
+	)
+
+	if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorBody(http.StatusBadRequest))
+		return
+	}
+
+	for _, request := range batch.Requests {
+		recorder := httptest.NewRecorder()
+		if req, err := http.NewRequest(request.Method, version+request.Url, nil); err != nil {
+			writeJSON(recorder, http.StatusBadRequest, errorBody(http.StatusBadRequest))
+		} else {
+			req.Host = r.Host
+			req.Header.Set("Authorization", r.Header.Get("Authorization"))
+			for key, value := range request.Headers {
+				req.Header.Set(key, value)
+			}
+			s.serveHTTP(recorder, req)
+		}
+
+		headers := map[string]string{}
+		for key := range recorder.Header() {
+			headers[key] = recorder.Header().Get(key)
+		}
+		responses = append(responses, map[string]interface{}{
+			"id":      request.Id,
+			"status":  recorder.Code,
+			"headers": headers,
+			"body":    json.RawMessage(recorder.Body.Bytes()),
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"responses": responses})
+}
+
+// filterObjects applies filters of the form `property eq 'value'` or `property eq true`, joined by `and`. Other
+// filters such as atScope() are ignored.
+func filterObjects(objects []map[string]interface{}, filter string) []map[string]interface{} {
+	filtered := objects
+	for _, clause := range strings.Split(filter, " and ") {
+		if parts := strings.SplitN(strings.TrimSpace(clause), " eq ", 2); len(parts) == 2 {
+			var (
+				property = parts[0]
+				value    = strings.Trim(parts[1], "'")
+				matches  = []map[string]interface{}{}
+			)
+			for _, object := range filtered {
+				if fmt.Sprint(object[property]) == value {
+					matches = append(matches, object)
+				}
+			}
+			filtered = matches
+		}
+	}
+	return filtered
+}
+
+func errorBody(status int) map[string]interface{} {
+	return map[string]interface{}{
+		"error": map[string]string{
+			"code":    strings.ReplaceAll(http.StatusText(status), " ", ""),
+			"message": http.StatusText(status),
+		},
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,298 @@
+// Copyright (C) 2024 Specter Ops, Inc.
+//
+// This file is part of AzureHound.
+//
+// AzureHound is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// AzureHound is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package metrics records the requests AzureHound makes and the objects each collection stage emits, so a run can be
+// summarized once it finishes.
+package metrics
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bloodhoundad/azurehound/v2/enums"
+)
+
+// LatencyBuckets are the upper bounds of the request latency histogram buckets
+var LatencyBuckets = []time.Duration{
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	1 * time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+	30 * time.Second,
+}
+
+var (
+	current      = NewRecorder()
+	currentMutex sync.RWMutex
+
+	uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+)
+
+// Current returns the recorder of the current run
+func Current() *Recorder {
+	currentMutex.RLock()
+	defer currentMutex.RUnlock()
+	return current
+}
+
+// Reset starts a new run, returning the recorder of the previous one
+func Reset() *Recorder {
+	currentMutex.Lock()
+	defer currentMutex.Unlock()
+	previous := current
+	current = NewRecorder()
+	return previous
+}
+
+// Request is the outcome of a request, including every attempt made to complete it
+type Request struct {
+	Endpoint  string        // The endpoint as returned by Endpoint
+	Attempts  int           // The number of times the request was sent
+	Throttles int           // The number of attempts that were throttled
+	Latency   time.Duration // The time taken by every attempt and the delays between them
+	Failed    bool          // Whether the request was ultimately unsuccessful
+}
+
+// Histogram counts observations into LatencyBuckets, the last bucket counting those greater than every bound
+type Histogram struct {
+	Buckets []int64
+	Count   int64
+	Sum     time.Duration
+}
+
+func (s *Histogram) observe(value time.Duration) {
+	if s.Buckets == nil {
+		s.Buckets = make([]int64, len(LatencyBuckets)+1)
+	}
+	i := 0
+	for i < len(LatencyBuckets) && value > LatencyBuckets[i] {
+		i++
+	}
+	s.Buckets[i]++
+	s.Count++
+	s.Sum += value
+}
+
+// EndpointStats are the totals of the requests made to an endpoint
+type EndpointStats struct {
+	Requests  int64
+	Retries   int64
+	Throttles int64
+	Failures  int64
+	Bytes     int64
+	Latency   Histogram
+}
+
+// StageStats are the totals of a collection stage
+type StageStats struct {
+	Start    time.Time
+	End      time.Time
+	Requests int64
+	Failures int64 // Requests that were ultimately unsuccessful
+	Errors   int64 // Listings abandoned because of an error, losing whatever was left to list
+	Objects  map[enums.Kind]int64
+}
+
+// Recorder records the requests and stages of a run
+type Recorder struct {
+	mutex     sync.Mutex
+	start     time.Time
+	endpoints map[string]*EndpointStats
+	stages    map[string]*StageStats
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{
+		start:     time.Now(),
+		endpoints: make(map[string]*EndpointStats),
+		stages:    make(map[string]*StageStats),
+	}
+}
+
+func (s *Recorder) endpoint(endpoint string) *EndpointStats {
+	if stats, ok := s.endpoints[endpoint]; ok {
+		return stats
+	} else {
+		stats := &EndpointStats{}
+		s.endpoints[endpoint] = stats
+		return stats
+	}
+}
+
+// Record records the outcome of a request against its endpoint
+func (s *Recorder) Record(request Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	endpoint := s.endpoint(request.Endpoint)
+	endpoint.Requests++
+	if request.Attempts > 1 {
+		endpoint.Retries += int64(request.Attempts - 1)
+	}
+	endpoint.Throttles += int64(request.Throttles)
+	endpoint.Latency.observe(request.Latency)
+	if request.Failed {
+		endpoint.Failures++
+	}
+}
+
+// CountBytes wraps the body of a response, recording the bytes read from it against the endpoint
+func (s *Recorder) CountBytes(endpoint string, body io.ReadCloser) io.ReadCloser {
+	return countingReader{ReadCloser: body, recorder: s, endpoint: endpoint}
+}
+
+type countingReader struct {
+	io.ReadCloser
+	recorder *Recorder
+	endpoint string
+}
+
+func (s countingReader) Read(p []byte) (int, error) {
+	n, err := s.ReadCloser.Read(p)
+	if n > 0 {
+		s.recorder.mutex.Lock()
+		s.recorder.endpoint(s.endpoint).Bytes += int64(n)
+		s.recorder.mutex.Unlock()
+	}
+	return n, err
+}
+
+// Stage begins recording the named collection stage
+func (s *Recorder) Stage(name string) *Stage {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stats, ok := s.stages[name]
+	if !ok {
+		stats = &StageStats{Start: time.Now(), Objects: make(map[enums.Kind]int64)}
+		s.stages[name] = stats
+	}
+	return &Stage{recorder: s, stats: stats}
+}
+
+// Stage records the objects a collection stage emits and the requests it makes. A nil stage records nothing.
+type Stage struct {
+	recorder *Recorder
+	stats    *StageStats
+}
+
+// Emit counts an object of the kind emitted by the stage
+func (s *Stage) Emit(kind enums.Kind) {
+	if s != nil {
+		s.recorder.mutex.Lock()
+		defer s.recorder.mutex.Unlock()
+		s.stats.Objects[kind]++
+	}
+}
+
+// Request counts a request made by the stage
+func (s *Stage) Request(failed bool) {
+	if s != nil {
+		s.recorder.mutex.Lock()
+		defer s.recorder.mutex.Unlock()
+		s.stats.Requests++
+		if failed {
+			s.stats.Failures++
+		}
+	}
+}
+
+// Error counts a listing the stage abandoned because of an error
+func (s *Stage) Error() {
+	if s != nil {
+		s.recorder.mutex.Lock()
+		defer s.recorder.mutex.Unlock()
+		s.stats.Errors++
+	}
+}
+
+// Done marks the stage finished
+func (s *Stage) Done() {
+	if s != nil {
+		s.recorder.mutex.Lock()
+		defer s.recorder.mutex.Unlock()
+		s.stats.End = time.Now()
+	}
+}
+
+type stageKey struct{}
+
+// WithStage begins recording the named stage of the current run, returning a context that attributes the requests
+// made with it to the stage
+func WithStage(ctx context.Context, name string) (context.Context, *Stage) {
+	stage := Current().Stage(name)
+	return context.WithValue(ctx, stageKey{}, stage), stage
+}
+
+// StageFromContext returns the stage the context was created for, or nil
+func StageFromContext(ctx context.Context) *Stage {
+	stage, _ := ctx.Value(stageKey{}).(*Stage)
+	return stage
+}
+
+// RecordRequest records the outcome of a request with the current run, attributing it to the stage of the context, and
+// adds it to the totals of its host
+func RecordRequest(ctx context.Context, request Request) {
+	Current().Record(request)
+	recordHostTotals(request)
+	StageFromContext(ctx).Request(request.Failed)
+}
+
+// CountBytes wraps the body of a response, recording the bytes read from it against the endpoint with the current run
+func CountBytes(endpoint string, body io.ReadCloser) io.ReadCloser {
+	return Current().CountBytes(endpoint, body)
+}
+
+// Endpoint identifies the endpoint of a request by its method, host and path, with the IDs and names of objects in the
+// path replaced so that requests for different objects of the same kind share an endpoint.
+//
+// For example:
+// - GET graph.microsoft.com/beta/groups/{id}/owners
+// - GET management.azure.com/subscriptions/{id}/resourceGroups/{name}/providers/Microsoft.KeyVault/vaults/{name}/providers/Microsoft.Authorization/roleAssignments
+func Endpoint(method string, endpoint *url.URL) string {
+	var (
+		segments = strings.Split(strings.Trim(endpoint.Path, "/"), "/")
+		provider = -1 // The index of the resource provider namespace in an ARM path
+	)
+	for i, segment := range segments {
+		previous := ""
+		if i > 0 {
+			previous = strings.ToLower(segments[i-1])
+		}
+
+		if previous == "providers" {
+			provider = i
+		} else if uuidPattern.MatchString(segment) {
+			segments[i] = "{id}"
+		} else if previous == "subscriptions" || previous == "resourcegroups" {
+			segments[i] = "{name}"
+		} else if provider >= 0 && (i-provider)%2 == 0 && strings.ToLower(segment) != "providers" {
+			// resource types and names alternate after the provider namespace
+			segments[i] = "{name}"
+		}
+	}
+	return method + " " + endpoint.Host + "/" + strings.Join(segments, "/")
+}
@@ -0,0 +1,107 @@
+// Copyright (C) 2024 Specter Ops, Inc.
+//
+// This file is part of AzureHound.
+//
+// AzureHound is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// AzureHound is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bloodhoundad/azurehound/v2/enums"
+)
+
+func TestEndpoint(t *testing.T) {
+	cases := map[string]string{
+		"https://graph.microsoft.com/beta/groups/6c2b8e3a-1f0e-4c2b-9a55-3f1f0c1c2d3e/owners?$top=999":   "GET graph.microsoft.com/beta/groups/{id}/owners",
+		"https://graph.microsoft.com/v1.0/users":                                                         "GET graph.microsoft.com/v1.0/users",
+		"https://management.azure.com/subscriptions/6c2b8e3a-1f0e-4c2b-9a55-3f1f0c1c2d3e/resourcegroups": "GET management.azure.com/subscriptions/{id}/resourcegroups",
+		"https://management.azure.com/subscriptions/6c2b8e3a-1f0e-4c2b-9a55-3f1f0c1c2d3e/resourceGroups/rg/providers/Microsoft.KeyVault/vaults/kv/providers/Microsoft.Authorization/roleAssignments": "GET management.azure.com/subscriptions/{id}/resourceGroups/{name}/providers/Microsoft.KeyVault/vaults/{name}/providers/Microsoft.Authorization/roleAssignments",
+		"https://management.azure.com/providers/Microsoft.Management/managementGroups/root/descendants":                                                                                              "GET management.azure.com/providers/Microsoft.Management/managementGroups/{name}/descendants",
+	}
+	for raw, expected := range cases {
+		if endpoint, err := url.Parse(raw); err != nil {
+			t.Fatal(err)
+		} else if actual := Endpoint("GET", endpoint); actual != expected {
+			t.Errorf("got %s, want %s", actual, expected)
+		}
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	Reset()
+	defer Reset()
+
+	ctx, stage := WithStage(context.Background(), "groups")
+	RecordRequest(ctx, Request{Endpoint: "GET example.com/groups", Attempts: 3, Throttles: 2, Latency: 75 * time.Millisecond})
+	RecordRequest(ctx, Request{Endpoint: "GET example.com/groups", Attempts: 1, Latency: time.Minute, Failed: true})
+	RecordRequest(context.Background(), Request{Endpoint: "GET example.com/users", Attempts: 1, Latency: time.Millisecond})
+	stage.Emit(enums.KindAZGroup)
+	stage.Emit(enums.KindAZGroup)
+	stage.Error()
+	stage.Done()
+
+	body := CountBytes("GET example.com/users", io.NopCloser(strings.NewReader("0123456789")))
+	io.Copy(io.Discard, body)
+
+	summary := Current().Summary()
+	if len(summary.Stages) != 1 {
+		t.Fatalf("got %d stages, want 1", len(summary.Stages))
+	} else if groups := summary.Stages[0]; groups.Requests != 2 || groups.Failures != 1 || groups.Errors != 1 || groups.Objects[enums.KindAZGroup] != 2 {
+		t.Errorf("got stage %+v, want 2 requests, 1 failure, 1 error and 2 groups", groups)
+	} else if summary.Objects[enums.KindAZGroup] != 2 {
+		t.Errorf("got %d groups in total, want 2", summary.Objects[enums.KindAZGroup])
+	}
+
+	if len(summary.Endpoints) != 2 {
+		t.Fatalf("got %d endpoints, want 2", len(summary.Endpoints))
+	} else if groups := summary.Endpoints[0]; groups.Requests != 2 || groups.Retries != 2 || groups.Throttles != 2 || groups.Failures != 1 {
+		t.Errorf("got endpoint %+v, want 2 requests, 2 retries, 2 throttles and 1 failure", groups)
+	} else if buckets := groups.Latency.Buckets; buckets[0].Count != 0 || buckets[1].Count != 1 || buckets[len(buckets)-1].Count != 2 {
+		t.Errorf("got latency buckets %+v, want a request in the 100ms bucket and one beyond every bound", buckets)
+	} else if users := summary.Endpoints[1]; users.Bytes != 10 {
+		t.Errorf("got %d bytes, want 10", users.Bytes)
+	}
+
+	var table bytes.Buffer
+	if err := summary.WriteTable(&table); err != nil {
+		t.Fatal(err)
+	} else if !strings.Contains(table.String(), "AZGroup=2") || !strings.Contains(table.String(), "GET example.com/users") {
+		t.Errorf("table is missing stages or endpoints:\n%s", table.String())
+	}
+
+	var (
+		path    = filepath.Join(t.TempDir(), "stats.json")
+		decoded Summary
+	)
+	if err := summary.WriteFile(path); err != nil {
+		t.Fatal(err)
+	} else if data, err := os.ReadFile(path); err != nil {
+		t.Fatal(err)
+	} else if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	} else if len(decoded.Endpoints) != 2 || decoded.Stages[0].Name != "groups" {
+		t.Errorf("got %s, want the summary as JSON", data)
+	}
+}
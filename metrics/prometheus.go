@@ -0,0 +1,169 @@
+// Copyright (C) 2024 Specter Ops, Inc.
+//
+// This file is part of AzureHound.
+//
+// AzureHound is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// AzureHound is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	PrometheusContentType  = "text/plain; version=0.0.4; charset=utf-8"
+	OpenMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+)
+
+// Handler serves the service totals in the Prometheus text exposition format, or as OpenMetrics when the scraper
+// accepts it
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		openMetrics := strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text")
+		if openMetrics {
+			w.Header().Set("Content-Type", OpenMetricsContentType)
+		} else {
+			w.Header().Set("Content-Type", PrometheusContentType)
+		}
+		WritePrometheus(w, openMetrics)
+	})
+}
+
+// WritePrometheus writes the service totals in the Prometheus text exposition format, or as OpenMetrics
+func WritePrometheus(w io.Writer, openMetrics bool) error {
+	var (
+		out          = exposition{Writer: bufio.NewWriter(w), openMetrics: openMetrics}
+		job          = Job()
+		hosts, stats = Hosts()
+	)
+
+	out.family("azurehound_job_id", "gauge", "The ID of the job being run, or 0 when no job is running.")
+	out.sample("azurehound_job_id", nil, float64(job))
+
+	out.family("azurehound_job_running", "gauge", "Whether a job is being run.")
+	if job != 0 {
+		out.sample("azurehound_job_running", nil, 1)
+	} else {
+		out.sample("azurehound_job_running", nil, 0)
+	}
+
+	if checkin := LastCheckin(); !checkin.IsZero() {
+		out.family("azurehound_last_checkin_timestamp_seconds", "gauge", "When the last successful checkin with BloodHound Enterprise happened.")
+		out.sample("azurehound_last_checkin_timestamp_seconds", nil, float64(checkin.UnixNano())/float64(time.Second))
+		out.family("azurehound_seconds_since_last_checkin", "gauge", "The time since the last successful checkin with BloodHound Enterprise.")
+		out.sample("azurehound_seconds_since_last_checkin", nil, time.Since(checkin).Seconds())
+	}
+
+	out.counter("azurehound_ingest_batches", "The batches of collected data sent to BloodHound Enterprise.", nil, ingestBatches.Load())
+	out.counter("azurehound_ingest_batches_failed", "The batches of collected data BloodHound Enterprise did not accept.", nil, ingestBatchesFailed.Load())
+	out.counter("azurehound_token_refresh_failures", "The unsuccessful requests for an access token.", nil, tokenRefreshFailures.Load())
+
+	counters := []struct {
+		name  string
+		help  string
+		value func(HostTotals) int64
+	}{
+		{"azurehound_azure_requests", "The requests made to an Azure API host.", func(s HostTotals) int64 { return s.Requests }},
+		{"azurehound_azure_retries", "The attempts made to complete requests to an Azure API host beyond the first.", func(s HostTotals) int64 { return s.Retries }},
+		{"azurehound_azure_throttles", "The attempts throttled by an Azure API host.", func(s HostTotals) int64 { return s.Throttles }},
+		{"azurehound_azure_failures", "The requests to an Azure API host that were ultimately unsuccessful.", func(s HostTotals) int64 { return s.Failures }},
+	}
+	for _, counter := range counters {
+		if len(hosts) > 0 {
+			out.family(counter.name, "counter", counter.help)
+		}
+		for i, host := range hosts {
+			out.sample(counter.name+"_total", []string{"host", host}, float64(counter.value(stats[i])))
+		}
+	}
+
+	if len(hosts) > 0 {
+		out.family("azurehound_azure_request_duration_seconds", "histogram", "The time taken to complete requests to an Azure API host, including retries.")
+	}
+	for i, host := range hosts {
+		var (
+			latency = stats[i].Latency
+			count   int64
+		)
+		for b, bound := range LatencyBuckets {
+			if b < len(latency.Buckets) {
+				count += latency.Buckets[b]
+			}
+			out.sample("azurehound_azure_request_duration_seconds_bucket", []string{"host", host, "le", formatFloat(bound.Seconds())}, float64(count))
+		}
+		out.sample("azurehound_azure_request_duration_seconds_bucket", []string{"host", host, "le", "+Inf"}, float64(latency.Count))
+		out.sample("azurehound_azure_request_duration_seconds_sum", []string{"host", host}, latency.Sum.Seconds())
+		out.sample("azurehound_azure_request_duration_seconds_count", []string{"host", host}, float64(latency.Count))
+	}
+
+	if openMetrics {
+		out.WriteString("# EOF\n")
+	}
+	return out.Flush()
+}
+
+type exposition struct {
+	*bufio.Writer
+	openMetrics bool
+}
+
+// family writes the metadata of a metric family. Prometheus names a counter family after its samples, ending _total,
+// while OpenMetrics names it without the suffix.
+func (s exposition) family(name, kind, help string) {
+	if kind == "counter" && !s.openMetrics {
+		name += "_total"
+	}
+	fmt.Fprintf(s, "# HELP %s %s\n# TYPE %s %s\n", name, escape(help, false), name, kind)
+}
+
+func (s exposition) counter(name, help string, labels []string, value int64) {
+	s.family(name, "counter", help)
+	s.sample(name+"_total", labels, float64(value))
+}
+
+// sample writes a sample with its labels given as name and value pairs
+func (s exposition) sample(name string, labels []string, value float64) {
+	s.WriteString(name)
+	if len(labels) > 0 {
+		s.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				s.WriteByte(',')
+			}
+			fmt.Fprintf(s, "%s=\"%s\"", labels[i], escape(labels[i+1], true))
+		}
+		s.WriteByte('}')
+	}
+	s.WriteByte(' ')
+	s.WriteString(formatFloat(value))
+	s.WriteByte('\n')
+}
+
+func escape(value string, quoted bool) string {
+	replacer := strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	if quoted {
+		replacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+	}
+	return replacer.Replace(value)
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
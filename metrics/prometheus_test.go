@@ -0,0 +1,86 @@
+// Copyright (C) 2024 Specter Ops, Inc.
+//
+// This file is part of AzureHound.
+//
+// AzureHound is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// AzureHound is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package metrics
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandler(t *testing.T) {
+	SetJob(42)
+	defer SetJob(0)
+	Checkin()
+	IngestBatch(false)
+	IngestBatch(true)
+	TokenRefreshFailed()
+	RecordRequest(context.Background(), Request{Endpoint: "GET prometheus.test/v1.0/users", Attempts: 3, Throttles: 2, Latency: 75 * time.Millisecond})
+	Reset()
+
+	server := httptest.NewServer(Handler())
+	defer server.Close()
+
+	res, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(res.Body)
+	text := string(body)
+
+	if res.Header.Get("Content-Type") != PrometheusContentType {
+		t.Errorf("got content type %s, want %s", res.Header.Get("Content-Type"), PrometheusContentType)
+	}
+	for _, expected := range []string{
+		"# TYPE azurehound_job_id gauge\nazurehound_job_id 42\n",
+		"azurehound_job_running 1\n",
+		"# TYPE azurehound_ingest_batches_total counter\n",
+		"azurehound_seconds_since_last_checkin ",
+		`azurehound_azure_requests_total{host="prometheus.test"} 1`,
+		`azurehound_azure_retries_total{host="prometheus.test"} 2`,
+		`azurehound_azure_throttles_total{host="prometheus.test"} 2`,
+		`azurehound_azure_request_duration_seconds_bucket{host="prometheus.test",le="0.05"} 0`,
+		`azurehound_azure_request_duration_seconds_bucket{host="prometheus.test",le="0.1"} 1`,
+		`azurehound_azure_request_duration_seconds_bucket{host="prometheus.test",le="+Inf"} 1`,
+		`azurehound_azure_request_duration_seconds_count{host="prometheus.test"} 1`,
+	} {
+		if !strings.Contains(text, expected) {
+			t.Errorf("exposition is missing %q:\n%s", expected, text)
+		}
+	}
+	if strings.Contains(text, "# EOF") {
+		t.Error("got the OpenMetrics terminator in the Prometheus text format")
+	}
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	req.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+	if res, err := http.DefaultClient.Do(req); err != nil {
+		t.Fatal(err)
+	} else {
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+		if text := string(body); !strings.HasSuffix(text, "# EOF\n") || !strings.Contains(text, "# TYPE azurehound_ingest_batches counter\nazurehound_ingest_batches_total ") {
+			t.Errorf("got %s, want counter families named without _total and the OpenMetrics terminator", text)
+		}
+	}
+}
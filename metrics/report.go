@@ -0,0 +1,189 @@
+// Copyright (C) 2024 Specter Ops, Inc.
+//
+// This file is part of AzureHound.
+//
+// AzureHound is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// AzureHound is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bloodhoundad/azurehound/v2/enums"
+)
+
+// Summary is a snapshot of the statistics of a run
+type Summary struct {
+	Start           time.Time            `json:"start"`
+	DurationSeconds float64              `json:"durationSeconds"`
+	Stages          []StageSummary       `json:"stages"`
+	Endpoints       []EndpointSummary    `json:"endpoints"`
+	Objects         map[enums.Kind]int64 `json:"objects"` // The objects emitted by every stage, by kind
+}
+
+type StageSummary struct {
+	Name            string               `json:"name"`
+	DurationSeconds float64              `json:"durationSeconds"`
+	Requests        int64                `json:"requests"`
+	Failures        int64                `json:"failures"`
+	Errors          int64                `json:"errors"`
+	Objects         map[enums.Kind]int64 `json:"objects"`
+}
+
+type EndpointSummary struct {
+	Endpoint  string           `json:"endpoint"`
+	Requests  int64            `json:"requests"`
+	Retries   int64            `json:"retries"`
+	Throttles int64            `json:"throttles"`
+	Failures  int64            `json:"failures"`
+	Bytes     int64            `json:"bytes"`
+	Latency   HistogramSummary `json:"latency"`
+}
+
+// HistogramSummary has cumulative bucket counts in the manner of a Prometheus histogram
+type HistogramSummary struct {
+	Buckets    []BucketSummary `json:"buckets"`
+	Count      int64           `json:"count"`
+	SumSeconds float64         `json:"sumSeconds"`
+}
+
+type BucketSummary struct {
+	UpperBoundSeconds float64 `json:"le"` // The upper bound of the bucket; 0 for the unbounded last bucket
+	Count             int64   `json:"count"`
+}
+
+// Summary returns a snapshot of the statistics recorded so far, with stages and endpoints sorted by name
+func (s *Recorder) Summary() Summary {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var (
+		now     = time.Now()
+		summary = Summary{
+			Start:           s.start,
+			DurationSeconds: now.Sub(s.start).Seconds(),
+			Stages:          []StageSummary{},
+			Endpoints:       []EndpointSummary{},
+			Objects:         make(map[enums.Kind]int64),
+		}
+	)
+
+	for name, stats := range s.stages {
+		end := stats.End
+		if end.IsZero() {
+			end = now
+		}
+		stage := StageSummary{
+			Name:            name,
+			DurationSeconds: end.Sub(stats.Start).Seconds(),
+			Requests:        stats.Requests,
+			Failures:        stats.Failures,
+			Errors:          stats.Errors,
+			Objects:         make(map[enums.Kind]int64),
+		}
+		for kind, count := range stats.Objects {
+			stage.Objects[kind] = count
+			summary.Objects[kind] += count
+		}
+		summary.Stages = append(summary.Stages, stage)
+	}
+	sort.Slice(summary.Stages, func(i, j int) bool { return summary.Stages[i].Name < summary.Stages[j].Name })
+
+	for name, stats := range s.endpoints {
+		latency := HistogramSummary{Count: stats.Latency.Count, SumSeconds: stats.Latency.Sum.Seconds()}
+		var count int64
+		for i, n := range stats.Latency.Buckets {
+			count += n
+			bucket := BucketSummary{Count: count}
+			if i < len(LatencyBuckets) {
+				bucket.UpperBoundSeconds = LatencyBuckets[i].Seconds()
+			}
+			latency.Buckets = append(latency.Buckets, bucket)
+		}
+		summary.Endpoints = append(summary.Endpoints, EndpointSummary{
+			Endpoint:  name,
+			Requests:  stats.Requests,
+			Retries:   stats.Retries,
+			Throttles: stats.Throttles,
+			Failures:  stats.Failures,
+			Bytes:     stats.Bytes,
+			Latency:   latency,
+		})
+	}
+	sort.Slice(summary.Endpoints, func(i, j int) bool { return summary.Endpoints[i].Endpoint < summary.Endpoints[j].Endpoint })
+
+	return summary
+}
+
+// WriteTable writes the stages and endpoints of the summary as aligned tables
+func (s Summary) WriteTable(w io.Writer) error {
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(table, "STAGE\tDURATION\tREQUESTS\tFAILURES\tERRORS\tOBJECTS\n")
+	for _, stage := range s.Stages {
+		kinds := make([]string, 0, len(stage.Objects))
+		for kind, count := range stage.Objects {
+			kinds = append(kinds, fmt.Sprintf("%s=%d", kind, count))
+		}
+		sort.Strings(kinds)
+		fmt.Fprintf(table, "%s\t%s\t%d\t%d\t%d\t%s\n", stage.Name, seconds(stage.DurationSeconds), stage.Requests, stage.Failures, stage.Errors, strings.Join(kinds, " "))
+	}
+
+	fmt.Fprintf(table, "\nENDPOINT\tREQUESTS\tRETRIES\tTHROTTLES\tFAILURES\tBYTES\tMEAN\tP95\n")
+	for _, endpoint := range s.Endpoints {
+		var mean time.Duration
+		if endpoint.Latency.Count > 0 {
+			mean = time.Duration(endpoint.Latency.SumSeconds / float64(endpoint.Latency.Count) * float64(time.Second))
+		}
+		fmt.Fprintf(table, "%s\t%d\t%d\t%d\t%d\t%d\t%s\t%s\n", endpoint.Endpoint, endpoint.Requests, endpoint.Retries, endpoint.Throttles, endpoint.Failures, endpoint.Bytes, mean.Round(time.Millisecond), endpoint.Latency.quantile(0.95).Round(time.Millisecond))
+	}
+
+	fmt.Fprintf(table, "\nTotal duration %s\n", seconds(s.DurationSeconds))
+	return table.Flush()
+}
+
+// WriteFile writes the summary as JSON to the file at the path
+func (s Summary) WriteFile(path string) error {
+	if data, err := json.MarshalIndent(s, "", "  "); err != nil {
+		return err
+	} else {
+		return os.WriteFile(path, data, 0644)
+	}
+}
+
+// quantile estimates the q-quantile as the upper bound of the bucket it falls within
+func (s HistogramSummary) quantile(q float64) time.Duration {
+	rank := int64(q*float64(s.Count) + 0.5)
+	for _, bucket := range s.Buckets {
+		if bucket.Count >= rank && bucket.Count > 0 && bucket.UpperBoundSeconds > 0 {
+			return time.Duration(bucket.UpperBoundSeconds * float64(time.Second))
+		}
+	}
+	if s.Count > 0 {
+		// beyond the last bound the slowest we can say is the mean
+		return time.Duration(s.SumSeconds / float64(s.Count) * float64(time.Second))
+	}
+	return 0
+}
+
+func seconds(value float64) time.Duration {
+	return time.Duration(value * float64(time.Second)).Round(time.Millisecond)
+}
@@ -0,0 +1,130 @@
+// Copyright (C) 2024 Specter Ops, Inc.
+//
+// This file is part of AzureHound.
+//
+// AzureHound is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// AzureHound is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package metrics
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The service totals accumulate for as long as the process runs; unlike the recorder of the current run, Reset does
+// not clear them, so they can be exposed as monotonic counters while running as a service.
+var (
+	jobId                atomic.Int64
+	lastCheckin          atomic.Int64 // unix nanoseconds, 0 before the first checkin
+	ingestBatches        atomic.Int64
+	ingestBatchesFailed  atomic.Int64
+	tokenRefreshFailures atomic.Int64
+
+	hostTotals      = make(map[string]*HostTotals)
+	hostTotalsMutex sync.Mutex
+)
+
+// HostTotals are the totals of every request made to an API host since the process started
+type HostTotals struct {
+	Requests  int64
+	Retries   int64
+	Throttles int64
+	Failures  int64
+	Latency   Histogram
+}
+
+// SetJob records the ID of the job being run, or 0 when no job is running
+func SetJob(id int64) {
+	jobId.Store(id)
+}
+
+// Job returns the ID of the job being run, or 0 when no job is running
+func Job() int64 {
+	return jobId.Load()
+}
+
+// Checkin records a successful checkin with BloodHound Enterprise
+func Checkin() {
+	lastCheckin.Store(time.Now().UnixNano())
+}
+
+// LastCheckin returns when the last successful checkin happened, or the zero time if none has
+func LastCheckin() time.Time {
+	if nanos := lastCheckin.Load(); nanos == 0 {
+		return time.Time{}
+	} else {
+		return time.Unix(0, nanos)
+	}
+}
+
+// IngestBatch counts a batch of collected data sent for ingestion
+func IngestBatch(failed bool) {
+	ingestBatches.Add(1)
+	if failed {
+		ingestBatchesFailed.Add(1)
+	}
+}
+
+// TokenRefreshFailed counts an unsuccessful request for an access token
+func TokenRefreshFailed() {
+	tokenRefreshFailures.Add(1)
+}
+
+func recordHostTotals(request Request) {
+	host := request.Endpoint
+	if _, path, ok := strings.Cut(host, " "); ok {
+		host = path
+	}
+	host, _, _ = strings.Cut(host, "/")
+
+	hostTotalsMutex.Lock()
+	defer hostTotalsMutex.Unlock()
+
+	totals, ok := hostTotals[host]
+	if !ok {
+		totals = &HostTotals{}
+		hostTotals[host] = totals
+	}
+	totals.Requests++
+	if request.Attempts > 1 {
+		totals.Retries += int64(request.Attempts - 1)
+	}
+	totals.Throttles += int64(request.Throttles)
+	totals.Latency.observe(request.Latency)
+	if request.Failed {
+		totals.Failures++
+	}
+}
+
+// Hosts returns a copy of the request totals of every API host, sorted by host
+func Hosts() ([]string, []HostTotals) {
+	hostTotalsMutex.Lock()
+	defer hostTotalsMutex.Unlock()
+
+	hosts := make([]string, 0, len(hostTotals))
+	for host := range hostTotals {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	totals := make([]HostTotals, len(hosts))
+	for i, host := range hosts {
+		totals[i] = *hostTotals[host]
+		totals[i].Latency.Buckets = append([]int64(nil), hostTotals[host].Latency.Buckets...)
+	}
+	return hosts, totals
+}
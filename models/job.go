@@ -60,3 +60,15 @@ type ClientJob struct {
 	Status           JobStatus `json:"status"`
 	StatusMessage    string    `json:"status_message"`
 }
+
+// FileUploadJob is a BloodHound CE file upload job, to which collected data is uploaded as files
+type FileUploadJob struct {
+	ID            int64     `json:"id"`
+	UserID        string    `json:"user_id"`
+	Status        JobStatus `json:"status"`
+	StatusMessage string    `json:"status_message"`
+	StartTime     time.Time `json:"start_time"`
+	EndTime       time.Time `json:"end_time"`
+	TotalFiles    int       `json:"total_files"`
+	FailedFiles   int       `json:"failed_files"`
+}
@@ -0,0 +1,164 @@
+// Copyright (C) 2024 Specter Ops, Inc.
+//
+// This file is part of AzureHound.
+//
+// AzureHound is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// AzureHound is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package sinks
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/bloodhoundad/azurehound/v2/client/rest"
+)
+
+// blobApiVersion is the version of the Blob service REST API requested, which accepts both SAS and bearer tokens
+const blobApiVersion = "2021-08-06"
+
+// BlobToken returns the authorization header of a request to Azure Blob Storage, such as "Bearer <access token>"
+type BlobToken func() (string, error)
+
+// NewBlobStorage returns the container at the URL, such as https://<account>.blob.core.windows.net/<container>/<prefix>.
+// A storage emulator, such as Azurite, is addressed by IP or localhost with the account as the first segment of the path.
+// Requests are authorized by the SAS token in the query of the URL or, without one, by the token.
+func NewBlobStorage(httpClient *http.Client, retry rest.RetryPolicy, containerUrl url.URL, token BlobToken) (Storage, error) {
+	var (
+		sas      = containerUrl.Query()
+		segments = strings.Split(strings.Trim(containerUrl.Path, "/"), "/")
+		account  = 0
+	)
+
+	if host := containerUrl.Hostname(); host == "localhost" || net.ParseIP(host) != nil {
+		account = 1
+	}
+	if len(segments) <= account || segments[account] == "" {
+		return nil, fmt.Errorf("no container given in %s", containerUrl.Redacted())
+	} else if sas.Get("sig") == "" && token == nil {
+		return nil, fmt.Errorf("no SAS token or credential given for %s", containerUrl.Redacted())
+	}
+
+	if sas.Get("sig") == "" {
+		sas = nil
+	}
+	container := containerUrl
+	container.Path = "/" + strings.Join(segments[:account+1], "/")
+	container.RawPath = ""
+	container.RawQuery = ""
+
+	return &blobStorage{
+		client:    httpClient,
+		retry:     retry,
+		container: container,
+		prefix:    strings.Join(segments[account+1:], "/"),
+		sas:       sas,
+		token:     token,
+	}, nil
+}
+
+type blobStorage struct {
+	client    *http.Client
+	retry     rest.RetryPolicy
+	container url.URL
+	prefix    string
+	sas       url.Values // The SAS token authorizing requests, if given
+	token     BlobToken  // Authorizes requests without a SAS token
+}
+
+// blobUpload is a block blob uploaded a block at a time and committed by its block list
+type blobUpload struct {
+	storage *blobStorage
+	name    string
+	blocks  []string
+}
+
+func (s *blobStorage) create(ctx context.Context, name string) (upload, error) {
+	return &blobUpload{storage: s, name: joinKey(s.prefix, name)}, nil
+}
+
+func (s *blobStorage) put(ctx context.Context, name string, data []byte) error {
+	headers := map[string]string{"x-ms-blob-type": "BlockBlob"}
+	if res, err := s.do(ctx, joinKey(s.prefix, name), nil, headers, data); err != nil {
+		return err
+	} else {
+		return closeBody(res)
+	}
+}
+
+func (s *blobUpload) uploadPart(ctx context.Context, number int, data []byte) error {
+	// block ids must be base64 of the same length for every block of the blob
+	blockId := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("block-%06d", number)))
+	if res, err := s.storage.do(ctx, s.name, url.Values{"comp": {"block"}, "blockid": {blockId}}, nil, data); err != nil {
+		return err
+	} else {
+		s.blocks = append(s.blocks, blockId)
+		return closeBody(res)
+	}
+}
+
+func (s *blobUpload) complete(ctx context.Context) error {
+	headers := map[string]string{"Content-Type": "application/xml"}
+	if body, err := xml.Marshal(struct {
+		XMLName xml.Name `xml:"BlockList"`
+		Latest  []string `xml:"Latest"`
+	}{Latest: s.blocks}); err != nil {
+		return err
+	} else if res, err := s.storage.do(ctx, s.name, url.Values{"comp": {"blocklist"}}, headers, append([]byte(xml.Header), body...)); err != nil {
+		return err
+	} else {
+		return closeBody(res)
+	}
+}
+
+// abort leaves the uncommitted blocks of the blob to be discarded by the service
+func (s *blobUpload) abort(ctx context.Context) error {
+	return nil
+}
+
+func (s *blobStorage) do(ctx context.Context, name string, query url.Values, headers map[string]string, body []byte) (*http.Response, error) {
+	endpoint := s.container.JoinPath(name)
+
+	values := url.Values{}
+	for key, value := range s.sas {
+		values[key] = value
+	}
+	for key, value := range query {
+		values[key] = value
+	}
+	endpoint.RawQuery = values.Encode()
+
+	if req, err := http.NewRequestWithContext(ctx, "PUT", endpoint.String(), bytes.NewReader(body)); err != nil {
+		return nil, err
+	} else {
+		req.Header.Set("x-ms-version", blobApiVersion)
+		for key, value := range headers {
+			req.Header.Set(key, value)
+		}
+		if s.sas == nil {
+			if authorization, err := s.token(); err != nil {
+				return nil, fmt.Errorf("unable to authorize upload of %s: %w", name, err)
+			} else {
+				req.Header.Set("Authorization", authorization)
+			}
+		}
+		return send(s.client, s.retry, req)
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright (C) 2024 Specter Ops, Inc.
+//
+// This file is part of AzureHound.
+//
+// AzureHound is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// AzureHound is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package sinks
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+)
+
+func TestWriteToBlob(t *testing.T) {
+	defer func(size int) { partSize = size }(partSize)
+	partSize = 64
+
+	var (
+		server = newBlobStandIn(t)
+		items  = []string{`{"kind":"AZUser","data":{"id":"1"}}`, `{"kind":"AZUser","data":{"id":"2"}}`, `{"kind":"AZGroup","data":{"id":"3"}}`}
+		ctx    = context.Background()
+	)
+	defer server.Close()
+
+	// authorized by a SAS token
+	sasUrl, _ := url.Parse(server.URL + "/devstoreaccount1/container/collections?sv=2021-08-06&sp=cw&sig=signature")
+	if storage, err := NewBlobStorage(server.Client(), retryPolicy(t), *sasUrl, nil); err != nil {
+		t.Fatal(err)
+	} else if err := WriteToStorage(ctx, storage, "output.json", FormatJSON, CompressionNone, stream(items)); err != nil {
+		t.Fatal(err)
+	} else if blocks := server.blocks["/devstoreaccount1/container/collections/output.json"]; blocks < 2 {
+		t.Errorf("got %d blocks, want the blob uploaded in several", blocks)
+	}
+	expectObjects(t, server.blobs["/devstoreaccount1/container/collections/output.json"], items)
+
+	// authorized by an access token
+	containerUrl, _ := url.Parse(server.URL + "/devstoreaccount1/container")
+	token := func() (string, error) {
+		return "Bearer access-token", nil
+	}
+	if storage, err := NewBlobStorage(server.Client(), retryPolicy(t), *containerUrl, token); err != nil {
+		t.Fatal(err)
+	} else if err := WriteToStorage(ctx, storage, "output.ndjson.zst", FormatNDJSON, CompressionZstd, stream(items)); err != nil {
+		t.Fatal(err)
+	} else if meta := server.blobs["/devstoreaccount1/container/output.ndjson.zst.meta.json"]; !bytes.Contains(meta, []byte(`"count":3`)) {
+		t.Errorf("got metadata %s, want a count of 3", meta)
+	}
+	expectObjects(t, server.blobs["/devstoreaccount1/container/output.ndjson.zst"], items)
+
+	if _, err := NewBlobStorage(server.Client(), retryPolicy(t), *containerUrl, nil); err == nil {
+		t.Error("got storage without a SAS token or credential")
+	}
+}
+
+// blobStandIn emulates the block blobs of Azure Blob Storage, like Azurite
+type blobStandIn struct {
+	*httptest.Server
+	mutex  sync.Mutex
+	staged map[string]map[string][]byte // The uncommitted blocks of each blob
+	blocks map[string]int               // The number of blocks each blob was committed with
+	blobs  map[string][]byte
+}
+
+func newBlobStandIn(t *testing.T) *blobStandIn {
+	standIn := &blobStandIn{staged: map[string]map[string][]byte{}, blocks: map[string]int{}, blobs: map[string][]byte{}}
+	standIn.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		standIn.mutex.Lock()
+		defer standIn.mutex.Unlock()
+
+		var (
+			query   = r.URL.Query()
+			body, _ = io.ReadAll(r.Body)
+		)
+		if r.Method != "PUT" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		} else if r.Header.Get("x-ms-version") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		} else if query.Get("sig") == "" && r.Header.Get("Authorization") != "Bearer access-token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		switch query.Get("comp") {
+		case "block":
+			if standIn.staged[r.URL.Path] == nil {
+				standIn.staged[r.URL.Path] = map[string][]byte{}
+			}
+			standIn.staged[r.URL.Path][query.Get("blockid")] = body
+		case "blocklist":
+			var request struct {
+				Latest []string
+			}
+			if err := xml.Unmarshal(body, &request); err != nil {
+				t.Errorf("got invalid block list: %v", err)
+			}
+
+			blob := []byte{}
+			for _, id := range request.Latest {
+				if block, ok := standIn.staged[r.URL.Path][id]; !ok {
+					t.Errorf("got uncommitted block %s", id)
+				} else {
+					blob = append(blob, block...)
+				}
+			}
+			delete(standIn.staged, r.URL.Path)
+			standIn.blobs[r.URL.Path] = blob
+			standIn.blocks[r.URL.Path] = len(request.Latest)
+		case "":
+			if r.Header.Get("x-ms-blob-type") != "BlockBlob" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			standIn.blobs[r.URL.Path] = body
+		default:
+			w.WriteHeader(http.StatusNotImplemented)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	return standIn
+}
@@ -0,0 +1,101 @@
+// Copyright (C) 2024 Specter Ops, Inc.
+//
+// This file is part of AzureHound.
+//
+// AzureHound is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// AzureHound is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package sinks
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression is the algorithm with which output files are compressed as they are written
+type Compression string
+
+const (
+	CompressionNone Compression = "none"
+	CompressionGzip Compression = "gzip"
+	CompressionZstd Compression = "zstd"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+func Compressions() []string {
+	return []string{string(CompressionNone), string(CompressionGzip), string(CompressionZstd)}
+}
+
+// ParseCompression parses the name of a compression. No name chooses the compression by the extension of the path.
+func ParseCompression(value string, filePath string) (Compression, error) {
+	switch compression := Compression(strings.ToLower(value)); compression {
+	case "":
+		return CompressionFor(filePath), nil
+	case CompressionNone, CompressionGzip, CompressionZstd:
+		return compression, nil
+	default:
+		return "", fmt.Errorf("unsupported compression %s: must be one of %s", value, strings.Join(Compressions(), ", "))
+	}
+}
+
+// CompressionFor returns the compression implied by the extension of the path, .gz or .zst
+func CompressionFor(filePath string) Compression {
+	switch {
+	case strings.HasSuffix(filePath, ".gz"):
+		return CompressionGzip
+	case strings.HasSuffix(filePath, ".zst"), strings.HasSuffix(filePath, ".zstd"):
+		return CompressionZstd
+	default:
+		return CompressionNone
+	}
+}
+
+// compressor begins a gzip member or zstd frame written to the writer. Closing it ends the member or frame without
+// closing the writer, so that a file can be continued with another; readers decompress the members or frames in turn.
+func (s Compression) compressor(writer io.Writer) (io.WriteCloser, error) {
+	switch s {
+	case CompressionGzip:
+		return gzip.NewWriter(writer), nil
+	case CompressionZstd:
+		return zstd.NewWriter(writer, zstd.WithEncoderConcurrency(1))
+	default:
+		return nil, nil
+	}
+}
+
+// decompress detects the compression of the reader by its magic number, returning a reader of the decompressed data
+func decompress(reader *bufio.Reader) (io.ReadCloser, error) {
+	if magic, err := reader.Peek(len(zstdMagic)); err != nil && err != io.EOF {
+		return nil, err
+	} else if bytes.HasPrefix(magic, gzipMagic) {
+		return gzip.NewReader(reader)
+	} else if bytes.HasPrefix(magic, zstdMagic) {
+		if decoder, err := zstd.NewReader(reader); err != nil {
+			return nil, err
+		} else {
+			return decoder.IOReadCloser(), nil
+		}
+	} else {
+		return io.NopCloser(reader), nil
+	}
+}
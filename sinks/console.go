@@ -24,7 +24,11 @@ import (
 	"github.com/bloodhoundad/azurehound/v2/pipeline"
 )
 
-func WriteToConsole[T any](ctx context.Context, stream <-chan T) {
+// WriteToConsole writes one item per line, after a metadata line when the format is NDJSON
+func WriteToConsole[T any](ctx context.Context, format Format, stream <-chan T) {
+	if format == FormatNDJSON {
+		fmt.Print(format.header())
+	}
 	for item := range pipeline.OrDone(ctx.Done(), stream) {
 		fmt.Println(item)
 	}
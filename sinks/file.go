@@ -20,45 +20,206 @@ package sinks
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 
 	"github.com/bloodhoundad/azurehound/v2/models"
 	"github.com/bloodhoundad/azurehound/v2/pipeline"
 )
 
-func WriteToFile[T any](ctx context.Context, filePath string, stream <-chan T) error {
-
-	if file, err := os.OpenFile(filePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666); err != nil {
+// WriteToFile writes the items of the stream to a file in the format given, compressing it as it is written
+func WriteToFile[T any](ctx context.Context, filePath string, format Format, compression Compression, stream <-chan T) error {
+	if writer, err := CreateFile(filePath, format, compression); err != nil {
 		return err
 	} else {
-		defer file.Close()
+		defer writer.file.Close()
 
-		if _, err := file.WriteString("{\n\t\"data\": [\n"); err != nil {
-			return err
-		} else {
-			meta := models.Meta{
-				Type:    "azure",
-				Version: 5,
-				Count:   0,
+		for item := range pipeline.OrDone(ctx.Done(), stream) {
+			if err := writer.Write(fmt.Sprint(item)); err != nil {
+				return err
 			}
+		}
+		return writer.Close()
+	}
+}
 
-			format := "\t\t%v"
-			for item := range pipeline.OrDone(ctx.Done(), stream) {
-				if _, err := file.WriteString(fmt.Sprintf(format, item)); err != nil {
-					return err
-				}
-				meta.Count++
-				format = ",\n\t\t%v"
-			}
+// FileWriter writes items to a file, or an object uploaded to object storage, in the format given, counting them for
+// the metadata written by Close
+type FileWriter struct {
+	path        string
+	file        output
+	writeMeta   func(data []byte) error // Writes the metadata of NDJSON output beside it
+	format      Format
+	compression Compression
+	compressor  io.WriteCloser // The compressor of the items written since the last Sync
+	meta        models.Meta
+	offset      int64 // The length of the file
+	size        int64 // The length of the output before compression
+}
 
-			if bytes, err := json.Marshal(meta); err != nil {
-				return err
-			} else if _, err := file.WriteString(fmt.Sprintf("\n\t],\n\t\"meta\": %s\n}\n", string(bytes))); err != nil {
-				return err
-			} else {
-				return nil
-			}
+// CreateFile creates or truncates the file at the path, writing the opening of the data
+func CreateFile(filePath string, format Format, compression Compression) (*FileWriter, error) {
+	if err := removeMetaFile(filePath, format); err != nil {
+		return nil, err
+	} else if file, err := os.OpenFile(filePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666); err != nil {
+		return nil, err
+	} else {
+		return newWriter(filePath, file, writeMetaFile(filePath), format, compression)
+	}
+}
+
+// newWriter begins output to the file, writing the opening of the data
+func newWriter(filePath string, file output, writeMeta func(data []byte) error, format Format, compression Compression) (*FileWriter, error) {
+	writer := &FileWriter{path: filePath, file: file, writeMeta: writeMeta, format: format, compression: compression, meta: newMeta()}
+	if err := writer.writeString(format.header()); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return writer, nil
+}
+
+// ResumeFile continues a file partially written by a FileWriter, truncating it to the offset returned by Sync once
+// count items had been written
+func ResumeFile(filePath string, format Format, compression Compression, offset int64, count int) (*FileWriter, error) {
+	if offset == 0 || count == 0 {
+		return CreateFile(filePath, format, compression)
+	} else if err := removeMetaFile(filePath, format); err != nil {
+		return nil, err
+	} else if file, err := os.OpenFile(filePath, os.O_WRONLY, 0666); err != nil {
+		return nil, err
+	} else if stat, err := file.Stat(); err != nil {
+		file.Close()
+		return nil, err
+	} else if stat.Size() < offset {
+		file.Close()
+		return nil, fmt.Errorf("%s is shorter than the %d bytes written before", filePath, offset)
+	} else if err := file.Truncate(offset); err != nil {
+		file.Close()
+		return nil, err
+	} else if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	} else {
+		meta := newMeta()
+		meta.Count = count
+		return &FileWriter{path: filePath, file: file, writeMeta: writeMetaFile(filePath), format: format, compression: compression, meta: meta, offset: offset}, nil
+	}
+}
+
+// removeMetaFile removes the metadata of NDJSON output being rewritten, so that it only exists once the output is
+// complete
+func removeMetaFile(filePath string, format Format) error {
+	if format != FormatNDJSON {
+		return nil
+	} else if err := os.Remove(MetaFile(filePath)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	} else {
+		return nil
+	}
+}
+
+func writeMetaFile(filePath string) func(data []byte) error {
+	return func(data []byte) error {
+		return os.WriteFile(MetaFile(filePath), data, 0666)
+	}
+}
+
+// Write writes a formatted item
+func (s *FileWriter) Write(item string) error {
+	if s.format == FormatNDJSON {
+		item = item + "\n"
+	} else if s.meta.Count == 0 {
+		item = "\t\t" + item
+	} else {
+		item = ",\n\t\t" + item
+	}
+	if err := s.writeString(item); err != nil {
+		return err
+	} else {
+		s.meta.Count++
+		return nil
+	}
+}
+
+// Sync flushes the items written so far to disk, returning the offset and count from which ResumeFile can continue.
+// Compressed output is continued in a new gzip member or zstd frame.
+func (s *FileWriter) Sync() (int64, int, error) {
+	if s.compressor != nil {
+		err := s.compressor.Close()
+		s.compressor = nil
+		if err != nil {
+			return s.offset, s.meta.Count, err
+		}
+	}
+	return s.offset, s.meta.Count, s.file.Sync()
+}
+
+// Close writes the metadata and closes the file. The metadata of NDJSON output is written to its MetaFile.
+func (s *FileWriter) Close() error {
+	if err := s.close(); err != nil {
+		s.file.Close()
+		return err
+	} else if err := s.file.Sync(); err != nil {
+		s.file.Close()
+		return err
+	} else if err := s.file.Close(); err != nil {
+		return err
+	} else if s.format == FormatNDJSON {
+		bytes, _ := json.Marshal(s.meta)
+		return s.writeMeta(append(bytes, '\n'))
+	} else {
+		return nil
+	}
+}
+
+func (s *FileWriter) close() error {
+	if s.format != FormatNDJSON {
+		if bytes, err := json.Marshal(s.meta); err != nil {
+			return err
+		} else if err := s.writeString(fmt.Sprintf("\n\t],\n\t\"meta\": %s\n}\n", string(bytes))); err != nil {
+			return err
 		}
 	}
+	if s.compressor != nil {
+		return s.compressor.Close()
+	}
+	return nil
+}
+
+func (s *FileWriter) writeString(value string) error {
+	if s.compressor == nil && s.compression != CompressionNone && s.compression != "" {
+		if compressor, err := s.compression.compressor(fileWriter{s}); err != nil {
+			return err
+		} else {
+			s.compressor = compressor
+		}
+	}
+
+	var err error
+	if s.compressor != nil {
+		_, err = io.WriteString(s.compressor, value)
+	} else {
+		_, err = io.WriteString(fileWriter{s}, value)
+	}
+	s.size += int64(len(value))
+	return err
+}
+
+// output is what a FileWriter writes to, such as an *os.File
+type output interface {
+	io.WriteCloser
+	Sync() error
+}
+
+// fileWriter writes to the file of a FileWriter, keeping count of its length
+type fileWriter struct {
+	writer *FileWriter
+}
+
+func (s fileWriter) Write(p []byte) (int, error) {
+	n, err := s.writer.file.Write(p)
+	s.writer.offset += int64(n)
+	return n, err
 }
@@ -0,0 +1,186 @@
+// Copyright (C) 2024 Specter Ops, Inc.
+//
+// This file is part of AzureHound.
+//
+// AzureHound is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// AzureHound is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package sinks
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bloodhoundad/azurehound/v2/models"
+)
+
+func TestWriteToFile(t *testing.T) {
+	var (
+		dir   = t.TempDir()
+		items = []string{`{"kind":"AZUser","data":{"id":"1"}}`, `{"kind":"AZUser","data":{"id":"2"}}`}
+	)
+
+	path := filepath.Join(dir, "output.json")
+	if err := WriteToFile(context.Background(), path, FormatJSON, CompressionNone, stream(items)); err != nil {
+		t.Fatal(err)
+	}
+	var document struct {
+		Data []json.RawMessage
+		Meta models.Meta
+	}
+	if data, err := os.ReadFile(path); err != nil {
+		t.Fatal(err)
+	} else if err := json.Unmarshal(data, &document); err != nil {
+		t.Fatalf("got invalid json: %v", err)
+	} else if len(document.Data) != 2 || document.Meta.Count != 2 || document.Meta.Type != "azure" {
+		t.Errorf("got %d objects and meta %+v, want 2 of each", len(document.Data), document.Meta)
+	}
+
+	path = filepath.Join(dir, "output.ndjson")
+	if err := WriteToFile(context.Background(), path, FormatNDJSON, CompressionNone, stream(items)); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(path); err != nil {
+		t.Fatal(err)
+	} else if lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n"); len(lines) != 3 {
+		t.Fatalf("got %d lines, want the metadata and 2 objects", len(lines))
+	} else if lines[0] != `{"meta":{"type":"azure","version":5}}` || lines[1] != items[0] || lines[2] != items[1] {
+		t.Errorf("got lines %q", lines)
+	}
+	var meta models.Meta
+	if data, err := os.ReadFile(MetaFile(path)); err != nil {
+		t.Fatal(err)
+	} else if err := json.Unmarshal(data, &meta); err != nil {
+		t.Fatal(err)
+	} else if meta.Count != 2 {
+		t.Errorf("got a count of %d in the metadata file, want 2", meta.Count)
+	}
+}
+
+func TestResumeFile(t *testing.T) {
+	for _, format := range []Format{FormatJSON, FormatNDJSON} {
+		for _, compression := range []Compression{CompressionNone, CompressionGzip, CompressionZstd} {
+			path := filepath.Join(t.TempDir(), "output")
+
+			writer, err := CreateFile(path, format, compression)
+			if err != nil {
+				t.Fatal(err)
+			} else if err := writer.Write(`{"id":"1"}`); err != nil {
+				t.Fatal(err)
+			}
+			offset, count, err := writer.Sync()
+			if err != nil {
+				t.Fatal(err)
+			}
+			// written after the sync and lost when interrupted
+			writer.Write(`{"id":"lost"}`)
+			writer.Sync()
+			writer.file.Close()
+
+			if writer, err := ResumeFile(path, format, compression, offset, count); err != nil {
+				t.Fatal(err)
+			} else if err := writer.Write(`{"id":"2"}`); err != nil {
+				t.Fatal(err)
+			} else if err := writer.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			if objects, meta := readAll(t, path); len(objects) != 2 || objects[0] != `{"id":"1"}` || objects[1] != `{"id":"2"}` {
+				t.Errorf("got %s output compressed with %s holding %v, want the object written after the sync replaced", format, compression, objects)
+			} else if format == FormatJSON && meta.Count != 2 {
+				t.Errorf("got a count of %d, want 2", meta.Count)
+			}
+		}
+	}
+}
+
+func TestOpenFile(t *testing.T) {
+	items := []string{`{"kind":"AZUser","data":{"id":"1"}}`, `{"kind":"AZUser","data":{"id":"2"}}`}
+	for _, format := range []Format{FormatJSON, FormatNDJSON} {
+		for _, extension := range []string{".json", ".json.gz", ".json.zst"} {
+			path := filepath.Join(t.TempDir(), "output"+extension)
+			if err := WriteToFile(context.Background(), path, format, CompressionFor(path), stream(items)); err != nil {
+				t.Fatal(err)
+			}
+
+			if data, err := os.ReadFile(path); err != nil {
+				t.Fatal(err)
+			} else if compressed := !strings.HasPrefix(string(data), "{"); compressed != (extension != ".json") {
+				t.Errorf("got %s output compressed %t", extension, compressed)
+			}
+
+			reader, err := OpenFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer reader.Close()
+
+			if objects, meta := readAll(t, path); len(objects) != 2 || objects[0] != items[0] || objects[1] != items[1] {
+				t.Errorf("got %v from %s %s output", objects, extension, format)
+			} else if meta.Type != "azure" {
+				t.Errorf("got meta %+v from %s %s output", meta, extension, format)
+			} else if reader.Format() != format {
+				t.Errorf("got format %s, want %s", reader.Format(), format)
+			}
+		}
+	}
+}
+
+func readAll(t *testing.T, path string) ([]string, models.Meta) {
+	reader, err := OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	var objects []string
+	for {
+		if object, err := reader.Next(); err == io.EOF {
+			return objects, reader.Meta()
+		} else if err != nil {
+			t.Fatal(err)
+		} else {
+			objects = append(objects, string(object))
+		}
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	if format, err := ParseFormat("NDJSON"); err != nil || format != FormatNDJSON {
+		t.Errorf("got %s, %v, want ndjson", format, err)
+	} else if _, err := ParseFormat("xml"); err == nil {
+		t.Error("got no error for an unsupported format")
+	} else if compression, err := ParseCompression("", "output.json.zst"); err != nil || compression != CompressionZstd {
+		t.Errorf("got %s, %v, want zstd chosen by the extension", compression, err)
+	} else if compression, err := ParseCompression("none", "output.json.gz"); err != nil || compression != CompressionNone {
+		t.Errorf("got %s, %v, want the flag to override the extension", compression, err)
+	} else if _, err := ParseCompression("brotli", "output.json"); err == nil {
+		t.Error("got no error for an unsupported compression")
+	}
+}
+
+func stream(items []string) <-chan string {
+	out := make(chan string)
+	go func() {
+		defer close(out)
+		for _, item := range items {
+			out <- item
+		}
+	}()
+	return out
+}
@@ -0,0 +1,69 @@
+// Copyright (C) 2024 Specter Ops, Inc.
+//
+// This file is part of AzureHound.
+//
+// AzureHound is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// AzureHound is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package sinks
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bloodhoundad/azurehound/v2/models"
+)
+
+// Format is the layout in which collected objects are written
+type Format string
+
+const (
+	// FormatJSON is a single document holding the objects followed by their metadata, as read by BloodHound
+	FormatJSON Format = "json"
+
+	// FormatNDJSON is a metadata line followed by one object per line, so that partial output can be read as a stream
+	FormatNDJSON Format = "ndjson"
+)
+
+func Formats() []string {
+	return []string{string(FormatJSON), string(FormatNDJSON)}
+}
+
+func ParseFormat(value string) (Format, error) {
+	switch format := Format(strings.ToLower(value)); format {
+	case FormatJSON, FormatNDJSON:
+		return format, nil
+	default:
+		return "", fmt.Errorf("unsupported output format %s: must be one of %s", value, strings.Join(Formats(), ", "))
+	}
+}
+
+// MetaFile returns the path of the file holding the metadata of NDJSON output, written once the output is complete
+func MetaFile(filePath string) string {
+	return filePath + ".meta.json"
+}
+
+func newMeta() models.Meta {
+	return models.Meta{Type: "azure", Version: 5}
+}
+
+// header returns what is written before the first object
+func (s Format) header() string {
+	if s == FormatNDJSON {
+		// the count is not known until the output is complete
+		meta := newMeta()
+		return fmt.Sprintf("{\"meta\":{\"type\":%q,\"version\":%d}}\n", meta.Type, meta.Version)
+	} else {
+		return "{\n\t\"data\": [\n"
+	}
+}
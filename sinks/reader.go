@@ -0,0 +1,166 @@
+// Copyright (C) 2024 Specter Ops, Inc.
+//
+// This file is part of AzureHound.
+//
+// AzureHound is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// AzureHound is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package sinks
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/bloodhoundad/azurehound/v2/models"
+)
+
+// Reader reads the objects of output written by a FileWriter one at a time, in either format and compressed or not
+type Reader struct {
+	file         *os.File
+	decompressor io.ReadCloser
+	decoder      *json.Decoder
+	format       Format
+	meta         models.Meta
+	inData       bool // Whether the decoder is within the data of JSON output
+	done         bool
+}
+
+// OpenFile opens output for reading, detecting its compression and format
+func OpenFile(filePath string) (*Reader, error) {
+	if file, err := os.Open(filePath); err != nil {
+		return nil, err
+	} else if decompressor, err := decompress(bufio.NewReader(file)); err != nil {
+		file.Close()
+		return nil, err
+	} else {
+		reader := &Reader{file: file, decompressor: decompressor, decoder: json.NewDecoder(decompressor)}
+		if err := reader.begin(); err != nil {
+			reader.Close()
+			return nil, fmt.Errorf("unable to read %s: %w", filePath, err)
+		}
+		return reader, nil
+	}
+}
+
+// Format returns the format of the output
+func (s *Reader) Format() Format {
+	return s.format
+}
+
+// Meta returns the metadata of the output. The count of JSON output is known once every object has been read; that of
+// NDJSON output is only written to its MetaFile.
+func (s *Reader) Meta() models.Meta {
+	return s.meta
+}
+
+// Next returns the next object, or io.EOF once every object has been read
+func (s *Reader) Next() (json.RawMessage, error) {
+	if s.done {
+		return nil, io.EOF
+	} else if s.format == FormatNDJSON {
+		var object json.RawMessage
+		if err := s.decoder.Decode(&object); err == io.EOF {
+			s.done = true
+			return nil, io.EOF
+		} else if err != nil {
+			return nil, err
+		} else {
+			return object, nil
+		}
+	}
+
+	for {
+		if s.inData {
+			if s.decoder.More() {
+				var object json.RawMessage
+				err := s.decoder.Decode(&object)
+				return object, err
+			} else if err := expectDelim(s.decoder, ']'); err != nil {
+				return nil, err
+			} else {
+				s.inData = false
+			}
+		} else if !s.decoder.More() {
+			s.done = true
+			if err := expectDelim(s.decoder, '}'); err != nil {
+				return nil, err
+			}
+			return nil, io.EOF
+		} else if err := s.readKey(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (s *Reader) Close() error {
+	s.decompressor.Close()
+	return s.file.Close()
+}
+
+// begin reads up to the first object. JSON output is an object holding data and meta, NDJSON output begins with a line
+// holding only the meta.
+func (s *Reader) begin() error {
+	if err := expectDelim(s.decoder, '{'); err != nil {
+		return err
+	} else if token, err := s.decoder.Token(); err != nil {
+		return err
+	} else if token == "meta" {
+		s.format = FormatNDJSON
+		if err := s.decoder.Decode(&s.meta); err != nil {
+			return err
+		} else {
+			return expectDelim(s.decoder, '}')
+		}
+	} else {
+		s.format = FormatJSON
+		return s.readValue(token)
+	}
+}
+
+func (s *Reader) readKey() error {
+	if token, err := s.decoder.Token(); err != nil {
+		return err
+	} else {
+		return s.readValue(token)
+	}
+}
+
+// readValue reads the value of the key of JSON output, up to the first object when it is the data
+func (s *Reader) readValue(key json.Token) error {
+	switch key {
+	case "data":
+		s.inData = true
+		return expectDelim(s.decoder, '[')
+	case "meta":
+		return s.decoder.Decode(&s.meta)
+	default:
+		var ignored json.RawMessage
+		return s.decoder.Decode(&ignored)
+	}
+}
+
+func expectDelim(decoder *json.Decoder, delim json.Delim) error {
+	if token, err := decoder.Token(); errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	} else if err != nil {
+		return err
+	} else if token != delim {
+		return fmt.Errorf("expected %s but got %v", delim, token)
+	} else {
+		return nil
+	}
+}
@@ -0,0 +1,302 @@
+// Copyright (C) 2024 Specter Ops, Inc.
+//
+// This file is part of AzureHound.
+//
+// AzureHound is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// AzureHound is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package sinks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bloodhoundad/azurehound/v2/client/rest"
+)
+
+// S3Options locate an S3-compatible bucket and the AWS credentials with which to upload to it
+type S3Options struct {
+	Bucket          string
+	Prefix          string // The directory of the bucket to which objects are uploaded
+	Region          string
+	Endpoint        string // The URL of an S3-compatible service, such as MinIO, addressed by path; AWS when empty
+	AccessKeyId     string
+	SecretAccessKey string
+	SessionToken    string // The token of temporary credentials, if any
+}
+
+// NewS3Storage returns the bucket, signing requests made through the HTTP client with AWS Signature Version 4
+func NewS3Storage(httpClient *http.Client, retry rest.RetryPolicy, options S3Options) (Storage, error) {
+	var endpoint *url.URL
+	if options.Bucket == "" {
+		return nil, fmt.Errorf("no S3 bucket given")
+	} else if options.Region == "" {
+		return nil, fmt.Errorf("no S3 region given")
+	} else if options.AccessKeyId == "" || options.SecretAccessKey == "" {
+		return nil, fmt.Errorf("no AWS credentials given")
+	} else if options.Endpoint == "" {
+		endpoint = &url.URL{Scheme: "https", Host: fmt.Sprintf("%s.s3.%s.amazonaws.com", options.Bucket, options.Region), Path: "/"}
+	} else if parsed, err := url.Parse(options.Endpoint); err != nil {
+		return nil, fmt.Errorf("unable to parse S3 endpoint: %w", err)
+	} else {
+		endpoint = parsed.JoinPath(options.Bucket)
+	}
+
+	base := httpClient.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	client := *httpClient
+	client.Transport = sigV4Transport{
+		base:            base,
+		service:         "s3",
+		region:          options.Region,
+		accessKeyId:     options.AccessKeyId,
+		secretAccessKey: options.SecretAccessKey,
+		sessionToken:    options.SessionToken,
+	}
+	return &s3Storage{client: &client, retry: retry, endpoint: *endpoint, prefix: options.Prefix}, nil
+}
+
+type s3Storage struct {
+	client   *http.Client
+	retry    rest.RetryPolicy
+	endpoint url.URL // The URL of the bucket
+	prefix   string
+}
+
+type s3Upload struct {
+	storage  *s3Storage
+	key      string
+	uploadId string
+	parts    []s3Part
+}
+
+type s3Part struct {
+	PartNumber int
+	ETag       string
+}
+
+func (s *s3Storage) create(ctx context.Context, name string) (upload, error) {
+	var (
+		key    = joinKey(s.prefix, name)
+		result struct {
+			UploadId string
+		}
+	)
+
+	if res, err := s.do(ctx, "POST", key, url.Values{"uploads": {""}}, nil); err != nil {
+		return nil, err
+	} else {
+		defer res.Body.Close()
+		if err := xml.NewDecoder(res.Body).Decode(&result); err != nil {
+			return nil, fmt.Errorf("unable to read the multipart upload of %s: %w", key, err)
+		} else if result.UploadId == "" {
+			return nil, fmt.Errorf("no upload id was returned for %s", key)
+		} else {
+			return &s3Upload{storage: s, key: key, uploadId: result.UploadId}, nil
+		}
+	}
+}
+
+func (s *s3Storage) put(ctx context.Context, name string, data []byte) error {
+	if res, err := s.do(ctx, "PUT", joinKey(s.prefix, name), nil, data); err != nil {
+		return err
+	} else {
+		return closeBody(res)
+	}
+}
+
+func (s *s3Upload) uploadPart(ctx context.Context, number int, data []byte) error {
+	query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {s.uploadId}}
+	if res, err := s.storage.do(ctx, "PUT", s.key, query, data); err != nil {
+		return err
+	} else {
+		s.parts = append(s.parts, s3Part{PartNumber: number, ETag: res.Header.Get("ETag")})
+		return closeBody(res)
+	}
+}
+
+func (s *s3Upload) complete(ctx context.Context) error {
+	var result struct {
+		XMLName xml.Name
+		Code    string
+		Message string
+	}
+
+	if body, err := xml.Marshal(struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []s3Part `xml:"Part"`
+	}{Parts: s.parts}); err != nil {
+		return err
+	} else if res, err := s.storage.do(ctx, "POST", s.key, url.Values{"uploadId": {s.uploadId}}, body); err != nil {
+		return err
+	} else {
+		defer res.Body.Close()
+		// S3 may report the failure of a completion in the body of a successful response
+		if err := xml.NewDecoder(res.Body).Decode(&result); err != nil {
+			return fmt.Errorf("unable to read the completion of %s: %w", s.key, err)
+		} else if result.XMLName.Local == "Error" {
+			return fmt.Errorf("unable to complete the upload of %s: %s %s", s.key, result.Code, result.Message)
+		} else {
+			return nil
+		}
+	}
+}
+
+func (s *s3Upload) abort(ctx context.Context) error {
+	if res, err := s.storage.do(ctx, "DELETE", s.key, url.Values{"uploadId": {s.uploadId}}, nil); err != nil {
+		return err
+	} else {
+		return closeBody(res)
+	}
+}
+
+func (s *s3Storage) do(ctx context.Context, method string, key string, query url.Values, body []byte) (*http.Response, error) {
+	var (
+		endpoint = s.endpoint.JoinPath(key)
+		hash     = sha256.Sum256(body)
+	)
+	endpoint.RawPath = awsEscape(endpoint.Path, false)
+	endpoint.RawQuery = canonicalQuery(query)
+
+	if req, err := http.NewRequestWithContext(ctx, method, endpoint.String(), bytes.NewReader(body)); err != nil {
+		return nil, err
+	} else {
+		req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(hash[:]))
+		return send(s.client, s.retry, req)
+	}
+}
+
+// sigV4Transport signs each request with AWS Signature Version 4 as it is sent, so that retries are signed afresh
+type sigV4Transport struct {
+	base            http.RoundTripper
+	service         string
+	region          string
+	accessKeyId     string
+	secretAccessKey string
+	sessionToken    string
+}
+
+func (s sigV4Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	s.sign(req, time.Now().UTC())
+	return s.base.RoundTrip(req)
+}
+
+// sign adds the authorization of the request made at the time. The request has an X-Amz-Content-Sha256 header holding
+// the hash of its body, and every header it has is signed.
+func (s sigV4Transport) sign(req *http.Request, now time.Time) {
+	var (
+		date    = now.Format("20060102T150405Z")
+		scope   = fmt.Sprintf("%s/%s/%s/aws4_request", now.Format("20060102"), s.region, s.service)
+		host    = req.Host
+		headers = map[string]string{}
+		names   []string
+	)
+
+	req.Header.Set("X-Amz-Date", date)
+	if s.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers["host"] = host
+	for name, values := range req.Header {
+		if name = strings.ToLower(name); name != "authorization" && name != "user-agent" {
+			trimmed := make([]string, len(values))
+			for i, value := range values {
+				trimmed[i] = strings.Join(strings.Fields(value), " ")
+			}
+			headers[name] = strings.Join(trimmed, ",")
+		}
+	}
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	canonical := &strings.Builder{}
+	fmt.Fprintf(canonical, "%s\n%s\n%s\n", req.Method, req.URL.EscapedPath(), req.URL.RawQuery)
+	for _, name := range names {
+		fmt.Fprintf(canonical, "%s:%s\n", name, headers[name])
+	}
+	signedHeaders := strings.Join(names, ";")
+	fmt.Fprintf(canonical, "\n%s\n%s", signedHeaders, req.Header.Get("X-Amz-Content-Sha256"))
+
+	canonicalHash := sha256.Sum256([]byte(canonical.String()))
+	stringToSign := fmt.Sprintf("AWS4-HMAC-SHA256\n%s\n%s\n%s", date, scope, hex.EncodeToString(canonicalHash[:]))
+
+	key := []byte("AWS4" + s.secretAccessKey)
+	for _, part := range []string{now.Format("20060102"), s.region, s.service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", s.accessKeyId, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	digester := hmac.New(sha256.New, key)
+	digester.Write([]byte(data))
+	return digester.Sum(nil)
+}
+
+// canonicalQuery encodes the query as AWS signs it, sorted by key with every value escaped
+func canonicalQuery(query url.Values) string {
+	var pairs [][2]string
+	for key, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, [2]string{awsEscape(key, true), awsEscape(value, true)})
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i][0] < pairs[j][0] || (pairs[i][0] == pairs[j][0] && pairs[i][1] < pairs[j][1])
+	})
+
+	encoded := make([]string, len(pairs))
+	for i, pair := range pairs {
+		encoded[i] = pair[0] + "=" + pair[1]
+	}
+	return strings.Join(encoded, "&")
+}
+
+// awsEscape percent-encodes all but the unreserved characters, and slashes unless escaping a query value
+func awsEscape(value string, escapeSlash bool) string {
+	escaped := &strings.Builder{}
+	for _, b := range []byte(value) {
+		switch {
+		case 'A' <= b && b <= 'Z', 'a' <= b && b <= 'z', '0' <= b && b <= '9', b == '-', b == '.', b == '_', b == '~':
+			escaped.WriteByte(b)
+		case b == '/' && !escapeSlash:
+			escaped.WriteByte(b)
+		default:
+			fmt.Fprintf(escaped, "%%%02X", b)
+		}
+	}
+	return escaped.String()
+}
@@ -0,0 +1,170 @@
+// Copyright (C) 2024 Specter Ops, Inc.
+//
+// This file is part of AzureHound.
+//
+// AzureHound is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// AzureHound is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package sinks
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/bloodhoundad/azurehound/v2/client/rest"
+	"github.com/bloodhoundad/azurehound/v2/pipeline"
+)
+
+// partSize is the size of the parts in which objects are uploaded, above the 5 MiB minimum of S3 multipart uploads
+var partSize = 16 * 1024 * 1024
+
+// Storage is a bucket or container of object storage to which output is uploaded as it is written
+type Storage interface {
+	// create begins the upload of the named object in parts
+	create(ctx context.Context, name string) (upload, error)
+
+	// put uploads the named object whole
+	put(ctx context.Context, name string, data []byte) error
+}
+
+// upload is an object being uploaded in parts, numbered from 1
+type upload interface {
+	uploadPart(ctx context.Context, number int, data []byte) error
+	complete(ctx context.Context) error
+	abort(ctx context.Context) error
+}
+
+// WriteToStorage uploads the items of the stream to the named object in the format given, compressing it as it is
+// written. The object is completed with the items collected so far when the context is cancelled; it is abandoned on
+// failure.
+func WriteToStorage[T any](ctx context.Context, storage Storage, name string, format Format, compression Compression, stream <-chan T) error {
+	uploadCtx := context.WithoutCancel(ctx)
+	if upload, err := storage.create(uploadCtx, name); err != nil {
+		return fmt.Errorf("unable to begin upload of %s: %w", name, err)
+	} else {
+		var (
+			object    = &objectWriter{ctx: uploadCtx, upload: upload}
+			writeMeta = func(data []byte) error {
+				return storage.put(uploadCtx, MetaFile(name), data)
+			}
+		)
+
+		if writer, err := newWriter(name, object, writeMeta, format, compression); err != nil {
+			upload.abort(uploadCtx)
+			return err
+		} else {
+			for item := range pipeline.OrDone(ctx.Done(), stream) {
+				if err := writer.Write(fmt.Sprint(item)); err != nil {
+					upload.abort(uploadCtx)
+					return err
+				}
+			}
+			if err := writer.Close(); err != nil {
+				upload.abort(uploadCtx)
+				return err
+			}
+			return nil
+		}
+	}
+}
+
+// objectWriter buffers what is written to an object, uploading it a part at a time
+type objectWriter struct {
+	ctx    context.Context
+	upload upload
+	buffer bytes.Buffer
+	parts  int
+	err    error // The failure of an upload, after which the object is never completed
+	closed bool
+}
+
+func (s *objectWriter) Write(p []byte) (int, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	s.buffer.Write(p)
+	if s.buffer.Len() >= partSize {
+		if err := s.flush(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Sync does nothing; parts are uploaded once they are full, as object storage requires
+func (s *objectWriter) Sync() error {
+	return nil
+}
+
+// Close uploads the last part and completes the object
+func (s *objectWriter) Close() error {
+	if s.closed || s.err != nil {
+		return s.err
+	}
+	s.closed = true
+
+	if s.buffer.Len() > 0 || s.parts == 0 {
+		if err := s.flush(); err != nil {
+			return err
+		}
+	}
+	return s.upload.complete(s.ctx)
+}
+
+func (s *objectWriter) flush() error {
+	s.parts++
+	data := bytes.Clone(s.buffer.Bytes())
+	s.buffer.Reset()
+	s.err = s.upload.uploadPart(s.ctx, s.parts, data)
+	return s.err
+}
+
+// joinKey joins the prefix of a bucket or container to the name of an object, the prefix taken as a directory
+func joinKey(prefix string, name string) string {
+	if prefix = strings.Trim(prefix, "/"); prefix == "" {
+		return name
+	} else {
+		return prefix + "/" + name
+	}
+}
+
+// send makes a request to object storage, retrying as the policy allows, and fails an unsuccessful response with the
+// error it describes
+func send(client *http.Client, policy rest.RetryPolicy, req *http.Request) (*http.Response, error) {
+	if res, attempts, err := rest.SendWithRetry(client, req, policy); err != nil {
+		// keep any SAS token in the query of the URL out of the error
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			urlErr.URL = req.URL.Host + req.URL.Path
+		}
+		return nil, fmt.Errorf("unable to complete request to %s %s%s; attempts=%d: %w", req.Method, req.URL.Host, req.URL.Path, attempts, err)
+	} else if res.StatusCode < http.StatusOK || res.StatusCode >= http.StatusBadRequest {
+		defer res.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 4096))
+		return nil, fmt.Errorf("received unexpected response code from %s %s%s: %s %s; attempts=%d", req.Method, req.URL.Host, req.URL.Path, res.Status, strings.TrimSpace(string(body)), attempts)
+	} else {
+		return res, nil
+	}
+}
+
+// closeBody discards the body of a response so that its connection can be reused
+func closeBody(res *http.Response) error {
+	io.Copy(io.Discard, res.Body)
+	return res.Body.Close()
+}
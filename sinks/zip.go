@@ -0,0 +1,163 @@
+// Copyright (C) 2024 Specter Ops, Inc.
+//
+// This file is part of AzureHound.
+//
+// AzureHound is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// AzureHound is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package sinks
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/bloodhoundad/azurehound/v2/enums"
+	"github.com/bloodhoundad/azurehound/v2/pipeline"
+)
+
+// Object is a formatted object along with its kind, by which split output may be divided
+type Object struct {
+	Kind enums.Kind
+	Data string
+}
+
+// SplitOptions decide how output is divided between files
+type SplitOptions struct {
+	ByKind     bool  // Write each kind of object to files of its own
+	MaxObjects int   // Start a new file once a file holds this many objects, unlimited when 0
+	MaxBytes   int64 // Start a new file before a file grows beyond this size, unlimited when 0
+}
+
+func (s SplitOptions) Enabled() bool {
+	return s.ByKind || s.MaxObjects > 0 || s.MaxBytes > 0
+}
+
+// WriteToZip writes the objects of the stream to JSON files divided as the options decide, each with the count of its
+// own objects, and packages the files in a zip at the path ready for upload to BloodHound CE
+func WriteToZip(ctx context.Context, filePath string, options SplitOptions, stream <-chan Object) error {
+	dir, err := os.MkdirTemp(filepath.Dir(filePath), ".azurehound-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	splitter := splitter{dir: dir, options: options, writers: make(map[string]*FileWriter), parts: make(map[string]int)}
+	for object := range pipeline.OrDone(ctx.Done(), stream) {
+		if err := splitter.write(object); err != nil {
+			splitter.close()
+			return err
+		}
+	}
+	if err := splitter.close(); err != nil {
+		return err
+	} else {
+		return writeZip(filePath, dir, splitter.files)
+	}
+}
+
+type splitter struct {
+	dir     string
+	options SplitOptions
+	writers map[string]*FileWriter // The file being written for each name
+	parts   map[string]int         // The number of files started for each name
+	files   []string               // The files started, in order
+}
+
+func (s *splitter) write(object Object) error {
+	name := "azurehound"
+	if s.options.ByKind && object.Kind != "" {
+		name = string(object.Kind)
+	}
+
+	writer, ok := s.writers[name]
+	if ok && s.full(writer, object) {
+		delete(s.writers, name)
+		if err := writer.Close(); err != nil {
+			return err
+		}
+		ok = false
+	}
+	if !ok {
+		s.parts[name]++
+		file := fmt.Sprintf("%s-%04d.json", name, s.parts[name])
+		if created, err := CreateFile(filepath.Join(s.dir, file), FormatJSON, CompressionNone); err != nil {
+			return err
+		} else {
+			writer = created
+			s.writers[name] = writer
+			s.files = append(s.files, file)
+		}
+	}
+	return writer.Write(object.Data)
+}
+
+// full returns whether the object must go to a new file. A file always holds at least one object.
+func (s *splitter) full(writer *FileWriter, object Object) bool {
+	if writer.meta.Count == 0 {
+		return false
+	} else if s.options.MaxObjects > 0 && writer.meta.Count >= s.options.MaxObjects {
+		return true
+	} else {
+		return s.options.MaxBytes > 0 && writer.size+int64(len(object.Data)) > s.options.MaxBytes
+	}
+}
+
+func (s *splitter) close() error {
+	var result error
+	for name, writer := range s.writers {
+		if err := writer.Close(); err != nil && result == nil {
+			result = err
+		}
+		delete(s.writers, name)
+	}
+	return result
+}
+
+func writeZip(filePath string, dir string, files []string) error {
+	if file, err := os.OpenFile(filePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666); err != nil {
+		return err
+	} else {
+		defer file.Close()
+
+		archive := zip.NewWriter(file)
+		for _, name := range files {
+			if err := addToZip(archive, dir, name); err != nil {
+				return err
+			}
+		}
+		if err := archive.Close(); err != nil {
+			return err
+		} else {
+			return file.Close()
+		}
+	}
+}
+
+func addToZip(archive *zip.Writer, dir string, name string) error {
+	if file, err := os.Open(filepath.Join(dir, name)); err != nil {
+		return err
+	} else {
+		defer file.Close()
+
+		if entry, err := archive.Create(name); err != nil {
+			return err
+		} else {
+			_, err := io.Copy(entry, file)
+			return err
+		}
+	}
+}
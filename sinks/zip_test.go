@@ -0,0 +1,99 @@
+// Copyright (C) 2024 Specter Ops, Inc.
+//
+// This file is part of AzureHound.
+//
+// AzureHound is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// AzureHound is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package sinks
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/bloodhoundad/azurehound/v2/enums"
+	"github.com/bloodhoundad/azurehound/v2/models"
+)
+
+func TestWriteToZip(t *testing.T) {
+	var objects []Object
+	for i := 0; i < 5; i++ {
+		objects = append(objects, Object{Kind: enums.KindAZUser, Data: fmt.Sprintf(`{"kind":"AZUser","data":{"id":"%d"}}`, i)})
+	}
+	objects = append(objects, Object{Kind: enums.KindAZGroup, Data: `{"kind":"AZGroup","data":{"id":"group"}}`})
+
+	tests := []struct {
+		name     string
+		options  SplitOptions
+		expected map[string]int
+	}{
+		{"by kind", SplitOptions{ByKind: true}, map[string]int{"AZUser-0001.json": 5, "AZGroup-0001.json": 1}},
+		{"by count", SplitOptions{MaxObjects: 4}, map[string]int{"azurehound-0001.json": 4, "azurehound-0002.json": 2}},
+		{"by kind and count", SplitOptions{ByKind: true, MaxObjects: 2}, map[string]int{"AZUser-0001.json": 2, "AZUser-0002.json": 2, "AZUser-0003.json": 1, "AZGroup-0001.json": 1}},
+		{"by size", SplitOptions{MaxBytes: 100}, map[string]int{"azurehound-0001.json": 2, "azurehound-0002.json": 2, "azurehound-0003.json": 2}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "output.zip")
+			stream := make(chan Object)
+			go func() {
+				defer close(stream)
+				for _, object := range objects {
+					stream <- object
+				}
+			}()
+			if err := WriteToZip(context.Background(), path, test.options, stream); err != nil {
+				t.Fatal(err)
+			}
+
+			archive, err := zip.OpenReader(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer archive.Close()
+
+			actual := make(map[string]int)
+			for _, entry := range archive.File {
+				var document struct {
+					Data []json.RawMessage
+					Meta models.Meta
+				}
+				if file, err := entry.Open(); err != nil {
+					t.Fatal(err)
+				} else if err := json.NewDecoder(file).Decode(&document); err != nil {
+					t.Fatalf("got invalid json in %s: %v", entry.Name, err)
+				} else if document.Meta.Count != len(document.Data) {
+					t.Errorf("got a count of %d for %d objects in %s", document.Meta.Count, len(document.Data), entry.Name)
+				} else {
+					actual[entry.Name] = document.Meta.Count
+				}
+			}
+			if !reflect.DeepEqual(actual, test.expected) {
+				t.Errorf("got files %v, want %v", actual, test.expected)
+			}
+
+			// the files are only kept in the zip
+			if entries, err := os.ReadDir(filepath.Dir(path)); err != nil {
+				t.Fatal(err)
+			} else if len(entries) != 1 {
+				t.Errorf("got %d entries beside the zip, want none", len(entries)-1)
+			}
+		})
+	}
+}
@@ -0,0 +1,278 @@
+// Copyright (C) 2024 Specter Ops, Inc.
+//
+// This file is part of AzureHound.
+//
+// AzureHound is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// AzureHound is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bloodhoundad/azurehound/v2/constants"
+)
+
+// OTLPExporter posts spans to an OpenTelemetry collector using OTLP over HTTP with the JSON encoding
+type OTLPExporter struct {
+	endpoint string
+	client   *http.Client
+}
+
+// NewOTLPExporter exports to the collector at the endpoint, e.g. http://localhost:4318. The spans are posted to
+// /v1/traces unless the endpoint has a path of its own.
+func NewOTLPExporter(endpoint string) (*OTLPExporter, error) {
+	if parsed, err := url.Parse(endpoint); err != nil {
+		return nil, err
+	} else if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported trace endpoint %s: must be an http or https url", endpoint)
+	} else {
+		if strings.Trim(parsed.Path, "/") == "" {
+			parsed.Path = "/v1/traces"
+		}
+		return &OTLPExporter{
+			endpoint: parsed.String(),
+			client:   &http.Client{Timeout: 30 * time.Second},
+		}, nil
+	}
+}
+
+func (s *OTLPExporter) Export(ctx context.Context, spans []SpanData) error {
+	if body, err := json.Marshal(encode(spans)); err != nil {
+		return err
+	} else if req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, bytes.NewReader(body)); err != nil {
+		return err
+	} else {
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", constants.UserAgent())
+		if res, err := s.client.Do(req); err != nil {
+			return err
+		} else {
+			defer res.Body.Close()
+			if res.StatusCode < http.StatusOK || res.StatusCode >= http.StatusMultipleChoices {
+				message, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+				return fmt.Errorf("received unexpected response code from %s: %s %s", s.endpoint, res.Status, message)
+			}
+			io.Copy(io.Discard, res.Body)
+			return nil
+		}
+	}
+}
+
+func (s *OTLPExporter) Shutdown(ctx context.Context) error {
+	s.client.CloseIdleConnections()
+	return nil
+}
+
+// FileExporter appends spans to a local file, one OTLP JSON export request per line, in the format read by the
+// collector's otlpjsonfile receiver
+type FileExporter struct {
+	mutex sync.Mutex
+	file  *os.File
+}
+
+func NewFileExporter(path string) (*FileExporter, error) {
+	if file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644); err != nil {
+		return nil, err
+	} else {
+		return &FileExporter{file: file}, nil
+	}
+}
+
+func (s *FileExporter) Export(ctx context.Context, spans []SpanData) error {
+	if data, err := json.Marshal(encode(spans)); err != nil {
+		return err
+	} else {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		_, err := s.file.Write(append(data, '\n'))
+		return err
+	}
+}
+
+func (s *FileExporter) Shutdown(ctx context.Context) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.file.Close()
+}
+
+// MemoryExporter keeps spans in memory for tests
+type MemoryExporter struct {
+	mutex sync.Mutex
+	spans []SpanData
+}
+
+func (s *MemoryExporter) Export(ctx context.Context, spans []SpanData) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.spans = append(s.spans, spans...)
+	return nil
+}
+
+func (s *MemoryExporter) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+// Spans returns the spans exported so far
+func (s *MemoryExporter) Spans() []SpanData {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]SpanData(nil), s.spans...)
+}
+
+// The OTLP JSON encoding of an export request.
+// See https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type otlpSpan struct {
+	TraceId           string          `json:"traceId"`
+	SpanId            string          `json:"spanId"`
+	ParentSpanId      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              SpanKind        `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Events            []otlpEvent     `json:"events,omitempty"`
+	Links             []otlpLink      `json:"links,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpEvent struct {
+	TimeUnixNano string          `json:"timeUnixNano"`
+	Name         string          `json:"name"`
+	Attributes   []otlpAttribute `json:"attributes,omitempty"`
+}
+
+type otlpLink struct {
+	TraceId string `json:"traceId"`
+	SpanId  string `json:"spanId"`
+}
+
+type otlpStatus struct {
+	Code    StatusCode `json:"code,omitempty"`
+	Message string     `json:"message,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"` // int64 is encoded as a string
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+func encode(spans []SpanData) otlpRequest {
+	encoded := make([]otlpSpan, 0, len(spans))
+	for _, span := range spans {
+		otlp := otlpSpan{
+			TraceId:           span.TraceID.String(),
+			SpanId:            span.SpanID.String(),
+			Name:              span.Name,
+			Kind:              span.Kind,
+			StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+			Attributes:        encodeAttributes(span.Attributes),
+			Status:            otlpStatus{Code: span.Status.Code, Message: span.Status.Message},
+		}
+		if span.ParentID.IsValid() {
+			otlp.ParentSpanId = span.ParentID.String()
+		}
+		for _, event := range span.Events {
+			otlp.Events = append(otlp.Events, otlpEvent{
+				TimeUnixNano: strconv.FormatInt(event.Time.UnixNano(), 10),
+				Name:         event.Name,
+				Attributes:   encodeAttributes(event.Attributes),
+			})
+		}
+		for _, link := range span.Links {
+			otlp.Links = append(otlp.Links, otlpLink{TraceId: link.TraceID.String(), SpanId: link.SpanID.String()})
+		}
+		encoded = append(encoded, otlp)
+	}
+
+	return otlpRequest{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource: otlpResource{Attributes: encodeAttributes([]Attribute{
+				String("service.name", constants.Name),
+				String("service.version", constants.Version),
+			})},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{Name: "github.com/bloodhoundad/azurehound/v2/tracing", Version: constants.Version},
+				Spans: encoded,
+			}},
+		}},
+	}
+}
+
+func encodeAttributes(attributes []Attribute) []otlpAttribute {
+	var encoded []otlpAttribute
+	for _, attribute := range attributes {
+		var value otlpValue
+		switch typed := attribute.Value.(type) {
+		case string:
+			value.StringValue = &typed
+		case bool:
+			value.BoolValue = &typed
+		case int:
+			integer := strconv.Itoa(typed)
+			value.IntValue = &integer
+		case int64:
+			integer := strconv.FormatInt(typed, 10)
+			value.IntValue = &integer
+		case float64:
+			value.DoubleValue = &typed
+		default:
+			text := fmt.Sprint(typed)
+			value.StringValue = &text
+		}
+		encoded = append(encoded, otlpAttribute{Key: attribute.Key, Value: value})
+	}
+	return encoded
+}
@@ -0,0 +1,345 @@
+// Copyright (C) 2024 Specter Ops, Inc.
+//
+// This file is part of AzureHound.
+//
+// AzureHound is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// AzureHound is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package tracing records OpenTelemetry compatible spans for collection jobs, list stages and HTTP requests, exporting
+// them in the OTLP JSON encoding. Until Init is called with an exporter every span is nil and records nothing.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+const (
+	exportInterval = 5 * time.Second
+	exportBatch    = 512  // The number of ended spans that triggers an export before the interval
+	maxQueued      = 8192 // The number of ended spans after which further spans are dropped until the next export
+)
+
+var active atomic.Pointer[processor]
+
+type TraceID [16]byte
+
+func (s TraceID) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+type SpanID [8]byte
+
+func (s SpanID) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+func (s SpanID) IsValid() bool {
+	return s != SpanID{}
+}
+
+// SpanKind values match those of OTLP
+type SpanKind int
+
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindClient   SpanKind = 3
+)
+
+// StatusCode values match those of OTLP
+type StatusCode int
+
+const (
+	StatusUnset StatusCode = 0
+	StatusOK    StatusCode = 1
+	StatusError StatusCode = 2
+)
+
+type Status struct {
+	Code    StatusCode
+	Message string
+}
+
+// Attribute is a key and a string, bool, int, int64 or float64 value
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+func String(key, value string) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+func Int(key string, value int) Attribute {
+	return Attribute{Key: key, Value: int64(value)}
+}
+
+func Int64(key string, value int64) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+func Bool(key string, value bool) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+func Float64(key string, value float64) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+type Event struct {
+	Name       string
+	Time       time.Time
+	Attributes []Attribute
+}
+
+// Link relates a span to another that is not its parent, such as one of the requests a batch was sent for
+type Link struct {
+	TraceID TraceID
+	SpanID  SpanID
+}
+
+// SpanData is what is exported of a span once it ends
+type SpanData struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	ParentID   SpanID // Invalid for a root span
+	Name       string
+	Kind       SpanKind
+	Start      time.Time
+	End        time.Time
+	Attributes []Attribute
+	Events     []Event
+	Links      []Link
+	Status     Status
+}
+
+// Span records an operation until it ends. A nil span records nothing.
+type Span struct {
+	processor *processor
+	mutex     sync.Mutex
+	data      SpanData
+	ended     bool
+}
+
+// SetAttributes adds attributes to the span
+func (s *Span) SetAttributes(attributes ...Attribute) {
+	if s != nil {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		s.data.Attributes = append(s.data.Attributes, attributes...)
+	}
+}
+
+// AddEvent records something that happened during the span
+func (s *Span) AddEvent(name string, attributes ...Attribute) {
+	if s != nil {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		s.data.Events = append(s.data.Events, Event{Name: name, Time: time.Now(), Attributes: attributes})
+	}
+}
+
+// AddLink relates the span to another span, which may belong to another trace
+func (s *Span) AddLink(other *Span) {
+	if s != nil && other != nil {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		s.data.Links = append(s.data.Links, Link{TraceID: other.data.TraceID, SpanID: other.data.SpanID})
+	}
+}
+
+// SetError marks the span failed with the error, recording it as an exception event
+func (s *Span) SetError(err error) {
+	if s != nil && err != nil {
+		s.AddEvent("exception", String("exception.message", err.Error()))
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		s.data.Status = Status{Code: StatusError, Message: err.Error()}
+	}
+}
+
+// SetStatus sets the status of the span
+func (s *Span) SetStatus(code StatusCode, message string) {
+	if s != nil {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		s.data.Status = Status{Code: code, Message: message}
+	}
+}
+
+// End ends the span, queueing it for export. Only the first call has any effect.
+func (s *Span) End() {
+	if s != nil {
+		s.mutex.Lock()
+		if s.ended {
+			s.mutex.Unlock()
+			return
+		}
+		s.ended = true
+		s.data.End = time.Now()
+		data := s.data
+		s.mutex.Unlock()
+
+		s.processor.enqueue(data)
+	}
+}
+
+type spanKey struct{}
+
+// SpanFromContext returns the span the context was created for, or nil
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// Start begins a span, the child of the span of the context if there is one, returning a context for its children.
+// The span is nil when tracing has not been initialized.
+func Start(ctx context.Context, name string, kind SpanKind, attributes ...Attribute) (context.Context, *Span) {
+	processor := active.Load()
+	if processor == nil {
+		return ctx, nil
+	}
+
+	span := &Span{
+		processor: processor,
+		data: SpanData{
+			SpanID:     newSpanID(),
+			Name:       name,
+			Kind:       kind,
+			Start:      time.Now(),
+			Attributes: attributes,
+		},
+	}
+	if parent := SpanFromContext(ctx); parent != nil {
+		span.data.TraceID = parent.data.TraceID
+		span.data.ParentID = parent.data.SpanID
+	} else {
+		rand.Read(span.data.TraceID[:])
+	}
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	rand.Read(id[:])
+	return id
+}
+
+// Exporter sends ended spans somewhere they can be viewed
+type Exporter interface {
+	Export(ctx context.Context, spans []SpanData) error
+	Shutdown(ctx context.Context) error
+}
+
+// Init begins tracing, exporting ended spans in batches until Shutdown. Export errors are logged.
+func Init(exporter Exporter, log logr.Logger) {
+	processor := &processor{
+		exporter: exporter,
+		log:      log,
+		flush:    make(chan struct{}, 1),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go processor.run()
+	if previous := active.Swap(processor); previous != nil {
+		previous.shutdown(context.Background())
+	}
+}
+
+// Shutdown exports the spans that have ended and stops tracing
+func Shutdown(ctx context.Context) error {
+	if processor := active.Swap(nil); processor != nil {
+		return processor.shutdown(ctx)
+	}
+	return nil
+}
+
+type processor struct {
+	exporter Exporter
+	log      logr.Logger
+	mutex    sync.Mutex
+	queue    []SpanData
+	dropped  int
+	flush    chan struct{}
+	done     chan struct{}
+	stopped  chan struct{}
+}
+
+func (s *processor) enqueue(span SpanData) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(s.queue) >= maxQueued {
+		s.dropped++
+		return
+	}
+	s.queue = append(s.queue, span)
+	if len(s.queue) >= exportBatch {
+		select {
+		case s.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (s *processor) run() {
+	defer close(s.stopped)
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.export()
+		case <-s.flush:
+			s.export()
+		case <-s.done:
+			s.export()
+			return
+		}
+	}
+}
+
+func (s *processor) export() {
+	s.mutex.Lock()
+	spans, dropped := s.queue, s.dropped
+	s.queue, s.dropped = nil, 0
+	s.mutex.Unlock()
+
+	if dropped > 0 {
+		s.log.Info("dropped spans that could not be exported quickly enough", "count", dropped)
+	}
+	if len(spans) > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := s.exporter.Export(ctx, spans); err != nil {
+			s.log.Error(err, "unable to export spans", "count", len(spans))
+		}
+	}
+}
+
+func (s *processor) shutdown(ctx context.Context) error {
+	close(s.done)
+	select {
+	case <-s.stopped:
+		return s.exporter.Shutdown(ctx)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
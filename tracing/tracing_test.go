@@ -0,0 +1,140 @@
+// Copyright (C) 2024 Specter Ops, Inc.
+//
+// This file is part of AzureHound.
+//
+// AzureHound is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// AzureHound is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+)
+
+func TestStart(t *testing.T) {
+	if ctx, span := Start(context.Background(), "untraced", SpanKindInternal); span != nil || SpanFromContext(ctx) != nil {
+		t.Fatal("got a span before tracing was initialized")
+	} else {
+		// a nil span records nothing
+		span.SetAttributes(String("key", "value"))
+		span.AddEvent("event")
+		span.SetError(errors.New("error"))
+		span.End()
+	}
+
+	exporter := &MemoryExporter{}
+	Init(exporter, logr.Discard())
+
+	ctx, job := Start(context.Background(), "job", SpanKindInternal, Int("azurehound.job.id", 7))
+	_, stage := Start(ctx, "list users", SpanKindInternal)
+	stage.AddEvent("retry", Int("azurehound.attempt", 1))
+	stage.SetError(errors.New("unable to list users"))
+	stage.End()
+	stage.End()
+	job.End()
+
+	if err := Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := exporter.Spans()
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	} else if stage, job := spans[0], spans[1]; stage.TraceID != job.TraceID || stage.ParentID != job.SpanID || job.ParentID.IsValid() {
+		t.Errorf("got stage %s/%s with parent %s and job %s/%s, want the stage to be a child of the job", stage.TraceID, stage.SpanID, stage.ParentID, job.TraceID, job.SpanID)
+	} else if stage.Status.Code != StatusError || len(stage.Events) != 2 || stage.Events[1].Name != "exception" {
+		t.Errorf("got status %+v and events %+v, want an error with the retry and exception events", stage.Status, stage.Events)
+	}
+
+	if _, span := Start(context.Background(), "after shutdown", SpanKindInternal); span != nil {
+		t.Error("got a span after tracing was shut down")
+	}
+}
+
+func TestFileExporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.json")
+	if exporter, err := NewFileExporter(path); err != nil {
+		t.Fatal(err)
+	} else {
+		Init(exporter, logr.Discard())
+	}
+
+	ctx, job := Start(context.Background(), "job", SpanKindInternal, Int("azurehound.job.id", 7), Bool("sampled", true))
+	_, request := Start(ctx, "HTTP GET", SpanKindClient, String("url.path", "/v1.0/users"), Float64("delay", 0.5))
+	request.End()
+	_, batch := Start(context.Background(), "batch", SpanKindInternal)
+	batch.AddLink(request)
+	batch.End()
+	job.End()
+	if err := Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Spans []map[string]interface{}
+			}
+		}
+	}
+	if data, err := os.ReadFile(path); err != nil {
+		t.Fatal(err)
+	} else if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); len(lines) != 1 {
+		t.Fatalf("got %d lines, want one export request", len(lines))
+	} else if err := json.Unmarshal([]byte(lines[0]), &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := decoded.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 3 {
+		t.Fatalf("got %d spans, want 3", len(spans))
+	} else if request, job := spans[0], spans[2]; len(request["traceId"].(string)) != 32 || request["parentSpanId"] != job["spanId"] || request["kind"] != float64(SpanKindClient) {
+		t.Errorf("got request %v and job %v, want hex IDs with the request a client span and child of the job", request, job)
+	} else if attribute := job["attributes"].([]interface{})[0].(map[string]interface{}); attribute["value"].(map[string]interface{})["intValue"] != "7" {
+		t.Errorf("got attribute %v, want the job ID encoded as a string", attribute)
+	} else if links, _ := spans[1]["links"].([]interface{}); len(links) != 1 || links[0].(map[string]interface{})["spanId"] != request["spanId"] {
+		t.Errorf("got links %v, want the batch linked to the request", spans[1]["links"])
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var path, contentType string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, contentType = r.URL.Path, r.Header.Get("Content-Type")
+		io.Copy(io.Discard, r.Body)
+	}))
+	defer server.Close()
+
+	if exporter, err := NewOTLPExporter(server.URL); err != nil {
+		t.Fatal(err)
+	} else if err := exporter.Export(context.Background(), []SpanData{{Name: "job"}}); err != nil {
+		t.Fatal(err)
+	} else if path != "/v1/traces" || contentType != "application/json" {
+		t.Errorf("got a request to %s with content type %s, want JSON posted to /v1/traces", path, contentType)
+	}
+
+	if _, err := NewOTLPExporter("localhost:4318"); err == nil {
+		t.Error("got no error for an endpoint without a scheme")
+	}
+}